@@ -0,0 +1,281 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"strconv"
+	"strings"
+)
+
+// EagerAggregateRatio : the partial aggregation is only pushed when it is expected to keep at most this
+// fraction of the rows of the join side
+var EagerAggregateRatio = 0.5
+
+// aggregateColumnID numbers the columns computed by the partial Aggregates, it is reset by QueryOptimizer
+var aggregateColumnID = 0
+
+// EagerAggregate pushes a partial aggregation below the Join under an Aggregate, or under a Project computing
+// aggregates without GROUP BY. All the aggregate arguments must come from one side of the join, which is grouped
+// by its columns used above it: the group by columns, the join conditions and the filters in between.
+// Every row of the partial result stands for its group and the join repeats it as many times as it would repeat
+// each row of the group, so the Aggregate above only has to merge the partial results.
+// Return true if any partial Aggregate is pushed
+func (plan *LogicalPlan) EagerAggregate() bool {
+	var modify = false
+	for i := range plan.child {
+		if plan.child[i].EagerAggregate() {
+			modify = true
+		}
+	}
+	if CanEagerAggregate(plan) && EagerAggregateForInstance(plan) {
+		modify = true
+	}
+	return modify
+}
+
+// CanEagerAggregate check the aggregation of plan can be split: it is computed in one phase, it only uses
+// decomposable functions, and no node above it in the query block uses aggregates it does not output
+func CanEagerAggregate(plan *LogicalPlan) bool {
+	var cols []Expression
+	switch n := plan.Content.(type) {
+	case AggregateNode:
+		if n.Mode != CompleteAggregate {
+			return false
+		}
+		cols = n.cols
+	case ProjectionNode:
+		if !hasAggregate(n.cols) {
+			return false
+		}
+		cols = n.cols
+	default:
+		return false
+	}
+	if len(plan.child) != 1 {
+		return false
+	}
+	for _, f := range aggregateFunctions(cols) {
+		for _, arg := range f.Args {
+			if AggregatorInExpression(arg) || !CheckExprDeterministic(arg) {
+				return false
+			}
+		}
+		if def, ok := LookupFunction(f.GetString()); !ok || !def.Decomposable() || len(f.Args) != 1 {
+			return false
+		}
+	}
+	for cur := plan; cur.parent != nil && !cur.parent.IsDerivedTable() && cur.parent.Tp != Union; cur = cur.parent {
+		for _, expr := range cur.parent.Expressions() {
+			if AggregatorInExpression(expr) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// EagerAggregateForInstance : plan is an Aggregate or a Project computing aggregates, over Filters over a Join
+func EagerAggregateForInstance(plan *LogicalPlan) bool {
+	var cols, items []Expression
+	switch n := plan.Content.(type) {
+	case AggregateNode:
+		cols, items = n.cols, n.Items
+	case ProjectionNode:
+		cols = n.cols
+	}
+	//the columns used between the aggregate and the join
+	var used []Expression
+	join := &plan.child[0]
+	for join.Tp == Filter && len(join.child) == 1 {
+		used = append(used, join.Expressions()...)
+		join = &join.child[0]
+	}
+	if join.Tp != Join || len(join.child) != 2 {
+		return false
+	}
+	used = append(used, join.Content.(JoinNode).On...)
+	used = append(used, items...)
+	for _, col := range cols {
+		used = append(used, nonAggregatePart(col)...)
+	}
+	side, keys, ok := EagerAggregateSide(join, aggregateFunctions(cols), used)
+	if !ok {
+		return false
+	}
+
+	partial, final := SplitAggregates(cols)
+	newNode := OpNodeInit(Aggregate, AggregateNode{
+		ProjectionNode: ProjectionNode{append(keys, partial...)},
+		GroupByNode:    GroupByNode{keys},
+		Mode:           PartialAggregate,
+	})
+	newNode.child = []LogicalPlan{join.child[side]}
+	join.child[side] = *newNode
+	plan.Tp = Aggregate
+	plan.Content = AggregateNode{
+		ProjectionNode: ProjectionNode{final},
+		GroupByNode:    GroupByNode{items},
+		Mode:           FinalAggregate,
+	}
+	treeRoot.ResetParent()
+
+	ruleApplied("Eager Aggregate")
+	return true
+}
+
+// EagerAggregateSide choose the side of join to aggregate and its group by keys, the columns of that side in used.
+// The side must hold all the arguments of funcs, every one of its rows must reach the output, and its
+// aggregation must be expected to pay off. The side whose aggregation removes the most rows is preferred
+func EagerAggregateSide(join *LogicalPlan, funcs []Datum, used []Expression) (int, []Expression, bool) {
+	var sides []int
+	switch join.Content.(JoinNode).Tp {
+	case ast.CrossJoin, InnerJoin:
+		sides = []int{0, 1}
+	case ast.LeftJoin:
+		sides = []int{0}
+	case ast.RightJoin:
+		sides = []int{1}
+	}
+	ret, best := -1, 0.0
+	var retKeys []Expression
+	for _, side := range sides {
+		var ok = true
+		for _, f := range funcs {
+			for _, arg := range f.Args {
+				if !join.ExprInChild(arg, side) {
+					ok = false
+				}
+			}
+		}
+		//without keys the partial Aggregate would return a row for an empty input
+		keys := groupKeys(join, side, used)
+		if !ok || len(keys) == 0 {
+			continue
+		}
+		rows := EstimateRows(&join.child[side])
+		groups := EstimateGroups(&join.child[side], keys)
+		if groups > rows*EagerAggregateRatio {
+			continue
+		}
+		if ret == -1 || rows-groups > best {
+			ret, best, retKeys = side, rows-groups, keys
+		}
+	}
+	return ret, retKeys, ret != -1
+}
+
+// groupKeys return the columns of the side-th child of join referenced by exprs
+func groupKeys(join *LogicalPlan, side int, exprs []Expression) []Expression {
+	var keys []Expression
+	seen := make(map[string]bool)
+	schema := join.child[side].Schema()
+	for _, expr := range exprs {
+		for _, col := range GetExpressionColName(expr) {
+			i := schema.ResolveColumn(col)
+			if i == -1 || join.ChildOfColumn(col) != side {
+				continue
+			}
+			c := schema.Columns[i]
+			key := strings.ToLower(c.TblName + "." + c.ColName)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keys = append(keys, ColumnExpression(ColumnName{
+				OrigTblName: c.OrigTblName,
+				OrigColName: c.OrigColName,
+				TblName:     c.TblName,
+				ColName:     c.ColName,
+			}))
+		}
+	}
+	return keys
+}
+
+// SplitAggregates split the aggregates of cols into the columns computed by the partial Aggregate and
+// the columns of the final Aggregate, whose functions merge the partial results:
+//
+//	count, sum, min, max(x) -> f(x) AS aggN, merged by f(aggN)
+//	avg(x) -> sum(x) AS aggN, count(x) AS aggM, merged by avg(aggN, aggM)
+//
+// the aggregates computed by the partial phase are given by the registry
+func SplitAggregates(cols []Expression) ([]Expression, []Expression) {
+	var partial []Expression
+	computed := make(map[string][]Expression)
+	newColumn := func(name string, args []Expression) Expression {
+		aggregateColumnID++
+		d := InitSetValue(name)
+		d.Args = args
+		partial = append(partial, Expression{
+			expr:   []Datum{d},
+			Fields: make(map[string]ColumnName),
+			AsName: "agg" + strconv.Itoa(aggregateColumnID),
+		})
+		return ColumnExpression(ColumnName{ColName: partial[len(partial)-1].AsName})
+	}
+	var final []Expression
+	for _, col := range cols {
+		//the output column keeps its name
+		if _, ok := SingleColumn(col); !ok && col.AsName == "" && !IsWildCard(col) {
+			col = CopyExpression(col)
+			col.AsName = col.print()
+		}
+		final = append(final, mapAggregates(col, func(f Datum) Datum {
+			e := Expression{expr: []Datum{f}}
+			key := e.print()
+			if _, ok := computed[key]; !ok {
+				def, _ := LookupFunction(f.GetString())
+				for _, name := range def.Partial {
+					computed[key] = append(computed[key], newColumn(name, f.Args))
+				}
+			}
+			ret := InitSetValue(f.GetString())
+			ret.Args = computed[key]
+			return ret
+		}))
+	}
+	return partial, final
+}
+
+// mapAggregates return a copy of expr whose aggregate functions are replaced by f
+func mapAggregates(expr Expression, f func(Datum) Datum) Expression {
+	ret := CopyExpression(expr)
+	for i, d := range ret.expr {
+		if d.Args == nil || d.Kind() != test_driver.KindString {
+			continue
+		}
+		if IsAggregateFunction(d.GetString()) {
+			ret.expr[i] = f(d)
+			continue
+		}
+		for j := range d.Args {
+			d.Args[j] = mapAggregates(d.Args[j], f)
+		}
+	}
+	return ret
+}
+
+// aggregateFunctions return the aggregate function datums of cols
+func aggregateFunctions(cols []Expression) []Datum {
+	var ret []Datum
+	for _, col := range cols {
+		mapAggregates(col, func(d Datum) Datum {
+			ret = append(ret, d)
+			return d
+		})
+	}
+	return ret
+}
+
+// nonAggregatePart return the column references of expr outside of its aggregate functions
+func nonAggregatePart(expr Expression) []Expression {
+	ret := []Expression{{expr: nil, Fields: expr.Fields}}
+	for _, d := range expr.expr {
+		if d.Args != nil && d.Kind() == test_driver.KindString && !IsAggregateFunction(d.GetString()) {
+			for _, arg := range d.Args {
+				ret = append(ret, nonAggregatePart(arg)...)
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+// aggregateModes print the mode of the Aggregates of plan in pre-order
+func aggregateModes(plan *LogicalPlan) string {
+	var ret string
+	if plan.Tp == Aggregate {
+		ret = AggregateModeNames[plan.Content.(AggregateNode).Mode] + " "
+	}
+	for i := range plan.child {
+		ret += aggregateModes(&plan.child[i])
+	}
+	return ret
+}
+
+func TestEagerAggregate(t *testing.T) {
+	tests := []struct {
+		sql   string
+		modes string
+		rows  int
+	}{
+		{"select t.a, sum(testdata2.b), count(*) from t join testdata2 on t.a = testdata2.a group by t.a", "Final Partial ", 5},
+		{"select count(*), max(testdata2.b) from t join testdata2 on t.a = testdata2.a", "Final Partial ", 1},
+		{"select t.b, avg(testdata2.b) from t join testdata2 on t.a = testdata2.a group by t.b", "Final Partial ", 5},
+		//count(distinct) can not be merged, the argument uses both sides, a left join adds NULL rows
+		{"select t.a, count(distinct testdata2.b) from t join testdata2 on t.a = testdata2.a group by t.a", "Complete ", 5},
+		{"select t.a, sum(testdata2.b + t.b) from t join testdata2 on t.a = testdata2.a group by t.a", "Complete ", 5},
+		{"select t.a, sum(testdata2.b) from t left join testdata2 on t.a = testdata2.a group by t.a", "Complete ", 12},
+	}
+	for _, test := range tests {
+		checkOptimized(t, test.sql, test.rows)
+		_, plan := optimizeQuery(t, test.sql)
+		if got := aggregateModes(plan); got != test.modes {
+			t.Errorf("%v: the aggregates are %v, want %v", test.sql, got, test.modes)
+		}
+	}
+	checkResult(t, "select t.a, sum(testdata2.b), count(*), avg(testdata2.b) from t join testdata2 on t.a = testdata2.a group by t.a",
+		"1, 3, 2, 1.5000", "2, 3, 2, 1.5000", "3, 5, 3, 1.6667", "4, 5, 2, 2.5000", "5, NULL, 1, NULL")
+}
+
+func TestEagerAggregateRatio(t *testing.T) {
+	ratio := EagerAggregateRatio
+	EagerAggregateRatio = 0.01
+	defer func() { EagerAggregateRatio = ratio }()
+	sql := "select t.a, sum(testdata2.b) from t join testdata2 on t.a = testdata2.a group by t.a"
+	checkOptimized(t, sql, 5)
+	if _, plan := optimizeQuery(t, sql); aggregateModes(plan) != "Complete " {
+		t.Errorf("%v: the partial aggregate is pushed although it keeps most rows", sql)
+	}
+}
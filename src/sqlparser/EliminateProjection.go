@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strings"
+)
+
+// EliminateProjection collapses the stacked projections of nested derived tables:
+// a derived Table over a Project only renames, so it is folded into the Project,
+// adjacent Projects are merged by substituting the inner expressions into the outer ones,
+// and a Project returning its input unchanged is removed. Return true if anything changed
+func (plan *LogicalPlan) EliminateProjection() bool {
+	var modify = false
+	for i := range plan.child {
+		if plan.child[i].EliminateProjection() {
+			modify = true
+		}
+	}
+	if plan.IsDerivedTable() || (plan.Tp == Project && len(plan.child) == 1) {
+		if EliminateProjectionForInstance(plan) {
+			modify = true
+		}
+	}
+	return modify
+}
+
+// EliminateProjectionForInstance : plan is a derived Table or a Project.
+// The columns of the base tables are inferred from the references of their query block when they are not in
+// the Catalog, so removing or moving a reference may change what is inferred.
+// The rewrite is undone if plan or the query ends up with a different schema, or with more unresolved columns
+func EliminateProjectionForInstance(plan *LogicalPlan) bool {
+	schema, unresolved := treeRoot.Schema(), CountUnresolvedColumns(treeRoot)
+	output := plan.Schema()
+	saved := *plan
+	var modify = false
+	if FoldDerivedTable(plan) {
+		modify = true
+	}
+	if plan.Tp == Project && MergeProjection(plan) {
+		modify = true
+	}
+	if plan.Tp == Project && RemoveIdentityProjection(plan) {
+		modify = true
+	}
+	if !modify {
+		return false
+	}
+	treeRoot.ResetParent()
+	if !SameSchema(schema, treeRoot.Schema()) || !SameSchema(output, plan.Schema()) ||
+		CountUnresolvedColumns(treeRoot) > unresolved {
+		*plan = saved
+		treeRoot.ResetParent()
+		return false
+	}
+
+	ruleApplied("Eliminate Projection")
+	return true
+}
+
+// SameSchema check whether s1 and s2 have the same columns, the spelling of the base column names may differ
+// as it depends on the first reference seen when the columns are inferred
+func SameSchema(s1, s2 Schema) bool {
+	if s1.Len() != s2.Len() {
+		return false
+	}
+	for i, c1 := range s1.Columns {
+		c2 := s2.Columns[i]
+		if c1.TblName != c2.TblName || c1.ColName != c2.ColName || c1.Tp != c2.Tp || c1.Nullable != c2.Nullable ||
+			!strings.EqualFold(c1.OrigTblName, c2.OrigTblName) || !strings.EqualFold(c1.OrigColName, c2.OrigColName) {
+			return false
+		}
+	}
+	return true
+}
+
+// CountUnresolvedColumns count the column references of the plan which are not produced by the input of their node
+func CountUnresolvedColumns(root *LogicalPlan) int {
+	var ret = 0
+	input := root.childSchema()
+	if root.Tp == Join {
+		input = root.Schema()
+	}
+	for _, expr := range root.Expressions() {
+		for _, col := range GetExpressionColName(expr) {
+			if input.ResolveColumn(col) == -1 {
+				ret++
+			}
+		}
+	}
+	for i := range root.child {
+		ret += CountUnresolvedColumns(&root.child[i])
+	}
+	return ret
+}
+
+// FoldDerivedTable replace the derived table tbl by its Project, whose columns are renamed to the qualified
+// names given by the derived table. A Project computing aggregates stays the root of its query block
+func FoldDerivedTable(tbl *LogicalPlan) bool {
+	if !tbl.IsDerivedTable() || tbl.child[0].Tp != Project || len(tbl.child[0].child) != 1 {
+		return false
+	}
+	inner := tbl.child[0]
+	defs := inner.OutputDefinitions()
+	schema := tbl.Schema()
+	if len(defs) != schema.Len() || hasAggregate(defs) {
+		return false
+	}
+	cols := make([]Expression, 0, len(defs))
+	for i, def := range defs {
+		def.AsName, def.AsTblName = schema.Columns[i].ColName, schema.Columns[i].TblName
+		cols = append(cols, def)
+	}
+	newNode := OpNodeInit(Project, ProjectionNode{cols})
+	newNode.child = inner.child
+	newNode.parent = tbl.parent
+	*tbl = *newNode
+	return true
+}
+
+// MergeProjection merge proj with the Project right under it, the inner Project must not compute aggregates
+// and its expressions must be deterministic, as they may be evaluated more than once after the merge
+func MergeProjection(proj *LogicalPlan) bool {
+	inner := &proj.child[0]
+	if inner.Tp != Project || len(inner.child) != 1 {
+		return false
+	}
+	innerSchema := inner.Schema()
+	innerDefs := inner.OutputDefinitions()
+	if len(innerDefs) != innerSchema.Len() || hasAggregate(innerDefs) || !CheckFieldsDeterministic(innerDefs) {
+		return false
+	}
+	outerSchema := proj.Schema()
+	outerDefs := proj.OutputDefinitions()
+	if len(outerDefs) != outerSchema.Len() {
+		return false
+	}
+	below := inner.childSchema()
+	cols := make([]Expression, 0, len(outerDefs))
+	for i, def := range outerDefs {
+		col, ok := SubstituteExpression(def, func(col ColumnName) (Expression, bool) {
+			j := innerSchema.ResolveColumn(col)
+			if j == -1 {
+				return Expression{}, false
+			}
+			return innerDefs[j], true
+		})
+		if !ok {
+			return false
+		}
+		//keep the name the parent sees
+		name := projectSchema([]Expression{col}, below).Columns[0]
+		if name.ColName != outerSchema.Columns[i].ColName || name.TblName != outerSchema.Columns[i].TblName {
+			col.AsName, col.AsTblName = outerSchema.Columns[i].ColName, outerSchema.Columns[i].TblName
+		}
+		cols = append(cols, col)
+	}
+	proj.Content = ProjectionNode{cols}
+	proj.child = inner.child
+	return true
+}
+
+// RemoveIdentityProjection remove proj if it returns the columns of its child, in the same order and with
+// the same names
+func RemoveIdentityProjection(proj *LogicalPlan) bool {
+	schema := proj.Schema()
+	child := proj.childSchema()
+	if schema.Len() != child.Len() {
+		return false
+	}
+	for i, def := range proj.OutputDefinitions() {
+		col, ok := SingleColumn(def)
+		if !ok || child.ResolveColumn(col) != i || schema.Columns[i].ColName != child.Columns[i].ColName ||
+			schema.Columns[i].TblName != child.Columns[i].TblName {
+			return false
+		}
+	}
+	par := proj.parent
+	*proj = proj.child[0]
+	proj.parent = par
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+// countPlans return the number of nodes of type tp in plan
+func countPlans(plan *LogicalPlan, tp OpType) int {
+	ret := 0
+	if plan.Tp == tp {
+		ret++
+	}
+	for i := range plan.child {
+		ret += countPlans(&plan.child[i], tp)
+	}
+	return ret
+}
+
+func TestEliminateProjection(t *testing.T) {
+	loadTestTables(t)
+	tests := []struct {
+		sql      string
+		projects int
+		rows     int
+	}{
+		//identity projections are removed
+		{"select a, b from (select a, b from testdata2) tmp", 0, 11},
+		{"select x.k from (select y.a as k from (select a, b from t) y) x where x.k > 10", 1, 2},
+		{"select x.s * 2 from (select a + b as s from t) x", 1, 12},
+		//a different order is not an identity
+		{"select b, a from (select a, b from t) x", 1, 12},
+		//aggregates are not substituted into the outer projection
+		{"select x.m + 1 from (select max(a) as m from t) x", 2, 1},
+	}
+	for _, test := range tests {
+		checkOptimized(t, test.sql, test.rows)
+		_, plan := optimizeQuery(t, test.sql)
+		if got := countPlans(plan, Project); got != test.projects {
+			t.Errorf("%v: %d projections, want %d", test.sql, got, test.projects)
+		}
+	}
+
+	_, plan := optimizeQuery(t, "select x.s * 2 from (select a + b as s from t) x")
+	if got := plan.Content.(ProjectionNode).cols[0]; got.print() != "((a+b)*2)" || got.AsName != "(x.s*2)" {
+		t.Errorf("the merged projection is %v AS %v", got.print(), got.AsName)
+	}
+	//rand() would be called twice for every row
+	_, plan = optimizeQuery(t, "select x.r, x.r from (select rand() as r from t) x")
+	if got := countPlans(plan, Project); got != 2 {
+		t.Errorf("a volatile projection is merged, %d projections", got)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"strings"
+)
+
+// PropagateEmptyRelation replaces the subtrees known to produce no row by an EmptyRelation:
+// a Filter whose conjuncts contradict each other like `a > 5 AND a < 3`, or a LIMIT 0.
+// The empty input then collapses its parents. Return true if anything changed
+func (plan *LogicalPlan) PropagateEmptyRelation() bool {
+	var modify = false
+	for i := range plan.child {
+		if plan.child[i].PropagateEmptyRelation() {
+			modify = true
+		}
+	}
+	if PropagateEmptyRelationForInstance(plan) {
+		modify = true
+	}
+	return modify
+}
+
+// PropagateEmptyRelationForInstance : the children of plan are already processed.
+//
+//	Filter, HavingFilter, OrderBy, Limit, derived Table: empty if the input is empty
+//	Join: an inner join is empty if one side is, an outer join with an empty null-supplying side
+//	      becomes a Project padding the preserved side with NULLs
+//	Project, Aggregate: empty if the input is empty, unless they compute aggregates without GROUP BY,
+//	      which return one row on an empty input. GroupBy is left alone for the same reason
+func PropagateEmptyRelationForInstance(plan *LogicalPlan) bool {
+	if plan.Tp == EmptyRelation {
+		return false
+	}
+	var empty bool
+	childEmpty := len(plan.child) > 0 && plan.child[0].Tp == EmptyRelation
+	switch plan.Tp {
+	case Filter:
+		empty = childEmpty || IsContradiction(plan.Content.(WhereFilterNode).Expr)
+	case HavingFilter:
+		empty = childEmpty || IsContradiction(plan.Content.(HavingFilterNode).Expr)
+	case Limit:
+		empty = childEmpty || IsZeroLimit(plan.Content.(LimitNode).Count)
+	case OrderBy, Table:
+		empty = childEmpty
+	case Project:
+		empty = childEmpty && !hasAggregate(plan.Content.(ProjectionNode).cols)
+	case Aggregate:
+		n := plan.Content.(AggregateNode)
+		empty = childEmpty && (len(n.Items) > 0 || !hasAggregate(n.cols))
+	case Join:
+		return emptyJoin(plan)
+	}
+	if !empty {
+		return false
+	}
+	plan.ReplaceWithEmptyRelation()
+
+	ruleApplied("Propagate Empty Relation")
+	return true
+}
+
+func hasAggregate(cols []Expression) bool {
+	for _, col := range cols {
+		if AggregatorInExpression(col) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceWithEmptyRelation turns plan into an EmptyRelation with the same schema
+func (plan *LogicalPlan) ReplaceWithEmptyRelation() {
+	schema := plan.Schema()
+	plan.Tp = EmptyRelation
+	plan.Content = EmptyRelationNode{schema}
+	plan.child = []LogicalPlan{}
+}
+
+func emptyJoin(join *LogicalPlan) bool {
+	var empty []bool
+	for i := range join.child {
+		empty = append(empty, join.child[i].Tp == EmptyRelation)
+	}
+	if len(empty) == 1 {
+		if !empty[0] {
+			return false
+		}
+		join.ReplaceWithEmptyRelation()
+	} else {
+		if len(empty) != 2 || (!empty[0] && !empty[1]) {
+			return false
+		}
+		switch join.Content.(JoinNode).Tp {
+		case ast.LeftJoin:
+			if empty[0] {
+				join.ReplaceWithEmptyRelation()
+			} else {
+				join.ReplaceWithNullPadding(0)
+			}
+		case ast.RightJoin:
+			if empty[1] {
+				join.ReplaceWithEmptyRelation()
+			} else {
+				join.ReplaceWithNullPadding(1)
+			}
+		case FullJoin:
+			switch {
+			case empty[0] && empty[1]:
+				join.ReplaceWithEmptyRelation()
+			case empty[0]:
+				join.ReplaceWithNullPadding(1)
+			default:
+				join.ReplaceWithNullPadding(0)
+			}
+		default:
+			join.ReplaceWithEmptyRelation()
+		}
+	}
+
+	ruleApplied("Propagate Empty Relation")
+	return true
+}
+
+// ReplaceWithNullPadding turns the outer join into a Project over its child keep,
+// the columns of the other side are NULL and keep their table name
+func (join *LogicalPlan) ReplaceWithNullPadding(keep int) {
+	schema := join.Schema()
+	left := join.child[0].Schema().Len()
+	var cols []Expression
+	for i, c := range schema.Columns {
+		if (i < left) == (keep == 0) {
+			cols = append(cols, ColumnExpression(ColumnName{
+				OrigTblName: c.OrigTblName,
+				OrigColName: c.OrigColName,
+				TblName:     c.TblName,
+				ColName:     c.ColName,
+			}))
+			continue
+		}
+		cols = append(cols, Expression{
+			expr:      []Datum{NullDatum()},
+			Fields:    make(map[string]ColumnName),
+			AsName:    c.ColName,
+			AsTblName: c.TblName,
+		})
+	}
+	join.Tp = Project
+	join.Content = ProjectionNode{cols}
+	join.child = []LogicalPlan{join.child[keep]}
+	join.ResetParent()
+}
+
+// IsZeroLimit check whether the count of a LIMIT is the constant 0
+func IsZeroLimit(count Expression) bool {
+	n, ok := LimitValue(count)
+	return ok && len(count.expr) > 0 && n == 0
+}
+
+// IsContradiction check whether the conjuncts can never be all TRUE, either because one of them is
+// a FALSE or NULL constant, or because the conjuncts on a column leave it no possible value
+func IsContradiction(exprs []Expression) bool {
+	ranges := make(map[string]*ColumnRange)
+	get := func(col ColumnName) *ColumnRange {
+		key := strings.ToLower(col.TblName + "." + col.ColName)
+		if _, ok := ranges[key]; !ok {
+			ranges[key] = new(ColumnRange)
+		}
+		return ranges[key]
+	}
+	for _, expr := range exprs {
+		if len(expr.expr) == 1 && expr.IsConstantDatum(expr.expr[0]) {
+			d := expr.expr[0]
+			if truth, null := Truth(d); null || (IsNumericKind(d.Kind()) && !truth) {
+				return true
+			}
+			continue
+		}
+		if col, ok := AsIsNull(expr); ok {
+			get(col).IsNull = true
+			continue
+		}
+		if cmp, ok := AsColumnComparison(expr); ok {
+			get(cmp.Col).Add(cmp)
+		}
+	}
+	for _, r := range ranges {
+		if r.Empty() {
+			return true
+		}
+	}
+	return false
+}
+
+// AsIsNull match expr against `col IS NULL`
+func AsIsNull(expr Expression) (ColumnName, bool) {
+	e := expr.expr
+	if len(e) != 1 || e[0].Args == nil || e[0].GetString() != Ops[IsNull].Name || len(e[0].Args) != 1 {
+		return ColumnName{}, false
+	}
+	return SingleColumn(e[0].Args[0])
+}
+
+// ColumnRange is what the conjuncts on one column tell of its value: the interval between Low and High,
+// the set of Points if HasPoints, minus the Excluded values. Only numeric values take part in the range,
+// as strings are compared by collation
+type ColumnRange struct {
+	Low, High         *Datum
+	LowOpen, HighOpen bool
+	HasPoints         bool
+	Points            []Datum
+	Excluded          []Datum
+	IsNull, NotNull   bool
+	Never             bool //a comparison with NULL, never TRUE
+}
+
+// Add narrow the range by the conjunct cmp
+func (r *ColumnRange) Add(cmp ColumnComparison) {
+	//a comparison is not TRUE on NULL
+	r.NotNull = true
+	var values []Datum
+	for _, v := range cmp.Value {
+		switch {
+		case v.Kind() == test_driver.KindNull:
+			if cmp.Op != In {
+				r.Never = true
+				return
+			}
+		case IsNumericKind(v.Kind()):
+			values = append(values, v)
+		default:
+			return
+		}
+	}
+	if len(values) == 0 {
+		//x IN (NULL)
+		r.Never = true
+		return
+	}
+	v := values[0]
+	switch cmp.Op {
+	case GT, GE:
+		if r.Low == nil {
+			r.Low, r.LowOpen = &v, cmp.Op == GT
+		} else if c, ok := CompareDatum(v, *r.Low); ok && (c > 0 || (c == 0 && cmp.Op == GT)) {
+			r.Low, r.LowOpen = &v, cmp.Op == GT
+		}
+	case LT, LE:
+		if r.High == nil {
+			r.High, r.HighOpen = &v, cmp.Op == LT
+		} else if c, ok := CompareDatum(v, *r.High); ok && (c < 0 || (c == 0 && cmp.Op == LT)) {
+			r.High, r.HighOpen = &v, cmp.Op == LT
+		}
+	case EQ, In:
+		if !r.HasPoints {
+			r.HasPoints, r.Points = true, values
+			return
+		}
+		var points []Datum
+		for _, p := range r.Points {
+			for _, v := range values {
+				if c, ok := CompareDatum(p, v); !ok || c == 0 {
+					points = append(points, p)
+					break
+				}
+			}
+		}
+		r.Points = points
+	case NE:
+		r.Excluded = append(r.Excluded, v)
+	}
+}
+
+// Contains check whether v may be a value of the column, true if unsure
+func (r *ColumnRange) Contains(v Datum) bool {
+	if r.Low != nil {
+		if c, ok := CompareDatum(v, *r.Low); ok && (c < 0 || (c == 0 && r.LowOpen)) {
+			return false
+		}
+	}
+	if r.High != nil {
+		if c, ok := CompareDatum(v, *r.High); ok && (c > 0 || (c == 0 && r.HighOpen)) {
+			return false
+		}
+	}
+	for _, e := range r.Excluded {
+		if c, ok := CompareDatum(v, e); ok && c == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty check whether no value satisfies the range
+func (r *ColumnRange) Empty() bool {
+	if r.Never || (r.IsNull && r.NotNull) {
+		return true
+	}
+	if r.Low != nil && r.High != nil {
+		if c, ok := CompareDatum(*r.Low, *r.High); ok && (c > 0 || (c == 0 && (r.LowOpen || r.HighOpen))) {
+			return true
+		}
+	}
+	if r.HasPoints {
+		for _, p := range r.Points {
+			if r.Contains(p) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsContradiction(t *testing.T) {
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{"a > 5 and a < 3", true},
+		{"a > 5 and a <= 5", true},
+		{"a = 1 and a = 2", true},
+		{"a = 1 and a <> 1", true},
+		{"a in (1, 2) and a = 3", true},
+		{"a is null and a = 1", true},
+		{"b > 1 and 0", true},
+		{"a >= 5 and a <= 5", false},
+		{"a > 5 and a < 6", false},
+		{"a in (1, 2) and a = 2", false},
+		{"a > 5 and a <> 6", false},
+		{"a > 5 and b < 3", false},
+		{"c > 'b' and c < 'a'", false},
+	}
+	for _, test := range tests {
+		node, err := parse("select a from s where " + test.where)
+		if err != nil {
+			t.Fatalf("%v: %v", test.where, err)
+		}
+		filter := findPlan(GetQuery(node), Filter)
+		if got := IsContradiction(filter.Content.(WhereFilterNode).Expr); got != test.want {
+			t.Errorf("%v: contradiction %v, want %v", test.where, got, test.want)
+		}
+	}
+}
+
+func TestPropagateEmptyRelation(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []string
+	}{
+		{"select a from t where a > 5 and a < 3", nil},
+		{"select a, b from t limit 0", nil},
+		{"select t.a, s.c from t join s on t.a = s.a where s.a = 1 and s.a = 2", nil},
+		{"select a, count(*) from t where a > 5 and a < 3 group by a", nil},
+		{"select count(*), max(a) from t where a > 5 and a < 3", []string{"0, NULL"}},
+	}
+	for _, test := range tests {
+		checkResult(t, test.sql, test.want...)
+		original, optimized := optimizeQuery(t, test.sql)
+		if findPlan(optimized, EmptyRelation) == nil {
+			t.Errorf("%v: no EmptyRelation in the optimized plan", test.sql)
+		}
+		if got, want := formatSchema(optimized.Schema()), formatSchema(original.Schema()); got != want {
+			t.Errorf("%v: the schema is %v after optimization, want %v", test.sql, got, want)
+		}
+	}
+	//the left join with an empty right side pads t with NULLs
+	sql := "select t.a, x.c from t left join (select a, c from s where a > 5 and a < 3) x on t.a = x.a where t.a < 3"
+	checkResult(t, sql, "1, NULL", "2, NULL")
+	if _, optimized := optimizeQuery(t, sql); findPlan(optimized, Join) != nil {
+		t.Errorf("%v: the join is kept", sql)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// InferPredicates derives new predicates from the equalities between columns of a Join:
+// for t1.c = t2.c and t1.c >= 213, t2.c >= 213 is inferred and put above the t2 side.
+// Return true if any predicate is inferred
+func (plan *LogicalPlan) InferPredicates() bool {
+	var modify = false
+	for i := range plan.child {
+		if plan.child[i].InferPredicates() {
+			modify = true
+		}
+	}
+	if plan.Tp == Join && len(plan.child) == 2 {
+		if InferPredicatesForInstance(plan) {
+			modify = true
+		}
+	}
+	return modify
+}
+
+// ColumnComparison is a conjunct of the form `col op constant` or `col IN (constants)`
+type ColumnComparison struct {
+	Col   ColumnName
+	Op    MyOp
+	Value []Datum
+}
+
+func (c ColumnComparison) Expression(col ColumnName) Expression {
+	ret := ColumnExpression(col)
+	if c.Op == In {
+		in := InitSetValue(Ops[In].Name)
+		in.Args = []Expression{ret}
+		for _, v := range c.Value {
+			in.Args = append(in.Args, Expression{expr: []Datum{v}, Fields: make(map[string]ColumnName)})
+		}
+		ret = Expression{expr: []Datum{in}, Fields: make(map[string]ColumnName)}
+		return ret
+	}
+	ret.expr = append(ret.expr, c.Value[0], InitSetValue(Ops[c.Op].Name))
+	return ret
+}
+
+// IsConstantDatum check whether d is a literal of expr
+func (expr *Expression) IsConstantDatum(d Datum) bool {
+	if d.Args != nil {
+		return false
+	}
+	if d.Kind() != test_driver.KindString {
+		return true
+	}
+	return !expr.IsColumnDatum(d) && StrToOp(d.GetString()) == -1
+}
+
+// ReverseComparison return op' such that `a op b` equals `b op' a`
+func ReverseComparison(op MyOp) MyOp {
+	switch op {
+	case LT:
+		return GT
+	case LE:
+		return GE
+	case GT:
+		return LT
+	case GE:
+		return LE
+	}
+	return op
+}
+
+func IsComparison(op MyOp) bool {
+	switch op {
+	case EQ, NE, LT, LE, GT, GE:
+		return true
+	}
+	return false
+}
+
+// AsColumnComparison match expr against `col op constant`, `constant op col` and `col IN (constants)`
+func AsColumnComparison(expr Expression) (ColumnComparison, bool) {
+	e := expr.expr
+	if len(e) == 1 && e[0].Args != nil && e[0].GetString() == Ops[In].Name && len(e[0].Args) > 1 {
+		col, ok := SingleColumn(e[0].Args[0])
+		if !ok {
+			return ColumnComparison{}, false
+		}
+		ret := ColumnComparison{Col: col, Op: In}
+		for _, arg := range e[0].Args[1:] {
+			if len(arg.expr) != 1 || !arg.IsConstantDatum(arg.expr[0]) {
+				return ColumnComparison{}, false
+			}
+			ret.Value = append(ret.Value, arg.expr[0])
+		}
+		return ret, true
+	}
+	if len(e) != 3 || e[2].Args != nil || e[2].Kind() != test_driver.KindString {
+		return ColumnComparison{}, false
+	}
+	op := StrToOp(e[2].GetString())
+	if !IsComparison(op) {
+		return ColumnComparison{}, false
+	}
+	switch {
+	case expr.IsColumnDatum(e[0]) && expr.IsConstantDatum(e[1]):
+		return ColumnComparison{expr.Fields[e[0].GetString()], op, []Datum{e[1]}}, true
+	case expr.IsConstantDatum(e[0]) && expr.IsColumnDatum(e[1]):
+		return ColumnComparison{expr.Fields[e[1].GetString()], ReverseComparison(op), []Datum{e[0]}}, true
+	}
+	return ColumnComparison{}, false
+}
+
+// AsColumnEquality match expr against `col1 = col2`
+func AsColumnEquality(expr Expression) (ColumnName, ColumnName, bool) {
+	e := expr.expr
+	if len(e) != 3 || !expr.IsColumnDatum(e[0]) || !expr.IsColumnDatum(e[1]) ||
+		e[2].Args != nil || e[2].Kind() != test_driver.KindString || StrToOp(e[2].GetString()) != EQ {
+		return ColumnName{}, ColumnName{}, false
+	}
+	return expr.Fields[e[0].GetString()], expr.Fields[e[1].GetString()], true
+}
+
+// FiltersAbove return the Filter nodes in the single chain right above plan
+func (plan *LogicalPlan) FiltersAbove() []*LogicalPlan {
+	var ret []*LogicalPlan
+	for cur := plan.parent; cur != nil && cur.Tp == Filter && len(cur.child) == 1; cur = cur.parent {
+		ret = append(ret, cur)
+	}
+	return ret
+}
+
+// KnownPredicates return the conjuncts which hold on every row produced by plan: those of the Filters and of
+// the ON conditions of the inner joins below it, down to the null-supplying side of an outer join or a derived table
+func (plan *LogicalPlan) KnownPredicates() []Expression {
+	switch plan.Tp {
+	case Filter:
+		return append(append([]Expression{}, plan.Content.(WhereFilterNode).Expr...), plan.child[0].KnownPredicates()...)
+	case Join:
+		if len(plan.child) != 2 {
+			return nil
+		}
+		switch plan.Content.(JoinNode).Tp {
+		case ast.LeftJoin:
+			return plan.child[0].KnownPredicates()
+		case ast.RightJoin:
+			return plan.child[1].KnownPredicates()
+		case FullJoin:
+			return nil
+		}
+		ret := append([]Expression{}, plan.Content.(JoinNode).On...)
+		return append(append(ret, plan.child[0].KnownPredicates()...), plan.child[1].KnownPredicates()...)
+	}
+	return nil
+}
+
+// InferPredicatesForInstance : join.Tp = Join.
+// For inner and cross joins the conjuncts of ON and of the filters right above are used and both sides may
+// receive inferred predicates. For outer joins only the ON conditions are used and only the null-supplying side
+// receives predicates, as the ON conditions never filter the preserved side.
+// The predicates known to hold on the children are used too, so that a class spanning several joins is complete
+func InferPredicatesForInstance(join *LogicalPlan) bool {
+	n := join.Content.(JoinNode)
+	sources := append([]Expression{}, n.On...)
+	targets := []bool{true, true}
+	switch n.Tp {
+	case ast.LeftJoin:
+		targets[0] = false
+	case ast.RightJoin:
+		targets[1] = false
+	case FullJoin:
+		return false
+	default:
+		for _, filter := range join.FiltersAbove() {
+			sources = append(sources, filter.Content.(WhereFilterNode).Expr...)
+		}
+	}
+	for i := range join.child {
+		sources = append(sources, join.child[i].KnownPredicates()...)
+	}
+
+	schema := join.Schema()
+	//union find over the indexes of the join schema
+	class := make([]int, schema.Len())
+	for i := range class {
+		class[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if class[i] != i {
+			class[i] = find(class[i])
+		}
+		return class[i]
+	}
+	var comparisons []ColumnComparison
+	for _, expr := range sources {
+		if c1, c2, ok := AsColumnEquality(expr); ok {
+			i, j := schema.ResolveColumn(c1), schema.ResolveColumn(c2)
+			if i != -1 && j != -1 {
+				class[find(i)] = find(j)
+			}
+		} else if cmp, ok := AsColumnComparison(expr); ok {
+			comparisons = append(comparisons, cmp)
+		}
+	}
+
+	existing := make(map[string]bool)
+	for _, expr := range sources {
+		existing[expr.print()] = true
+	}
+	inferred := make([][]Expression, 2)
+	for _, cmp := range comparisons {
+		src := schema.ResolveColumn(cmp.Col)
+		if src == -1 {
+			continue
+		}
+		for dst, c := range schema.Columns {
+			if dst == src || find(dst) != find(src) {
+				continue
+			}
+			col := ColumnName{
+				OrigTblName: c.OrigTblName,
+				OrigColName: c.OrigColName,
+				TblName:     c.TblName,
+				ColName:     c.ColName,
+			}
+			side := join.ChildOfColumn(col)
+			if side == -1 || !targets[side] {
+				continue
+			}
+			expr := cmp.Expression(col)
+			if !existing[expr.print()] {
+				existing[expr.print()] = true
+				inferred[side] = append(inferred[side], expr)
+			}
+		}
+	}
+
+	var modify = false
+	for side, exprs := range inferred {
+		if len(exprs) == 0 {
+			continue
+		}
+		child := &join.child[side]
+		switch {
+		case child.Tp == Filter:
+			child.Content = WhereFilterNode{Expr: append(child.Content.(WhereFilterNode).Expr, exprs...)}
+		case IsInnerJoin(join) && IsInnerJoin(child):
+			//a Filter between the two joins would split their group for JoinReorder, which places the ON
+			//conditions on the lowest join holding their relations
+			n.On = append(n.On, exprs...)
+			join.Content = n
+		default:
+			newNode := OpNodeInit(Filter, WhereFilterNode{Expr: exprs})
+			newNode.child = []LogicalPlan{*child}
+			join.child[side] = *newNode
+		}
+		modify = true
+	}
+	if modify {
+		join.ResetParent()
+		ruleApplied("Infer Predicates From Join Equalities")
+	}
+	return modify
+}
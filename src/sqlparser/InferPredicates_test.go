@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// inferPredicates run only InferPredicates on the plan of sql
+func inferPredicates(t *testing.T, sql string) *LogicalPlan {
+	t.Helper()
+	node, err := parse(sql)
+	if err != nil {
+		t.Fatalf("%v: %v", sql, err)
+	}
+	plan := GetQuery(node)
+	quiet := QuietRules
+	QuietRules = true
+	defer func() { QuietRules = quiet }()
+	treeRoot = plan
+	plan.InferPredicates()
+	return plan
+}
+
+// joinConditions print the ON conditions of the Joins of plan in pre-order
+func joinConditions(plan *LogicalPlan) []string {
+	var ret []string
+	if plan.Tp == Join {
+		for _, expr := range plan.Content.(JoinNode).On {
+			ret = append(ret, expr.print())
+		}
+	}
+	for i := range plan.child {
+		ret = append(ret, joinConditions(&plan.child[i])...)
+	}
+	return ret
+}
+
+func TestInferPredicatesFromEqualities(t *testing.T) {
+	loadTestTables(t)
+	bytes, err := ioutil.ReadFile(testDir + f2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := inferPredicates(t, string(bytes))
+	if got := strings.Join(filterExprs(plan), " "); got != "(t1.c>=213) (t2.c>=213)" {
+		t.Errorf("the filters of %v are %v", f2, got)
+	}
+
+	plan = inferPredicates(t, "select t.a from t join s on t.a = s.a where t.a in (2, 4) and s.a < 10")
+	if got := strings.Join(filterExprs(plan), " "); got != "in(t.a, 2, 4) (s.a<10) (t.a<10) in(s.a, 2, 4)" {
+		t.Errorf("the filters are %v", got)
+	}
+	checkOptimized(t, "select t.a from t join s on t.a = s.a where t.a in (2, 4) and s.a < 10", 3)
+}
+
+func TestInferPredicatesAcrossJoins(t *testing.T) {
+	sql := "select t.a from t join s on t.a = s.a join testdata2 on s.a = testdata2.a where t.a in (2, 4)"
+	plan := inferPredicates(t, sql)
+	if got := strings.Join(filterExprs(plan), " "); got != "in(t.a, 2, 4) in(testdata2.a, 2, 4)" {
+		t.Errorf("the filters are %v", got)
+	}
+	if got := strings.Join(joinConditions(plan), " "); got != "(s.a=testdata2.a) in(s.a, 2, 4) (t.a=s.a)" {
+		t.Errorf("the join conditions are %v", got)
+	}
+	_, plan = optimizeQuery(t, sql)
+	if got := len(filterExprs(plan)); got != 3 {
+		t.Errorf("%v: %v filters in the optimized plan, want one above every table", sql, got)
+	}
+	checkOptimized(t, sql, 6)
+}
+
+func TestInferPredicatesKeepsOuterJoins(t *testing.T) {
+	//ON conditions never filter the preserved side, only the null-supplying side receives predicates
+	plan := inferPredicates(t, "select t.a, s.c from t left join s on t.a = s.a and t.a > 5")
+	if got := strings.Join(filterExprs(plan), " "); got != "(s.a>5)" {
+		t.Errorf("the filters are %v, want (s.a>5)", got)
+	}
+	plan = inferPredicates(t, "select t.a, s.c from t left join s on t.a = s.a and s.a > 5")
+	if got := strings.Join(filterExprs(plan), " "); got != "" {
+		t.Errorf("the filters are %v, the preserved side must not be filtered", got)
+	}
+	plan = inferPredicates(t, "select t.a, s.c from t left join s on t.a = s.a where s.a > 5")
+	if got := strings.Join(filterExprs(plan), " "); got != "(s.a>5)" {
+		t.Errorf("the filters are %v, the WHERE of an outer join must not be used", got)
+	}
+	checkOptimized(t, "select t.a, s.c from t left join s on t.a = s.a and t.a > 5", 12)
+	checkOptimized(t, "select t.a, s.c from t left join s on t.a = s.a and s.a > 5", 12)
+}
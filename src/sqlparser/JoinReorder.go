@@ -0,0 +1,444 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// JoinReorderDPThreshold : the join groups with at most this many relations are ordered by dynamic programming,
+// the larger ones by the greedy heuristic
+var JoinReorderDPThreshold = 10
+
+// JoinSet is a plan joining the relations of Rels, a leaf if Left is nil
+type JoinSet struct {
+	Rels        uint64
+	Leaf        int
+	Rows        float64
+	Cost        float64
+	Left, Right *JoinSet
+}
+
+// JoinCostModel estimate the cost of joining left and right into rows rows, the cost of a leaf is 0
+type JoinCostModel interface {
+	JoinCost(left, right *JoinSet, rows float64) float64
+}
+
+// COutCostModel is the sum of the sizes of the intermediate results
+type COutCostModel struct{}
+
+func (COutCostModel) JoinCost(left, right *JoinSet, rows float64) float64 {
+	return left.Cost + right.Cost + rows
+}
+
+// JoinCost is the cost model used by JoinReorder
+var JoinCost JoinCostModel = COutCostModel{}
+
+// JoinReorder reorders the groups of inner joins. A group is made of the adjacent inner joins and the Filter right
+// above them, its relations are the nodes under it which are not inner joins, so the outer joins keep their place
+// and are only reordered inside. The join graph has an edge for every conjunct of the ON conditions and of the
+// Filter, the conjuncts are placed back on the lowest join holding all their relations.
+// Return true if any group is reordered
+func (plan *LogicalPlan) JoinReorder() bool {
+	var modify = false
+	if IsInnerJoin(plan) || (plan.Tp == Filter && len(plan.child) == 1 && IsInnerJoin(&plan.child[0])) {
+		if JoinReorderForInstance(plan) {
+			modify = true
+		}
+		root := plan
+		for root.Tp == Filter || root.Tp == Project {
+			root = &root.child[0]
+		}
+		for _, leaf := range joinLeaves(root) {
+			if leaf.JoinReorder() {
+				modify = true
+			}
+		}
+		return modify
+	}
+	for i := range plan.child {
+		if plan.child[i].JoinReorder() {
+			modify = true
+		}
+	}
+	return modify
+}
+
+func IsInnerJoin(plan *LogicalPlan) bool {
+	if plan.Tp != Join || len(plan.child) != 2 {
+		return false
+	}
+	tp := plan.Content.(JoinNode).Tp
+	return tp == ast.CrossJoin || tp == InnerJoin
+}
+
+// joinLeaves return the relations of the group of inner joins rooted at root
+func joinLeaves(root *LogicalPlan) []*LogicalPlan {
+	if !IsInnerJoin(root) {
+		return []*LogicalPlan{root}
+	}
+	return append(joinLeaves(&root.child[0]), joinLeaves(&root.child[1])...)
+}
+
+// joinPredicate is a conjunct of the join graph, rels is the set of the relations it references
+type joinPredicate struct {
+	expr Expression
+	rels uint64
+	//for `a = b` between the columns of two relations
+	equi       bool
+	cols       [2]ColumnName
+	equiLeaves [2]int
+}
+
+type joinGroup struct {
+	leaves []LogicalPlan
+	schema []Schema
+	preds  []joinPredicate
+	rows   []float64
+}
+
+// JoinReorderForInstance : plan is an inner join or a Filter above one.
+// The rewrite is undone if the query ends up with a different schema or with more unresolved columns
+func JoinReorderForInstance(plan *LogicalPlan) bool {
+	var remained, conjuncts []Expression
+	root := plan
+	if plan.Tp == Filter {
+		for _, expr := range plan.Content.(WhereFilterNode).Expr {
+			if CheckExprDeterministic(expr) && len(GetExpressionColName(expr)) > 0 {
+				conjuncts = append(conjuncts, expr)
+			} else {
+				remained = append(remained, expr)
+			}
+		}
+		root = &plan.child[0]
+	}
+	g := new(joinGroup)
+	shape, ok := g.collect(root)
+	if !ok || len(g.leaves) < 3 || len(g.leaves) > 64 {
+		return false
+	}
+	for _, leaf := range g.leaves {
+		g.schema = append(g.schema, leaf.Schema())
+	}
+	for _, expr := range append(conjuncts, g.conjuncts(root)...) {
+		if !g.addPredicate(expr) {
+			return false
+		}
+	}
+	for i := range g.leaves {
+		g.rows = append(g.rows, EstimateRows(&g.leaves[i])*Selectivity(g.schema[i], g.leafPredicates(i)))
+	}
+
+	var best *JoinSet
+	if len(g.leaves) <= JoinReorderDPThreshold {
+		best = g.dpSize()
+	} else {
+		var sets []*JoinSet
+		for i := range g.leaves {
+			sets = append(sets, g.leaf(i))
+		}
+		best = g.greedy(sets)
+	}
+	if best.String() == shape && len(conjuncts) == 0 {
+		return false
+	}
+
+	querySchema, unresolved := treeRoot.Schema(), CountUnresolvedColumns(treeRoot)
+	saved := *plan
+	if plan.Tp == Filter {
+		//saved keeps the old child
+		plan.child = append([]LogicalPlan{}, plan.child...)
+		root = &plan.child[0]
+	}
+	output := root.Schema()
+	newNode := g.build(best, make([]bool, len(g.preds)), true)
+	newNode.parent = root.parent
+	*root = newNode
+	if !SameSchema(output, root.Schema()) {
+		//restore the order of the columns
+		var cols []Expression
+		schema := root.Schema()
+		for _, c := range output.Columns {
+			col := ColumnName{OrigTblName: c.OrigTblName, OrigColName: c.OrigColName, TblName: c.TblName, ColName: c.ColName}
+			if schema.ResolveColumn(col) == -1 {
+				*plan = saved
+				treeRoot.ResetParent()
+				return false
+			}
+			cols = append(cols, ColumnExpression(col))
+		}
+		proj := OpNodeInit(Project, ProjectionNode{cols})
+		proj.child = []LogicalPlan{*root}
+		proj.parent = root.parent
+		*root = *proj
+	}
+	if plan.Tp == Filter {
+		if len(remained) > 0 {
+			plan.Content = WhereFilterNode{Expr: remained}
+		} else {
+			par := plan.parent
+			*plan = plan.child[0]
+			plan.parent = par
+		}
+	}
+	treeRoot.ResetParent()
+	if !SameSchema(querySchema, treeRoot.Schema()) || CountUnresolvedColumns(treeRoot) > unresolved {
+		*plan = saved
+		treeRoot.ResetParent()
+		return false
+	}
+
+	ruleApplied("Join Reorder")
+	return true
+}
+
+// collect the relations of the group rooted at plan, return the shape of the group like ((0,1),2)
+func (g *joinGroup) collect(plan *LogicalPlan) (string, bool) {
+	if !IsInnerJoin(plan) {
+		g.leaves = append(g.leaves, *plan)
+		return strconv.Itoa(len(g.leaves) - 1), true
+	}
+	for _, expr := range plan.Content.(JoinNode).On {
+		if !CheckExprDeterministic(expr) {
+			return "", false
+		}
+	}
+	left, ok1 := g.collect(&plan.child[0])
+	right, ok2 := g.collect(&plan.child[1])
+	return "(" + left + "," + right + ")", ok1 && ok2
+}
+
+// conjuncts return the ON conditions of the group rooted at plan
+func (g *joinGroup) conjuncts(plan *LogicalPlan) []Expression {
+	if !IsInnerJoin(plan) {
+		return nil
+	}
+	ret := append([]Expression{}, plan.Content.(JoinNode).On...)
+	return append(append(ret, g.conjuncts(&plan.child[0])...), g.conjuncts(&plan.child[1])...)
+}
+
+// relation return the index of the relation producing col, -1 if none or more than one does
+func (g *joinGroup) relation(col ColumnName) int {
+	ret := -1
+	for i, schema := range g.schema {
+		if schema.ResolveColumn(col) != -1 {
+			if ret != -1 {
+				return -1
+			}
+			ret = i
+		}
+	}
+	return ret
+}
+
+func (g *joinGroup) addPredicate(expr Expression) bool {
+	p := joinPredicate{expr: expr}
+	for _, col := range GetExpressionColName(expr) {
+		i := g.relation(col)
+		if i == -1 {
+			return false
+		}
+		p.rels |= 1 << uint(i)
+	}
+	e := expr.expr
+	if len(e) == 3 && expr.IsColumnDatum(e[0]) && expr.IsColumnDatum(e[1]) && e[2].Args == nil &&
+		StrToOp(e[2].GetString()) == EQ {
+		p.cols = [2]ColumnName{expr.Fields[e[0].GetString()], expr.Fields[e[1].GetString()]}
+		p.equiLeaves = [2]int{g.relation(p.cols[0]), g.relation(p.cols[1])}
+		p.equi = p.equiLeaves[0] != p.equiLeaves[1]
+	}
+	g.preds = append(g.preds, p)
+	return true
+}
+
+// allColumns return the columns of all the relations
+func (g *joinGroup) allColumns() Schema {
+	var ret Schema
+	for _, schema := range g.schema {
+		ret.Columns = append(ret.Columns, schema.Columns...)
+	}
+	return ret
+}
+
+func (g *joinGroup) leafPredicates(i int) []Expression {
+	var ret []Expression
+	for _, p := range g.preds {
+		if p.rels == 1<<uint(i) {
+			ret = append(ret, p.expr)
+		}
+	}
+	return ret
+}
+
+func (g *joinGroup) leaf(i int) *JoinSet {
+	return &JoinSet{Rels: 1 << uint(i), Leaf: i, Rows: g.rows[i]}
+}
+
+// connected check whether a conjunct references both s1 and s2 and nothing else
+func (g *joinGroup) connected(s1, s2 uint64) bool {
+	for _, p := range g.preds {
+		if p.rels&s1 != 0 && p.rels&s2 != 0 && p.rels&^(s1|s2) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// join build the plan joining s1 and s2, the larger side is put on the left
+func (g *joinGroup) join(s1, s2 *JoinSet) *JoinSet {
+	if s2.Rows > s1.Rows {
+		s1, s2 = s2, s1
+	}
+	rows := s1.Rows * s2.Rows
+	for _, p := range g.preds {
+		if p.rels&s1.Rels == 0 || p.rels&s2.Rels == 0 || p.rels&^(s1.Rels|s2.Rels) != 0 {
+			continue
+		}
+		if p.equi {
+			ndv := 1.0
+			for k, i := range p.equiLeaves {
+				ndv = math.Max(ndv, math.Min(EstimateNDV(&g.leaves[i], p.cols[k]), g.rows[i]))
+			}
+			rows /= ndv
+		} else {
+			rows *= Selectivity(g.allColumns(), []Expression{p.expr})
+		}
+	}
+	return &JoinSet{Rels: s1.Rels | s2.Rels, Rows: rows, Cost: JoinCost.JoinCost(s1, s2, rows), Left: s1, Right: s2}
+}
+
+// dpSize enumerate the plans by increasing number of relations, only joining connected sets.
+// The connected components of the join graph are then joined by the greedy heuristic
+func (g *joinGroup) dpSize() *JoinSet {
+	n := len(g.leaves)
+	best := make(map[uint64]*JoinSet)
+	bySize := make([][]uint64, n+1)
+	for i := 0; i < n; i++ {
+		best[1<<uint(i)] = g.leaf(i)
+		bySize[1] = append(bySize[1], 1<<uint(i))
+	}
+	for size := 2; size <= n; size++ {
+		for k := 1; k <= size/2; k++ {
+			for _, s1 := range bySize[k] {
+				for _, s2 := range bySize[size-k] {
+					if s1&s2 != 0 || (k == size-k && s1 > s2) || !g.connected(s1, s2) {
+						continue
+					}
+					candidate := g.join(best[s1], best[s2])
+					old, ok := best[s1|s2]
+					if !ok {
+						bySize[size] = append(bySize[size], s1|s2)
+					}
+					if !ok || candidate.Cost < old.Cost {
+						best[s1|s2] = candidate
+					}
+				}
+			}
+		}
+	}
+	var components []*JoinSet
+	for _, comp := range g.components() {
+		if s, ok := best[comp]; ok {
+			components = append(components, s)
+			continue
+		}
+		//a conjunct on more than two relations may leave a component unreachable by pairs
+		var sets []*JoinSet
+		for i := 0; i < n; i++ {
+			if comp&(1<<uint(i)) != 0 {
+				sets = append(sets, g.leaf(i))
+			}
+		}
+		components = append(components, g.greedy(sets))
+	}
+	return g.greedy(components)
+}
+
+// components return the relation sets of the connected components of the join graph
+func (g *joinGroup) components() []uint64 {
+	var ret []uint64
+	for i := range g.leaves {
+		ret = append(ret, 1<<uint(i))
+	}
+	for _, p := range g.preds {
+		var merged uint64
+		var rest []uint64
+		for _, comp := range ret {
+			if comp&p.rels != 0 {
+				merged |= comp
+			} else {
+				rest = append(rest, comp)
+			}
+		}
+		if merged != 0 {
+			ret = append(rest, merged)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return bits.TrailingZeros64(ret[i]) < bits.TrailingZeros64(ret[j]) })
+	return ret
+}
+
+// greedy join the pair of sets of the lowest cost until one set is left, connected pairs come first so cross
+// products are only used when the graph is not connected
+func (g *joinGroup) greedy(sets []*JoinSet) *JoinSet {
+	for len(sets) > 1 {
+		var best *JoinSet
+		bi, bj, bestConnected := -1, -1, false
+		for i := range sets {
+			for j := i + 1; j < len(sets); j++ {
+				connected := g.connected(sets[i].Rels, sets[j].Rels)
+				if bestConnected && !connected {
+					continue
+				}
+				candidate := g.join(sets[i], sets[j])
+				if best == nil || (connected && !bestConnected) || candidate.Cost < best.Cost {
+					best, bi, bj, bestConnected = candidate, i, j, connected
+				}
+			}
+		}
+		sets[bi] = best
+		sets = append(sets[:bj], sets[bj+1:]...)
+	}
+	return sets[0]
+}
+
+// build the plan of s, every conjunct is placed on the lowest node holding all its relations.
+// The conjuncts on one relation go into a Filter above it, those on no relation go to the top join
+func (g *joinGroup) build(s *JoinSet, placed []bool, top bool) LogicalPlan {
+	var exprs []Expression
+	for i, p := range g.preds {
+		if placed[i] || p.rels&^s.Rels != 0 || (p.rels == 0 && !top) {
+			continue
+		}
+		if s.Left == nil || (p.rels&^s.Left.Rels != 0 && p.rels&^s.Right.Rels != 0) || (top && p.rels == 0) {
+			exprs = append(exprs, p.expr)
+			placed[i] = true
+		}
+	}
+	if s.Left == nil {
+		leaf := g.leaves[s.Leaf]
+		if len(exprs) == 0 {
+			return leaf
+		}
+		filter := OpNodeInit(Filter, WhereFilterNode{Expr: exprs})
+		filter.child = []LogicalPlan{leaf}
+		return *filter
+	}
+	var tp ast.JoinType = InnerJoin
+	if len(exprs) == 0 {
+		tp = ast.CrossJoin
+	}
+	newNode := OpNodeInit(Join, JoinNode{Tp: tp, On: exprs})
+	newNode.child = []LogicalPlan{g.build(s.Left, placed, false), g.build(s.Right, placed, false)}
+	return *newNode
+}
+
+// String print the shape of s like ((0,1),2)
+func (s *JoinSet) String() string {
+	if s.Left == nil {
+		return strconv.Itoa(s.Leaf)
+	}
+	return "(" + s.Left.String() + "," + s.Right.String() + ")"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/parser/ast"
+)
+
+// crossJoins return the number of Joins of plan without condition
+func crossJoins(plan *LogicalPlan) int {
+	ret := 0
+	if plan.Tp == Join && len(plan.Content.(JoinNode).On) == 0 {
+		ret++
+	}
+	for i := range plan.child {
+		ret += crossJoins(&plan.child[i])
+	}
+	return ret
+}
+
+// countingCostModel is the COutCostModel counting its calls
+type countingCostModel struct {
+	calls int
+}
+
+func (m *countingCostModel) JoinCost(left, right *JoinSet, rows float64) float64 {
+	m.calls++
+	return COutCostModel{}.JoinCost(left, right, rows)
+}
+
+func TestJoinReorder(t *testing.T) {
+	tests := []struct {
+		sql          string
+		joins, cross int
+		rows         int
+	}{
+		//t and testdata2 are only connected through s
+		{"select t.a, testdata2.b from t, testdata2, s where t.a = s.a and s.a = testdata2.a", 2, 0, 6},
+		{"select t.a from t join testdata2 join s on t.a = s.a and s.a = testdata2.a where testdata2.b > 1", 2, 0, 3},
+		//two connected components are joined last
+		{"select t.a, t3.a from t, t3, s, t4 where t.a = s.a and t3.a = t4.c", 3, 1, 8},
+	}
+	threshold := JoinReorderDPThreshold
+	defer func() { JoinReorderDPThreshold = threshold }()
+	for _, dp := range []int{threshold, 0} {
+		JoinReorderDPThreshold = dp
+		for _, test := range tests {
+			checkOptimized(t, test.sql, test.rows)
+			_, plan := optimizeQuery(t, test.sql)
+			if got := crossJoins(plan); got != test.cross {
+				t.Errorf("%v: %d joins without condition, want %d", test.sql, got, test.cross)
+			}
+			if got := countPlans(plan, Join); got != test.joins {
+				t.Errorf("%v: %d joins, want %d", test.sql, got, test.joins)
+			}
+		}
+	}
+}
+
+func TestJoinReorderKeepsOuterJoins(t *testing.T) {
+	sql := "select t.a from t left join s on t.a = s.a join testdata2 on t.a = testdata2.a join t3 on t3.a = testdata2.a"
+	checkOptimized(t, sql, 11)
+	_, plan := optimizeQuery(t, sql)
+	left := findPlan(plan, Join)
+	for left != nil && left.Content.(JoinNode).Tp != ast.LeftJoin {
+		left = findPlan(&left.child[0], Join)
+	}
+	if left == nil || formatSchema(left.child[0].Schema()) != "t.a:int64 t.b:int64?" ||
+		formatSchema(left.child[1].Schema()) != "s.a:int64? s.c:string?" {
+		t.Errorf("%v: the left join of t and s is changed", sql)
+	}
+}
+
+func TestJoinCostModel(t *testing.T) {
+	cost := JoinCost
+	model := new(countingCostModel)
+	JoinCost = model
+	defer func() { JoinCost = cost }()
+	checkOptimized(t, "select t.a from t, testdata2, s where t.a = s.a and s.a = testdata2.a", 6)
+	if model.calls == 0 {
+		t.Error("the cost model is not used")
+	}
+}
@@ -1,146 +1,222 @@
 package main
 
 import (
-	"fmt"
 	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
 )
 
-func (plan *LogicalPlan) LimitPushDown() (bool, *LogicalPlan) {
-	var ret = false
-	for {
-		var modify = false
-		modify = modify || LimitPushDownToProject(plan)
-		modify = modify || LimitPushDownToJoin(plan)
-		if !modify {
-			break
-		} else {
-			ret = true
+// LimitPushDown pushes the Limit nodes down the plan, the same way TopNPushDown does for the TopN nodes:
+//
+//	through a Project or a derived table, the Limit is moved below;
+//	into the preserved side of an outer join and into each branch of a UNION ALL, a Limit of count+offset
+//	rows without offset is copied, the Limit above keeps the offset;
+//	a Limit right above another Limit or a TopN is merged into it when the counts and offsets are constants.
+//
+// Return true if any Limit is pushed
+func (plan *LogicalPlan) LimitPushDown() bool {
+	var modify = false
+	for plan.Tp == Limit && len(plan.child) == 1 && LimitPushDownForInstance(plan) {
+		modify = true
+	}
+	for i := range plan.child {
+		if plan.child[i].LimitPushDown() {
+			modify = true
 		}
 	}
-	return ret, plan.LogicalPlanFindRoot()
+	return modify
 }
 
-func LimitPushDownToProject(root *LogicalPlan) bool {
-	if root == nil {
-		return false
-	}
-	cur := root
+// LimitPushDownForInstance : limit.Tp = Limit. Return true if limit is changed,
+// when limit is moved or merged, the node now holds what was below it
+func LimitPushDownForInstance(limit *LogicalPlan) bool {
+	child := &limit.child[0]
 	var modify = false
-	for {
-		tmp := &cur.child[0]
-		if cur.Tp == Limit {
-			if dst, ok := FindLogicalPlanInSingleChain(cur, Project); ok {
-				LimitPush2ProjectForInstance(cur, dst)
-				modify = true
-			}
-		}
-		cur = tmp
-		if len(cur.child) != 1 {
-			break
+	switch child.Tp {
+	case Limit, TopN:
+		modify = MergeLimit(limit, child)
+	case Project:
+		modify = LimitPush2ProjectForInstance(limit, child)
+	case Table:
+		modify = LimitPush2DerivedTable(limit, child)
+	case Join:
+		if flag, side := CanLimitPush2Join(limit, child); flag {
+			LimitPush2JoinForInstance(limit, child, side)
+			modify = true
 		}
+	case Union:
+		modify = LimitPush2Union(limit, child)
 	}
-	for _, child := range cur.child {
-		modify = modify || PredicatePush2Aggregate(&child)
+	if !modify {
+		return false
 	}
-	return modify
+	treeRoot.ResetParent()
+	ruleApplied("Limit Push Down")
+	return true
 }
 
-func LimitPush2ProjectForInstance(limit, proj *LogicalPlan) {
-	if limit.child[0].Tp == OrderBy {
-		order := &limit.child[0]
-		order.LogicalPlanDelete()
-		proj.LogicalPlanInsert(order)
-	}
-	limit.LogicalPlanDelete()
-	proj.LogicalPlanInsert(limit)
-
-	treeRoot = treeRoot.LogicalPlanFindRoot()
-	fmt.Printf("Limit Push Down to Project\n")
-	OutputQuery(treeRoot, 0)
+// LimitPush2ProjectForInstance move limit below proj, unless proj computes aggregates and returns a single row
+func LimitPush2ProjectForInstance(limit, proj *LogicalPlan) bool {
+	if len(proj.child) != 1 || hasAggregate(proj.Content.(ProjectionNode).cols) {
+		return false
+	}
+	swapWithChild(limit)
+	return true
 }
 
-func LimitPushDownToJoin(root *LogicalPlan) bool {
-	if root == nil {
+// LimitPush2DerivedTable move limit into the derived table tbl, which only renames the columns
+func LimitPush2DerivedTable(limit, tbl *LogicalPlan) bool {
+	if !tbl.IsDerivedTable() {
 		return false
 	}
-	cur := root
-	var modify = false
-	for {
-		tmp := &cur.child[0]
-		if cur.Tp == Limit {
-			if dst, ok := FindLogicalPlanInSingleChain(cur, Join); ok {
-				if flag, place := CanLimitPush2Join(cur, dst); flag {
-					LimitPush2JoinForInstance(cur, dst, place)
-					cur.Content = LimitNode{
-						Count:   cur.Content.(LimitNode).Count,
-						Offset:  cur.Content.(LimitNode).Offset,
-						hasPush: true,
-					}
-					modify = true
-				}
+	swapWithChild(limit)
+	return true
+}
 
-			}
-		}
-		cur = tmp
-		if len(cur.child) != 1 {
-			break
-		}
-	}
-	for _, child := range cur.child {
-		modify = modify || PredicatePush2Aggregate(&child)
-	}
-	return modify
+// swapWithChild exchange the single-child node plan with its single child
+func swapWithChild(plan *LogicalPlan) {
+	child := plan.child[0]
+	node := *plan
+	node.child = child.child
+	child.child = []LogicalPlan{node}
+	child.parent = plan.parent
+	*plan = child
 }
 
+// CanLimitPush2Join return whether limit can be copied below join and the index of the preserved side
 func CanLimitPush2Join(limit, join *LogicalPlan) (bool, int) {
-	if limit.child[0].Tp != OrderBy || limit.Content.(LimitNode).hasPush {
+	if limit.Content.(LimitNode).hasPush || len(join.child) != 2 {
 		return false, 0
 	}
-	order := limit.child[0]
-	j := join.Content.(JoinNode)
-	attributes := order.Content.(OrderByNode).Items
-	switch j.Tp {
-	case ast.CrossJoin:
-		return false, 0
+	switch join.Content.(JoinNode).Tp {
 	case ast.LeftJoin:
-		for _, item := range attributes {
-			for _, v := range item.Item.Fields {
-				if !TableInSubLogicalPlan(&join.child[0], v.OrigTblName) {
-					return false, 0
-				}
-			}
-		}
-		return true, 1
+		return true, 0
 	case ast.RightJoin:
-		for _, item := range attributes {
-			for _, v := range item.Item.Fields {
-				if !TableInSubLogicalPlan(&join.child[1], v.OrigTblName) {
-					return false, 0
-				}
-			}
+		return true, 1
+	}
+	return false, 0
+}
+
+// LimitPush2JoinForInstance copy limit into the preserved side of join. Every row of that side appears
+// at least once in the output, so count+offset rows of it are enough to produce the rows kept by limit
+func LimitPush2JoinForInstance(limit, join *LogicalPlan, side int) {
+	n := limit.Content.(LimitNode)
+	newNode := OpNodeInit(Limit, LimitNode{
+		Count: AddLimitExpression(n.Count, n.Offset),
+		Offset: Expression{
+			Fields: make(map[string]ColumnName),
+		},
+	})
+	newNode.child = []LogicalPlan{join.child[side]}
+	join.child[side] = *newNode
+	n.hasPush = true
+	limit.Content = n
+}
+
+// LimitPush2Union copy limit into every branch of a UNION ALL
+func LimitPush2Union(limit, union *LogicalPlan) bool {
+	n := limit.Content.(LimitNode)
+	if n.hasPush || !union.Content.(UnionNode).All {
+		return false
+	}
+	for i := range union.child {
+		newNode := OpNodeInit(Limit, LimitNode{
+			Count: AddLimitExpression(n.Count, n.Offset),
+			Offset: Expression{
+				Fields: make(map[string]ColumnName),
+			},
+		})
+		newNode.child = []LogicalPlan{union.child[i]}
+		union.child[i] = *newNode
+	}
+	n.hasPush = true
+	limit.Content = n
+	return true
+}
+
+// MergeLimit merge limit into child, a Limit or a TopN. Skipping o1 rows then taking n1 rows of the n2 rows
+// after the o2 first ones is taking min(n1, n2-o1) rows after the o1+o2 first ones
+func MergeLimit(limit, child *LogicalPlan) bool {
+	n := limit.Content.(LimitNode)
+	n1, ok1 := LimitValue(n.Count)
+	o1, ok2 := LimitValue(n.Offset)
+	var count, offset Expression
+	switch c := child.Content.(type) {
+	case LimitNode:
+		count, offset = c.Count, c.Offset
+	case TopNNode:
+		count, offset = c.Count, c.Offset
+	}
+	n2, ok3 := LimitValue(count)
+	o2, ok4 := LimitValue(offset)
+	if !ok1 || !ok2 || !ok3 || !ok4 || o1+o2 < o1 {
+		return false
+	}
+	rest := uint64(0)
+	if n2 > o1 {
+		rest = n2 - o1
+	}
+	if n1 < rest {
+		rest = n1
+	}
+	count, offset = LimitExpression(rest), LimitExpression(o1+o2)
+	if o1+o2 == 0 {
+		offset.expr = nil
+	}
+	switch c := child.Content.(type) {
+	case LimitNode:
+		c.Count, c.Offset = count, offset
+		child.Content = c
+	case TopNNode:
+		c.Count, c.Offset = count, offset
+		child.Content = c
+	}
+	par := limit.parent
+	*limit = *child
+	limit.parent = par
+	return true
+}
+
+// LimitValue evaluate the constant count or offset of a LIMIT, an empty offset is 0
+func LimitValue(expr Expression) (uint64, bool) {
+	if len(expr.expr) == 0 {
+		return 0, true
+	}
+	t := simplifyTree(expr.Tree(), false)
+	if !t.IsConstant() {
+		return 0, false
+	}
+	switch t.Value.Kind() {
+	case test_driver.KindInt64:
+		if t.Value.GetInt64() < 0 {
+			return 0, false
 		}
-		return true, 2
-	default:
-		LogFuncName()
-		panic("Error")
+		return uint64(t.Value.GetInt64()), true
+	case test_driver.KindUint64:
+		return t.Value.GetUint64(), true
 	}
+	return 0, false
 }
 
-func LimitPush2JoinForInstance(limit, join *LogicalPlan, choice int) {
-	prev := &join.child[choice-1]
-	if limit.child[0].Tp == OrderBy {
-		newNode := OpNodeInit(OrderBy, limit.child[0].Content)
-		newNode.child = append(newNode.child, *prev)
-		prev.parent = newNode
-		prev = prev.parent
-	}
-	newNode := OpNodeInit(Limit, limit.Content)
-	newNode.child = append(newNode.child, *prev)
-	prev.parent = newNode
-	join.child[choice-1] = *newNode
-	newNode.parent = join
+// LimitExpression build the constant expression of a count or offset
+func LimitExpression(v uint64) Expression {
+	return Expression{expr: []Datum{InitSetValue(v)}, Fields: make(map[string]ColumnName)}
+}
 
-	treeRoot = treeRoot.LogicalPlanFindRoot()
-	fmt.Printf("Limit Push Down to Join\n")
-	OutputQuery(treeRoot, 0)
+// AddLimitExpression return count+offset, evaluated if both are constants
+func AddLimitExpression(count, offset Expression) Expression {
+	if len(offset.expr) == 0 {
+		return CopyExpression(count)
+	}
+	n, ok1 := LimitValue(count)
+	o, ok2 := LimitValue(offset)
+	if ok1 && ok2 && n+o >= n {
+		return LimitExpression(n + o)
+	}
+	ret := CopyExpression(count)
+	ret.expr = append(ret.expr, CopyExpression(offset).expr...)
+	ret.expr = append(ret.expr, InitSetValue(Ops[Plus].Name))
+	for k, v := range offset.Fields {
+		ret.Fields[k] = v
+	}
+	return ret
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// limits print the Limit nodes of plan in pre-order
+func limits(plan *LogicalPlan) string {
+	var ret []string
+	var walk func(p *LogicalPlan)
+	walk = func(p *LogicalPlan) {
+		if p.Tp == Limit {
+			n := p.Content.(LimitNode)
+			str := n.Count.print()
+			if len(n.Offset.expr) > 0 {
+				str = n.Offset.print() + "," + str
+			}
+			ret = append(ret, str)
+		}
+		for i := range p.child {
+			walk(&p.child[i])
+		}
+	}
+	walk(plan)
+	return strings.Join(ret, " ")
+}
+
+func TestLimitPushDown(t *testing.T) {
+	tests := []struct {
+		sql    string
+		limits string
+		want   []string
+	}{
+		{"select a from t limit 2, 3", "2,3", []string{"3", "4", "5"}},
+		{"select x.a from (select a + 1 as a from t) x limit 1, 2", "1,2", []string{"3", "4"}},
+		//the preserved side keeps the rows skipped by the offset
+		{"select t.a, s.c from t left join s on t.a = s.a limit 2, 3", "2,3 5", []string{"3, NULL", "4, four", "4, quatre"}},
+		{"select s.c, t.a from t right join s on t.a = s.a limit 1", "1 1", []string{"two, 2"}},
+		{"select a from t union all select a from s limit 1, 3", "1,3 4 4", []string{"2", "3", "4"}},
+		{"select a from t limit 20, 3", "20,3", nil},
+		//an inner join may drop the rows of both sides
+		{"select t.a from t join s on t.a = s.a limit 1", "1", []string{"2"}},
+		//a scalar aggregate reads its whole input
+		{"select count(*) from t limit 1", "1", []string{"12"}},
+	}
+	for _, test := range tests {
+		checkOrdered(t, test.sql, test.want...)
+		_, plan := optimizeQuery(t, test.sql)
+		if got := limits(plan); got != test.limits {
+			t.Errorf("%v: the limits are %v, want %v", test.sql, got, test.limits)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"fmt"
 	"github.com/pingcap/tidb/parser/test_driver"
+	"strings"
 )
 
 // PushPredicateThroughNonJoin : return true means push down successfully
@@ -12,6 +12,7 @@ func (plan *LogicalPlan) PushPredicateThroughNonJoin() bool {
 		var modify = false
 		modify = modify || PredicatePush2Project(plan)
 		modify = modify || PredicatePush2Aggregate(plan)
+		modify = modify || HavingPush2Aggregate(plan)
 		if !modify {
 			break
 		} else {
@@ -31,8 +32,7 @@ func PredicatePush2Project(root *LogicalPlan) bool {
 	for {
 		tmp := &cur.child[0]
 		if cur.Tp == Filter && len(cur.child[0].child) == 1 && cur.child[0].child[0].Tp == Project {
-			if CanPredicatePush2Project(cur) {
-				PredicatePush2ProjectForInstance(cur)
+			if CanPredicatePush2Project(cur) && PredicatePush2ProjectForInstance(cur) {
 				modify = true
 			}
 		}
@@ -47,27 +47,54 @@ func PredicatePush2Project(root *LogicalPlan) bool {
 	return modify
 }
 
+// CanPredicatePush2Project : the filter must be above a derived table, whose columns can be mapped
+// to the expressions of the Project, and the Project must not be a scalar aggregate
 func CanPredicatePush2Project(root *LogicalPlan) bool {
-	if CheckFieldsDeterministic(root.child[0].child[0].Content.(ProjectionNode).cols) {
-		return true
-	} else {
-		return false
+	return root.child[0].IsDerivedTable() && !root.child[0].child[0].IsScalarAggregate()
+}
+
+// IsScalarAggregate check whether plan computes aggregates without GROUP BY. It returns one row even
+// when its input is empty, so no predicate can go below it, not even one without columns
+func (plan *LogicalPlan) IsScalarAggregate() bool {
+	switch n := plan.Content.(type) {
+	case ProjectionNode:
+		return hasAggregate(n.cols)
+	case AggregateNode:
+		return len(n.Items) == 0
 	}
+	return false
 }
 
-// PredicatePush2ProjectForInstance : root.Tp = Filter, root.child[0].Child[0].Tp = Project
-func PredicatePush2ProjectForInstance(root *LogicalPlan) {
+// PredicatePush2ProjectForInstance : root.Tp = Filter, root.child[0] is a derived table, root.child[0].Child[0].Tp = Project
+// The conjuncts are rewritten in terms of the input of the Project, those which can not be rewritten are kept
+func PredicatePush2ProjectForInstance(root *LogicalPlan) bool {
 	if root.Tp != Filter || root.child[0].child[0].Tp != Project {
 		LogFuncName()
 		panic("Error Root Node When Predicate push down")
 	}
-	child := &root.child[0].child[0]
-	root.LogicalPlanDelete()
-	child.LogicalPlanInsert(root)
-
-	fmt.Printf("Predicate Push Down to Project\n")
-	OutputQuery(treeRoot, 0)
+	tbl := &root.child[0]
+	var pushDown, remained []Expression
+	for _, expr := range root.Content.(WhereFilterNode).Expr {
+		if newExpr, ok := MapThroughDerivedTable(expr, tbl, false); ok {
+			pushDown = append(pushDown, newExpr)
+		} else {
+			remained = append(remained, expr)
+		}
+	}
+	if len(pushDown) == 0 {
+		return false
+	}
+	child := &tbl.child[0]
+	child.LogicalPlanInsert(OpNodeInit(Filter, WhereFilterNode{Expr: pushDown}))
+	if len(remained) > 0 {
+		root.Content = WhereFilterNode{Expr: remained}
+	} else {
+		root.LogicalPlanDelete()
+	}
+	treeRoot.ResetParent()
 
+	ruleApplied("Predicate Push Down to Project")
+	return true
 }
 
 // CheckFieldsDeterministic checks the exprs whether is deterministic
@@ -87,6 +114,9 @@ func CheckExprDeterministic(expr Expression) bool {
 			str := datum.GetString()
 			if _, ok := expr.Fields[str]; !ok {
 				//Datum is a Function
+				if datum.Args != nil && IsNonDeterministicFunction(str) {
+					return false
+				}
 				if !CheckFieldsDeterministic(datum.Args) {
 					return false
 				}
@@ -129,7 +159,7 @@ func PredicatePush2Aggregate(root *LogicalPlan) bool {
 }
 
 func CanPush2Aggregator(aggregate *LogicalPlan) bool {
-	return CheckFieldsDeterministic(aggregate.Content.(AggregateNode).ProjectionNode.cols)
+	return !aggregate.IsScalarAggregate() && CheckFieldsDeterministic(aggregate.Content.(AggregateNode).ProjectionNode.cols)
 }
 
 func PredicatePush2AggregatorForInstance(filter, aggregate *LogicalPlan) bool {
@@ -154,10 +184,12 @@ func PredicatePush2AggregatorForInstance(filter, aggregate *LogicalPlan) bool {
 			}
 		}
 		if flag {
-			pushDown = append(pushDown, expr)
-		} else {
-			rest = append(rest, expr)
+			if newExpr, ok := MapPredicate2Aggregate(expr, filter, aggregate); ok {
+				pushDown = append(pushDown, newExpr)
+				continue
+			}
 		}
+		rest = append(rest, expr)
 	}
 	//rest和nonDeterministic合并
 	remained := append(nonDeterministic, rest...)
@@ -167,21 +199,114 @@ func PredicatePush2AggregatorForInstance(filter, aggregate *LogicalPlan) bool {
 		//push down
 		newNode := OpNodeInit(Filter, WhereFilterNode{Expr: pushDown})
 		aggregate.LogicalPlanInsert(newNode)
+		treeRoot.ResetParent()
 
-		fmt.Printf("Predicate Push Down to Aggregator\n")
-		OutputQuery(treeRoot, 0)
+		ruleApplied("Predicate Push Down to Aggregator")
 		return true
 	} else {
 		return false
 	}
 }
 
+// HavingPush2Aggregate move the conjuncts of the HAVING clauses which only use group by columns into a Filter
+// below their Aggregate, the conjuncts using aggregates stay in HAVING
+func HavingPush2Aggregate(root *LogicalPlan) bool {
+	var modify = false
+	for i := range root.child {
+		if HavingPush2Aggregate(&root.child[i]) {
+			modify = true
+		}
+	}
+	if root.Tp == HavingFilter && len(root.child) == 1 && root.child[0].Tp == Aggregate &&
+		CanPush2Aggregator(&root.child[0]) && HavingPush2AggregateForInstance(root, &root.child[0]) {
+		modify = true
+	}
+	return modify
+}
+
+// HavingPush2AggregateForInstance : having.Tp = HavingFilter, aggregate.Tp = Aggregate is its child.
+// The columns of a conjunct are either output columns of aggregate, replaced by their definitions, or
+// group by columns, the same way as PredicatePush2AggregatorForInstance
+func HavingPush2AggregateForInstance(having, aggregate *LogicalPlan) bool {
+	var remained, pushDown []Expression
+	items := aggregate.Content.(AggregateNode).Items
+	for _, expr := range having.Content.(HavingFilterNode).Expr {
+		if CheckExprDeterministic(expr) && !AggregatorInExpression(expr) && len(items) > 0 {
+			if newExpr, ok := MapThroughProject(expr, aggregate); ok {
+				var flag = true
+				for _, col := range GetExpressionColName(newExpr) {
+					if !GroupByContains(items, col) {
+						flag = false
+						break
+					}
+				}
+				if flag {
+					pushDown = append(pushDown, newExpr)
+					continue
+				}
+			}
+		}
+		remained = append(remained, expr)
+	}
+	if len(pushDown) == 0 {
+		return false
+	}
+	aggregate.LogicalPlanInsert(OpNodeInit(Filter, WhereFilterNode{Expr: pushDown}))
+	if len(remained) > 0 {
+		having.Content = HavingFilterNode{Expr: remained}
+	} else {
+		par := having.parent
+		*having = having.child[0]
+		having.parent = par
+	}
+	treeRoot.ResetParent()
+
+	ruleApplied("Having Push Down to Where")
+	return true
+}
+
+// MapPredicate2Aggregate rewrite the conjunct of filter in terms of the input of aggregate,
+// filter must be right above the derived table of aggregate and only group by columns may be referenced
+func MapPredicate2Aggregate(expr Expression, filter, aggregate *LogicalPlan) (Expression, bool) {
+	tbl := &filter.child[0]
+	if !tbl.IsDerivedTable() || &tbl.child[0] != aggregate {
+		return expr, false
+	}
+	newExpr, ok := MapThroughDerivedTable(expr, tbl, false)
+	if !ok {
+		return expr, false
+	}
+	items := aggregate.Content.(AggregateNode).Items
+	for _, col := range GetExpressionColName(newExpr) {
+		if !GroupByContains(items, col) {
+			return expr, false
+		}
+	}
+	return newExpr, true
+}
+
+// GroupByContains check whether col is one of the group by items
+func GroupByContains(items []Expression, col ColumnName) bool {
+	for _, item := range items {
+		if c, ok := SingleColumn(item); ok && strings.EqualFold(c.ColName, col.ColName) &&
+			(c.TblName == "" || col.TblName == "" || strings.EqualFold(c.TblName, col.TblName)) {
+			return true
+		}
+	}
+	return false
+}
+
 func AggregatorInExpression(expr Expression) bool {
 	for _, e := range expr.expr {
 		if e.Args != nil && e.Kind() == test_driver.KindString {
 			if IsAggregateFunction(e.GetString()) {
 				return true
 			}
+			for _, arg := range e.Args {
+				if AggregatorInExpression(arg) {
+					return true
+				}
+			}
 		}
 	}
 	return false
@@ -197,11 +322,18 @@ func GetAggregateFunctions(aggregate []Expression) []string {
 	return ret
 }
 
+// IsAggregateFunction check whether f is registered as an aggregate function
 func IsAggregateFunction(f string) bool {
-	switch f {
-	case "count", "sum", "avg", "max", "min",
-		"COUNT", "SUM", "AVG", "MAX", "MIN":
-		return true
+	def, ok := LookupFunction(f)
+	return ok && def.Kind == AggregateFunction
+}
+
+// IsNonDeterministicFunction check whether f is volatile, the functions missing from the registry are volatile.
+// Operators kept as functions like not or in are deterministic
+func IsNonDeterministicFunction(f string) bool {
+	if StrToOp(f) != -1 {
+		return false
 	}
-	return false
+	def, ok := LookupFunction(f)
+	return !ok || def.Volatile
 }
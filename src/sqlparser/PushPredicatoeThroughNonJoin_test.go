@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPredicatePushKeepsScalarAggregates(t *testing.T) {
+	//a scalar aggregate returns one row even when its input is empty, no predicate may go below it
+	for _, sql := range []string{
+		"select x.m from (select max(a) as m from t) x where 1 = 0",
+		"select x.m from (select max(a) as m from t) x where lower(lower('')) = 'ab'",
+		"select x.c from (select count(*) as c from t) x where 1 = 0",
+		"select x.c from (select count(*) as c, 1 as k from t) x where x.k = 0",
+		"select count(*) from t having 1 = 0",
+		"select count(*) from t having lower('') = 'ab'",
+	} {
+		checkOptimized(t, sql, 0)
+	}
+	checkOptimized(t, "select x.m from (select max(a) as m from t group by b) x where 1 = 0", 0)
+	checkOptimized(t, "select x.c from (select count(*) as c from t) x where 1 = 1", 1)
+}
+
+func TestPredicatePushRulesKeepScalarAggregates(t *testing.T) {
+	plan, _ := optimizeQuery(t, "select x.m from (select max(a) as m from t) x where 1 = 0")
+	filter := findPlan(plan, Filter)
+	if filter == nil {
+		t.Fatal("no Filter in the plan")
+	}
+	for _, rule := range TransformationRules {
+		if rule.Name == "PredicatePushToProject" && rule.Apply(filter) {
+			t.Errorf("%v pushed the filter below a scalar aggregate", rule.Name)
+		}
+	}
+}
+
+// filterExprs print the conjuncts of the Filters of plan in pre-order
+func filterExprs(plan *LogicalPlan) []string {
+	var ret []string
+	if plan.Tp == Filter {
+		for _, expr := range plan.Content.(WhereFilterNode).Expr {
+			ret = append(ret, expr.print())
+		}
+	}
+	for i := range plan.child {
+		ret = append(ret, filterExprs(&plan.child[i])...)
+	}
+	return ret
+}
+
+func TestPredicatePushMapsDerivedTableColumns(t *testing.T) {
+	tests := []struct {
+		sql  string
+		rows int
+		want string
+	}{
+		{"select a, id from (select a, b as id from testdata2 where a > 2) tmp where id < 2", 2, "(b<2) (a>2)"},
+		{"select x.s from (select a + b as s from t) x where x.s > 100", 3, "((a+b)>100)"},
+		{"select x.k from (select a as k from t) x where x.k in (3, 4) and x.k <> 4", 1, "in(a, 3, 4) (a!=4)"},
+	}
+	for _, test := range tests {
+		_, plan := optimizeQuery(t, test.sql)
+		if got := strings.Join(filterExprs(plan), " "); got != test.want {
+			t.Errorf("%v: the filters are %v, want %v", test.sql, got, test.want)
+		}
+		checkOptimized(t, test.sql, test.rows)
+	}
+}
+
+func TestPredicatePushKeepsVolatileColumns(t *testing.T) {
+	loadTestTables(t)
+	_, plan := optimizeQuery(t, "select x.r from (select rand() as r, a from t) x where x.r < 2 and x.a > 10")
+	if got := strings.Join(filterExprs(plan), " "); got != "(x.r<2) (a>10)" {
+		t.Errorf("the filters are %v, the condition on rand() must stay above the derived table", got)
+	}
+}
+
+func TestHavingPushDown(t *testing.T) {
+	tests := []struct {
+		sql            string
+		filter, having string
+		rows           int
+	}{
+		{"select a, count(*) from testdata2 group by a having a > 2 and count(*) > 1", "(a>2)", "(count(1)>1)", 2},
+		{"select a as k, sum(b) from testdata2 group by a having k < 2", "(a<2)", "", 1},
+		{"select a, max(b) as m from testdata2 group by a having m > 1", "", "(m>1)", 4},
+		{"select a, count(*) from testdata2 group by a having a + count(*) > 5", "", "((a+count(1))>5)", 3},
+		{"select a, count(*) from testdata2 group by a having rand() < 2 and a is null", "isnull(a)", "(rand()<2)", 1},
+	}
+	for _, test := range tests {
+		_, plan := optimizeQuery(t, test.sql)
+		if got := strings.Join(filterExprs(plan), " "); got != test.filter {
+			t.Errorf("%v: the filters are %v, want %v", test.sql, got, test.filter)
+		}
+		var having []string
+		if p := findPlan(plan, HavingFilter); p != nil {
+			for _, expr := range p.Content.(HavingFilterNode).Expr {
+				having = append(having, expr.print())
+			}
+		}
+		if got := strings.Join(having, " "); got != test.having {
+			t.Errorf("%v: the having conditions are %v, want %v", test.sql, got, test.having)
+		}
+		checkOptimized(t, test.sql, test.rows)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/test_driver"
+	"strings"
+)
+
+// SimplifyExpressions folds constants and simplifies the expressions of Filter, HavingFilter, Join ON,
+// Project and Aggregate nodes. Filters left without conjunct are removed. Return true if anything changed
+func (plan *LogicalPlan) SimplifyExpressions() bool {
+	var modify = false
+	for i := range plan.child {
+		if plan.child[i].SimplifyExpressions() {
+			modify = true
+		}
+		child := &plan.child[i]
+		if (child.Tp == Filter || child.Tp == HavingFilter) && len(child.Expressions()) == 0 && len(child.child) == 1 {
+			plan.child[i] = child.child[0]
+			plan.ResetParent()
+		}
+	}
+	if SimplifyExpressionsForInstance(plan) {
+		modify = true
+	}
+	return modify
+}
+
+func SimplifyExpressionsForInstance(plan *LogicalPlan) bool {
+	before := printExpressions(plan.Expressions())
+	switch n := plan.Content.(type) {
+	case WhereFilterNode:
+		plan.Content = WhereFilterNode{Expr: SimplifyConjuncts(n.Expr)}
+	case HavingFilterNode:
+		plan.Content = HavingFilterNode{Expr: SimplifyConjuncts(n.Expr)}
+	case JoinNode:
+		if len(n.On) > 0 {
+			plan.Content = JoinNode{Tp: n.Tp, On: SimplifyConjuncts(n.On)}
+		}
+	case ProjectionNode:
+		plan.Content = ProjectionNode{SimplifyFields(n.cols)}
+	case AggregateNode:
+		n.ProjectionNode = ProjectionNode{SimplifyFields(n.cols)}
+		plan.Content = n
+	default:
+		return false
+	}
+	if printExpressions(plan.Expressions()) == before {
+		return false
+	}
+	ruleApplied("Simplify Expressions")
+	return true
+}
+
+func printExpressions(exprs []Expression) string {
+	var str []string
+	for _, expr := range exprs {
+		if len(expr.expr) > 0 {
+			str = append(str, expr.print())
+		}
+	}
+	return strings.Join(str, ", ")
+}
+
+// SimplifyFields simplify the fields of a projection, a changed field keeps its output name
+func SimplifyFields(cols []Expression) []Expression {
+	var ret []Expression
+	for _, col := range cols {
+		if IsWildCard(col) {
+			ret = append(ret, col)
+			continue
+		}
+		newCol := simplifyTree(col.Tree(), false).Expression()
+		if newCol.print() == col.print() {
+			ret = append(ret, col)
+			continue
+		}
+		newCol.AsName = col.AsName
+		if newCol.AsName == "" {
+			newCol.AsName = col.print()
+		}
+		ret = append(ret, newCol)
+	}
+	return ret
+}
+
+// SimplifyConjuncts simplify a list of conjuncts, which are only looked at for their truth.
+// Always true conjuncts are dropped, a FALSE or NULL conjunct replaces the whole list,
+// and the bounds of the same column are merged
+func SimplifyConjuncts(exprs []Expression) []Expression {
+	var trees []*ExprTree
+	for _, expr := range exprs {
+		trees = append(trees, splitConjuncts(simplifyTree(expr.Tree(), true))...)
+	}
+	ret := make([]Expression, 0, len(trees))
+	seen := make(map[string]bool)
+	for _, t := range trees {
+		if t.IsConstant() {
+			if truth, null := Truth(t.Value); IsNumericKind(t.Value.Kind()) || null {
+				if truth {
+					continue
+				}
+				return []Expression{NewConstTree(BoolDatum(false)).Expression()}
+			}
+		}
+		expr := t.Expression()
+		if str := expr.print(); !seen[str] || !CheckExprDeterministic(expr) {
+			seen[str] = true
+			ret = append(ret, expr)
+		}
+	}
+	return MergeRangeBounds(ret)
+}
+
+func splitConjuncts(t *ExprTree) []*ExprTree {
+	if t == nil {
+		return nil
+	}
+	if t.Op == LogicAnd {
+		return append(splitConjuncts(t.Children[0]), splitConjuncts(t.Children[1])...)
+	}
+	return []*ExprTree{t}
+}
+
+// simplifyTree simplify t bottom-up, pred is true if only the truth of t matters (NULL is as good as FALSE)
+func simplifyTree(t *ExprTree, pred bool) *ExprTree {
+	if t == nil || t.Column != nil || t.IsConstant() {
+		return t
+	}
+	op := t.Op
+	if t.Func {
+		op = t.FuncOp()
+	}
+	for i, child := range t.Children {
+		t.Children[i] = simplifyTree(child, pred && (op == LogicAnd || op == LogicOr))
+	}
+	if op == -1 {
+		return t
+	}
+	if op == Not || op == Not2 {
+		if ret := pushNot(t.Children[0], pred); ret != nil {
+			return ret
+		}
+	}
+	constant := true
+	var args []Datum
+	for _, child := range t.Children {
+		if !child.IsConstant() {
+			constant = false
+			break
+		}
+		args = append(args, child.Value)
+	}
+	if constant {
+		if d, ok := EvalOp(op, args); ok {
+			return NewConstTree(d)
+		}
+	}
+	switch op {
+	case LogicAnd, LogicOr:
+		return simplifyLogic(t, op, pred)
+	case EQ, NE, LT, LE, GT, GE, NullEQ:
+		//constants go to the right: 2 < a is a > 2
+		if t.Children[0].IsConstant() && !t.Children[1].IsConstant() {
+			return NewBinaryTree(ReverseComparison(op), t.Children[1], t.Children[0])
+		}
+	case Plus, Minus, Mul:
+		return simplifyArithmetic(t, op)
+	}
+	return t
+}
+
+// pushNot return the simplified form of NOT t, nil if NOT can not be pushed into t.
+// NOT (a AND b) is NOT a OR NOT b and NOT (a > b) is a <= b, which both hold with NULL
+func pushNot(t *ExprTree, pred bool) *ExprTree {
+	op := t.Op
+	if t.Func {
+		op = t.FuncOp()
+	}
+	switch op {
+	case EQ, NE, LT, LE, GT, GE:
+		return simplifyTree(NewBinaryTree(NegateComparison(op), t.Children[0], t.Children[1]), pred)
+	case LogicAnd, LogicOr:
+		dual := LogicOr
+		if op == LogicOr {
+			dual = LogicAnd
+		}
+		return simplifyTree(NewBinaryTree(dual,
+			NewFuncTree(Ops[Not].Name, t.Children[0]),
+			NewFuncTree(Ops[Not].Name, t.Children[1])), pred)
+	case Not, Not2:
+		//NOT NOT 5 is 1, so p is only kept if its value is a truth value or only its truth matters
+		if pred || IsBooleanTree(t.Children[0]) {
+			return t.Children[0]
+		}
+	}
+	return nil
+}
+
+// NegateComparison return op' such that `a op' b` is `NOT (a op b)`
+func NegateComparison(op MyOp) MyOp {
+	switch op {
+	case EQ:
+		return NE
+	case NE:
+		return EQ
+	case LT:
+		return GE
+	case LE:
+		return GT
+	case GT:
+		return LE
+	case GE:
+		return LT
+	}
+	return op
+}
+
+// IsBooleanTree check whether the value of t is always 0, 1 or NULL
+func IsBooleanTree(t *ExprTree) bool {
+	op := t.Op
+	if t.Func {
+		op = t.FuncOp()
+	}
+	switch op {
+	case LogicAnd, LogicOr, LogicXor, Not, Not2, EQ, NE, LT, LE, GT, GE, NullEQ, In, Like, Regexp,
+		IsNull, IsTruth, IsFalsity:
+		return true
+	}
+	if t.IsConstant() {
+		switch t.Value.Kind() {
+		case test_driver.KindNull:
+			return true
+		case test_driver.KindInt64:
+			return t.Value.GetInt64() == 0 || t.Value.GetInt64() == 1
+		}
+	}
+	return false
+}
+
+// simplifyLogic remove the constant operand of AND/OR, x AND 0 is 0 and x OR 1 is 1 even if x is NULL
+func simplifyLogic(t *ExprTree, op MyOp, pred bool) *ExprTree {
+	l, r := t.Children[0], t.Children[1]
+	for i, c := range []*ExprTree{l, r} {
+		other := t.Children[1-i]
+		if !c.IsConstant() || !(IsNumericKind(c.Value.Kind()) || c.Value.Kind() == test_driver.KindNull) {
+			continue
+		}
+		truth, null := Truth(c.Value)
+		keepOther := pred || IsBooleanTree(other)
+		switch {
+		case op == LogicAnd && !null && !truth:
+			return NewConstTree(BoolDatum(false))
+		case op == LogicOr && !null && truth:
+			return NewConstTree(BoolDatum(true))
+		case null && pred && op == LogicAnd:
+			return NewConstTree(BoolDatum(false))
+		case null && pred && op == LogicOr:
+			return other
+		case !null && keepOther:
+			//x AND 1, x OR 0
+			return other
+		}
+	}
+	if lhs, rhs := l.Expression(), r.Expression(); lhs.print() == rhs.print() && CheckExprDeterministic(lhs) &&
+		(pred || IsBooleanTree(l)) {
+		return l
+	}
+	return t
+}
+
+// simplifyArithmetic remove x + 0, x - 0, x * 1. Constants are not reassociated, (x + c) - c may overflow
+// when x does not. x is assumed numeric, string columns are only known through the Catalog
+func simplifyArithmetic(t *ExprTree, op MyOp) *ExprTree {
+	l, r := t.Children[0], t.Children[1]
+	isInt := func(c *ExprTree) bool {
+		return c.IsConstant() && c.Value.Kind() == test_driver.KindInt64
+	}
+	if (op == Plus || op == Minus) && isInt(r) && r.Value.GetInt64() < 0 && r.Value.GetInt64() != -1<<63 {
+		dual := Minus
+		if op == Minus {
+			dual = Plus
+		}
+		return NewBinaryTree(dual, l, NewConstTree(InitSetValue(-r.Value.GetInt64())))
+	}
+	identity := int64(0)
+	if op == Mul {
+		identity = 1
+	}
+	if isInt(r) && r.Value.GetInt64() == identity && !IsStringTree(l) {
+		return l
+	}
+	if op != Minus && isInt(l) && l.Value.GetInt64() == identity && !IsStringTree(r) {
+		return r
+	}
+	return t
+}
+
+// IsStringTree check whether t is known to be a string, by its literal, the return type of its function
+// or by the Catalog
+func IsStringTree(t *ExprTree) bool {
+	if t.IsConstant() {
+		k := t.Value.Kind()
+		return isStringKind(k) || k == test_driver.KindBinaryLiteral
+	}
+	if t.Func {
+		def, ok := LookupFunction(t.FuncName())
+		return ok && def.ReturnType != nil && isStringKind(def.ReturnType(nil))
+	}
+	if t.Column != nil {
+		if def, ok := LookupTable(t.Column.OrigTblName); ok {
+			for _, c := range def.Columns {
+				if strings.EqualFold(c.Name, t.Column.ColName) {
+					return c.Tp == test_driver.KindString || c.Tp == test_driver.KindBytes
+				}
+			}
+		}
+	}
+	return false
+}
+
+// MergeRangeBounds keep the tightest lower and upper bound of every column, x > 5 AND x > 7 is x > 7.
+// The bounds are dropped if the column is fixed by an equality satisfying them
+func MergeRangeBounds(exprs []Expression) []Expression {
+	type bounds struct {
+		lower, upper, eq int
+		eqCount          int
+	}
+	cols := make(map[string]*bounds)
+	drop := make([]bool, len(exprs))
+	cmps := make([]ColumnComparison, len(exprs))
+	stronger := func(i, j int, lowerBound bool) bool {
+		//whether exprs[i] is a tighter bound than exprs[j]
+		c, ok := CompareDatum(cmps[i].Value[0], cmps[j].Value[0])
+		if !ok {
+			return false
+		}
+		if c == 0 {
+			return cmps[i].Op == GT || cmps[i].Op == LT
+		}
+		return (c > 0) == lowerBound
+	}
+	for i, expr := range exprs {
+		cmp, ok := AsColumnComparison(expr)
+		if !ok || cmp.Op == In || cmp.Op == NE || !IsNumericKind(cmp.Value[0].Kind()) {
+			continue
+		}
+		cmps[i] = cmp
+		key := strings.ToLower(cmp.Col.TblName + "." + cmp.Col.ColName)
+		b, ok := cols[key]
+		if !ok {
+			b = &bounds{-1, -1, -1, 0}
+			cols[key] = b
+		}
+		switch cmp.Op {
+		case GT, GE:
+			if b.lower == -1 {
+				b.lower = i
+			} else if stronger(i, b.lower, true) {
+				drop[b.lower] = true
+				b.lower = i
+			} else if stronger(b.lower, i, true) || printEqual(exprs[i], exprs[b.lower]) {
+				drop[i] = true
+			}
+		case LT, LE:
+			if b.upper == -1 {
+				b.upper = i
+			} else if stronger(i, b.upper, false) {
+				drop[b.upper] = true
+				b.upper = i
+			} else if stronger(b.upper, i, false) || printEqual(exprs[i], exprs[b.upper]) {
+				drop[i] = true
+			}
+		case EQ:
+			b.eq = i
+			b.eqCount++
+		}
+	}
+	for _, b := range cols {
+		if b.eqCount != 1 {
+			continue
+		}
+		v := cmps[b.eq].Value[0]
+		for _, bound := range []int{b.lower, b.upper} {
+			if bound == -1 {
+				continue
+			}
+			if c, ok := CompareDatum(v, cmps[bound].Value[0]); ok && CompareResult(cmps[bound].Op, c) {
+				drop[bound] = true
+			}
+		}
+	}
+	ret := make([]Expression, 0, len(exprs))
+	for i, expr := range exprs {
+		if !drop[i] {
+			ret = append(ret, expr)
+		}
+	}
+	return ret
+}
+
+func printEqual(e1, e2 Expression) bool {
+	return e1.print() == e2.print()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSimplifyBetween(t *testing.T) {
+	checkOptimized(t, "select a from t where a between 3 and 1", 0)
+	checkOptimized(t, "select a from t where a between 1 and 3", 3)
+	checkOptimized(t, "select a from t where a not between 1 and 3", 9)
+	checkOptimized(t, "select a from s where a not between 3 and 5", 3)
+	checkOptimized(t, "select a from s where a between null and 5", 0)
+}
+
+func TestTreeRejectsLeftoverOperands(t *testing.T) {
+	expr := Expression{expr: []Datum{InitSetValue(int64(1)), InitSetValue(int64(3))}, Fields: make(map[string]ColumnName)}
+	defer func() {
+		if recover() == nil {
+			t.Error("Tree accepted two operands without operator")
+		}
+	}()
+	expr.Tree()
+}
+
+func TestSimplifyArithmeticKeepsOverflow(t *testing.T) {
+	loadTestTables(t)
+	sql := "select a from t where a + 9223372036854775807 - 9223372036854775807 > 0"
+	original, optimized := optimizeQuery(t, sql)
+	if _, _, err := Execute(original); err == nil {
+		t.Fatalf("%v: no overflow", sql)
+	}
+	if _, rows, err := Execute(optimized); err == nil {
+		t.Errorf("%v: no overflow after optimization, %d rows", sql, len(rows))
+	}
+	checkOptimized(t, "select a from t where a + 0 - 0 > 10", 2)
+	checkOptimized(t, "select a from t where a * 1 + 2 - 2 > 10", 2)
+}
+
+func TestSimplifyExpressions(t *testing.T) {
+	loadTestTables(t)
+	quiet := QuietRules
+	QuietRules = true
+	defer func() { QuietRules = quiet }()
+	tests := []struct {
+		sql, want string
+	}{
+		{"select a from t where a > 1 + 2 * 3", "(a>7)"},
+		{"select a from t where 2 < a", "(a>2)"},
+		{"select a from t where not (a > 5)", "(a<=5)"},
+		{"select a from t where not (a > 5 and b < 3)", "((a<=5)OR(b>=3))"},
+		{"select a from t where not not (a > 5)", "(a>5)"},
+		{"select a from t where a > 5 and a > 7 and a < 11", "(a>7) (a<11)"},
+		{"select a from t where a = 4 and a > 2", "(a=4)"},
+		{"select a from t where b > 3 and 1 = 1", "(b>3)"},
+		{"select a from t where b > 3 or 1 = 1", ""},
+		{"select a from t where b > 3 and 0", "0"},
+		{"select a from t where b > 3 and null", "0"},
+	}
+	for _, test := range tests {
+		node, err := parse(test.sql)
+		if err != nil {
+			t.Fatalf("%v: %v", test.sql, err)
+		}
+		plan := GetQuery(node)
+		treeRoot = plan
+		plan.SimplifyExpressions()
+		if got := strings.Join(filterExprs(plan), " "); got != test.want {
+			t.Errorf("%v: the filters are %v, want %v", test.sql, got, test.want)
+		}
+	}
+	checkOptimized(t, "select a from t where not (a > 5 and b < 3)", 11)
+	checkOptimized(t, "select a from t where b > 3 and null", 0)
+	//NULL is kept apart from FALSE outside of a predicate
+	checkResult(t, "select a, b > 30 and null from t where a < 4", "1, 0", "2, 0", "3, NULL")
+}
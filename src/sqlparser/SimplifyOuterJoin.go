@@ -0,0 +1,212 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// SimplifyOuterJoin turns outer joins into inner (or one-sided outer) joins when a predicate above the join
+// rejects the NULL rows of the null-supplying side: `t LEFT JOIN s ... WHERE s.b > 3` is `t JOIN s`.
+// Return true if any join is changed
+func (plan *LogicalPlan) SimplifyOuterJoin() bool {
+	var modify = false
+	if plan.Tp == Join && len(plan.child) == 2 {
+		if SimplifyOuterJoinForInstance(plan) {
+			modify = true
+		}
+	}
+	for i := range plan.child {
+		if plan.child[i].SimplifyOuterJoin() {
+			modify = true
+		}
+	}
+	return modify
+}
+
+// SimplifyOuterJoinForInstance : join.Tp = Join.
+// The predicates above the join are the filters right above it and the ON conditions of an inner join parent
+func SimplifyOuterJoinForInstance(join *LogicalPlan) bool {
+	n := join.Content.(JoinNode)
+	if n.Tp != ast.LeftJoin && n.Tp != ast.RightJoin && n.Tp != FullJoin {
+		return false
+	}
+	var preds []Expression
+	for _, filter := range join.FiltersAbove() {
+		preds = append(preds, filter.Content.(WhereFilterNode).Expr...)
+	}
+	if par := join.parent; par != nil && par.Tp == Join {
+		if tp := par.Content.(JoinNode).Tp; tp == ast.CrossJoin || tp == InnerJoin {
+			preds = append(preds, par.Content.(JoinNode).On...)
+		}
+	}
+	rejectLeft := NullRejecting(preds, join.child[0].Schema())
+	rejectRight := NullRejecting(preds, join.child[1].Schema())
+
+	tp := n.Tp
+	switch n.Tp {
+	case ast.LeftJoin:
+		if rejectRight {
+			tp = InnerJoin
+		}
+	case ast.RightJoin:
+		if rejectLeft {
+			tp = InnerJoin
+		}
+	case FullJoin:
+		switch {
+		case rejectLeft && rejectRight:
+			tp = InnerJoin
+		case rejectLeft:
+			tp = ast.LeftJoin
+		case rejectRight:
+			tp = ast.RightJoin
+		}
+	}
+	if tp == n.Tp {
+		return false
+	}
+	join.Content = JoinNode{Tp: tp, On: n.On}
+
+	ruleApplied("Simplify Outer Join")
+	return true
+}
+
+// NullRejecting check whether one of the conjuncts is never TRUE when all the columns of side are NULL
+func NullRejecting(conjuncts []Expression, side Schema) bool {
+	for _, expr := range conjuncts {
+		switch nullValueOf(expr.Tree(), side) {
+		case nullValueNull, nullValueFalse, nullValueNotTrue:
+			return true
+		}
+	}
+	return false
+}
+
+// nullValue is what is known of an expression when all the columns of one side are NULL
+type nullValue int
+
+const (
+	nullValueUnknown nullValue = iota
+	nullValueNull
+	nullValueFalse
+	nullValueTrue
+	nullValueNotTrue //NULL or FALSE
+)
+
+func nullValueOf(t *ExprTree, side Schema) nullValue {
+	if t == nil {
+		return nullValueUnknown
+	}
+	switch {
+	case t.Column != nil:
+		if side.ResolveColumn(*t.Column) != -1 {
+			return nullValueNull
+		}
+		return nullValueUnknown
+	case t.IsConstant():
+		switch t.Value.Kind() {
+		case test_driver.KindNull:
+			return nullValueNull
+		case test_driver.KindInt64:
+			if t.Value.GetInt64() == 0 {
+				return nullValueFalse
+			}
+			return nullValueTrue
+		}
+		return nullValueUnknown
+	}
+	var children []nullValue
+	for _, child := range t.Children {
+		children = append(children, nullValueOf(child, side))
+	}
+	op := t.Op
+	if t.Func {
+		op = t.FuncOp()
+	}
+	switch op {
+	case LogicAnd:
+		ret := nullValueTrue
+		for _, v := range children {
+			switch v {
+			case nullValueFalse:
+				return nullValueFalse
+			case nullValueNull, nullValueNotTrue:
+				ret = nullValueNotTrue
+			case nullValueUnknown:
+				if ret == nullValueTrue {
+					ret = nullValueUnknown
+				}
+			}
+		}
+		return ret
+	case LogicOr:
+		ret := nullValueFalse
+		for _, v := range children {
+			switch v {
+			case nullValueTrue:
+				return nullValueTrue
+			case nullValueUnknown:
+				ret = nullValueUnknown
+			case nullValueNull, nullValueNotTrue:
+				if ret == nullValueFalse {
+					ret = nullValueNotTrue
+				}
+			}
+		}
+		return ret
+	case Not, Not2:
+		switch children[0] {
+		case nullValueNull:
+			return nullValueNull
+		case nullValueFalse:
+			return nullValueTrue
+		case nullValueTrue:
+			return nullValueFalse
+		}
+		return nullValueUnknown
+	case IsNull:
+		if children[0] == nullValueNull {
+			return nullValueTrue
+		}
+		return nullValueUnknown
+	case IsTruth:
+		switch children[0] {
+		case nullValueNull, nullValueFalse, nullValueNotTrue:
+			return nullValueFalse
+		case nullValueTrue:
+			return nullValueTrue
+		}
+		return nullValueUnknown
+	case IsFalsity:
+		switch children[0] {
+		case nullValueNull, nullValueTrue:
+			return nullValueFalse
+		case nullValueFalse:
+			return nullValueTrue
+		}
+		return nullValueUnknown
+	case NullEQ:
+		return nullValueUnknown
+	case In:
+		//only the left operand matters, 1 IN (NULL, 1) is TRUE
+		if children[0] == nullValueNull {
+			return nullValueNull
+		}
+		return nullValueUnknown
+	}
+	if !t.Func || IsNullIntolerantFunction(t.FuncName()) {
+		//null-intolerant: NULL in, NULL out. CASE, IF, IFNULL and COALESCE may turn NULL into a value
+		for _, v := range children {
+			if v == nullValueNull {
+				return nullValueNull
+			}
+		}
+	}
+	return nullValueUnknown
+}
+
+// IsNullIntolerantFunction check whether f returns NULL as soon as one argument is NULL
+func IsNullIntolerantFunction(f string) bool {
+	def, ok := LookupFunction(f)
+	return ok && def.NullIntolerant
+}
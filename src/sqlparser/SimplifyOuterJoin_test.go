@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/parser/ast"
+)
+
+func TestSimplifyOuterJoinKeepsNullTolerantPredicates(t *testing.T) {
+	//every predicate is true for the rows of t padded with NULL, the left join must stay
+	for _, sql := range []string{
+		"select t.a, s.c from t left join s on t.a = s.a where case when s.a is null then 1 else s.a end > 0",
+		"select t.a, s.c from t left join s on t.a = s.a where if(s.a is null, 1, s.a) > 0",
+		"select t.a, s.c from t left join s on t.a = s.a where ifnull(s.a, 1) > 0",
+		"select t.a, s.c from t left join s on t.a = s.a where coalesce(s.a, 1) > 0",
+	} {
+		checkOptimized(t, sql, 13)
+		_, optimized := optimizeQuery(t, sql)
+		if join := findPlan(optimized, Join); join != nil && join.Content.(JoinNode).Tp != ast.LeftJoin {
+			t.Errorf("%v: left join simplified", sql)
+		}
+	}
+}
+
+// simplifiedJoinTypes print the type of the Joins of plan in pre-order after SimplifyOuterJoin
+func simplifiedJoinTypes(t *testing.T, sql string) string {
+	t.Helper()
+	node, err := parse(sql)
+	if err != nil {
+		t.Fatalf("%v: %v", sql, err)
+	}
+	plan := GetQuery(node)
+	quiet := QuietRules
+	QuietRules = true
+	defer func() { QuietRules = quiet }()
+	treeRoot = plan
+	plan.SimplifyOuterJoin()
+	var ret []string
+	var walk func(p *LogicalPlan)
+	walk = func(p *LogicalPlan) {
+		if p.Tp == Join {
+			ret = append(ret, joinTypeNames[p.Content.(JoinNode).Tp])
+		}
+		for i := range p.child {
+			walk(&p.child[i])
+		}
+	}
+	walk(plan)
+	return strings.Join(ret, " ")
+}
+
+func TestSimplifyOuterJoin(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+		rows int
+	}{
+		{"select t.a, s.c from t left join s on t.a = s.a where s.a > 5", "inner", 1},
+		{"select t.a, s.c from t left join s on t.a = s.a where s.a > 5 and t.a < 10", "inner", 1},
+		{"select t.a, s.c from t left join s on t.a = s.a where not (s.a is null)", "inner", 4},
+		{"select t.a, s.c from t left join s on t.a = s.a where s.c like 'f%'", "inner", 1},
+		{"select s.a, t.b from t right join s on t.a = s.a where t.b > 0", "inner", 4},
+		{"select t.a, s.c from t left join s on t.a = s.a where s.a is null", "left", 9},
+		{"select t.a, s.c from t left join s on t.a = s.a where s.a > 5 or t.a < 3", "left", 3},
+		{"select t.a, s.c from t left join s on t.a = s.a where s.a <=> 5", "left", 0},
+		{"select t.a, s.c from t left join s on t.a = s.a where t.a > 5", "left", 7},
+		{"select t.a from t left join s on t.a = s.a join testdata2 on s.a = testdata2.a", "cross inner", 6},
+	}
+	for _, test := range tests {
+		if got := simplifiedJoinTypes(t, test.sql); got != test.want {
+			t.Errorf("%v: the joins are %v, want %v", test.sql, got, test.want)
+		}
+		checkOptimized(t, test.sql, test.rows)
+	}
+}
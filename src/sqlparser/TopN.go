@@ -0,0 +1,250 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// BuildTopN fuses every Limit sitting right above an OrderBy into a single TopN.
+// Return true if any TopN is built
+func (plan *LogicalPlan) BuildTopN() bool {
+	var modify = false
+	for i := range plan.child {
+		if plan.child[i].BuildTopN() {
+			modify = true
+		}
+	}
+	if plan.Tp == Limit && len(plan.child) == 1 && plan.child[0].Tp == OrderBy {
+		limit := plan.Content.(LimitNode)
+		order := plan.child[0]
+		plan.Tp = TopN
+		plan.Content = TopNNode{
+			Items:   order.Content.(OrderByNode).Items,
+			Count:   limit.Count,
+			Offset:  limit.Offset,
+			hasPush: limit.hasPush,
+		}
+		plan.child = order.child
+		plan.ResetParent()
+
+		ruleApplied("Build TopN")
+		modify = true
+	}
+	return modify
+}
+
+// TopNPushDown pushes the TopN nodes down the plan:
+//
+//	through a Project or a derived table, the TopN is moved below with its sort keys rewritten;
+//	into the preserved side of an outer join and into each branch of a UNION ALL, a TopN of count+offset
+//	rows without offset is copied, the TopN above keeps the offset.
+//
+// Return true if any TopN is pushed
+func (plan *LogicalPlan) TopNPushDown() bool {
+	var modify = false
+	if plan.Tp == TopN && len(plan.child) == 1 {
+		if TopNPushDownForInstance(plan) {
+			modify = true
+		}
+	}
+	for i := range plan.child {
+		if plan.child[i].TopNPushDown() {
+			modify = true
+		}
+	}
+	return modify
+}
+
+func TopNPushDownForInstance(topN *LogicalPlan) bool {
+	child := &topN.child[0]
+	var modify = false
+	switch child.Tp {
+	case Project:
+		modify = TopNPush2Project(topN, child)
+	case Table:
+		modify = TopNPush2DerivedTable(topN, child)
+	case Join:
+		modify = TopNPush2Join(topN, child)
+	case Union:
+		modify = TopNPush2Union(topN, child)
+	}
+	if !modify {
+		return false
+	}
+	treeRoot.ResetParent()
+	ruleApplied("TopN Push Down")
+	return true
+}
+
+// TopNPush2Project move topN below proj. A Project computing aggregates returns a single row,
+// sorting its input would change nothing but its cost, so it is left alone
+func TopNPush2Project(topN, proj *LogicalPlan) bool {
+	n := topN.Content.(TopNNode)
+	if len(proj.child) != 1 || hasAggregate(proj.Content.(ProjectionNode).cols) {
+		return false
+	}
+	defs := proj.OutputDefinitions()
+	items, ok := mapByItems(n.Items, func(expr Expression) (Expression, bool) {
+		if pos, ok := orderPosition(expr); ok {
+			//ORDER BY 2 is the second column of the Project
+			if pos < 1 || pos > len(defs) || !CheckExprDeterministic(defs[pos-1]) {
+				return expr, false
+			}
+			return defs[pos-1], true
+		}
+		return MapThroughProject(expr, proj)
+	})
+	if !ok {
+		return false
+	}
+	n.Items = items
+	newNode := OpNodeInit(TopN, n)
+	newNode.child = proj.child
+	p := *proj
+	p.child = []LogicalPlan{*newNode}
+	p.parent = topN.parent
+	*topN = p
+	return true
+}
+
+// TopNPush2DerivedTable move topN into the derived table tbl, right below its Project
+func TopNPush2DerivedTable(topN, tbl *LogicalPlan) bool {
+	n := topN.Content.(TopNNode)
+	if !tbl.IsDerivedTable() || tbl.child[0].Tp != Project || len(tbl.child[0].child) != 1 ||
+		hasAggregate(tbl.child[0].Content.(ProjectionNode).cols) {
+		return false
+	}
+	items, ok := mapByItems(n.Items, func(expr Expression) (Expression, bool) {
+		if _, ok := orderPosition(expr); ok {
+			return expr, false
+		}
+		return MapThroughDerivedTable(expr, tbl, false)
+	})
+	if !ok {
+		return false
+	}
+	n.Items = items
+	proj := &tbl.child[0]
+	newNode := OpNodeInit(TopN, n)
+	newNode.child = proj.child
+	proj.child = []LogicalPlan{*newNode}
+	t := *tbl
+	t.parent = topN.parent
+	*topN = t
+	return true
+}
+
+// TopNPush2Join copy topN into the preserved side of an outer join when all the sort keys come from that side.
+// Every row of the preserved side appears at least once in the output, so the first count+offset rows of the
+// output only come from the first count+offset rows of that side
+func TopNPush2Join(topN, join *LogicalPlan) bool {
+	n := topN.Content.(TopNNode)
+	if n.hasPush || len(join.child) != 2 {
+		return false
+	}
+	var side int
+	switch join.Content.(JoinNode).Tp {
+	case ast.LeftJoin:
+		side = 0
+	case ast.RightJoin:
+		side = 1
+	default:
+		return false
+	}
+	for _, item := range n.Items {
+		if _, ok := orderPosition(item.Item); ok || !join.ExprInChild(item.Item, side) {
+			return false
+		}
+	}
+	newNode := OpNodeInit(TopN, TopNNode{
+		Items: n.Items,
+		Count: AddLimitExpression(n.Count, n.Offset),
+		Offset: Expression{
+			Fields: make(map[string]ColumnName),
+		},
+	})
+	newNode.child = []LogicalPlan{join.child[side]}
+	join.child[side] = *newNode
+	n.hasPush = true
+	topN.Content = n
+	return true
+}
+
+// TopNPush2Union copy topN into every branch of a UNION ALL, the sort keys are rewritten with the columns of the
+// branch at the same position
+func TopNPush2Union(topN, union *LogicalPlan) bool {
+	n := topN.Content.(TopNNode)
+	if n.hasPush || !union.Content.(UnionNode).All {
+		return false
+	}
+	schema := union.Schema()
+	var branches []*LogicalPlan
+	for i := range union.child {
+		branch := union.child[i].Schema()
+		if branch.Len() != schema.Len() {
+			return false
+		}
+		items, ok := mapByItems(n.Items, func(expr Expression) (Expression, bool) {
+			if _, ok := orderPosition(expr); ok {
+				return expr, false
+			}
+			return SubstituteExpression(expr, func(col ColumnName) (Expression, bool) {
+				j := schema.ResolveColumn(col)
+				if j == -1 {
+					return Expression{}, false
+				}
+				c := branch.Columns[j]
+				return ColumnExpression(ColumnName{
+					OrigTblName: c.OrigTblName,
+					OrigColName: c.OrigColName,
+					TblName:     c.TblName,
+					ColName:     c.ColName,
+				}), true
+			})
+		})
+		if !ok {
+			return false
+		}
+		newNode := OpNodeInit(TopN, TopNNode{
+			Items: items,
+			Count: AddLimitExpression(n.Count, n.Offset),
+			Offset: Expression{
+				Fields: make(map[string]ColumnName),
+			},
+		})
+		newNode.child = []LogicalPlan{union.child[i]}
+		branches = append(branches, newNode)
+	}
+	for i, branch := range branches {
+		union.child[i] = *branch
+	}
+	n.hasPush = true
+	topN.Content = n
+	return true
+}
+
+func mapByItems(items []ByItem, f func(Expression) (Expression, bool)) ([]ByItem, bool) {
+	ret := make([]ByItem, 0, len(items))
+	for _, item := range items {
+		expr, ok := f(item.Item)
+		if !ok {
+			return nil, false
+		}
+		ret = append(ret, ByItem{expr, item.Desc})
+	}
+	return ret, true
+}
+
+// orderPosition return the position of an ORDER BY item like `ORDER BY 2`
+func orderPosition(expr Expression) (int, bool) {
+	if len(expr.expr) != 1 || !expr.IsConstantDatum(expr.expr[0]) {
+		return 0, false
+	}
+	switch d := expr.expr[0]; d.Kind() {
+	case test_driver.KindInt64:
+		return int(d.GetInt64()), true
+	case test_driver.KindUint64:
+		return int(d.GetUint64()), true
+	}
+	return 0, false
+}
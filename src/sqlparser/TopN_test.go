@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBuildTopN(t *testing.T) {
+	for _, sql := range []string{
+		"select a from t order by a desc limit 3",
+		"select a, b from t order by b limit 2, 3",
+		"select a, b from t order by 2 desc limit 1",
+	} {
+		_, plan := optimizeQuery(t, sql)
+		if countPlans(plan, TopN) != 1 || countPlans(plan, OrderBy) != 0 || countPlans(plan, Limit) != 0 {
+			t.Errorf("%v: ORDER BY and LIMIT are not fused", sql)
+		}
+	}
+	checkOrdered(t, "select a from t order by a desc limit 3", "12", "11", "10")
+	//NULL comes first in ascending order
+	checkOrdered(t, "select a, b from t order by b limit 1, 3", "8, NULL", "1, 10", "2, 20")
+	checkOrdered(t, "select a, b from t order by 2 desc limit 1", "12, 120")
+	checkOrdered(t, "select a, b from t order by b desc, a limit 0", []string{}...)
+}
+
+func TestTopNPushDown(t *testing.T) {
+	tests := []struct {
+		sql  string
+		topN int
+		want []string
+	}{
+		{"select t.a, s.c from t left join s on t.a = s.a order by t.a limit 3", 2, []string{"1, NULL", "2, two", "3, NULL"}},
+		{"select a from t union all select a from s order by a limit 2", 3, []string{"NULL", "1"}},
+		{"select x.a from (select a from t order by a desc limit 5) x order by x.a limit 2", 2, []string{"8", "9"}},
+		{"select x.b from (select a + b as b from t) x order by x.b desc limit 2", 1, []string{"132", "121"}},
+		//the sort keys are not all on the preserved side
+		{"select t.a, s.c from t left join s on t.a = s.a order by s.c, t.a limit 2", 1, []string{"1, NULL", "3, NULL"}},
+		//a scalar aggregate returns one row whatever its input
+		{"select max(a) from t order by 1 limit 1", 1, []string{"12"}},
+	}
+	for _, test := range tests {
+		checkOrdered(t, test.sql, test.want...)
+		_, plan := optimizeQuery(t, test.sql)
+		if got := countPlans(plan, TopN); got != test.topN {
+			t.Errorf("%v: %d TopN, want %d", test.sql, got, test.topN)
+		}
+	}
+}
@@ -19,10 +19,13 @@ func LogFuncName() {
 
 func GetQuery(root *ast.StmtNode) *LogicalPlan {
 	OpStack := new(Stack)
-	if _, ok := (*root).(*ast.SelectStmt); ok {
+	switch (*root).(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
 		(*root).Accept(OpStack)
 	}
-	return OpStack.Pop()
+	plan := OpStack.Pop()
+	plan.ResetParent()
+	return plan
 }
 
 func OutputQuery(root *LogicalPlan, deep int) {
@@ -33,6 +36,32 @@ func OutputQuery(root *LogicalPlan, deep int) {
 		fmt.Printf("    ")
 	}
 	fmt.Printf(" ")
+	root.printNode()
+	fmt.Printf("\n")
+	//fmt.Printf("  %+v\n", root)
+	for _, child := range root.child {
+		OutputQuery(&child, deep+1)
+	}
+}
+
+// Explain print the plan like OutputQuery, with the estimated number of rows produced by each node
+func Explain(root *LogicalPlan, deep int) {
+	if root == nil {
+		return
+	}
+	for i := 0; i < deep; i++ {
+		fmt.Printf("    ")
+	}
+	fmt.Printf(" ")
+	root.printNode()
+	fmt.Printf("  (rows=%.2f)\n", EstimateRows(root))
+	for i := range root.child {
+		Explain(&root.child[i], deep+1)
+	}
+}
+
+// printNode print the type and the content of the node
+func (root *LogicalPlan) printNode() {
 	switch root.Tp {
 	case Project:
 		fmt.Printf("Project: ")
@@ -61,11 +90,15 @@ func OutputQuery(root *LogicalPlan, deep int) {
 	case Limit:
 		fmt.Printf("Limit: ")
 		root.Content.(LimitNode).print()
-	}
-	fmt.Printf("\n")
-	//fmt.Printf("  %+v\n", root)
-	for _, child := range root.child {
-		OutputQuery(&child, deep+1)
+	case EmptyRelation:
+		fmt.Printf("EmptyRelation: ")
+		root.Content.(EmptyRelationNode).print()
+	case Union:
+		fmt.Printf("Union: ")
+		root.Content.(UnionNode).print()
+	case TopN:
+		fmt.Printf("TopN: ")
+		root.Content.(TopNNode).print()
 	}
 }
 
@@ -75,6 +108,13 @@ func (s *Stack) Enter(in ast.Node) (ast.Node, bool) {
 		*ast.HavingClause, *ast.OrderByClause, *ast.GroupByClause,
 		*ast.OnCondition, ast.ExprNode:
 		return in, true
+	case *ast.SelectStmt:
+		s.distinct = append(s.distinct, in.Distinct)
+		return in, false
+	case *ast.SetOprStmt:
+		//the ORDER BY and LIMIT of a UNION must not see the DISTINCT of an enclosing select
+		s.distinct = append(s.distinct, false)
+		return in, false
 	default:
 		return in, false
 	}
@@ -88,6 +128,11 @@ func (s *Stack) Leave(in ast.Node) (ast.Node, bool) {
 		s.TableSource(in)
 	case *ast.SelectStmt:
 		s.SelectStmt()
+		s.distinct = s.distinct[:len(s.distinct)-1]
+	case *ast.SetOprStmt:
+		s.distinct = s.distinct[:len(s.distinct)-1]
+	case *ast.SetOprSelectList:
+		s.SetOprSelectList(in)
 	case ast.ExprNode:
 		s.Where(&in)
 	case *ast.GroupByClause:
@@ -104,8 +149,21 @@ func (s *Stack) Leave(in ast.Node) (ast.Node, bool) {
 	return in, true
 }
 
+// SelectStmt assemble the select on the top of the stack, once it is done SELECT DISTINCT removes the duplicate
+// rows the way UNION DISTINCT does, by a Union of the select alone
 func (s *Stack) SelectStmt() {
 	LogFuncName()
+	s.selectBlock()
+	if n := len(s.distinct); n > 0 && s.distinct[n-1] {
+		s.distinct[n-1] = false
+		newNode := OpNodeInit(Union, UnionNode{false})
+		newNode.child = append(newNode.child, *s.Pop())
+		newNode.child[len(newNode.child)-1].parent = newNode
+		s.Push(newNode)
+	}
+}
+
+func (s *Stack) selectBlock() {
 	switch s.top().Tp {
 	case GroupBy:
 		top := s.Pop()
@@ -116,7 +174,15 @@ func (s *Stack) SelectStmt() {
 		})
 		newNode.child = top.child
 		s.Push(newNode)
-	case HavingFilter, Filter, Join, Table:
+	case HavingFilter:
+		//HAVING filters the rows of the Aggregate and may use the group by columns and aggregates it computes
+		top := s.Pop()
+		s.Push(&top.child[0])
+		s.selectBlock()
+		top.child = []LogicalPlan{*s.Pop()}
+		top.ResetParent()
+		s.Push(top)
+	case Filter, Join, Table:
 		top := s.Pop()
 		proj := s.Pop()
 		top.parent = proj
@@ -127,6 +193,39 @@ func (s *Stack) SelectStmt() {
 
 }
 
+// SetOprSelectList combine the plans of the selects, UNION binds left to right and consecutive UNION ALL
+// share one Union node
+func (s *Stack) SetOprSelectList(root *ast.SetOprSelectList) {
+	LogFuncName()
+	branches := make([]*LogicalPlan, len(root.Selects))
+	for i := len(root.Selects) - 1; i >= 0; i-- {
+		branches[i] = s.Pop()
+	}
+	cur := branches[0]
+	for i, sel := range root.Selects[1:] {
+		var opr *ast.SetOprType
+		switch sel := sel.(type) {
+		case *ast.SelectStmt:
+			opr = sel.AfterSetOperator
+		case *ast.SetOprSelectList:
+			opr = sel.AfterSetOperator
+		}
+		if opr == nil || (*opr != ast.Union && *opr != ast.UnionAll) {
+			panic("SetOpr Error Type")
+		}
+		all := *opr == ast.UnionAll
+		if cur.Tp == Union && all && cur.Content.(UnionNode).All {
+			cur.child = append(cur.child, *branches[i+1])
+			continue
+		}
+		newNode := OpNodeInit(Union, UnionNode{all})
+		newNode.child = append(newNode.child, *cur, *branches[i+1])
+		cur = newNode
+	}
+	cur.ResetParent()
+	s.Push(cur)
+}
+
 //func (s *Stack) From(root *ast.TableRefsClause) {
 //	LogFuncName()
 //}
@@ -172,6 +271,7 @@ func (s *Stack) OrderBy(root *ast.OrderByClause) {
 
 func (s *Stack) Limit(root *ast.Limit) {
 	LogFuncName()
+	s.SelectStmt()
 	e1, e2 := AnalyzeLimitNode(root)
 	newNode := OpNodeInit(Limit, LimitNode{e1, e2, false})
 	newNode.child = append(newNode.child, *s.Pop())
@@ -202,7 +302,7 @@ func (s *Stack) Join(root *ast.Join) {
 func (s *Stack) TableSource(root *ast.TableSource) {
 	LogFuncName()
 	switch root.Source.(type) {
-	case *ast.SelectStmt:
+	case *ast.SelectStmt, *ast.SetOprStmt:
 		newNode := OpNodeInit(Table, TableNode{ColumnName{TblName: root.AsName.String()}})
 		newNode.child = append(newNode.child, *s.Pop())
 		newNode.child[len(newNode.child)-1].parent = newNode
@@ -294,7 +394,7 @@ func AnalyzeLogicalAndExpr(root *ast.ExprNode) []Expression {
 	for _, node := range BiOpExpr {
 		var tempExpr Expression
 		tempExpr.Fields = make(map[string]ColumnName)
-		(*node).Accept(&tempExpr)
+		node.Accept(&tempExpr)
 		ret = append(ret, tempExpr)
 	}
 	return ret
@@ -304,7 +404,9 @@ func (expr *Expression) Enter(in ast.Node) (ast.Node, bool) {
 	switch root := in.(type) {
 	case *ast.BinaryOperationExpr:
 		//expr.expr = append(expr.expr, InitSetValue("("))
-	case *ast.AggregateFuncExpr:
+	case *ast.AggregateFuncExpr, *ast.FuncCallExpr, *ast.PatternInExpr, *ast.PatternLikeExpr,
+		*ast.PatternRegexpExpr, *ast.CaseExpr, *ast.IsNullExpr, *ast.IsTruthExpr, *ast.UnaryOperationExpr,
+		*ast.BetweenExpr:
 		return in, true
 	default:
 		_ = root
@@ -316,8 +418,14 @@ func (expr *Expression) Leave(in ast.Node) (ast.Node, bool) {
 	switch root := in.(type) {
 	case *test_driver.ValueExpr:
 		expr.expr = append(expr.expr, Datum{root.Datum, nil})
+	case *ast.PositionExpr:
+		//ORDER BY 2 is kept as the integer 2
+		expr.expr = append(expr.expr, InitSetValue(int64(root.N)))
 	case *ast.AggregateFuncExpr:
 		datum := InitSetValue(root.F)
+		if root.Distinct {
+			datum = InitSetValue(DistinctAggregate(root.F))
+		}
 		for _, arg := range root.Args {
 			var ArgExpr Expression
 			ArgExpr.Fields = make(map[string]ColumnName)
@@ -326,6 +434,114 @@ func (expr *Expression) Leave(in ast.Node) (ast.Node, bool) {
 		}
 		expr.expr = append(expr.expr, datum)
 		//expr.expr = append(expr.expr, InitSetValue(")"))
+	case *ast.FuncCallExpr:
+		datum := InitSetValue(root.FnName.L)
+		//Args of a function is never nil, even if it has no argument
+		datum.Args = make([]Expression, 0, len(root.Args))
+		for _, arg := range root.Args {
+			var ArgExpr Expression
+			ArgExpr.Fields = make(map[string]ColumnName)
+			arg.Accept(&ArgExpr)
+			datum.Args = append(datum.Args, ArgExpr)
+		}
+		expr.expr = append(expr.expr, datum)
+	case *ast.PatternInExpr:
+		//a IN (1, 2) is kept as the function in(a, 1, 2)
+		datum := InitSetValue(Ops[In].Name)
+		datum.Args = []Expression{AnalyzeExprNode(&root.Expr)}
+		for i := range root.List {
+			datum.Args = append(datum.Args, AnalyzeExprNode(&root.List[i]))
+		}
+		if root.Not {
+			datum = NotDatum(datum)
+		}
+		expr.expr = append(expr.expr, datum)
+	case *ast.PatternLikeExpr:
+		//a LIKE 'x%' is kept as the function like(a, 'x%'), the escape is only added if it is not \\
+		datum := InitSetValue(Ops[Like].Name)
+		datum.Args = []Expression{AnalyzeExprNode(&root.Expr), AnalyzeExprNode(&root.Pattern)}
+		if root.Escape != '\\' {
+			datum.Args = append(datum.Args, Expression{
+				expr:   []Datum{InitSetValue(string(root.Escape))},
+				Fields: make(map[string]ColumnName),
+			})
+		}
+		if root.Not {
+			datum = NotDatum(datum)
+		}
+		expr.expr = append(expr.expr, datum)
+	case *ast.PatternRegexpExpr:
+		datum := InitSetValue(Ops[Regexp].Name)
+		datum.Args = []Expression{AnalyzeExprNode(&root.Expr), AnalyzeExprNode(&root.Pattern)}
+		if root.Not {
+			datum = NotDatum(datum)
+		}
+		expr.expr = append(expr.expr, datum)
+	case *ast.CaseExpr:
+		//CASE is kept as case(when1, then1, ..., else), CASE v WHEN w compares v = w and the missing ELSE is NULL
+		datum := InitSetValue(Ops[Case].Name)
+		for _, when := range root.WhenClauses {
+			cond := AnalyzeExprNode(&when.Expr)
+			if root.Value != nil {
+				value := AnalyzeExprNode(&root.Value)
+				cond = NewBinaryTree(EQ, value.Tree(), cond.Tree()).Expression()
+			}
+			datum.Args = append(datum.Args, cond, AnalyzeExprNode(&when.Result))
+		}
+		if root.ElseClause != nil {
+			datum.Args = append(datum.Args, AnalyzeExprNode(&root.ElseClause))
+		} else {
+			datum.Args = append(datum.Args, Expression{
+				expr:   []Datum{NullDatum()},
+				Fields: make(map[string]ColumnName),
+			})
+		}
+		expr.expr = append(expr.expr, datum)
+	case *ast.IsNullExpr:
+		datum := UnaryDatum(IsNull, AnalyzeExprNode(&root.Expr))
+		if root.Not {
+			datum = NotDatum(datum)
+		}
+		expr.expr = append(expr.expr, datum)
+	case *ast.IsTruthExpr:
+		op := IsTruth
+		if root.True == 0 {
+			op = IsFalsity
+		}
+		datum := UnaryDatum(op, AnalyzeExprNode(&root.Expr))
+		if root.Not {
+			datum = NotDatum(datum)
+		}
+		expr.expr = append(expr.expr, datum)
+	case *ast.BetweenExpr:
+		//a BETWEEN l AND r is kept as a >= l AND a <= r, and a NOT BETWEEN l AND r as a < l OR a > r
+		value, low, high := AnalyzeExprNode(&root.Expr), AnalyzeExprNode(&root.Left), AnalyzeExprNode(&root.Right)
+		var t *ExprTree
+		if root.Not {
+			t = NewBinaryTree(LogicOr, NewBinaryTree(LT, value.Tree(), low.Tree()), NewBinaryTree(GT, value.Tree(), high.Tree()))
+		} else {
+			t = NewBinaryTree(LogicAnd, NewBinaryTree(GE, value.Tree(), low.Tree()), NewBinaryTree(LE, value.Tree(), high.Tree()))
+		}
+		between := t.Expression()
+		expr.expr = append(expr.expr, between.expr...)
+		for k, v := range between.Fields {
+			expr.Fields[k] = v
+		}
+	case *ast.UnaryOperationExpr:
+		arg := AnalyzeExprNode(&root.V)
+		switch root.Op {
+		case opcode.Plus:
+			expr.expr = append(expr.expr, arg.expr...)
+			for k, v := range arg.Fields {
+				expr.Fields[k] = v
+			}
+		case opcode.Minus:
+			expr.expr = append(expr.expr, UnaryDatum(UnaryMinus, arg))
+		case opcode.BitNeg:
+			expr.expr = append(expr.expr, UnaryDatum(BitNeg, arg))
+		default:
+			expr.expr = append(expr.expr, UnaryDatum(Not, arg))
+		}
 	case *ast.BinaryOperationExpr:
 		expr.expr = append(expr.expr, InitSetValue(root.Op.String()))
 	case *ast.ColumnNameExpr:
@@ -356,17 +572,17 @@ func (expr *Expression) Leave(in ast.Node) (ast.Node, bool) {
 	return in, true
 }
 
-func BinaryExpr(root *ast.ExprNode) []*ast.BinaryOperationExpr {
-	if root, ok := (*root).(*ast.BinaryOperationExpr); ok {
-		switch root.Op {
-		case opcode.LogicAnd:
-			ret := append(BinaryExpr(&root.L), BinaryExpr(&root.R)...)
-			return ret
-		default:
-			ret := []*ast.BinaryOperationExpr{root}
-			return ret
+// BinaryExpr split the conjuncts of root
+func BinaryExpr(root *ast.ExprNode) []ast.ExprNode {
+	switch node := (*root).(type) {
+	case *ast.BinaryOperationExpr:
+		if node.Op == opcode.LogicAnd {
+			return append(BinaryExpr(&node.L), BinaryExpr(&node.R)...)
 		}
-	} else {
+	case *ast.ParenthesesExpr:
+		return BinaryExpr(&node.Expr)
+	case nil:
 		return nil
 	}
+	return []ast.ExprNode{*root}
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// BenchRows is the number of rows of the generated table the benchmarks run on
+const BenchRows = 4096
+
+// benchTime is how long the -bench flag runs each measure, go test -bench uses testing.B instead
+const benchTime = time.Second
+
+// timePerOp run f once to warm up, then again and again during benchTime, and return the mean time of a run
+func timePerOp(f func()) time.Duration {
+	f()
+	n := 0
+	start := time.Now()
+	for time.Since(start) < benchTime {
+		f()
+		n++
+	}
+	return time.Since(start) / time.Duration(n)
+}
+
+// exprBenchFilters are the filter-heavy workloads of the expression benchmark, conditions on the columns of
+// benchSchema. 2e0 is a DOUBLE literal, 2.0 would be a DECIMAL
+var exprBenchFilters = []struct {
+	Name string
+	Cond string
+}{
+	{"int compare", "a > 500"},
+	{"int range", "a >= 100 AND a < 900 AND b <> 7"},
+	{"int arithmetic", "a * 3 + b > 1000"},
+	{"float arithmetic", "f * 2e0 + 1e0 > 100e0"},
+	{"string equality", "s = 'k42'"},
+	{"in list", "a IN (1, 5, 9, 13, 42, 77, 500)"},
+	{"like", "s LIKE 'k1%'"},
+	{"case", "CASE WHEN a < 100 THEN b WHEN a < 500 THEN b * 2 ELSE b * 3 END > 30"},
+	{"mixed", "(a % 7 = 3 OR b * 3 < 20) AND s <> 'k0' AND f IS NOT NULL"},
+}
+
+// ExprBenchmark is the time of the interpreter and of the compiled closures on a filter
+type ExprBenchmark struct {
+	Name        string
+	Cond        string
+	Selected    int //the rows passing the filter
+	Interpreted time.Duration
+	Compiled    time.Duration
+}
+
+// benchSchema is the table of the expression benchmark: a from 0 to 999, b from 0 to 99 with 10% of NULL,
+// f a DOUBLE from 0 to 100 and s one of 100 strings k0 to k99
+func benchSchema() Schema {
+	var s Schema
+	for _, c := range []struct {
+		name string
+		tp   byte
+	}{{"a", test_driver.KindInt64}, {"b", test_driver.KindInt64}, {"f", test_driver.KindFloat64},
+		{"s", test_driver.KindString}} {
+		s.Columns = append(s.Columns, SchemaColumn{TblName: "bench", ColName: c.name, OrigTblName: "bench",
+			OrigColName: c.name, Tp: c.tp, Nullable: c.name == "b"})
+	}
+	return s
+}
+
+func benchRows(n int, r *rand.Rand) []Row {
+	rows := make([]Row, 0, n)
+	for i := 0; i < n; i++ {
+		b := NullDatum()
+		if r.Intn(10) != 0 {
+			b = InitSetValue(int64(r.Intn(100)))
+		}
+		rows = append(rows, Row{InitSetValue(int64(r.Intn(1000))), b, InitSetValue(r.Float64() * 100),
+			InitSetValue("k" + strconv.Itoa(r.Intn(100)))})
+	}
+	return rows
+}
+
+// parseConditions return the conjuncts of the WHERE of SELECT * FROM bench WHERE cond
+func parseConditions(cond string) ([]Expression, error) {
+	node, err := parse("SELECT * FROM bench WHERE " + cond)
+	if err != nil {
+		return nil, err
+	}
+	stmt, ok := (*node).(*ast.SelectStmt)
+	if !ok || stmt.Where == nil {
+		return nil, fmt.Errorf("no condition in %v", cond)
+	}
+	return AnalyzeLogicalAndExpr(&stmt.Where), nil
+}
+
+// prepareExprBenchmark return the conjunction of the conditions cond interpreted by EvalExpression and compiled
+// by CompileExpression, and the number of rows they select. Both must select the same rows
+func prepareExprBenchmark(cond string, schema Schema, rows []Row) (interpreted, compiled func(Row) (bool, error),
+	selected int, err error) {
+	conds, err := parseConditions(cond)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var interpretedConds, compiledConds []CompiledExpr
+	for _, c := range conds {
+		interpretedConds = append(interpretedConds, InterpretExpression(c, schema))
+		compiledConds = append(compiledConds, CompileExpression(c, schema))
+	}
+	interpreted, compiled = conjunction(interpretedConds), conjunction(compiledConds)
+	counts := make([]int, 2)
+	for i, filter := range []func(Row) (bool, error){interpreted, compiled} {
+		for _, row := range rows {
+			ok, err := filter(row)
+			if err != nil {
+				return nil, nil, 0, fmt.Errorf("%v: %v", cond, err)
+			}
+			if ok {
+				counts[i]++
+			}
+		}
+	}
+	if counts[0] != counts[1] {
+		return nil, nil, 0, fmt.Errorf("%v: %d rows interpreted, %d rows compiled", cond, counts[0], counts[1])
+	}
+	return interpreted, compiled, counts[0], nil
+}
+
+// RunExprBenchmarks time every filter of exprBenchFilters on BenchRows generated rows, once interpreted
+// and once compiled
+func RunExprBenchmarks() ([]ExprBenchmark, error) {
+	schema := benchSchema()
+	rows := benchRows(BenchRows, rand.New(rand.NewSource(1)))
+	var ret []ExprBenchmark
+	for _, f := range exprBenchFilters {
+		interpreted, compiled, selected, err := prepareExprBenchmark(f.Cond, schema, rows)
+		if err != nil {
+			return nil, err
+		}
+		bench := ExprBenchmark{Name: f.Name, Cond: f.Cond, Selected: selected}
+		bench.Interpreted = timePerOp(func() { runFilter(interpreted, rows) })
+		bench.Compiled = timePerOp(func() { runFilter(compiled, rows) })
+		ret = append(ret, bench)
+	}
+	return ret, nil
+}
+
+func runFilter(filter func(Row) (bool, error), rows []Row) {
+	for _, row := range rows {
+		filter(row)
+	}
+}
+
+// nsPerRow is the time of one row of a benchmark running over BenchRows rows
+func nsPerRow(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / BenchRows
+}
+
+// OutputExprBenchmarks print the time per row of the interpreter and of the compiled closures
+func OutputExprBenchmarks(benchmarks []ExprBenchmark) {
+	fmt.Printf("%-18s %8s %14s %14s %8s\n", "filter", "selected", "interpreted", "compiled", "speedup")
+	for _, b := range benchmarks {
+		fmt.Printf("%-18s %8d %11.1fns %11.1fns %7.1fx\n", b.Name, b.Selected, nsPerRow(b.Interpreted),
+			nsPerRow(b.Compiled), nsPerRow(b.Interpreted)/nsPerRow(b.Compiled))
+	}
+}
+
+// TPCHRows is the number of rows of the generated lineitem, orders and customer have 4 and 32 times fewer rows
+const TPCHRows = 16384
+
+// tpchQueries are the analytic queries of the vectorized benchmark, modelled on the queries 1, 6 and 3 of TPC-H.
+// The dates are BIGINT of the form yyyymmdd
+var tpchQueries = []struct {
+	Name string
+	SQL  string
+}{
+	{"pricing summary", "SELECT l_returnflag, l_linestatus, sum(l_quantity), sum(l_extendedprice), " +
+		"sum(l_extendedprice * (1e0 - l_discount)), avg(l_quantity), avg(l_discount), count(*) FROM lineitem " +
+		"WHERE l_shipdate <= 19980902 GROUP BY l_returnflag, l_linestatus"},
+	{"forecast revenue", "SELECT sum(l_extendedprice * l_discount) FROM lineitem WHERE l_shipdate >= 19940101 " +
+		"AND l_shipdate < 19950101 AND l_discount >= 5e-2 AND l_discount <= 7e-2 AND l_quantity < 24"},
+	{"shipping priority", "SELECT l_orderkey, sum(l_extendedprice * (1e0 - l_discount)), o_orderdate, o_shippriority " +
+		"FROM customer, orders, lineitem WHERE c_mktsegment = 'BUILDING' AND c_custkey = o_custkey " +
+		"AND l_orderkey = o_orderkey AND o_orderdate < 19950315 AND l_shipdate > 19950315 " +
+		"GROUP BY l_orderkey, o_orderdate, o_shippriority"},
+}
+
+// VecBenchmark is the time of a query executed row by row and by chunks
+type VecBenchmark struct {
+	Name       string
+	SQL        string
+	Rows       int //the rows of the result
+	RowByRow   time.Duration
+	Vectorized time.Duration
+}
+
+type benchColumn struct {
+	name string
+	tp   byte
+}
+
+func benchTableDef(name string, columns ...benchColumn) TableDef {
+	def := TableDef{Name: name}
+	for _, c := range columns {
+		def.Columns = append(def.Columns, ColumnDef{Name: c.name, Tp: c.tp, NotNull: true})
+	}
+	return def
+}
+
+// benchDate is the date days after 1992-01-01 as yyyymmdd
+func benchDate(days int) int64 {
+	d := time.Date(1992, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, days)
+	return int64(d.Year()*10000 + int(d.Month())*100 + d.Day())
+}
+
+// RegisterTPCHTables generate the MemTables customer, orders and lineitem with TPCHRows lineitems
+func RegisterTPCHTables(r *rand.Rand) {
+	customers, orders := TPCHRows/32, TPCHRows/4
+	segments := []string{"AUTOMOBILE", "BUILDING", "FURNITURE", "HOUSEHOLD", "MACHINERY"}
+	var rows []Row
+	for i := 1; i <= customers; i++ {
+		rows = append(rows, Row{InitSetValue(int64(i)), InitSetValue("Customer#" + strconv.Itoa(i)),
+			InitSetValue(segments[r.Intn(len(segments))]), InitSetValue(int64(r.Intn(25)))})
+	}
+	RegisterMemTable(benchTableDef("customer", benchColumn{"c_custkey", test_driver.KindInt64},
+		benchColumn{"c_name", test_driver.KindString}, benchColumn{"c_mktsegment", test_driver.KindString},
+		benchColumn{"c_nationkey", test_driver.KindInt64}), rows)
+
+	rows = nil
+	dates := make([]int, orders+1)
+	for i := 1; i <= orders; i++ {
+		dates[i] = r.Intn(2405)
+		rows = append(rows, Row{InitSetValue(int64(i)), InitSetValue(int64(r.Intn(customers) + 1)),
+			InitSetValue(benchDate(dates[i])), InitSetValue(int64(r.Intn(5))), InitSetValue(r.Float64() * 100000)})
+	}
+	RegisterMemTable(benchTableDef("orders", benchColumn{"o_orderkey", test_driver.KindInt64},
+		benchColumn{"o_custkey", test_driver.KindInt64}, benchColumn{"o_orderdate", test_driver.KindInt64},
+		benchColumn{"o_shippriority", test_driver.KindInt64}, benchColumn{"o_totalprice", test_driver.KindFloat64}), rows)
+
+	rows = nil
+	for i := 0; i < TPCHRows; i++ {
+		order := r.Intn(orders) + 1
+		quantity := int64(r.Intn(50) + 1)
+		rows = append(rows, Row{InitSetValue(int64(order)), InitSetValue(quantity),
+			InitSetValue(float64(quantity) * (900 + r.Float64()*100)), InitSetValue(float64(r.Intn(11)) / 100),
+			InitSetValue(float64(r.Intn(9)) / 100), InitSetValue([]string{"R", "A", "N"}[r.Intn(3)]),
+			InitSetValue([]string{"O", "F"}[r.Intn(2)]), InitSetValue(benchDate(dates[order] + r.Intn(121) + 1))})
+	}
+	RegisterMemTable(benchTableDef("lineitem", benchColumn{"l_orderkey", test_driver.KindInt64},
+		benchColumn{"l_quantity", test_driver.KindInt64}, benchColumn{"l_extendedprice", test_driver.KindFloat64},
+		benchColumn{"l_discount", test_driver.KindFloat64}, benchColumn{"l_tax", test_driver.KindFloat64},
+		benchColumn{"l_returnflag", test_driver.KindString}, benchColumn{"l_linestatus", test_driver.KindString},
+		benchColumn{"l_shipdate", test_driver.KindInt64}), rows)
+}
+
+// optimizedPlan parse and optimize sql without printing the rules
+func optimizedPlan(sql string) (*LogicalPlan, error) {
+	node, err := parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	plan := GetQuery(node)
+	quiet := QuietRules
+	QuietRules = true
+	treeRoot = plan
+	plan.QueryOptimizer()
+	QuietRules = quiet
+	return treeRoot, nil
+}
+
+// prepareVecBenchmark return the optimized plan of sql and the number of rows it returns on the generated TPC-H
+// tables, which must be the same row by row and by chunks
+func prepareVecBenchmark(sql string) (*LogicalPlan, int, error) {
+	plan, err := optimizedPlan(sql)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, rows1, err := Execute(plan)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, rows2, err := ExecuteVectorized(plan)
+	if err != nil {
+		return nil, 0, fmt.Errorf("vectorized: %v", err)
+	}
+	if len(rows1) != len(rows2) || !sameRows(rows1, rows2) {
+		return nil, 0, fmt.Errorf("%d rows row by row, %d rows vectorized", len(rows1), len(rows2))
+	}
+	return plan, len(rows1), nil
+}
+
+// RunVecBenchmarks time the queries of tpchQueries on the generated TPC-H tables, once row by row and once
+// by chunks
+func RunVecBenchmarks() ([]VecBenchmark, error) {
+	RegisterTPCHTables(rand.New(rand.NewSource(1)))
+	var ret []VecBenchmark
+	for _, q := range tpchQueries {
+		plan, rows, err := prepareVecBenchmark(q.SQL)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", q.Name, err)
+		}
+		bench := VecBenchmark{Name: q.Name, SQL: q.SQL, Rows: rows}
+		bench.RowByRow = timePerOp(func() { Execute(plan) })
+		bench.Vectorized = timePerOp(func() { ExecuteVectorized(plan) })
+		ret = append(ret, bench)
+	}
+	return ret, nil
+}
+
+// OutputVecBenchmarks print the time of every query row by row and vectorized
+func OutputVecBenchmarks(benchmarks []VecBenchmark) {
+	fmt.Printf("%-18s %6s %14s %14s %8s\n", "query", "rows", "row by row", "vectorized", "speedup")
+	for _, b := range benchmarks {
+		row, vec := float64(b.RowByRow.Nanoseconds())/1e6, float64(b.Vectorized.Nanoseconds())/1e6
+		fmt.Printf("%-18s %6d %12.2fms %12.2fms %7.1fx\n", b.Name, b.Rows, row, vec, row/vec)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestExprBenchmarkFilters(t *testing.T) {
+	schema := benchSchema()
+	rows := benchRows(BenchRows, rand.New(rand.NewSource(1)))
+	for _, f := range exprBenchFilters {
+		if _, _, selected, err := prepareExprBenchmark(f.Cond, schema, rows); err != nil {
+			t.Error(err)
+		} else if selected == 0 || selected == len(rows) {
+			t.Errorf("%v selects %d rows of %d", f.Cond, selected, len(rows))
+		}
+	}
+}
+
+func TestVecBenchmarkQueries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the join of shipping priority takes seconds row by row")
+	}
+	RegisterTPCHTables(rand.New(rand.NewSource(1)))
+	for _, q := range tpchQueries {
+		if _, rows, err := prepareVecBenchmark(q.SQL); err != nil {
+			t.Errorf("%v: %v", q.Name, err)
+		} else if rows == 0 {
+			t.Errorf("%v returns no row", q.Name)
+		}
+	}
+}
+
+// BenchmarkExpressions run every filter of exprBenchFilters on BenchRows generated rows, once interpreted
+// by EvalExpression and once compiled by CompileExpression
+func BenchmarkExpressions(b *testing.B) {
+	schema := benchSchema()
+	rows := benchRows(BenchRows, rand.New(rand.NewSource(1)))
+	for _, f := range exprBenchFilters {
+		interpreted, compiled, _, err := prepareExprBenchmark(f.Cond, schema, rows)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(f.Name+"/interpreted", filterBenchmark(interpreted, rows))
+		b.Run(f.Name+"/compiled", filterBenchmark(compiled, rows))
+	}
+}
+
+func filterBenchmark(filter func(Row) (bool, error), rows []Row) func(b *testing.B) {
+	return func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			runFilter(filter, rows)
+		}
+	}
+}
+
+// BenchmarkVectorized run the queries of tpchQueries on the generated TPC-H tables, once row by row and once
+// by chunks
+func BenchmarkVectorized(b *testing.B) {
+	RegisterTPCHTables(rand.New(rand.NewSource(1)))
+	for _, q := range tpchQueries {
+		plan, _, err := prepareVecBenchmark(q.SQL)
+		if err != nil {
+			b.Fatalf("%v: %v", q.Name, err)
+		}
+		b.Run(q.Name+"/row by row", queryBenchmark(Execute, plan))
+		b.Run(q.Name+"/vectorized", queryBenchmark(ExecuteVectorized, plan))
+	}
+}
+
+func queryBenchmark(execute func(*LogicalPlan) (Schema, []Row, error), plan *LogicalPlan) func(b *testing.B) {
+	return func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := execute(plan); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The heuristics used when nothing is known about the data
+const (
+	DefaultTableRows        = 1000.0  //rows of a base table whose TableDef has no Rows
+	DefaultEqualSelectivity = 0.1     //fraction of the rows kept by an equality
+	DefaultSelectivity      = 1.0 / 3 //fraction of the rows kept by any other conjunct
+	DefaultNDVRatio         = 0.1     //distinct values of a column per row
+)
+
+// EstimateRows estimate the number of rows produced by plan
+func EstimateRows(plan *LogicalPlan) float64 {
+	switch plan.Tp {
+	case Table:
+		if len(plan.child) > 0 {
+			return EstimateRows(&plan.child[0])
+		}
+		return TableRows(plan.Content.(TableNode).Table.OrigTblName)
+	case EmptyRelation:
+		return 0
+	case Filter, HavingFilter:
+		return EstimateRows(&plan.child[0]) * Selectivity(plan.childSchema(), plan.Expressions())
+	case Project:
+		if len(plan.child) == 0 {
+			return 1
+		}
+		if hasAggregate(plan.Content.(ProjectionNode).cols) {
+			return 1
+		}
+		return EstimateRows(&plan.child[0])
+	case Aggregate:
+		n := plan.Content.(AggregateNode)
+		if len(n.Items) == 0 {
+			return 1
+		}
+		return EstimateGroups(&plan.child[0], n.Items)
+	case Limit:
+		return limitRows(EstimateRows(&plan.child[0]), plan.Content.(LimitNode).Count)
+	case TopN:
+		return limitRows(EstimateRows(&plan.child[0]), plan.Content.(TopNNode).Count)
+	case Union:
+		var ret float64
+		for i := range plan.child {
+			ret += EstimateRows(&plan.child[i])
+		}
+		return ret
+	case Join:
+		return estimateJoinRows(plan)
+	}
+	if len(plan.child) == 1 {
+		return EstimateRows(&plan.child[0])
+	}
+	return DefaultTableRows
+}
+
+// TableRows return the number of rows of a base table from its statistics, or from the Catalog
+func TableRows(table string) float64 {
+	if stats, ok := LookupStats(table); ok {
+		return stats.Rows
+	}
+	if def, ok := LookupTable(table); ok && def.Rows > 0 {
+		return def.Rows
+	}
+	return DefaultTableRows
+}
+
+func limitRows(rows float64, count Expression) float64 {
+	if n, ok := LimitValue(count); ok && float64(n) < rows {
+		return float64(n)
+	}
+	return rows
+}
+
+// estimateJoinRows : an equality between the two sides keeps one row of the cross product per distinct value
+// of the side with the most distinct values, the other conjuncts use the default selectivities.
+// An outer join returns at least the rows of its preserved side
+func estimateJoinRows(join *LogicalPlan) float64 {
+	if len(join.child) != 2 {
+		return EstimateRows(&join.child[0])
+	}
+	l, r := EstimateRows(&join.child[0]), EstimateRows(&join.child[1])
+	rows := l * r
+	for _, expr := range join.Content.(JoinNode).On {
+		if lcol, rcol, ok := EquiJoinColumns(join, expr); ok {
+			ndv := math.Max(EstimateNDV(&join.child[0], lcol), EstimateNDV(&join.child[1], rcol))
+			rows /= math.Max(ndv, 1)
+		} else {
+			rows *= Selectivity(join.Schema(), []Expression{expr})
+		}
+	}
+	switch join.Content.(JoinNode).Tp {
+	case ast.LeftJoin:
+		rows = math.Max(rows, l)
+	case ast.RightJoin:
+		rows = math.Max(rows, r)
+	case FullJoin:
+		rows = math.Max(rows, l+r)
+	}
+	return rows
+}
+
+// EquiJoinColumns return the columns of the left and right child of join compared by the conjunct `l = r`
+func EquiJoinColumns(join *LogicalPlan, expr Expression) (ColumnName, ColumnName, bool) {
+	n := len(expr.expr)
+	if n != 3 || !expr.IsColumnDatum(expr.expr[0]) || !expr.IsColumnDatum(expr.expr[1]) ||
+		expr.expr[2].Args != nil || StrToOp(expr.expr[2].GetString()) != EQ {
+		return ColumnName{}, ColumnName{}, false
+	}
+	c1, c2 := expr.Fields[expr.expr[0].GetString()], expr.Fields[expr.expr[1].GetString()]
+	switch {
+	case join.ChildOfColumn(c1) == 0 && join.ChildOfColumn(c2) == 1:
+		return c1, c2, true
+	case join.ChildOfColumn(c1) == 1 && join.ChildOfColumn(c2) == 0:
+		return c2, c1, true
+	}
+	return ColumnName{}, ColumnName{}, false
+}
+
+// ColumnStatsOf return the statistics of the base table column behind col, which is resolved in schema
+func ColumnStatsOf(schema Schema, col ColumnName) (*ColumnStats, bool) {
+	i := schema.ResolveColumn(col)
+	if i == -1 || schema.Columns[i].OrigTblName == "" {
+		return nil, false
+	}
+	stats, ok := LookupStats(schema.Columns[i].OrigTblName)
+	if !ok {
+		return nil, false
+	}
+	return stats.Column(schema.Columns[i].OrigColName)
+}
+
+// Selectivity estimate the fraction of the rows for which all the conjuncts are TRUE, the columns are resolved in
+// schema. The conjuncts are assumed independent
+func Selectivity(schema Schema, exprs []Expression) float64 {
+	ret := 1.0
+	for _, expr := range exprs {
+		ret *= treeSelectivity(schema, simplifyTree(expr.Tree(), true))
+	}
+	return ret
+}
+
+func treeSelectivity(schema Schema, t *ExprTree) float64 {
+	if t == nil {
+		return 1
+	}
+	if t.IsConstant() {
+		if truth, _ := Truth(t.Value); truth {
+			return 1
+		}
+		return 0
+	}
+	op := t.Op
+	if t.Func {
+		op = t.FuncOp()
+	}
+	switch op {
+	case LogicAnd:
+		return treeSelectivity(schema, t.Children[0]) * treeSelectivity(schema, t.Children[1])
+	case LogicOr:
+		s1, s2 := treeSelectivity(schema, t.Children[0]), treeSelectivity(schema, t.Children[1])
+		return s1 + s2 - s1*s2
+	case Not, Not2:
+		if len(t.Children) == 1 {
+			return math.Max(0, 1-treeSelectivity(schema, t.Children[0]))
+		}
+	case IsNull:
+		if len(t.Children) == 1 && t.Children[0].Column != nil {
+			if c, ok := ColumnStatsOf(schema, *t.Children[0].Column); ok {
+				return c.NullFraction
+			}
+		}
+		return DefaultEqualSelectivity
+	case In:
+		if len(t.Children) > 1 && t.Children[0].Column != nil {
+			c, ok := ColumnStatsOf(schema, *t.Children[0].Column)
+			ret := 0.0
+			for _, v := range t.Children[1:] {
+				switch {
+				case !v.IsConstant():
+					ret += DefaultEqualSelectivity
+				case ok:
+					ret += c.EqualFraction(v.Value)
+				default:
+					ret += DefaultEqualSelectivity
+				}
+			}
+			return math.Min(ret, 1)
+		}
+	case EQ, NE, LT, LE, GT, GE:
+		return comparisonSelectivity(schema, op, t.Children[0], t.Children[1])
+	}
+	return DefaultSelectivity
+}
+
+// comparisonSelectivity : `col op constant` uses the statistics of col, `col = col` keeps one row per distinct value
+func comparisonSelectivity(schema Schema, op MyOp, l, r *ExprTree) float64 {
+	if l.IsConstant() && r.Column != nil {
+		l, r, op = r, l, ReverseComparison(op)
+	}
+	var eq = DefaultEqualSelectivity
+	if l.Column != nil && r.Column != nil {
+		ndv := 1.0
+		for _, col := range []*ColumnName{l.Column, r.Column} {
+			if c, ok := ColumnStatsOf(schema, *col); ok {
+				ndv = math.Max(ndv, c.NDV)
+			} else if n, ok := UniqueKeyNDV(schema, *col); ok {
+				ndv = math.Max(ndv, n)
+			}
+		}
+		if ndv > 1 {
+			eq = 1 / ndv
+		}
+	}
+	var c *ColumnStats
+	var ok bool
+	if l.Column != nil && r.IsConstant() {
+		c, ok = ColumnStatsOf(schema, *l.Column)
+		if n, unique := UniqueKeyNDV(schema, *l.Column); !ok && unique {
+			eq = 1 / n
+		}
+	}
+	switch op {
+	case EQ:
+		if ok {
+			return c.EqualFraction(r.Value)
+		}
+		return eq
+	case NE:
+		if ok {
+			return math.Max(0, 1-c.NullFraction-c.EqualFraction(r.Value))
+		}
+		return 1 - eq
+	}
+	if !ok {
+		return DefaultSelectivity
+	}
+	notNull := 1 - c.NullFraction
+	var ret float64
+	switch op {
+	case LT, GE:
+		ret, ok = c.LessFraction(r.Value, false)
+	case LE, GT:
+		ret, ok = c.LessFraction(r.Value, true)
+	}
+	if !ok {
+		return DefaultSelectivity
+	}
+	if op == GT || op == GE {
+		ret = notNull - ret
+	}
+	return math.Max(0, math.Min(ret, notNull))
+}
+
+// UniqueKeyNDV return the number of rows of the base table behind col, resolved in schema, when col alone is
+// a unique index of it: every row has its own value
+func UniqueKeyNDV(schema Schema, col ColumnName) (float64, bool) {
+	i := schema.ResolveColumn(col)
+	if i == -1 || schema.Columns[i].OrigTblName == "" {
+		return 0, false
+	}
+	def, ok := LookupTable(schema.Columns[i].OrigTblName)
+	if !ok {
+		return 0, false
+	}
+	for _, index := range def.Indexes {
+		if index.Unique && len(index.Columns) == 1 && strings.EqualFold(index.Columns[0], schema.Columns[i].OrigColName) {
+			return math.Max(TableRows(def.Name), 1), true
+		}
+	}
+	return 0, false
+}
+
+// EstimateNDV estimate the number of distinct values of col in the output of plan
+func EstimateNDV(plan *LogicalPlan, col ColumnName) float64 {
+	rows := EstimateRows(plan)
+	if c, ok := ColumnStatsOf(plan.Schema(), col); ok && c.NDV > 0 {
+		return math.Max(math.Min(c.NDV, rows), 1)
+	}
+	if n, ok := UniqueKeyNDV(plan.Schema(), col); ok {
+		return math.Max(math.Min(n, rows), 1)
+	}
+	return math.Min(rows, math.Max(rows*DefaultNDVRatio, 1))
+}
+
+// EstimateGroups estimate the number of distinct values of the keys in the output of plan. The columns are
+// not independent, so the distinct values of the less selective keys are damped by a square root each time
+func EstimateGroups(plan *LogicalPlan, keys []Expression) float64 {
+	rows := EstimateRows(plan)
+	var ndvs []float64
+	for _, key := range keys {
+		if col, ok := SingleColumn(key); ok {
+			ndvs = append(ndvs, EstimateNDV(plan, col))
+		} else {
+			ndvs = append(ndvs, math.Max(rows*DefaultNDVRatio, 1))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(ndvs)))
+	ret, exp := 1.0, 1.0
+	for _, ndv := range ndvs {
+		ret *= math.Pow(ndv, exp)
+		exp /= 2
+	}
+	return math.Min(ret, rows)
+}
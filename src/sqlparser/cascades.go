@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/ast"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxMemoExprs stops the exploration once the memo holds that many expressions
+var MaxMemoExprs = 5000
+
+// Memo holds the groups of equivalent expressions explored by the Cascades search
+type Memo struct {
+	Groups []*Group
+	Root   *Group
+	Pruned int //expressions skipped by the search because they could not beat the best plan found
+	exprs  map[string]*GroupExpr
+	count  int
+}
+
+// Group is a set of logically equivalent expressions. The first expression is the one the group was created
+// with, its children are older groups, so following the first expressions always ends
+type Group struct {
+	ID        int
+	Exprs     []*GroupExpr
+	explored  bool
+	winners   map[string]*PhysicalPlan //the best plan for every order asked, see orderKeys
+	searching map[string]bool          //the orders being searched, to cut the cycles between groups
+	lowest    float64                  //the cost of the cheapest winner, 0 if none
+}
+
+// GroupExpr is a logical operator whose children are groups
+type GroupExpr struct {
+	Tp       OpType
+	Content  interface{}
+	Children []*Group
+	group    *Group
+	applied  map[int]bool //the transformation rules already applied, by index in TransformationRules
+}
+
+// TransformationRule rewrites a binding of Pattern, a copy of the plan made of expressions of the memo,
+// into an equivalent plan. Apply rewrites the binding in place and return true if it changed
+type TransformationRule struct {
+	Name    string
+	Pattern Pattern
+	Apply   func(plan *LogicalPlan) bool
+}
+
+// TransformationRules are the rewrites of QueryOptimizer, and the join commutativity the single tree can not hold
+var TransformationRules = []TransformationRule{
+	{"MergeFilters", Pattern{Filter, []Pattern{{Tp: Filter}}}, MergeFilters},
+	{"PredicatePushToProject", Pattern{Filter, []Pattern{{Table, []Pattern{{Tp: Project}}}}}, func(plan *LogicalPlan) bool {
+		return CanPredicatePush2Project(plan) && PredicatePush2ProjectForInstance(plan)
+	}},
+	{"PredicatePushToAggregate", Pattern{Filter, []Pattern{{Tp: Aggregate}}}, func(plan *LogicalPlan) bool {
+		return CanPush2Aggregator(&plan.child[0]) && PredicatePush2AggregatorForInstance(plan, &plan.child[0])
+	}},
+	{"PredicatePushToAggregate", Pattern{Filter, []Pattern{{Table, []Pattern{{Tp: Aggregate}}}}}, func(plan *LogicalPlan) bool {
+		aggregate := &plan.child[0].child[0]
+		return plan.child[0].IsDerivedTable() && CanPush2Aggregator(aggregate) &&
+			PredicatePush2AggregatorForInstance(plan, aggregate)
+	}},
+	{"HavingPushToAggregate", Pattern{HavingFilter, []Pattern{{Tp: Aggregate}}}, func(plan *LogicalPlan) bool {
+		return CanPush2Aggregator(&plan.child[0]) && HavingPush2AggregateForInstance(plan, &plan.child[0])
+	}},
+	{"SimplifyOuterJoin", Pattern{Filter, []Pattern{{Tp: Join}}}, func(plan *LogicalPlan) bool {
+		return len(plan.child[0].child) == 2 && SimplifyOuterJoinForInstance(&plan.child[0])
+	}},
+	{"InferPredicates", Pattern{Filter, []Pattern{{Tp: Join}}}, func(plan *LogicalPlan) bool {
+		return len(plan.child[0].child) == 2 && InferPredicatesForInstance(&plan.child[0])
+	}},
+	{"InferPredicates", Pattern{Tp: Join}, func(plan *LogicalPlan) bool {
+		return len(plan.child) == 2 && InferPredicatesForInstance(plan)
+	}},
+	{"BuildTopN", Pattern{Limit, []Pattern{{Tp: OrderBy}}}, func(plan *LogicalPlan) bool {
+		return plan.BuildTopN()
+	}},
+	{"TopNPushDown", Pattern{TopN, []Pattern{{Tp: AnyOp}}}, TopNPushDownForInstance},
+	{"LimitPushDown", Pattern{Limit, []Pattern{{Tp: AnyOp}}}, LimitPushDownForInstance},
+	{"FoldDerivedTable", Pattern{Table, []Pattern{{Tp: Project}}}, FoldDerivedTable},
+	{"MergeProjection", Pattern{Project, []Pattern{{Tp: Project}}}, MergeProjection},
+	{"JoinCommute", Pattern{Join, []Pattern{{Tp: AnyOp}, {Tp: AnyOp}}}, CommuteJoin},
+}
+
+// CascadesOptimizer is the alternative to QueryOptimizer followed by BuildPhysicalPlan. The expressions are
+// simplified, then the plan is copied into a Memo where the transformation rules add the equivalent expressions
+// instead of rewriting the single tree, and the search picks the cheapest physical plan among all of them.
+// The plan of QueryOptimizer is added to the root group, the rules without transformation rule, like
+// JoinReorder and PropagateEmptyRelation, take part this way and the memo is never costlier than the rules
+func CascadesOptimizer(plan *LogicalPlan) (*Memo, *PhysicalPlan) {
+	rewritten, err := CopyLogicalPlan(plan)
+	treeRoot = plan
+	plan.SimplifyExpressions()
+	plan.ResetParent()
+	m := NewMemo(plan)
+	quiet := QuietRules
+	QuietRules = true
+	if err == nil {
+		treeRoot = rewritten
+		rewritten.QueryOptimizer()
+		treeRoot.ResetParent()
+		m.insert(treeRoot, m.Root)
+		treeRoot = plan
+	}
+	m.explore(m.Root)
+	QuietRules = quiet
+	return m, m.optimizeGroup(m.Root, nil)
+}
+
+func NewMemo(plan *LogicalPlan) *Memo {
+	m := &Memo{exprs: make(map[string]*GroupExpr)}
+	m.Root, _ = m.insert(plan, nil)
+	return m
+}
+
+// insert add the tree plan to the memo, into target if not nil. The subtrees already in the memo are shared.
+// Return the group of plan and whether a new expression was added
+func (m *Memo) insert(plan *LogicalPlan, target *Group) (*Group, bool) {
+	var children []*Group
+	for i := range plan.child {
+		g, _ := m.insert(&plan.child[i], nil)
+		children = append(children, g)
+	}
+	key := fingerprint(plan.Tp, plan.Content, children)
+	if e, ok := m.exprs[key]; ok {
+		return e.group, false
+	}
+	//an expression of target reading target itself, like a Project keeping all the columns in order,
+	//adds nothing and would make the group its own input
+	for _, g := range children {
+		if g == target {
+			return target, false
+		}
+	}
+	if target == nil {
+		target = &Group{
+			ID:        len(m.Groups),
+			winners:   make(map[string]*PhysicalPlan),
+			searching: make(map[string]bool),
+		}
+		m.Groups = append(m.Groups, target)
+	}
+	e := &GroupExpr{Tp: plan.Tp, Content: plan.Content, Children: children, group: target, applied: make(map[int]bool)}
+	target.Exprs = append(target.Exprs, e)
+	m.exprs[key] = e
+	m.count++
+	return target, true
+}
+
+// fingerprint identify an expression by its operator, its content and the groups of its children
+func fingerprint(tp OpType, content interface{}, children []*Group) string {
+	enc := &planEncoder{fingerprint: true}
+	p := enc.encodePlan(&LogicalPlan{Tp: tp, Content: content})
+	var b strings.Builder
+	b.WriteString(tp.String())
+	if p != nil {
+		b.Write(p.Content)
+	}
+	for _, g := range children {
+		b.WriteString(" " + strconv.Itoa(g.ID))
+	}
+	return b.String()
+}
+
+// extract build the tree of e, the children groups are represented by their first expression
+func (m *Memo) extract(e *GroupExpr) LogicalPlan {
+	ret := LogicalPlan{Tp: e.Tp, Content: e.Content}
+	for _, g := range e.Children {
+		ret.child = append(ret.child, m.extract(g.Exprs[0]))
+	}
+	return ret
+}
+
+// copyTree deep copy plan, the rules may modify the contents of the binding they rewrite
+func copyTree(plan *LogicalPlan) LogicalPlan {
+	ret := LogicalPlan{Tp: plan.Tp, Content: plan.Content}
+	enc := new(planEncoder)
+	if p := enc.encodePlan(&LogicalPlan{Tp: plan.Tp, Content: plan.Content}); enc.err == nil {
+		if copied, err := decodePlan(p); err == nil {
+			ret.Content = copied.Content
+		}
+	}
+	for i := range plan.child {
+		ret.child = append(ret.child, copyTree(&plan.child[i]))
+	}
+	return ret
+}
+
+// explore apply the transformation rules to the expressions of g, the groups of the children are explored first
+// so the patterns see all their expressions
+func (m *Memo) explore(g *Group) {
+	if g.explored {
+		return
+	}
+	g.explored = true
+	for i := 0; i < len(g.Exprs) && m.count < MaxMemoExprs; i++ {
+		e := g.Exprs[i]
+		for _, child := range e.Children {
+			m.explore(child)
+		}
+		for r, rule := range TransformationRules {
+			if e.applied[r] {
+				continue
+			}
+			e.applied[r] = true
+			for _, binding := range m.bindings(e, rule.Pattern) {
+				m.transform(e, rule, &binding)
+			}
+		}
+	}
+}
+
+// bindings return the plans made of e and the expressions of its children groups matching p, every expression
+// of a group is tried for a child of p, AnyOp included. The groups below the leaves of p are represented by
+// their first expression
+func (m *Memo) bindings(e *GroupExpr, p Pattern) []LogicalPlan {
+	if p.Tp != AnyOp && p.Tp != e.Tp {
+		return nil
+	}
+	if len(p.Children) > 0 && len(p.Children) != len(e.Children) {
+		return nil
+	}
+	ret := []LogicalPlan{{Tp: e.Tp, Content: e.Content}}
+	for i, g := range e.Children {
+		var options []LogicalPlan
+		if len(p.Children) == 0 {
+			options = []LogicalPlan{m.extract(g.Exprs[0])}
+		} else {
+			for _, child := range g.Exprs {
+				options = append(options, m.bindings(child, p.Children[i])...)
+			}
+		}
+		var next []LogicalPlan
+		for _, b := range ret {
+			for _, o := range options {
+				nb := b
+				nb.child = append(append([]LogicalPlan{}, b.child...), o)
+				next = append(next, nb)
+			}
+		}
+		ret = next
+	}
+	return ret
+}
+
+// transform apply rule to a copy of binding and add the result to the group of e. The binding is put under
+// a holder node, so the rules can replace or delete its root like any other node. The result is dropped if
+// its schema differs, the columns of the tables missing from the Catalog are inferred from the binding only
+func (m *Memo) transform(e *GroupExpr, rule TransformationRule, binding *LogicalPlan) {
+	holder := LogicalPlan{child: []LogicalPlan{copyTree(binding)}}
+	holder.ResetParent()
+	schema := holder.child[0].Schema()
+	saved := treeRoot
+	treeRoot = &holder
+	modify := rule.Apply(&holder.child[0])
+	treeRoot = saved
+	if !modify || len(holder.child) != 1 {
+		return
+	}
+	result := &holder.child[0]
+	removeEmptyFilters(result)
+	holder.ResetParent()
+	if !SameSchema(schema, result.Schema()) {
+		return
+	}
+	result.parent = nil
+	m.insert(result, e.group)
+}
+
+// removeEmptyFilters remove the Filters a rule left without conjunct
+func removeEmptyFilters(plan *LogicalPlan) {
+	for i := range plan.child {
+		removeEmptyFilters(&plan.child[i])
+	}
+	if plan.Tp == Filter && len(plan.Expressions()) == 0 && len(plan.child) == 1 {
+		parent := plan.parent
+		*plan = plan.child[0]
+		plan.parent = parent
+	}
+}
+
+// CommuteJoin swap the children of an inner join, a Project restores the order of the columns.
+// All the columns must be known, so the base tables must be in the Catalog
+func CommuteJoin(join *LogicalPlan) bool {
+	if tp := join.Content.(JoinNode).Tp; tp != ast.CrossJoin && tp != InnerJoin {
+		return false
+	}
+	var known = true
+	walkQueryBlock(join, func(p *LogicalPlan) {
+		if isBaseTable(p) {
+			if _, ok := LookupTable(p.Content.(TableNode).Table.OrigTblName); !ok {
+				known = false
+			}
+		}
+	})
+	schema := join.Schema()
+	if !known || schema.Len() == 0 {
+		return false
+	}
+	var cols []Expression
+	for _, c := range schema.Columns {
+		col := ColumnName{TblName: c.TblName, ColName: c.ColName}
+		if schema.ResolveColumn(col) == -1 {
+			return false
+		}
+		cols = append(cols, ColumnExpression(col))
+	}
+	swapped := *join
+	swapped.child = []LogicalPlan{join.child[1], join.child[0]}
+	parent := join.parent
+	*join = *OpNodeInit(Project, ProjectionNode{cols})
+	join.child = []LogicalPlan{swapped}
+	join.parent = parent
+	join.ResetParent()
+	return true
+}
+
+// optimizeGroup return the cheapest plan of g producing its rows in order. An expression is skipped when the
+// costs of its children already exceed the best plan found, a cycle back to a group being searched costs +Inf
+func (m *Memo) optimizeGroup(g *Group, order []ByItem) *PhysicalPlan {
+	rep := m.extract(g.Exprs[0])
+	rep.ResetParent()
+	want := orderKeys(rep.Schema(), order)
+	key := strings.Join(want, ",")
+	if ret, ok := g.winners[key]; ok {
+		return ret
+	}
+	if g.searching[key] {
+		return &PhysicalPlan{Cost: math.Inf(1)}
+	}
+	g.searching[key] = true
+	var ret *PhysicalPlan
+	for _, e := range g.Exprs {
+		if ret != nil && m.lowerBound(e) >= ret.Cost {
+			m.Pruned++
+			continue
+		}
+		plan := m.extract(e)
+		plan.ResetParent()
+		children := make(map[*LogicalPlan]*Group)
+		for i := range plan.child {
+			children[&plan.child[i]] = e.Children[i]
+		}
+		b := &physicalBuilder{memo: make(map[string]*PhysicalPlan)}
+		b.input = func(child *LogicalPlan, order []ByItem) *PhysicalPlan {
+			if g, ok := children[child]; ok {
+				return m.optimizeGroup(g, order)
+			}
+			return b.best(child, order)
+		}
+		for _, p := range b.enumerate(&plan, order) {
+			p = enforceOrder(p, order, want)
+			if ret == nil || p.Cost < ret.Cost {
+				ret = p
+			}
+		}
+	}
+	delete(g.searching, key)
+	g.winners[key] = ret
+	if g.lowest == 0 || ret.Cost < g.lowest {
+		g.lowest = ret.Cost
+	}
+	return ret
+}
+
+// lowerBound return a cost no plan of e can beat, the costs of its children groups if it reads all their rows
+func (m *Memo) lowerBound(e *GroupExpr) float64 {
+	switch e.Tp {
+	case Project, Aggregate, GroupBy, OrderBy, Union, HavingFilter, Table:
+	case Filter:
+		//an index may read less than the base table
+		if e.Children[0].Exprs[0].Tp == Table && len(e.Children[0].Exprs[0].Children) == 0 {
+			return 0
+		}
+	default:
+		return 0
+	}
+	var ret float64
+	for _, g := range e.Children {
+		ret += g.lowest
+	}
+	return ret
+}
+
+// Output print the groups of the memo and their expressions
+func (m *Memo) Output() {
+	fmt.Printf("Memo: %d groups, %d expressions, %d pruned\n", len(m.Groups), m.count, m.Pruned)
+	for _, g := range m.Groups {
+		fmt.Printf(" Group %d:\n", g.ID)
+		for _, e := range g.Exprs {
+			fmt.Printf("     %v: ", e.Tp)
+			if n, ok := e.Content.(interface{ print() }); ok {
+				n.print()
+			}
+			var children []string
+			for _, child := range e.Children {
+				children = append(children, strconv.Itoa(child.ID))
+			}
+			fmt.Printf(" [%v]\n", strings.Join(children, ", "))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+// transformationRule return the first rule of TransformationRules named name
+func transformationRule(t *testing.T, name string) TransformationRule {
+	t.Helper()
+	for _, rule := range TransformationRules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	t.Fatalf("no transformation rule %v", name)
+	return TransformationRule{}
+}
+
+func TestMergeFiltersRule(t *testing.T) {
+	rule := transformationRule(t, "MergeFilters")
+	plan, _ := optimizeQuery(t, "select x.a from (select a, b from t where b > 10) x where x.a < 5")
+	filter := findPlan(plan, Filter)
+	if rule.Apply(filter) {
+		t.Errorf("%v changed a Filter without Filter below it", rule.Name)
+	}
+	inner := OpNodeInit(Filter, WhereFilterNode{Expr: filter.Content.(WhereFilterNode).Expr})
+	inner.child = filter.child
+	outer := OpNodeInit(Filter, WhereFilterNode{Expr: filter.Content.(WhereFilterNode).Expr})
+	outer.child = []LogicalPlan{*inner}
+	outer.ResetParent()
+	if !rule.Apply(outer) {
+		t.Fatalf("%v did not merge two Filters", rule.Name)
+	}
+	if n := len(outer.Content.(WhereFilterNode).Expr); n != 2 || outer.child[0].Tp == Filter {
+		t.Errorf("%v left %d conjuncts above a %v", rule.Name, n, outer.child[0].Tp)
+	}
+}
+
+func TestCascadesMatchesRules(t *testing.T) {
+	loadTestTables(t)
+	for _, sql := range []string{
+		"select t.a, s.c from t left join s on t.a = s.a where s.a > 2",
+		"select x.m from (select b, max(a) as m from t group by b) x where x.b > 10 and x.m > 3",
+		"select a from t where b > 10 order by a limit 3",
+	} {
+		original, optimized := optimizeQuery(t, sql)
+		rules := BuildPhysicalPlan(optimized)
+		_, best := CascadesOptimizer(original)
+		if best == nil || best.Cost > rules.Cost {
+			t.Errorf("%v: cascades plan costs %v, the plan of the rules %v", sql, best.Cost, rules.Cost)
+		}
+	}
+}
+
+func TestCascadesNeverCostlierThanRules(t *testing.T) {
+	loadTestTables(t)
+	check := func(sql string) {
+		t.Helper()
+		original, optimized := optimizeQuery(t, sql)
+		rules := BuildPhysicalPlan(optimized)
+		_, best := CascadesOptimizer(original)
+		if best.Cost > rules.Cost {
+			t.Errorf("%v: cascades plan costs %v, the plan of the rules %v", sql, best.Cost, rules.Cost)
+		}
+	}
+	for _, f := range fixtures {
+		bytes, err := ioutil.ReadFile(testDir + f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(string(bytes))
+	}
+	useFuzzTables()
+	defer loadTestTables(t)
+	for seed := int64(1); seed <= 100; seed++ {
+		check(NewQueryGenerator(seed, FuzzTables).Generate().String())
+	}
+}
+
+func TestMemoGroupsHoldDistinctExpressions(t *testing.T) {
+	loadTestTables(t)
+	bytes, err := ioutil.ReadFile(testDir + f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, _ := optimizeQuery(t, string(bytes))
+	m, _ := CascadesOptimizer(original)
+	for _, g := range m.Groups {
+		seen := make(map[string]bool)
+		for _, e := range g.Exprs {
+			for _, child := range e.Children {
+				if child == g {
+					t.Errorf("a %v of group %d reads its own group", e.Tp, g.ID)
+				}
+			}
+			if e.Tp != Project {
+				continue
+			}
+			//the printed columns leave out the base table columns the references were resolved to
+			key := strconv.Itoa(e.Children[0].ID)
+			for _, col := range e.Content.(ProjectionNode).cols {
+				key += " " + col.print() + " AS " + col.AsTblName + "." + col.AsName
+			}
+			if seen[key] {
+				t.Errorf("group %d holds the Project %v twice", g.ID, key)
+			}
+			seen[key] = true
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+)
+
+// ColumnDef describes a column of a base table, Tp is test_driver.KindXXX
+type ColumnDef struct {
+	Name    string
+	Tp      byte
+	NotNull bool
+}
+
+// IndexDef describes a secondary index of a base table, the rows are ordered by Columns
+type IndexDef struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+type TableDef struct {
+	Name    string
+	Columns []ColumnDef
+	Rows    float64 //estimated number of rows, 0 if unknown
+	Indexes []IndexDef
+}
+
+// Catalog maps the lower case table name to its definition.
+// Tables not in the Catalog get their columns inferred from the references in the query
+var Catalog = make(map[string]*TableDef)
+
+func RegisterTable(def TableDef) {
+	Catalog[strings.ToLower(def.Name)] = &def
+}
+
+func LookupTable(name string) (*TableDef, bool) {
+	def, ok := Catalog[strings.ToLower(name)]
+	return def, ok
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// ChunkSize is the number of rows of the batches of the vectorized engine
+const ChunkSize = 1024
+
+// Column is a vector of values. The values of a column of kind KindInt64, KindFloat64 or KindString are held
+// unboxed in ints, floats or strs, the columns of any other kind, KindNull included, hold Datums of any kind.
+// The NULLs are the bits set in the bitmap nulls, whatever the kind
+type Column struct {
+	kind   byte
+	ints   []int64
+	floats []float64
+	strs   []string
+	datums []Datum
+	nulls  []uint64
+	length int
+}
+
+// isTypedKind check whether the values of a column of kind k are unboxed
+func isTypedKind(k byte) bool {
+	return k == test_driver.KindInt64 || k == test_driver.KindFloat64 || k == test_driver.KindString
+}
+
+// NewColumn return a column of n values of kind, all zero and not NULL
+func NewColumn(kind byte, n int) *Column {
+	c := &Column{kind: kind, length: n, nulls: make([]uint64, (n+63)/64)}
+	switch kind {
+	case test_driver.KindInt64:
+		c.ints = make([]int64, n)
+	case test_driver.KindFloat64:
+		c.floats = make([]float64, n)
+	case test_driver.KindString:
+		c.strs = make([]string, n)
+	default:
+		c.kind = test_driver.KindNull
+		c.datums = make([]Datum, n)
+	}
+	return c
+}
+
+func (c *Column) Len() int {
+	return c.length
+}
+
+// Kind is the kind of the unboxed values, KindNull if the column holds Datums
+func (c *Column) Kind() byte {
+	return c.kind
+}
+
+func (c *Column) IsNull(i int) bool {
+	return c.nulls[i>>6]&(1<<uint(i&63)) != 0
+}
+
+func (c *Column) SetNull(i int) {
+	c.nulls[i>>6] |= 1 << uint(i&63)
+}
+
+// Get return the value i of the column as a Datum
+func (c *Column) Get(i int) Datum {
+	if c.IsNull(i) {
+		return datumNull
+	}
+	var d Datum
+	switch c.kind {
+	case test_driver.KindInt64:
+		d.SetInt64(c.ints[i])
+	case test_driver.KindFloat64:
+		d.SetFloat64(c.floats[i])
+	case test_driver.KindString:
+		d.SetString(c.strs[i])
+	default:
+		return c.datums[i]
+	}
+	return d
+}
+
+// Set store the value i, the column is turned into a column of Datums if d does not fit its kind
+func (c *Column) Set(i int, d Datum) {
+	k := d.Kind()
+	switch {
+	case k == test_driver.KindNull:
+		c.SetNull(i)
+		return
+	case c.kind == test_driver.KindNull:
+		c.datums[i] = d
+	case k != c.kind:
+		c.box()
+		c.datums[i] = d
+	case k == test_driver.KindInt64:
+		c.ints[i] = d.GetInt64()
+	case k == test_driver.KindFloat64:
+		c.floats[i] = d.GetFloat64()
+	default:
+		c.strs[i] = d.GetString()
+	}
+	c.nulls[i>>6] &^= 1 << uint(i&63)
+}
+
+// box turn the column into a column of Datums
+func (c *Column) box() {
+	if c.kind == test_driver.KindNull {
+		return
+	}
+	datums := make([]Datum, c.length)
+	for i := range datums {
+		if !c.IsNull(i) {
+			datums[i] = c.Get(i)
+		}
+	}
+	c.kind, c.ints, c.floats, c.strs, c.datums = test_driver.KindNull, nil, nil, nil, datums
+}
+
+// Append add d at the end of the column
+func (c *Column) Append(d Datum) {
+	i := c.length
+	c.length++
+	if i>>6 >= len(c.nulls) {
+		c.nulls = append(c.nulls, 0)
+	}
+	switch c.kind {
+	case test_driver.KindInt64:
+		c.ints = append(c.ints, 0)
+	case test_driver.KindFloat64:
+		c.floats = append(c.floats, 0)
+	case test_driver.KindString:
+		c.strs = append(c.strs, "")
+	default:
+		c.datums = append(c.datums, Datum{})
+	}
+	c.Set(i, d)
+}
+
+// Chunk is a batch of at most ChunkSize rows stored by column. The selection vector Sel lists the rows of the
+// batch in order, the other rows have been filtered out. Sel is nil if all the rows are selected
+type Chunk struct {
+	Columns []*Column
+	Sel     []int
+	length  int
+}
+
+// NewChunk return an empty chunk with a column for every column of schema, unboxed if its type allows
+func NewChunk(schema Schema) *Chunk {
+	chk := &Chunk{}
+	for _, c := range schema.Columns {
+		col := NewColumn(c.Tp, 0)
+		chk.Columns = append(chk.Columns, col)
+	}
+	return chk
+}
+
+// Len is the number of rows of the chunk, the selected ones or not
+func (chk *Chunk) Len() int {
+	return chk.length
+}
+
+// NumRows is the number of selected rows
+func (chk *Chunk) NumRows() int {
+	if chk.Sel != nil {
+		return len(chk.Sel)
+	}
+	return chk.length
+}
+
+// Rows return the selected rows
+func (chk *Chunk) Rows() []int {
+	switch {
+	case chk.Sel != nil:
+		return chk.Sel
+	case chk.length <= ChunkSize:
+		return sequence[:chk.length]
+	}
+	ret := make([]int, chk.length)
+	for i := range ret {
+		ret[i] = i
+	}
+	return ret
+}
+
+// Row return the values of the row i of the chunk
+func (chk *Chunk) Row(i int) Row {
+	row := make(Row, 0, len(chk.Columns))
+	for _, c := range chk.Columns {
+		row = append(row, c.Get(i))
+	}
+	return row
+}
+
+// AppendRow add row at the end of the chunk, the chunk must not have a selection vector
+func (chk *Chunk) AppendRow(row Row) {
+	for i, c := range chk.Columns {
+		c.Append(row[i])
+	}
+	chk.length++
+}
+
+// sequence is the rows 0 to ChunkSize-1, shared by the chunks selecting all their rows
+var sequence = func() []int {
+	ret := make([]int, ChunkSize)
+	for i := range ret {
+		ret[i] = i
+	}
+	return ret
+}()
+
+// appendRows add the values rows of src at the end of the column
+func (c *Column) appendRows(src *Column, rows []int) {
+	if c.kind != src.kind || !isTypedKind(c.kind) {
+		for _, i := range rows {
+			c.Append(src.Get(i))
+		}
+		return
+	}
+	for _, i := range rows {
+		j := c.length
+		c.length++
+		if j>>6 >= len(c.nulls) {
+			c.nulls = append(c.nulls, 0)
+		}
+		switch c.kind {
+		case test_driver.KindInt64:
+			c.ints = append(c.ints, src.ints[i])
+		case test_driver.KindFloat64:
+			c.floats = append(c.floats, src.floats[i])
+		default:
+			c.strs = append(c.strs, src.strs[i])
+		}
+		if src.IsNull(i) {
+			c.SetNull(j)
+		}
+	}
+}
+
+// gather return the values idx of src, NULL for the index -1 and for all the values of a nil src
+func gather(src *Column, idx []int) *Column {
+	if src == nil {
+		ret := NewColumn(test_driver.KindNull, len(idx))
+		for k := range idx {
+			ret.SetNull(k)
+		}
+		return ret
+	}
+	ret := NewColumn(src.kind, len(idx))
+	for k, i := range idx {
+		if i < 0 || src.IsNull(i) {
+			ret.SetNull(k)
+			continue
+		}
+		switch src.kind {
+		case test_driver.KindInt64:
+			ret.ints[k] = src.ints[i]
+		case test_driver.KindFloat64:
+			ret.floats[k] = src.floats[i]
+		case test_driver.KindString:
+			ret.strs[k] = src.strs[i]
+		default:
+			ret.datums[k] = src.datums[i]
+		}
+	}
+	return ret
+}
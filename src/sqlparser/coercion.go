@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// isStringKind check whether the datum kind is a character or binary string
+func isStringKind(k byte) bool {
+	return k == test_driver.KindString || k == test_driver.KindBytes
+}
+
+// StringToFloat convert s like MySQL in a numeric context: the longest prefix of s which is a number,
+// after the leading spaces, 0 if there is none. '12abc' is 12, '1e3x' is 1000 and 'abc' is 0
+func StringToFloat(s string) float64 {
+	s = strings.TrimLeft(s, " \t\n\r\f\v")
+	end, digits := 0, false
+	if end < len(s) && (s[end] == '+' || s[end] == '-') {
+		end++
+	}
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end, digits = end+1, true
+	}
+	if end < len(s) && s[end] == '.' {
+		end++
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end, digits = end+1, true
+		}
+	}
+	if !digits {
+		return 0
+	}
+	//the exponent is only taken if it has digits
+	if e := end; e < len(s) && (s[e] == 'e' || s[e] == 'E') {
+		e++
+		if e < len(s) && (s[e] == '+' || s[e] == '-') {
+			e++
+		}
+		if e < len(s) && s[e] >= '0' && s[e] <= '9' {
+			for e < len(s) && s[e] >= '0' && s[e] <= '9' {
+				e++
+			}
+			end = e
+		}
+	}
+	f, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil {
+		//out of range, ParseFloat returns ±Inf and MySQL the largest DOUBLE
+		return math.Copysign(math.MaxFloat64, f)
+	}
+	return f
+}
+
+// binaryLiteralValue is the BIGINT UNSIGNED of a hexadecimal or bit literal in a numeric context
+func binaryLiteralValue(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// NumericValue return d in a numeric context: strings are converted to DOUBLE and hexadecimal literals
+// to BIGINT UNSIGNED, numbers and NULL are kept
+func NumericValue(d Datum) Datum {
+	switch d.Kind() {
+	case test_driver.KindString:
+		return InitSetValue(StringToFloat(d.GetString()))
+	case test_driver.KindBytes:
+		return InitSetValue(StringToFloat(string(d.GetBytes())))
+	case test_driver.KindBinaryLiteral:
+		return InitSetValue(binaryLiteralValue(d.GetBytes()))
+	}
+	return d
+}
+
+// numericArgs return the arguments in a numeric context, args is left untouched
+func numericArgs(args []Datum) []Datum {
+	ret := make([]Datum, len(args))
+	for i, arg := range args {
+		ret[i] = NumericValue(arg)
+	}
+	return ret
+}
+
+// StringValue is the text of d in a string context
+func StringValue(d Datum) string {
+	if d.Kind() == test_driver.KindString {
+		return d.GetString()
+	}
+	return FormatDatum(d)
+}
+
+// compareCoerced compare two non-NULL values after the implicit conversions of the comparison operators:
+// strings and hexadecimal literals are compared as strings, a string with a number as DOUBLE
+func compareCoerced(a, b Datum) (int, bool) {
+	if c, ok := CompareValues(a, b); ok {
+		return c, true
+	}
+	ak, bk := a.Kind(), b.Kind()
+	textual := func(k byte) bool {
+		return isStringKind(k) || k == test_driver.KindBinaryLiteral
+	}
+	if textual(ak) && textual(bk) {
+		return strings.Compare(StringValue(a), StringValue(b)), true
+	}
+	a, b = NumericValue(a), NumericValue(b)
+	if !IsNumericKind(a.Kind()) || !IsNumericKind(b.Kind()) {
+		return 0, false
+	}
+	if !isStringKind(ak) && !isStringKind(bk) {
+		if c, ok := CompareDatum(a, b); ok {
+			return c, true
+		}
+	}
+	//a string with a number, or an infinite DOUBLE
+	fa, _ := DatumFloat(a)
+	fb, _ := DatumFloat(b)
+	switch {
+	case fa < fb:
+		return -1, true
+	case fa > fb:
+		return 1, true
+	case fa == fb:
+		return 0, true
+	}
+	return 0, false
+}
+
+// bitValue is the BIGINT UNSIGNED operand of a bit operator: negative integers are taken in two's complement,
+// the other numbers are rounded and clipped to the range of BIGINT, strings are truncated to integers
+func bitValue(d Datum) uint64 {
+	switch d.Kind() {
+	case test_driver.KindInt64, test_driver.KindUint64:
+		return d.GetUint64()
+	case test_driver.KindBinaryLiteral:
+		return binaryLiteralValue(d.GetBytes())
+	case test_driver.KindString, test_driver.KindBytes:
+		return clipToUint64(math.Trunc(StringToFloat(StringValue(d))))
+	case test_driver.KindMysqlDecimal:
+		r, _ := DatumRat(d)
+		//round half away from zero like MySQL
+		half := big.NewRat(int64(r.Sign()), 2)
+		q := new(big.Rat).Add(r, half)
+		n := new(big.Int).Quo(q.Num(), q.Denom())
+		switch {
+		case n.IsInt64():
+			return uint64(n.Int64())
+		case n.Sign() < 0:
+			return 1 << 63
+		case n.IsUint64():
+			return n.Uint64()
+		}
+		return math.MaxUint64
+	}
+	f, _ := DatumFloat(d)
+	return clipToUint64(math.Round(f))
+}
+
+func clipToUint64(f float64) uint64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f < math.MinInt64:
+		return 1 << 63
+	case f < 0:
+		return uint64(int64(f))
+	case f >= math.MaxUint64:
+		return math.MaxUint64
+	}
+	return uint64(f)
+}
+
+// evalBitwise compute the bit operators on any values, the result is a BIGINT UNSIGNED
+func evalBitwise(op MyOp, args []Datum) (Datum, error) {
+	if HasNull(args) {
+		return NullDatum(), nil
+	}
+	v := make([]Datum, 0, len(args))
+	for _, arg := range args {
+		v = append(v, InitSetValue(bitValue(arg)))
+	}
+	if ret, ok := evalBit(op, v); ok {
+		return ret, nil
+	}
+	return Datum{}, fmt.Errorf("can not evaluate %v on %v", Ops[op].Literal, FormatRow(args))
+}
+
+// evalLike match like(expr, pattern[, escape]) where % matches any sequence of characters and _ one character.
+// Like the string comparisons of the evaluator, see CompareValues, the match uses the binary collation:
+// 'abc' LIKE 'A%' is 0 here and 1 with the default collation of MySQL
+func evalLike(args []Datum) (Datum, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return Datum{}, fmt.Errorf("incorrect parameter count in the call to like")
+	}
+	if HasNull(args) {
+		return NullDatum(), nil
+	}
+	escape := '\\'
+	if len(args) == 3 {
+		e := []rune(StringValue(args[2]))
+		if len(e) > 1 {
+			return Datum{}, fmt.Errorf("incorrect arguments to ESCAPE")
+		}
+		escape = 0
+		if len(e) == 1 {
+			escape = e[0]
+		}
+	}
+	return BoolDatum(likeMatch([]rune(StringValue(args[0])), []rune(StringValue(args[1])), escape)), nil
+}
+
+// likeMatch backtrack to the last % only, a later % can always match what an earlier one would
+func likeMatch(s, p []rune, escape rune) bool {
+	si, pi := 0, 0
+	star, mark := -1, 0
+	for si < len(s) {
+		if pi < len(p) {
+			c, escaped := p[pi], false
+			if c == escape && escape != 0 && pi+1 < len(p) {
+				c, escaped = p[pi+1], true
+			}
+			switch {
+			case c == '%' && !escaped:
+				star, mark = pi+1, si
+				pi++
+				continue
+			case (c == '_' && !escaped) || c == s[si]:
+				si++
+				if escaped {
+					pi += 2
+				} else {
+					pi++
+				}
+				continue
+			}
+		}
+		if star == -1 {
+			return false
+		}
+		mark++
+		pi, si = star, mark
+	}
+	for pi < len(p) && p[pi] == '%' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+var regexpCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compileRegexp compile the pattern of REGEXP once, the patterns are usually constants of the query
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+	if re, ok := regexpCache.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("illegal argument to a regular expression: %v", err)
+	}
+	if len(regexpCache.m) >= 1024 {
+		regexpCache.m = make(map[string]*regexp.Regexp)
+	}
+	regexpCache.m[pattern] = re
+	return re, nil
+}
+
+// evalRegexp check whether the pattern regexp(expr, pattern) matches a part of expr, case-sensitively like LIKE
+func evalRegexp(args []Datum) (Datum, error) {
+	if len(args) != 2 {
+		return Datum{}, fmt.Errorf("incorrect parameter count in the call to regexp")
+	}
+	if HasNull(args) {
+		return NullDatum(), nil
+	}
+	re, err := compileRegexp(StringValue(args[1]))
+	if err != nil {
+		return Datum{}, err
+	}
+	return BoolDatum(re.MatchString(StringValue(args[0]))), nil
+}
+
+// evalCase return the result of the first true condition of case(when1, then1, ..., else)
+func evalCase(args []Datum) (Datum, error) {
+	if len(args)%2 == 0 {
+		return Datum{}, fmt.Errorf("malformed CASE of %d arguments", len(args))
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		if IsTrue(args[i]) {
+			return args[i+1], nil
+		}
+	}
+	return args[len(args)-1], nil
+}
+
+// rangeError is the error of MySQL when the result of op does not fit in tp
+func rangeError(tp string, op MyOp, args []Datum) error {
+	var text string
+	if len(args) == 1 {
+		text = Ops[op].Literal + FormatDatum(args[0])
+	} else {
+		text = "(" + FormatDatum(args[0]) + " " + strings.TrimSpace(Ops[op].Literal) + " " + FormatDatum(args[1]) + ")"
+	}
+	return fmt.Errorf("%v value is out of range in '%v'", tp, text)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+func TestStringsUseBinaryCollation(t *testing.T) {
+	schema := benchSchema()
+	var rows []Row
+	for _, s := range []string{"k1", "K1", "k10", "k1 "} {
+		rows = append(rows, Row{InitSetValue(int64(1)), InitSetValue(int64(2)), InitSetValue(3e0), InitSetValue(s)})
+	}
+	for _, c := range []struct {
+		cond string
+		want int
+	}{
+		{"s LIKE 'K%'", 1},
+		{"s LIKE 'k%'", 3},
+		{"s LIKE 'k_'", 1},
+		{"s NOT LIKE 'K%'", 3},
+		{"s = 'K1'", 1},
+		{"s < 'k'", 1},
+		{"s REGEXP '^K'", 1},
+		//NO PAD, the trailing spaces are compared
+		{"s = 'k1'", 1},
+		{"s = 'k1 '", 1},
+		{"s > 'k1'", 2},
+		{"s LIKE 'k1'", 1},
+	} {
+		conds, err := parseConditions(c.cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engines := map[string]func(Row) (bool, error){
+			"interpreted": conjunction([]CompiledExpr{InterpretExpression(conds[0], schema)}),
+			"compiled":    conjunction([]CompiledExpr{CompileExpression(conds[0], schema)}),
+		}
+		for name, filter := range engines {
+			n := 0
+			for _, row := range rows {
+				if ok, err := filter(row); err != nil {
+					t.Fatalf("%v %v: %v", c.cond, name, err)
+				} else if ok {
+					n++
+				}
+			}
+			if n != c.want {
+				t.Errorf("%v %v: %d rows, want %d", c.cond, name, n, c.want)
+			}
+		}
+		chk := NewChunk(schema)
+		for _, row := range rows {
+			chk.AppendRow(row)
+		}
+		sel, err := filterRows([]VecExpr{CompileVector(conds[0], schema)}, chk, chk.Rows())
+		if err != nil {
+			t.Fatalf("%v vectorized: %v", c.cond, err)
+		}
+		if len(sel) != c.want {
+			t.Errorf("%v vectorized: %d rows, want %d", c.cond, len(sel), c.want)
+		}
+	}
+}
+
+func TestCoercionAndNulls(t *testing.T) {
+	schema := benchSchema()
+	row := Row{InitSetValue(int64(7)), NullDatum(), InitSetValue(2.5), InitSetValue("10")}
+	tests := []struct {
+		expr, want string
+	}{
+		//strings compared with numbers are converted to numbers, from their longest numeric prefix
+		{"'10' = 10", "1"},
+		{"'abc' = 0", "1"},
+		{"' 12abc' + 1", "13"},
+		{"'1e1' + 1", "11"},
+		{"3 < '10'", "1"},
+		{"'3' < '10'", "0"},
+		{"s = 10.0", "1"},
+		{"f = '2.5'", "1"},
+		{"a between '5' and 8", "1"},
+		{"s * 2", "20"},
+		{"-s", "-10"},
+		//the arithmetic
+		{"a / 2", "3.5000"},
+		{"a div 2", "3"},
+		{"1.5 + 1", "2.5"},
+		{"a / 0", "NULL"},
+		{"a % 0", "NULL"},
+		{"~0", "18446744073709551615"},
+		//NULL propagates through the operators, AND, OR and IN use the three valued logic
+		{"a + b", "NULL"},
+		{"b = b", "NULL"},
+		{"b <=> b", "1"},
+		{"not (b and 0)", "1"},
+		{"not (b and 1)", "NULL"},
+		{"b or 1", "1"},
+		{"b or 0", "NULL"},
+		{"a in (7, b)", "1"},
+		{"a in (8, b)", "NULL"},
+		{"a not in (8, b)", "NULL"},
+		{"b like '1%'", "NULL"},
+		{"concat(a, b)", "NULL"},
+		{"coalesce(b, null, s)", "10"},
+		{"case when b then 1 else 2 end", "2"},
+	}
+	for _, test := range tests {
+		conds, err := parseConditions(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, expr := range map[string]CompiledExpr{
+			"interpreted": InterpretExpression(conds[0], schema),
+			"compiled":    CompileExpression(conds[0], schema),
+		} {
+			v, err := expr(row)
+			if err != nil {
+				t.Fatalf("%v %v: %v", test.expr, name, err)
+			}
+			if got := FormatDatum(v); got != test.want {
+				t.Errorf("%v %v: %v, want %v", test.expr, name, got, test.want)
+			}
+		}
+	}
+	conds, err := parseConditions("9223372036854775807 + a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EvalExpression(conds[0], schema, row); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("the overflow of BIGINT returns error %v", err)
+	}
+}
+
+func TestSumCoercesStrings(t *testing.T) {
+	tests := []struct {
+		sql, want string
+	}{
+		{"select sum('1.5'), avg('2') from t", "18, 2"},
+		{"select sum(x.v), avg(x.v) from (select concat(a, '.5') as v from t) x", "84, 7"},
+		//the words of s.c have no numeric prefix
+		{"select a, sum(c) from s where a = 4 group by a", "4, 0"},
+		{"select std(x.v) from (select concat(a, '') as v from testdata2) x where x.v < '3'", "0.5"},
+	}
+	for _, test := range tests {
+		checkResult(t, test.sql, test.want)
+		_, plan := optimizeQuery(t, test.sql)
+		schema, rows, err := ExecuteVectorized(plan)
+		if err != nil {
+			t.Fatalf("%v vectorized: %v", test.sql, err)
+		}
+		if len(rows) != 1 || FormatRow(rows[0]) != test.want {
+			t.Errorf("%v vectorized: got %d rows, want %v", test.sql, len(rows), test.want)
+		} else if tp := schema.Columns[len(schema.Columns)-1].Tp; tp != test_driver.KindFloat64 {
+			t.Errorf("%v: the sum of strings has type %v", test.sql, tp)
+		}
+	}
+}
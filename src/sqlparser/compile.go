@@ -0,0 +1,608 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// CompiledExpr computes a compiled expression on a row of the schema it was compiled for
+type CompiledExpr func(row Row) (Datum, error)
+
+// compiled is a node of a compiled expression. kind is the kind of the values the node is expected to return,
+// KindNull if it is unknown, the fast paths chosen from it still check the kinds of the actual values.
+// A constant node holds its value, computed once at compile time
+type compiled struct {
+	eval     CompiledExpr
+	kind     byte
+	constant bool
+	value    Datum
+}
+
+var (
+	datumTrue  = BoolDatum(true)
+	datumFalse = BoolDatum(false)
+	datumNull  = NullDatum()
+)
+
+func boolResult(b bool) (Datum, error) {
+	if b {
+		return datumTrue, nil
+	}
+	return datumFalse, nil
+}
+
+// CompileExpression turn expr into a tree of closures computing the same values as EvalExpression: the columns
+// are resolved once in schema, the operators are resolved once instead of by name on every row, the constant
+// subexpressions are folded, and the comparisons and the arithmetic of int64, float64 and strings take fast paths.
+// AND and OR do not evaluate their right operand when the left one decides the result.
+// The errors of the expression, like an unknown column, are returned by the evaluation, not by the compilation
+func CompileExpression(expr Expression, schema Schema) CompiledExpr {
+	c := exprCompiler{schema: schema}
+	return c.compile(expr).eval
+}
+
+// InterpretExpressions makes the executor interpret the expressions with EvalExpression instead of
+// compiling them, to compare both
+var InterpretExpressions = false
+
+// InterpretExpression wrap the interpretation of expr by EvalExpression into a CompiledExpr
+func InterpretExpression(expr Expression, schema Schema) CompiledExpr {
+	return func(row Row) (Datum, error) {
+		return EvalExpression(expr, schema, row)
+	}
+}
+
+// executorExpression is the expression the executor computes on its rows of schema
+func executorExpression(expr Expression, schema Schema) CompiledExpr {
+	if InterpretExpressions {
+		return InterpretExpression(expr, schema)
+	}
+	return CompileExpression(expr, schema)
+}
+
+// CompileConditions compile the conjunction of conditions into a closure checking whether they are all true,
+// the conditions are interpreted if InterpretExpressions is set
+func CompileConditions(conditions []Expression, schema Schema) func(row Row) (bool, error) {
+	var exprs []CompiledExpr
+	for _, cond := range conditions {
+		exprs = append(exprs, executorExpression(cond, schema))
+	}
+	return conjunction(exprs)
+}
+
+// conjunction check whether all the values of exprs are true, the first false or NULL one ends the check
+func conjunction(exprs []CompiledExpr) func(row Row) (bool, error) {
+	return func(row Row) (bool, error) {
+		for _, expr := range exprs {
+			v, err := expr(row)
+			if err != nil || !IsTrue(v) {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
+
+type exprCompiler struct {
+	schema Schema
+}
+
+// compile read the postfix expression like evaluator.eval, with a stack of compiled nodes instead of values
+func (c *exprCompiler) compile(expr Expression) *compiled {
+	var s []*compiled
+	for _, d := range expr.expr {
+		switch {
+		case d.Args != nil:
+			s = append(s, c.function(d))
+		case expr.IsColumnDatum(d):
+			s = append(s, c.column(expr.Fields[d.GetString()]))
+		case d.Kind() == test_driver.KindString && StrToOp(d.GetString()) != -1 && len(s) >= 2:
+			node := c.operator(StrToOp(d.GetString()), s[len(s)-2:])
+			s = append(s[:len(s)-2], node)
+		default:
+			s = append(s, constant(Datum{d.Datum, nil}))
+		}
+	}
+	if len(s) != 1 {
+		return failure(fmt.Errorf("malformed expression %v", printExpressions([]Expression{expr})))
+	}
+	return s[0]
+}
+
+func constant(d Datum) *compiled {
+	return &compiled{
+		eval: func(Row) (Datum, error) {
+			return d, nil
+		},
+		kind:     d.Kind(),
+		constant: true,
+		value:    d,
+	}
+}
+
+func failure(err error) *compiled {
+	return &compiled{
+		eval: func(Row) (Datum, error) {
+			return Datum{}, err
+		},
+		kind: test_driver.KindNull,
+	}
+}
+
+// column resolve col once, all the columns are NULL on the nil row of an empty input
+func (c *exprCompiler) column(col ColumnName) *compiled {
+	i := c.schema.ResolveColumn(col)
+	if i == -1 {
+		return failure(fmt.Errorf("unknown or ambiguous column %v", columnText(col)))
+	}
+	return &compiled{
+		eval: func(row Row) (Datum, error) {
+			if row == nil {
+				return datumNull, nil
+			}
+			return row[i], nil
+		},
+		kind: c.schema.Columns[i].Tp,
+	}
+}
+
+// fold compute the node once if all its arguments are constants. The errors are left to the evaluation,
+// a CASE may never compute the subexpression failing
+func fold(node *compiled, args []*compiled) *compiled {
+	for _, arg := range args {
+		if !arg.constant {
+			return node
+		}
+	}
+	v, err := node.eval(nil)
+	if err != nil {
+		return node
+	}
+	return constant(v)
+}
+
+// generic compute op with EvalOperator on the values of args
+func generic(op MyOp, args []*compiled) CompiledExpr {
+	return func(row Row) (Datum, error) {
+		values := make([]Datum, len(args))
+		for i, arg := range args {
+			v, err := arg.eval(row)
+			if err != nil {
+				return Datum{}, err
+			}
+			values[i] = v
+		}
+		return EvalOperator(op, values)
+	}
+}
+
+func (c *exprCompiler) operator(op MyOp, args []*compiled) *compiled {
+	args = append([]*compiled{}, args...)
+	node := &compiled{eval: generic(op, args), kind: test_driver.KindNull}
+	l, r := args[0], args[1]
+	switch op {
+	case EQ, NE, LT, LE, GT, GE:
+		node.kind = test_driver.KindInt64
+		if eval := comparison(op, l, r); eval != nil {
+			node.eval = eval
+		}
+	case NullEQ:
+		node.kind = test_driver.KindInt64
+	case Plus, Minus, Mul, Mod:
+		if l.kind == test_driver.KindInt64 && r.kind == test_driver.KindInt64 {
+			node.kind, node.eval = test_driver.KindInt64, intArithmetic(op, l, r)
+		} else if l.kind == test_driver.KindFloat64 && r.kind == test_driver.KindFloat64 && op != Mod {
+			node.kind, node.eval = test_driver.KindFloat64, floatArithmetic(op, l, r)
+		}
+	case LogicAnd, LogicOr:
+		node.kind, node.eval = test_driver.KindInt64, logic(op, l, r)
+	}
+	return fold(node, args)
+}
+
+// comparison return the fast path of the comparison of l and r when both are known to be int64, float64
+// or strings, nil otherwise. The values of other kinds, NULL included, take the path of EvalOperator.
+// The strings are compared byte-wise with the NO PAD binary collation of CompareValues
+func comparison(op MyOp, l, r *compiled) CompiledExpr {
+	if l.kind != r.kind {
+		return nil
+	}
+	switch l.kind {
+	case test_driver.KindInt64:
+		if r.constant && r.value.Kind() == test_driver.KindInt64 {
+			k := r.value.GetInt64()
+			return func(row Row) (Datum, error) {
+				a, err := l.eval(row)
+				if err != nil || a.Kind() != test_driver.KindInt64 {
+					return fallback(op, []Datum{a, r.value}, err)
+				}
+				return boolResult(compareInt64(op, a.GetInt64(), k))
+			}
+		}
+		return func(row Row) (Datum, error) {
+			a, err1 := l.eval(row)
+			b, err2 := r.eval(row)
+			if err1 != nil || err2 != nil || a.Kind() != test_driver.KindInt64 || b.Kind() != test_driver.KindInt64 {
+				return fallback(op, []Datum{a, b}, err1, err2)
+			}
+			return boolResult(compareInt64(op, a.GetInt64(), b.GetInt64()))
+		}
+	case test_driver.KindFloat64:
+		return func(row Row) (Datum, error) {
+			a, err1 := l.eval(row)
+			b, err2 := r.eval(row)
+			if err1 != nil || err2 != nil || a.Kind() != test_driver.KindFloat64 || b.Kind() != test_driver.KindFloat64 {
+				return fallback(op, []Datum{a, b}, err1, err2)
+			}
+			x, y := a.GetFloat64(), b.GetFloat64()
+			if math.IsNaN(x) || math.IsNaN(y) {
+				return EvalOperator(op, []Datum{a, b})
+			}
+			c := 0
+			if x < y {
+				c = -1
+			} else if x > y {
+				c = 1
+			}
+			return boolResult(CompareResult(op, c))
+		}
+	case test_driver.KindString:
+		return func(row Row) (Datum, error) {
+			a, err1 := l.eval(row)
+			b, err2 := r.eval(row)
+			if err1 != nil || err2 != nil || a.Kind() != test_driver.KindString || b.Kind() != test_driver.KindString {
+				return fallback(op, []Datum{a, b}, err1, err2)
+			}
+			return boolResult(CompareResult(op, strings.Compare(a.GetString(), b.GetString())))
+		}
+	}
+	return nil
+}
+
+// fallback return the first error, or apply op on the values already computed with EvalOperator
+func fallback(op MyOp, values []Datum, errs ...error) (Datum, error) {
+	for _, err := range errs {
+		if err != nil {
+			return Datum{}, err
+		}
+	}
+	return EvalOperator(op, values)
+}
+
+func compareInt64(op MyOp, a, b int64) bool {
+	switch op {
+	case EQ:
+		return a == b
+	case NE:
+		return a != b
+	case LT:
+		return a < b
+	case LE:
+		return a <= b
+	case GT:
+		return a > b
+	}
+	return a >= b
+}
+
+// intArithmetic compute +, -, * and % of two int64, the overflows take the path of EvalOperator which reports them
+func intArithmetic(op MyOp, l, r *compiled) CompiledExpr {
+	return func(row Row) (Datum, error) {
+		a, err1 := l.eval(row)
+		b, err2 := r.eval(row)
+		if err1 != nil || err2 != nil || a.Kind() != test_driver.KindInt64 || b.Kind() != test_driver.KindInt64 {
+			return fallback(op, []Datum{a, b}, err1, err2)
+		}
+		x, y := a.GetInt64(), b.GetInt64()
+		var ret int64
+		switch op {
+		case Plus:
+			ret = x + y
+			if (ret > x) != (y > 0) {
+				return EvalOperator(op, []Datum{a, b})
+			}
+		case Minus:
+			ret = x - y
+			if (ret < x) != (y > 0) {
+				return EvalOperator(op, []Datum{a, b})
+			}
+		case Mod:
+			if y == 0 {
+				return datumNull, nil
+			}
+			//truncated toward zero like MySQL, MinInt64 % -1 is 0 in Go
+			ret = x % y
+		default:
+			hi, lo := bits.Mul64(uint64(absInt64(x)), uint64(absInt64(y)))
+			if hi != 0 || lo > math.MaxInt64 || x == math.MinInt64 || y == math.MinInt64 {
+				return EvalOperator(op, []Datum{a, b})
+			}
+			ret = x * y
+		}
+		var d Datum
+		d.SetInt64(ret)
+		return d, nil
+	}
+}
+
+func absInt64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// floatArithmetic compute +, - and * of two float64, the overflows take the path of EvalOperator
+func floatArithmetic(op MyOp, l, r *compiled) CompiledExpr {
+	return func(row Row) (Datum, error) {
+		a, err1 := l.eval(row)
+		b, err2 := r.eval(row)
+		if err1 != nil || err2 != nil || a.Kind() != test_driver.KindFloat64 || b.Kind() != test_driver.KindFloat64 {
+			return fallback(op, []Datum{a, b}, err1, err2)
+		}
+		var ret float64
+		switch op {
+		case Plus:
+			ret = a.GetFloat64() + b.GetFloat64()
+		case Minus:
+			ret = a.GetFloat64() - b.GetFloat64()
+		default:
+			ret = a.GetFloat64() * b.GetFloat64()
+		}
+		if math.IsInf(ret, 0) || math.IsNaN(ret) {
+			return EvalOperator(op, []Datum{a, b})
+		}
+		var d Datum
+		d.SetFloat64(ret)
+		return d, nil
+	}
+}
+
+// logic compute AND and OR with the three-valued logic, the right operand is skipped when the left one
+// is false for AND or true for OR. The values which are not numbers once converted are left to EvalOperator
+func logic(op MyOp, l, r *compiled) CompiledExpr {
+	return func(row Row) (Datum, error) {
+		a, err := l.eval(row)
+		if err != nil {
+			return Datum{}, err
+		}
+		na := NumericValue(a)
+		lt, ln := Truth(na)
+		if IsNumericKind(na.Kind()) && lt == (op == LogicOr) {
+			return boolResult(lt)
+		}
+		b, err := r.eval(row)
+		if err != nil {
+			return Datum{}, err
+		}
+		nb := NumericValue(b)
+		rt, rn := Truth(nb)
+		numbers := (ln || IsNumericKind(na.Kind())) && (rn || IsNumericKind(nb.Kind()))
+		switch {
+		case !numbers:
+			return EvalOperator(op, []Datum{a, b})
+		case !rn && rt == (op == LogicOr):
+			return boolResult(rt)
+		case ln || rn:
+			return datumNull, nil
+		}
+		return boolResult(op == LogicAnd)
+	}
+}
+
+// negation compute NOT with the three-valued logic, the values which are not numbers once converted
+// are left to EvalOperator
+func negation(op MyOp, arg *compiled) CompiledExpr {
+	return func(row Row) (Datum, error) {
+		v, err := arg.eval(row)
+		if err != nil {
+			return Datum{}, err
+		}
+		n := NumericValue(v)
+		t, null := Truth(n)
+		switch {
+		case null:
+			return datumNull, nil
+		case !IsNumericKind(n.Kind()):
+			return EvalOperator(op, []Datum{v})
+		}
+		return boolResult(!t)
+	}
+}
+
+// function compile the call d. The aggregates are columns of the input named after the printed function,
+// like for evaluator outside of an Aggregate
+func (c *exprCompiler) function(d Datum) *compiled {
+	name := d.GetString()
+	if IsAggregateFunction(name) {
+		key := Expression{expr: []Datum{d}}
+		return c.column(ColumnName{ColName: key.print()})
+	}
+	args := make([]*compiled, 0, len(d.Args))
+	for _, arg := range d.Args {
+		args = append(args, c.compile(arg))
+	}
+	if name == Ops[Case].Name || name == "if" {
+		return c.conditional(name, args)
+	}
+	if op := StrToOp(name); op != -1 {
+		node := &compiled{eval: generic(op, args), kind: test_driver.KindNull}
+		switch op {
+		case In:
+			node.kind = test_driver.KindInt64
+			if eval := inSet(args); eval != nil {
+				node.eval = eval
+			}
+		case Like:
+			node.kind = test_driver.KindInt64
+			if eval := likePattern(args); eval != nil {
+				node.eval = eval
+			}
+		case Regexp:
+			node.kind = test_driver.KindInt64
+			if eval := regexpPattern(args); eval != nil {
+				node.eval = eval
+			}
+		case IsNull:
+			node.kind = test_driver.KindInt64
+			node.eval = func(row Row) (Datum, error) {
+				v, err := args[0].eval(row)
+				if err != nil {
+					return Datum{}, err
+				}
+				return boolResult(v.Kind() == test_driver.KindNull)
+			}
+		case Not, Not2:
+			node.kind = test_driver.KindInt64
+			if len(args) == 1 {
+				node.eval = negation(op, args[0])
+			}
+		case IsTruth, IsFalsity:
+			node.kind = test_driver.KindInt64
+		}
+		return fold(node, args)
+	}
+	def, ok := LookupFunction(name)
+	if !ok || def.Eval == nil {
+		return failure(fmt.Errorf("unsupported function %v", name))
+	}
+	node := &compiled{
+		eval: func(row Row) (Datum, error) {
+			values := make([]Datum, len(args))
+			for i, arg := range args {
+				v, err := arg.eval(row)
+				if err != nil {
+					return Datum{}, err
+				}
+				values[i] = v
+			}
+			return def.Eval(values)
+		},
+		kind: test_driver.KindNull,
+	}
+	if def.Volatile {
+		return node
+	}
+	return fold(node, args)
+}
+
+// conditional compile case(when1, then1, ..., else) and if(cond, then, else), only the chosen result is computed
+func (c *exprCompiler) conditional(name string, args []*compiled) *compiled {
+	if name == "if" && len(args) != 3 {
+		return failure(fmt.Errorf("incorrect parameter count in the call to if"))
+	}
+	if len(args)%2 == 0 {
+		return failure(fmt.Errorf("malformed CASE of %d arguments", len(args)))
+	}
+	//the kind of the result is known if all the results are of the same kind, NULL aside
+	results := []*compiled{args[len(args)-1]}
+	for i := 1; i < len(args)-1; i += 2 {
+		results = append(results, args[i])
+	}
+	kind := test_driver.KindNull
+	for _, result := range results {
+		if result.constant && result.value.Kind() == test_driver.KindNull {
+			continue
+		}
+		if result.kind == test_driver.KindNull || (kind != test_driver.KindNull && result.kind != kind) {
+			kind = test_driver.KindNull
+			break
+		}
+		kind = result.kind
+	}
+	node := &compiled{
+		eval: func(row Row) (Datum, error) {
+			for i := 0; i+1 < len(args); i += 2 {
+				cond, err := args[i].eval(row)
+				if err != nil {
+					return Datum{}, err
+				}
+				if IsTrue(cond) {
+					return args[i+1].eval(row)
+				}
+			}
+			return args[len(args)-1].eval(row)
+		},
+		kind: kind,
+	}
+	return fold(node, args)
+}
+
+// inSet look the value up in a set when the list of IN is made of int64 or of string constants
+// and the value is known to be of the same kind
+func inSet(args []*compiled) CompiledExpr {
+	kind := args[0].kind
+	if kind != test_driver.KindInt64 && kind != test_driver.KindString {
+		return nil
+	}
+	ints, strs := make(map[int64]struct{}), make(map[string]struct{})
+	for _, arg := range args[1:] {
+		if !arg.constant || arg.value.Kind() != kind {
+			return nil
+		}
+		if kind == test_driver.KindInt64 {
+			ints[arg.value.GetInt64()] = struct{}{}
+		} else {
+			strs[arg.value.GetString()] = struct{}{}
+		}
+	}
+	list := make([]Datum, 0, len(args))
+	for _, arg := range args[1:] {
+		list = append(list, arg.value)
+	}
+	return func(row Row) (Datum, error) {
+		v, err := args[0].eval(row)
+		if err != nil || v.Kind() != kind {
+			return fallback(In, append([]Datum{v}, list...), err)
+		}
+		var found bool
+		if kind == test_driver.KindInt64 {
+			_, found = ints[v.GetInt64()]
+		} else {
+			_, found = strs[v.GetString()]
+		}
+		return boolResult(found)
+	}
+}
+
+// likePattern decode the pattern of LIKE once when it is a constant
+func likePattern(args []*compiled) CompiledExpr {
+	if len(args) != 2 || !args[1].constant || args[1].value.Kind() == test_driver.KindNull {
+		return nil
+	}
+	pattern := []rune(StringValue(args[1].value))
+	return func(row Row) (Datum, error) {
+		v, err := args[0].eval(row)
+		if err != nil {
+			return Datum{}, err
+		}
+		if v.Kind() == test_driver.KindNull {
+			return datumNull, nil
+		}
+		return boolResult(likeMatch([]rune(StringValue(v)), pattern, '\\'))
+	}
+}
+
+// regexpPattern compile the pattern of REGEXP once when it is a valid constant
+func regexpPattern(args []*compiled) CompiledExpr {
+	if len(args) != 2 || !args[1].constant || args[1].value.Kind() == test_driver.KindNull {
+		return nil
+	}
+	re, err := compileRegexp(StringValue(args[1].value))
+	if err != nil {
+		return nil
+	}
+	return func(row Row) (Datum, error) {
+		v, err := args[0].eval(row)
+		if err != nil {
+			return Datum{}, err
+		}
+		if v.Kind() == test_driver.KindNull {
+			return datumNull, nil
+		}
+		return boolResult(re.MatchString(StringValue(v)))
+	}
+}
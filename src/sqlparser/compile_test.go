@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCompiledMatchesInterpreted(t *testing.T) {
+	schema := benchSchema()
+	var rows []Row
+	ints := []Datum{NullDatum(), InitSetValue(int64(0)), InitSetValue(int64(-3)), InitSetValue(int64(7)),
+		InitSetValue(int64(9223372036854775807))}
+	floats := []Datum{NullDatum(), InitSetValue(0e0), InitSetValue(2.5)}
+	strs := []Datum{NullDatum(), InitSetValue(""), InitSetValue("10"), InitSetValue("abc"), InitSetValue("K1")}
+	for _, a := range ints {
+		for _, b := range ints {
+			for _, f := range floats {
+				for _, s := range strs {
+					rows = append(rows, Row{a, b, f, s})
+				}
+			}
+		}
+	}
+	exprs := []string{
+		"a + b", "a - b * 2", "a * f", "a / b", "a div b", "a % b", "-a", "a & b", "a | 1", "a << 2", "~b",
+		"a = b", "a < b", "a <=> b", "a >= f", "s = 'abc'", "s < 'b'", "s = 10", "a + s", "f = s",
+		"a > 0 and b > 0", "a > 0 or b > 0", "not (a = b)", "a xor b", "a is null", "s is not null",
+		"a in (0, 7, b)", "s not in ('abc', '')", "a between b and 7", "s like '%1%'", "s regexp '^[a-z]+$'",
+		"case when a > b then s when a < b then f else null end", "case a when 7 then 'seven' end",
+		"if(a > 0, b, f)", "ifnull(a, s)", "coalesce(b, f, s)", "abs(a)", "length(s)", "concat(s, a)",
+		"upper(s)", "substring(s, 2)", "round(f)", "1 + 2 * 3 = a",
+	}
+	for _, e := range exprs {
+		conds, err := parseConditions(e)
+		if err != nil {
+			t.Fatalf("%v: %v", e, err)
+		}
+		compiled := CompileExpression(conds[0], schema)
+		for _, row := range rows {
+			v1, err1 := EvalExpression(conds[0], schema, row)
+			v2, err2 := compiled(row)
+			if (err1 == nil) != (err2 == nil) {
+				t.Errorf("%v on %v: error %v interpreted, %v compiled", e, FormatRow(row), err1, err2)
+			} else if err1 == nil && (v1.Kind() != v2.Kind() || FormatDatum(v1) != FormatDatum(v2)) {
+				t.Errorf("%v on %v: %v interpreted, %v compiled", e, FormatRow(row), FormatDatum(v1),
+					FormatDatum(v2))
+			}
+		}
+	}
+}
+
+func TestCompiledErrors(t *testing.T) {
+	schema := benchSchema()
+	row := Row{InitSetValue(int64(0)), InitSetValue(int64(1)), InitSetValue(0e0), InitSetValue("")}
+	//an unknown column fails the evaluation, not the compilation
+	conds, err := parseConditions("nosuch = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompileExpression(conds[0], schema)(row); err == nil {
+		t.Error("an unknown column is evaluated")
+	}
+	//AND and OR do not evaluate their right operand when the left one decides the result
+	for _, e := range []string{"(a = 1 and nosuch = 1) is not null", "(b = 1 or nosuch = 1) is not null"} {
+		conds, err := parseConditions(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := CompileExpression(conds[0], schema)(row); err != nil || FormatDatum(v) != "1" {
+			t.Errorf("%v: %v, %v", e, FormatDatum(v), err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"github.com/pingcap/tidb/parser/test_driver"
 	"strconv"
 )
@@ -13,6 +14,8 @@ type Datum struct {
 func (d *Datum) print() string {
 	if d.Args == nil {
 		switch d.Datum.Kind() {
+		case test_driver.KindNull:
+			return "NULL"
 		case test_driver.KindString:
 			return d.Datum.GetString()
 		case test_driver.KindInt64:
@@ -23,14 +26,24 @@ func (d *Datum) print() string {
 			return strconv.FormatFloat(float64(d.GetFloat32()), 'e', -1, 32)
 		case test_driver.KindFloat64:
 			return strconv.FormatFloat(d.GetFloat64(), 'e', -1, 64)
+		case test_driver.KindMysqlDecimal:
+			return d.GetMysqlDecimal().String()
+		case test_driver.KindBytes, test_driver.KindBinaryLiteral:
+			return string(d.GetBytes())
 		default:
-			panic("Error Datum")
+			return fmt.Sprintf("%v", d.GetValue())
 		}
 	} else {
 		str := ""
-		for _, arg := range d.Args {
+		for i, arg := range d.Args {
+			if i > 0 {
+				str = str + ", "
+			}
 			str = str + arg.print()
 		}
+		if f, ok := SplitDistinctAggregate(d.GetString()); ok {
+			return f + "(distinct " + str + ")"
+		}
 		return d.GetString() + "(" + str + ")"
 	}
 }
@@ -75,6 +88,7 @@ const (
 	IsNull
 	IsTruth
 	IsFalsity
+	UnaryMinus
 )
 
 var Ops = [...]struct {
@@ -237,6 +251,11 @@ var Ops = [...]struct {
 		Literal:   "IS FALSE",
 		isKeyword: true,
 	},
+	UnaryMinus: {
+		Name:      "unaryminus",
+		Literal:   "-",
+		isKeyword: false,
+	},
 }
 
 func (o MyOp) String() string {
@@ -245,7 +264,7 @@ func (o MyOp) String() string {
 
 func StrToOp(str string) MyOp {
 	for i, v := range Ops {
-		if v.Name == str {
+		if v.Name != "" && v.Name == str {
 			return MyOp(i)
 		}
 	}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math/big"
+	"math/rand"
+	"strings"
+)
+
+// DiffMaxRows is the largest number of rows generated for a table by the differential test
+var DiffMaxRows = 8
+
+// DiffReport is the outcome of running a query before and after QueryOptimizer on the same generated data.
+//
+//	Compared is false when the result of the query is not determined by the data, like a LIMIT without ORDER BY
+//	or a volatile function, only the execution of both plans is checked then.
+//	Mismatch explains the difference, it is empty if the results match
+type DiffReport struct {
+	SQL       string
+	Seed      int64
+	Trace     []string
+	Compared  bool
+	Mismatch  string
+	Original  []Row
+	Optimized []Row
+}
+
+// DiffTest run sql on tables filled with data generated from seed, with the plan of GetQuery and with the plan
+// of QueryOptimizer, and compare the results: as multisets, or in order when the query ends with ORDER BY.
+// The rows tied on the ORDER BY items may come in any order, and the ties cut by a LIMIT may differ.
+// Queries failing the same way with both plans, like a query using an unknown column, report no mismatch
+func DiffTest(sql string, seed int64) (*DiffReport, error) {
+	node, err := parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	plan := GetQuery(node)
+	restore := generateTables(plan, rand.New(rand.NewSource(seed)))
+	defer restore()
+
+	original, err := CopyLogicalPlan(plan)
+	if err != nil {
+		return nil, err
+	}
+	quiet := QuietRules
+	QuietRules, RuleTrace = true, nil
+	treeRoot = plan
+	plan.QueryOptimizer()
+	QuietRules = quiet
+	report := &DiffReport{SQL: sql, Seed: seed, Trace: RuleTrace, Compared: Deterministic(original)}
+
+	schema, rows1, err1 := Execute(original)
+	_, rows2, err2 := Execute(treeRoot)
+	report.Original, report.Optimized = rows1, rows2
+	switch {
+	case err1 != nil && err2 != nil:
+		return report, nil
+	case err1 != nil || err2 != nil:
+		report.Mismatch = fmt.Sprintf("original error: %v, optimized error: %v", err1, err2)
+		return report, nil
+	case !report.Compared:
+		return report, nil
+	}
+	if order, ok := resultOrder(original); ok {
+		report.Mismatch = compareOrdered(schema, order, original.Tp == Limit, rows1, rows2)
+	} else if len(rows1) != len(rows2) || !sameRows(rows1, rows2) {
+		report.Mismatch = fmt.Sprintf("%d rows before optimization, %d rows after", len(rows1), len(rows2))
+	}
+	return report, nil
+}
+
+// Output print the report of a mismatch with the rules which rewrote the plan and both results
+func (r *DiffReport) Output() {
+	fmt.Printf("SQL: %v\n", r.SQL)
+	fmt.Printf("seed: %d\n", r.Seed)
+	fmt.Printf("mismatch: %v\n", r.Mismatch)
+	fmt.Printf("rules: %v\n", strings.Join(r.Trace, ", "))
+	fmt.Printf("original:\n")
+	for _, row := range r.Original {
+		fmt.Printf("    %v\n", FormatRow(row))
+	}
+	fmt.Printf("optimized:\n")
+	for _, row := range r.Optimized {
+		fmt.Printf("    %v\n", FormatRow(row))
+	}
+}
+
+// generateTables fill the base tables of plan with random rows, the tables missing from the Catalog get the
+// columns inferred from the query and are typed BIGINT. The returned function restores the previous tables
+func generateTables(plan *LogicalPlan, r *rand.Rand) func() {
+	var names []string
+	columns := make(map[string][]SchemaColumn)
+	var walk func(p *LogicalPlan)
+	walk = func(p *LogicalPlan) {
+		if p.Tp == Table && len(p.child) == 0 {
+			name := strings.ToLower(p.Content.(TableNode).Table.OrigTblName)
+			if _, ok := columns[name]; !ok {
+				names = append(names, name)
+			}
+			for _, c := range p.Schema().Columns {
+				if !hasColumn(columns[name], c.OrigColName) {
+					columns[name] = append(columns[name], c)
+				}
+			}
+		}
+		for i := range p.child {
+			walk(&p.child[i])
+		}
+	}
+	walk(plan)
+
+	type saved struct {
+		def   *TableDef
+		table *MemTable
+		stats *TableStats
+	}
+	previous := make(map[string]saved)
+	for _, name := range names {
+		previous[name] = saved{Catalog[name], Storage[name], Statistics[name]}
+		def := TableDef{Name: name}
+		if old, ok := LookupTable(name); ok {
+			def = *old
+		} else {
+			for _, c := range columns[name] {
+				tp := c.Tp
+				if tp == test_driver.KindNull {
+					tp = test_driver.KindInt64
+				}
+				def.Columns = append(def.Columns, ColumnDef{Name: c.OrigColName, Tp: tp})
+			}
+		}
+		var rows []Row
+		n := r.Intn(DiffMaxRows + 1)
+		for i := 0; i < n; i++ {
+			row := make(Row, 0, len(def.Columns))
+			for _, c := range def.Columns {
+				row = append(row, randomValue(r, c))
+			}
+			rows = append(rows, row)
+		}
+		RegisterMemTable(def, rows)
+	}
+	return func() {
+		for name, s := range previous {
+			delete(Catalog, name)
+			delete(Storage, name)
+			delete(Statistics, name)
+			if s.def != nil {
+				Catalog[name] = s.def
+			}
+			if s.table != nil {
+				Storage[name] = s.table
+			}
+			if s.stats != nil {
+				Statistics[name] = s.stats
+			}
+		}
+	}
+}
+
+func hasColumn(columns []SchemaColumn, name string) bool {
+	for _, c := range columns {
+		if strings.EqualFold(c.OrigColName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// randomValue draw a value of the type of c from a small domain, so that the joins and the predicates match
+func randomValue(r *rand.Rand, c ColumnDef) Datum {
+	if !c.NotNull && r.Intn(6) == 0 {
+		return NullDatum()
+	}
+	k := int64(r.Intn(7) - 1)
+	switch c.Tp {
+	case test_driver.KindUint64:
+		return InitSetValue(uint64(k + 1))
+	case test_driver.KindFloat32, test_driver.KindFloat64:
+		return InitSetValue(float64(k) / 2)
+	case test_driver.KindMysqlDecimal:
+		return DecimalDatum(big.NewRat(k, 2), 1)
+	case test_driver.KindString:
+		return InitSetValue([]string{"", "a", "b", "ab", "B", "abc", "b "}[k+1])
+	}
+	return InitSetValue(k)
+}
+
+// Deterministic check whether the result of plan only depends on the data: no volatile function, no LIMIT
+// below the top of the query, no group_concat whose order depends on the plan, and no column of an Aggregate
+// outside of its aggregates which is not grouped, as MySQL may take it from any row of the group
+func Deterministic(plan *LogicalPlan) bool {
+	return deterministic(plan, true)
+}
+
+func deterministic(plan *LogicalPlan, top bool) bool {
+	if !CheckFieldsDeterministic(plan.Expressions()) ||
+		strings.Contains(strings.ToLower(printExpressions(plan.Expressions())), "group_concat(") {
+		return false
+	}
+	switch n := plan.Content.(type) {
+	case LimitNode:
+		if !top || plan.child[0].Tp != OrderBy {
+			return false
+		}
+	case TopNNode:
+		if !top {
+			return false
+		}
+	case AggregateNode:
+		if !grouped(n.cols, n.Items) {
+			return false
+		}
+	case ProjectionNode:
+		if hasAggregate(n.cols) && !grouped(n.cols, nil) {
+			return false
+		}
+	}
+	top = top && (plan.Tp == Limit || plan.Tp == TopN || plan.Tp == OrderBy)
+	for i := range plan.child {
+		if !deterministic(&plan.child[i], top) {
+			return false
+		}
+	}
+	return true
+}
+
+// grouped check whether the columns of cols outside of the aggregates are group by items
+func grouped(cols []Expression, items []Expression) bool {
+	for _, col := range cols {
+		if IsWildCard(col) {
+			return false
+		}
+		for _, part := range nonAggregatePart(col) {
+			for _, c := range GetExpressionColName(part) {
+				if !GroupByContains(items, c) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// resultOrder return the ORDER BY items of the query and whether they can be computed on the rows of the result
+func resultOrder(plan *LogicalPlan) ([]ByItem, bool) {
+	cur := plan
+	for cur.Tp == Limit && len(cur.child) == 1 {
+		cur = &cur.child[0]
+	}
+	var items []ByItem
+	switch n := cur.Content.(type) {
+	case OrderByNode:
+		items = n.Items
+	case TopNNode:
+		items = n.Items
+	default:
+		return nil, false
+	}
+	schema := cur.childSchema()
+	for _, item := range items {
+		if _, ok := orderPosition(item.Item); !ok && !schema.Contains(item.Item) {
+			return nil, false
+		}
+	}
+	return items, true
+}
+
+// compareOrdered compare two sorted results. The runs of rows with the same ORDER BY values must be the same,
+// except the first and the last runs when they may be cut by a LIMIT and an OFFSET
+func compareOrdered(schema Schema, items []ByItem, limited bool, rows1, rows2 []Row) string {
+	if len(rows1) != len(rows2) {
+		return fmt.Sprintf("%d rows before optimization, %d rows after", len(rows1), len(rows2))
+	}
+	keys := func(row Row) Row {
+		var ret Row
+		for _, item := range items {
+			if pos, ok := orderPosition(item.Item); ok && pos >= 1 && pos <= len(row) {
+				ret = append(ret, row[pos-1])
+				continue
+			}
+			v, err := EvalExpression(item.Item, schema, row)
+			if err != nil {
+				v = NullDatum()
+			}
+			ret = append(ret, v)
+		}
+		return ret
+	}
+	start := 0
+	for i := range rows1 {
+		k1, k2 := keys(rows1[i]), keys(rows2[i])
+		if RowKey(k1) != RowKey(k2) {
+			return fmt.Sprintf("row %d is ordered by %v before optimization, by %v after", i+1, FormatRow(k1),
+				FormatRow(k2))
+		}
+		if i+1 < len(rows1) && RowKey(keys(rows1[i+1])) == RowKey(k1) {
+			continue
+		}
+		cut := limited && (start == 0 || i+1 == len(rows1))
+		if !cut && !sameRows(rows1[start:i+1], rows2[start:i+1]) {
+			return fmt.Sprintf("rows %d to %d differ", start+1, i+1)
+		}
+		start = i + 1
+	}
+	return ""
+}
+
+// sameRows check whether the rows are the same multiset
+func sameRows(rows1, rows2 []Row) bool {
+	count := make(map[string]int)
+	for _, row := range rows1 {
+		count[RowKey(row)]++
+	}
+	for _, row := range rows2 {
+		count[RowKey(row)]--
+	}
+	for _, c := range count {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestDiffTestFixtures(t *testing.T) {
+	loadTestTables(t)
+	for _, f := range fixtures {
+		bytes, err := ioutil.ReadFile(testDir + f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for seed := int64(1); seed <= 10; seed++ {
+			report, err := DiffTest(string(bytes), seed)
+			if err != nil {
+				t.Fatalf("%v: %v", f, err)
+			}
+			if report.Mismatch != "" {
+				t.Errorf("%v: seed %d: %v", f, seed, report.Mismatch)
+				break
+			}
+		}
+	}
+}
+
+func TestDiffTestQueries(t *testing.T) {
+	loadTestTables(t)
+	tests := []struct {
+		sql      string
+		compared bool
+	}{
+		{"select t.a, s.c from t join s on t.a = s.a where t.b > 20 and s.c is not null", true},
+		{"select t.a, s.c from t left join s on t.a = s.a where s.a is null or t.a > 5", true},
+		{"select a, count(*), sum(b) from testdata2 where b > 0 group by a having count(*) > 1", true},
+		{"select a, b from t order by a desc limit 2, 3", true},
+		{"select x.a from (select a, b from t where b is not null) x where x.a > 3", true},
+		{"select za, zb from nosuch where za > zb", true},
+		{"select a from t limit 3", false},
+		{"select a, rand() from t", false},
+		{"select nosuch from t", true},
+	}
+	for _, test := range tests {
+		for seed := int64(1); seed <= 5; seed++ {
+			report, err := DiffTest(test.sql, seed)
+			if err != nil {
+				t.Fatalf("%v: %v", test.sql, err)
+			}
+			if report.Compared != test.compared {
+				t.Errorf("%v: compared %v, want %v", test.sql, report.Compared, test.compared)
+			}
+			if report.Mismatch != "" {
+				t.Errorf("%v: seed %d: %v", test.sql, seed, report.Mismatch)
+			}
+		}
+	}
+	//the generated rows replace the tables only during the test
+	checkResult(t, "select count(*) from t", "12")
+	if Catalog["nosuch"] != nil {
+		t.Error("the table generated for nosuch is kept in the Catalog")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"select a, b from t where b > 1", true},
+		{"select a from t order by a limit 3", true},
+		{"select a from t limit 3", false},
+		{"select x.a from (select a from t order by a limit 3) x", false},
+		{"select a, uuid() from t", false},
+		{"select a, count(*) from testdata2 group by a", true},
+		{"select a, b, count(*) from testdata2 group by a", false},
+		{"select group_concat(b) from testdata2", false},
+	}
+	for _, test := range tests {
+		node, err := parse(test.sql)
+		if err != nil {
+			t.Fatalf("%v: %v", test.sql, err)
+		}
+		if got := Deterministic(GetQuery(node)); got != test.want {
+			t.Errorf("%v: deterministic %v, want %v", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestCompareOrdered(t *testing.T) {
+	loadTestTables(t)
+	node, err := parse("select a, b from t order by a limit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := GetQuery(node)
+	items, ok := resultOrder(plan)
+	if !ok {
+		t.Fatal("the order of the result is not found")
+	}
+	rows := func(values ...int64) []Row {
+		var ret []Row
+		for i := 0; i < len(values); i += 2 {
+			ret = append(ret, Row{InitSetValue(values[i]), InitSetValue(values[i+1])})
+		}
+		return ret
+	}
+	tests := []struct {
+		rows1, rows2 []Row
+		limited      bool
+		match        bool
+	}{
+		{rows(1, 10, 1, 20, 2, 5), rows(1, 20, 1, 10, 2, 5), false, true},
+		{rows(1, 10, 2, 5, 2, 6), rows(1, 10, 2, 5, 2, 7), true, true},
+		{rows(1, 10, 2, 5, 2, 6), rows(1, 10, 2, 5, 2, 7), false, false},
+		{rows(1, 10, 2, 5, 3, 6), rows(1, 10, 3, 6, 2, 5), false, false},
+		{rows(1, 10, 2, 5), rows(1, 10), false, false},
+	}
+	for i, test := range tests {
+		got := compareOrdered(plan.Schema(), items, test.limited, test.rows1, test.rows2)
+		if (got == "") != test.match {
+			t.Errorf("case %d: mismatch %q, want match %v", i, got, test.match)
+		}
+	}
+}
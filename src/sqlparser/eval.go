@@ -0,0 +1,363 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math"
+	"math/big"
+)
+
+// EvalOp evaluate the operator of the Ops table on constant arguments with the MySQL three-valued logic.
+// false is returned if the result can not be computed exactly here, the caller then keeps the expression
+func EvalOp(op MyOp, args []Datum) (Datum, bool) {
+	for _, arg := range args {
+		if !IsNumericKind(arg.Kind()) && arg.Kind() != test_driver.KindNull && arg.Kind() != test_driver.KindString {
+			return Datum{}, false
+		}
+	}
+	switch op {
+	case LogicAnd, LogicOr, LogicXor, Not, Not2, IsTruth, IsFalsity:
+		//the truth of a string depends on its conversion to a number
+		for _, arg := range args {
+			if arg.Kind() == test_driver.KindString {
+				return Datum{}, false
+			}
+		}
+	}
+	switch op {
+	case LogicAnd, LogicOr, LogicXor:
+		return evalLogic(op, args[0], args[1])
+	case Not, Not2:
+		t, null := Truth(args[0])
+		if null {
+			return NullDatum(), true
+		}
+		return BoolDatum(!t), true
+	case IsNull:
+		return BoolDatum(args[0].Kind() == test_driver.KindNull), true
+	case IsTruth, IsFalsity:
+		t, null := Truth(args[0])
+		if null {
+			return BoolDatum(false), true
+		}
+		return BoolDatum(t == (op == IsTruth)), true
+	case NullEQ:
+		n1, n2 := args[0].Kind() == test_driver.KindNull, args[1].Kind() == test_driver.KindNull
+		if n1 || n2 {
+			return BoolDatum(n1 && n2), true
+		}
+		c, ok := CompareDatum(args[0], args[1])
+		return BoolDatum(c == 0), ok
+	case EQ, NE, LT, LE, GT, GE:
+		if HasNull(args) {
+			return NullDatum(), true
+		}
+		c, ok := CompareDatum(args[0], args[1])
+		if !ok {
+			return Datum{}, false
+		}
+		return BoolDatum(CompareResult(op, c)), true
+	case In:
+		if args[0].Kind() == test_driver.KindNull {
+			return NullDatum(), true
+		}
+		null := false
+		for _, arg := range args[1:] {
+			if arg.Kind() == test_driver.KindNull {
+				null = true
+				continue
+			}
+			c, ok := CompareDatum(args[0], arg)
+			if !ok {
+				return Datum{}, false
+			}
+			if c == 0 {
+				return BoolDatum(true), true
+			}
+		}
+		if null {
+			return NullDatum(), true
+		}
+		return BoolDatum(false), true
+	case Plus, Minus, Mul, Mod, IntDiv, UnaryMinus:
+		if HasNull(args) {
+			return NullDatum(), true
+		}
+		return evalArithmetic(op, args)
+	case And, Or, Xor, LeftShift, RightShift, BitNeg:
+		if HasNull(args) {
+			return NullDatum(), true
+		}
+		return evalBit(op, args)
+	}
+	return Datum{}, false
+}
+
+func NullDatum() (d Datum) {
+	d.SetNull()
+	return
+}
+
+func BoolDatum(b bool) Datum {
+	return InitSetValue(b)
+}
+
+func HasNull(args []Datum) bool {
+	for _, arg := range args {
+		if arg.Kind() == test_driver.KindNull {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNumericKind check whether the datum kind is a number
+func IsNumericKind(k byte) bool {
+	switch k {
+	case test_driver.KindInt64, test_driver.KindUint64, test_driver.KindFloat32, test_driver.KindFloat64,
+		test_driver.KindMysqlDecimal:
+		return true
+	}
+	return false
+}
+
+// Truth return the truth value of a numeric datum, null is true for NULL
+func Truth(d Datum) (truth bool, null bool) {
+	switch d.Kind() {
+	case test_driver.KindNull:
+		return false, true
+	case test_driver.KindInt64:
+		return d.GetInt64() != 0, false
+	case test_driver.KindUint64:
+		return d.GetUint64() != 0, false
+	case test_driver.KindFloat32, test_driver.KindFloat64:
+		return d.GetFloat64() != 0, false
+	case test_driver.KindMysqlDecimal:
+		r, _ := DatumRat(d)
+		return r.Sign() != 0, false
+	}
+	return false, false
+}
+
+func evalLogic(op MyOp, l, r Datum) (Datum, bool) {
+	lt, ln := Truth(l)
+	rt, rn := Truth(r)
+	switch op {
+	case LogicAnd:
+		if (!ln && !lt) || (!rn && !rt) {
+			return BoolDatum(false), true
+		}
+		if ln || rn {
+			return NullDatum(), true
+		}
+		return BoolDatum(true), true
+	case LogicOr:
+		if (!ln && lt) || (!rn && rt) {
+			return BoolDatum(true), true
+		}
+		if ln || rn {
+			return NullDatum(), true
+		}
+		return BoolDatum(false), true
+	default:
+		if ln || rn {
+			return NullDatum(), true
+		}
+		return BoolDatum(lt != rt), true
+	}
+}
+
+// DatumRat convert a numeric datum to an exact rational number
+func DatumRat(d Datum) (*big.Rat, bool) {
+	switch d.Kind() {
+	case test_driver.KindInt64:
+		return new(big.Rat).SetInt64(d.GetInt64()), true
+	case test_driver.KindUint64:
+		return new(big.Rat).SetUint64(d.GetUint64()), true
+	case test_driver.KindFloat32, test_driver.KindFloat64:
+		f := d.GetFloat64()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(f), true
+	case test_driver.KindMysqlDecimal:
+		return new(big.Rat).SetString(d.GetMysqlDecimal().String())
+	}
+	return nil, false
+}
+
+// DatumFloat convert a numeric datum to float64
+func DatumFloat(d Datum) (float64, bool) {
+	switch d.Kind() {
+	case test_driver.KindInt64:
+		return float64(d.GetInt64()), true
+	case test_driver.KindUint64:
+		return float64(d.GetUint64()), true
+	case test_driver.KindFloat32, test_driver.KindFloat64:
+		return d.GetFloat64(), true
+	case test_driver.KindMysqlDecimal:
+		r, ok := DatumRat(d)
+		if !ok {
+			return 0, false
+		}
+		f, _ := r.Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+// CompareDatum compare two non-NULL datums of the same class, numbers with numbers and strings with strings.
+// Strings are compared byte-wise, which is only exact for equal strings under the default collation,
+// so only identical strings are reported equal and everything else is refused
+func CompareDatum(a, b Datum) (int, bool) {
+	if IsNumericKind(a.Kind()) && IsNumericKind(b.Kind()) {
+		ra, ok1 := DatumRat(a)
+		rb, ok2 := DatumRat(b)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		return ra.Cmp(rb), true
+	}
+	if a.Kind() == test_driver.KindString && b.Kind() == test_driver.KindString && a.GetString() == b.GetString() {
+		return 0, true
+	}
+	return 0, false
+}
+
+// CompareResult apply the comparison operator on the result of a three-way compare
+func CompareResult(op MyOp, c int) bool {
+	switch op {
+	case EQ, NullEQ:
+		return c == 0
+	case NE:
+		return c != 0
+	case LT:
+		return c < 0
+	case LE:
+		return c <= 0
+	case GT:
+		return c > 0
+	case GE:
+		return c >= 0
+	}
+	return false
+}
+
+func isIntKind(k byte) bool {
+	return k == test_driver.KindInt64 || k == test_driver.KindUint64
+}
+
+func bigInt(d Datum) *big.Int {
+	if d.Kind() == test_driver.KindUint64 {
+		return new(big.Int).SetUint64(d.GetUint64())
+	}
+	return big.NewInt(d.GetInt64())
+}
+
+func isFloatKind(k byte) bool {
+	return k == test_driver.KindFloat32 || k == test_driver.KindFloat64
+}
+
+// evalArithmetic fold integer arithmetic without overflow and float arithmetic,
+// decimals and strings are left to the evaluator
+func evalArithmetic(op MyOp, args []Datum) (Datum, bool) {
+	if op == UnaryMinus {
+		switch {
+		case args[0].Kind() == test_driver.KindInt64 && args[0].GetInt64() != math.MinInt64:
+			return InitSetValue(-args[0].GetInt64()), true
+		case isFloatKind(args[0].Kind()):
+			return InitSetValue(-args[0].GetFloat64()), true
+		}
+		return Datum{}, false
+	}
+	l, r := args[0], args[1]
+	switch {
+	case isIntKind(l.Kind()) && isIntKind(r.Kind()):
+		a, b := bigInt(l), bigInt(r)
+		var ret *big.Int
+		switch op {
+		case Plus:
+			ret = new(big.Int).Add(a, b)
+		case Minus:
+			ret = new(big.Int).Sub(a, b)
+		case Mul:
+			ret = new(big.Int).Mul(a, b)
+		case Mod, IntDiv:
+			if b.Sign() == 0 {
+				return NullDatum(), true
+			}
+			//both truncate toward zero like MySQL
+			if op == Mod {
+				ret = new(big.Int).Rem(a, b)
+			} else {
+				ret = new(big.Int).Quo(a, b)
+			}
+		}
+		//the result is unsigned if one operand is, negative results are an error in MySQL
+		if l.Kind() == test_driver.KindUint64 || r.Kind() == test_driver.KindUint64 {
+			if ret == nil || !ret.IsUint64() {
+				return Datum{}, false
+			}
+			return InitSetValue(ret.Uint64()), true
+		}
+		if ret == nil || !ret.IsInt64() {
+			return Datum{}, false
+		}
+		return InitSetValue(ret.Int64()), true
+	case (isFloatKind(l.Kind()) && IsNumericKind(r.Kind())) || (isFloatKind(r.Kind()) && IsNumericKind(l.Kind())):
+		a, _ := DatumFloat(l)
+		b, _ := DatumFloat(r)
+		var ret float64
+		switch op {
+		case Plus:
+			ret = a + b
+		case Minus:
+			ret = a - b
+		case Mul:
+			ret = a * b
+		case Mod:
+			if b == 0 {
+				return NullDatum(), true
+			}
+			ret = math.Mod(a, b)
+		default:
+			return Datum{}, false
+		}
+		if math.IsInf(ret, 0) || math.IsNaN(ret) {
+			return Datum{}, false
+		}
+		return InitSetValue(ret), true
+	}
+	return Datum{}, false
+}
+
+// evalBit fold the bit operators, whose result is an unsigned BIGINT in MySQL
+func evalBit(op MyOp, args []Datum) (Datum, bool) {
+	var v []uint64
+	for _, arg := range args {
+		if !isIntKind(arg.Kind()) {
+			return Datum{}, false
+		}
+		v = append(v, arg.GetUint64())
+	}
+	switch op {
+	case BitNeg:
+		return InitSetValue(^v[0]), true
+	case And:
+		return InitSetValue(v[0] & v[1]), true
+	case Or:
+		return InitSetValue(v[0] | v[1]), true
+	case Xor:
+		return InitSetValue(v[0] ^ v[1]), true
+	case LeftShift:
+		if v[1] >= 64 {
+			return InitSetValue(uint64(0)), true
+		}
+		return InitSetValue(v[0] << v[1]), true
+	case RightShift:
+		if v[1] >= 64 {
+			return InitSetValue(uint64(0)), true
+		}
+		return InitSetValue(v[0] >> v[1]), true
+	}
+	return Datum{}, false
+}
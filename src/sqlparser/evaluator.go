@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Row is a row of values, in the order of the columns of the schema it is read with
+type Row []Datum
+
+// evaluator computes expressions on one row of schema.
+// Inside an Aggregate, aggregate returns the value of an aggregate function for the current group,
+// elsewhere the aggregates must be columns of the row, named after the printed function like projectSchema does
+type evaluator struct {
+	schema    Schema
+	row       Row
+	aggregate func(f Datum) (Datum, error)
+}
+
+// EvalExpression compute expr on row, whose values are described by schema
+func EvalExpression(expr Expression, schema Schema, row Row) (Datum, error) {
+	e := evaluator{schema: schema, row: row}
+	return e.eval(expr)
+}
+
+func (e *evaluator) eval(expr Expression) (Datum, error) {
+	var s []Datum
+	for _, d := range expr.expr {
+		var v Datum
+		var err error
+		switch {
+		case d.Args != nil:
+			v, err = e.function(d)
+		case expr.IsColumnDatum(d):
+			v, err = e.column(expr.Fields[d.GetString()])
+		case d.Kind() == test_driver.KindString && StrToOp(d.GetString()) != -1 && len(s) >= 2:
+			v, err = EvalOperator(StrToOp(d.GetString()), s[len(s)-2:])
+			s = s[:len(s)-2]
+		default:
+			v = Datum{d.Datum, nil}
+		}
+		if err != nil {
+			return Datum{}, err
+		}
+		s = append(s, v)
+	}
+	if len(s) != 1 {
+		return Datum{}, fmt.Errorf("malformed expression %v", printExpressions([]Expression{expr}))
+	}
+	return s[0], nil
+}
+
+// column return the value of col, all the columns are NULL on the nil row of an empty input
+func (e *evaluator) column(col ColumnName) (Datum, error) {
+	i := e.schema.ResolveColumn(col)
+	if i == -1 {
+		return Datum{}, fmt.Errorf("unknown or ambiguous column %v", columnText(col))
+	}
+	if e.row == nil {
+		return NullDatum(), nil
+	}
+	return e.row[i], nil
+}
+
+func (e *evaluator) function(d Datum) (Datum, error) {
+	name := d.GetString()
+	if IsAggregateFunction(name) {
+		if e.aggregate != nil {
+			return e.aggregate(d)
+		}
+		key := Expression{expr: []Datum{d}}
+		return e.column(ColumnName{ColName: key.print()})
+	}
+	if name == Ops[Case].Name || name == "if" {
+		return e.conditional(d)
+	}
+	args := make([]Datum, 0, len(d.Args))
+	for _, arg := range d.Args {
+		v, err := e.eval(arg)
+		if err != nil {
+			return Datum{}, err
+		}
+		args = append(args, v)
+	}
+	if op := StrToOp(name); op != -1 {
+		return EvalOperator(op, args)
+	}
+	def, ok := LookupFunction(name)
+	if !ok || def.Eval == nil {
+		return Datum{}, fmt.Errorf("unsupported function %v", name)
+	}
+	return def.Eval(args)
+}
+
+// conditional evaluate case(when1, then1, ..., else) and if(cond, then, else) lazily,
+// the results which are not chosen are not computed and can not fail
+func (e *evaluator) conditional(d Datum) (Datum, error) {
+	if d.GetString() == "if" && len(d.Args) != 3 {
+		return Datum{}, fmt.Errorf("incorrect parameter count in the call to if")
+	}
+	if len(d.Args)%2 == 0 {
+		return Datum{}, fmt.Errorf("malformed CASE of %d arguments", len(d.Args))
+	}
+	for i := 0; i+1 < len(d.Args); i += 2 {
+		cond, err := e.eval(d.Args[i])
+		if err != nil {
+			return Datum{}, err
+		}
+		if IsTrue(cond) {
+			return e.eval(d.Args[i+1])
+		}
+	}
+	return e.eval(d.Args[len(d.Args)-1])
+}
+
+func columnText(col ColumnName) string {
+	if col.TblName != "" {
+		return col.TblName + "." + col.ColName
+	}
+	return col.ColName
+}
+
+// EvalOperator apply the operator on the values of its arguments with the implicit conversions of MySQL.
+// EvalOp computes it when it can, the conversions and the results it leaves out are computed here
+func EvalOperator(op MyOp, args []Datum) (Datum, error) {
+	if ret, ok := EvalOp(op, args); ok {
+		return ret, nil
+	}
+	switch op {
+	case EQ, NE, LT, LE, GT, GE, NullEQ, In:
+		return evalComparison(op, args)
+	case LogicAnd, LogicOr, LogicXor, Not, Not2, IsTruth, IsFalsity:
+		//the truth of a string is the truth of its number
+		if ret, ok := EvalOp(op, numericArgs(args)); ok {
+			return ret, nil
+		}
+	case IsNull:
+		return BoolDatum(args[0].Kind() == test_driver.KindNull), nil
+	case Plus, Minus, Mul, Div, Mod, IntDiv, UnaryMinus:
+		args = numericArgs(args)
+		if ret, ok := EvalOp(op, args); ok {
+			return ret, nil
+		}
+		return evalExactArithmetic(op, args)
+	case And, Or, Xor, LeftShift, RightShift, BitNeg:
+		return evalBitwise(op, args)
+	case Like:
+		return evalLike(args)
+	case Regexp:
+		return evalRegexp(args)
+	case Case:
+		return evalCase(args)
+	}
+	return Datum{}, fmt.Errorf("can not evaluate %v on %v", Ops[op].Literal, FormatRow(args))
+}
+
+// CompareValues compare two non-NULL values, numbers by value and strings byte-wise. The strings have the
+// binary collation utf8mb4_0900_bin and not the case-insensitive default of MySQL: 'a' = 'A' is 0 here.
+// The collation is NO PAD, the trailing spaces count: 'a' = 'a ' is 0 and 'a' < 'a ' is 1
+func CompareValues(a, b Datum) (int, bool) {
+	if c, ok := CompareDatum(a, b); ok {
+		return c, true
+	}
+	if a.Kind() == test_driver.KindString && b.Kind() == test_driver.KindString {
+		return strings.Compare(a.GetString(), b.GetString()), true
+	}
+	return 0, false
+}
+
+// CompareForOrder is the order of ORDER BY and MIN/MAX: NULL first, then the numbers, then the other values
+func CompareForOrder(a, b Datum) int {
+	an, bn := a.Kind() == test_driver.KindNull, b.Kind() == test_driver.KindNull
+	switch {
+	case an || bn:
+		if an && bn {
+			return 0
+		} else if an {
+			return -1
+		}
+		return 1
+	}
+	if c, ok := CompareValues(a, b); ok {
+		return c
+	}
+	if IsNumericKind(a.Kind()) != IsNumericKind(b.Kind()) {
+		if IsNumericKind(a.Kind()) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(FormatDatum(a), FormatDatum(b))
+}
+
+func evalComparison(op MyOp, args []Datum) (Datum, error) {
+	if op == NullEQ {
+		n1, n2 := args[0].Kind() == test_driver.KindNull, args[1].Kind() == test_driver.KindNull
+		if n1 || n2 {
+			return BoolDatum(n1 && n2), nil
+		}
+	} else if args[0].Kind() == test_driver.KindNull || (op != In && args[1].Kind() == test_driver.KindNull) {
+		return NullDatum(), nil
+	}
+	if op != In {
+		c, ok := compareCoerced(args[0], args[1])
+		if !ok {
+			return Datum{}, fmt.Errorf("can not compare %v with %v", FormatDatum(args[0]), FormatDatum(args[1]))
+		}
+		return BoolDatum(CompareResult(op, c)), nil
+	}
+	null := false
+	for _, arg := range args[1:] {
+		if arg.Kind() == test_driver.KindNull {
+			null = true
+			continue
+		}
+		c, ok := compareCoerced(args[0], arg)
+		if !ok {
+			return Datum{}, fmt.Errorf("can not compare %v with %v", FormatDatum(args[0]), FormatDatum(arg))
+		}
+		if c == 0 {
+			return BoolDatum(true), nil
+		}
+	}
+	if null {
+		return NullDatum(), nil
+	}
+	return BoolDatum(false), nil
+}
+
+// evalExactArithmetic compute the arithmetic EvalOp leaves out: the division, the decimals, the floats
+// with DIV and the results out of range. Integers only get here when the result overflows BIGINT
+func evalExactArithmetic(op MyOp, args []Datum) (Datum, error) {
+	if HasNull(args) {
+		return NullDatum(), nil
+	}
+	ints, floats, unsigned := true, false, false
+	for _, arg := range args {
+		if !IsNumericKind(arg.Kind()) {
+			return Datum{}, fmt.Errorf("can not evaluate %v on %v", Ops[op].Literal, FormatRow(args))
+		}
+		ints = ints && isIntKind(arg.Kind())
+		floats = floats || isFloatKind(arg.Kind())
+		unsigned = unsigned || arg.Kind() == test_driver.KindUint64
+	}
+	if op == UnaryMinus && args[0].Kind() == test_driver.KindUint64 && args[0].GetUint64() <= 1<<63 {
+		//the negation of an unsigned value is signed
+		return InitSetValue(int64(-args[0].GetUint64())), nil
+	}
+	if ints && op != Div {
+		if unsigned && op != UnaryMinus {
+			return Datum{}, rangeError("BIGINT UNSIGNED", op, args)
+		}
+		return Datum{}, rangeError("BIGINT", op, args)
+	}
+	if op == UnaryMinus {
+		r, _ := DatumRat(args[0])
+		return DecimalDatum(r.Neg(r), DecimalScale(args[0])), nil
+	}
+	if floats {
+		a, _ := DatumFloat(args[0])
+		b, _ := DatumFloat(args[1])
+		if (op == Div || op == IntDiv || op == Mod) && b == 0 {
+			return NullDatum(), nil
+		}
+		var ret float64
+		switch op {
+		case Plus:
+			ret = a + b
+		case Minus:
+			ret = a - b
+		case Mul:
+			ret = a * b
+		case Div:
+			ret = a / b
+		case Mod:
+			ret = math.Mod(a, b)
+		case IntDiv:
+			q := math.Trunc(a / b)
+			if q < math.MinInt64 || q >= math.MaxInt64 {
+				return Datum{}, rangeError("BIGINT", op, args)
+			}
+			return InitSetValue(int64(q)), nil
+		}
+		if math.IsInf(ret, 0) || math.IsNaN(ret) {
+			return Datum{}, rangeError("DOUBLE", op, args)
+		}
+		return InitSetValue(ret), nil
+	}
+	a, _ := DatumRat(args[0])
+	b, _ := DatumRat(args[1])
+	sa, sb := DecimalScale(args[0]), DecimalScale(args[1])
+	switch op {
+	case Plus:
+		return DecimalDatum(new(big.Rat).Add(a, b), maxInt(sa, sb)), nil
+	case Minus:
+		return DecimalDatum(new(big.Rat).Sub(a, b), maxInt(sa, sb)), nil
+	case Mul:
+		return DecimalDatum(new(big.Rat).Mul(a, b), sa+sb), nil
+	}
+	if b.Sign() == 0 {
+		return NullDatum(), nil
+	}
+	q := new(big.Rat).Quo(a, b)
+	switch op {
+	case Div:
+		//the scale grows by div_precision_increment
+		return DecimalDatum(q, sa+4), nil
+	case IntDiv:
+		t := new(big.Int).Quo(q.Num(), q.Denom())
+		if !t.IsInt64() {
+			return Datum{}, rangeError("BIGINT", op, args)
+		}
+		return InitSetValue(t.Int64()), nil
+	default:
+		t := new(big.Rat).SetInt(new(big.Int).Quo(q.Num(), q.Denom()))
+		return DecimalDatum(new(big.Rat).Sub(a, t.Mul(t, b)), maxInt(sa, sb)), nil
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// DecimalDatum build the DECIMAL value of r rounded to scale digits after the point
+func DecimalDatum(r *big.Rat, scale int) Datum {
+	v := new(test_driver.MyDecimal)
+	if err := v.FromString([]byte(r.FloatString(scale))); err != nil {
+		panic(err)
+	}
+	var ret Datum
+	ret.SetMysqlDecimal(v)
+	return ret
+}
+
+// DecimalScale return the number of digits after the point of a DECIMAL, 0 for the other values
+func DecimalScale(d Datum) int {
+	if d.Kind() != test_driver.KindMysqlDecimal {
+		return 0
+	}
+	str := d.GetMysqlDecimal().String()
+	if i := strings.IndexByte(str, '.'); i != -1 {
+		return len(str) - i - 1
+	}
+	return 0
+}
+
+// IsTrue check whether the value of a condition is true, NULL is not. A string is true if its number is
+func IsTrue(d Datum) bool {
+	t, null := Truth(NumericValue(d))
+	return t && !null
+}
+
+// ValueKey encode d such that the values equal under CompareValues have the same key, for grouping and DISTINCT
+func ValueKey(d Datum) string {
+	switch {
+	case d.Kind() == test_driver.KindNull:
+		return "n"
+	case IsNumericKind(d.Kind()):
+		if r, ok := DatumRat(d); ok {
+			return "#" + r.RatString()
+		}
+		return "f" + FormatDatum(d)
+	case d.Kind() == test_driver.KindString:
+		return "s" + strconv.Itoa(len(d.GetString())) + ":" + d.GetString()
+	}
+	return "k" + strconv.Itoa(int(d.Kind())) + ":" + FormatDatum(d)
+}
+
+// RowKey encode the values of row with ValueKey
+func RowKey(row Row) string {
+	var b strings.Builder
+	for _, d := range row {
+		key := ValueKey(d)
+		b.WriteString(strconv.Itoa(len(key)))
+		b.WriteByte(':')
+		b.WriteString(key)
+	}
+	return b.String()
+}
+
+// FormatDatum return the text of a value as a client shows it
+func FormatDatum(d Datum) string {
+	switch d.Kind() {
+	case test_driver.KindNull:
+		return "NULL"
+	case test_driver.KindInt64:
+		return strconv.FormatInt(d.GetInt64(), 10)
+	case test_driver.KindUint64:
+		return strconv.FormatUint(d.GetUint64(), 10)
+	case test_driver.KindFloat32:
+		return strconv.FormatFloat(float64(d.GetFloat32()), 'g', -1, 32)
+	case test_driver.KindFloat64:
+		return strconv.FormatFloat(d.GetFloat64(), 'g', -1, 64)
+	case test_driver.KindString:
+		return d.GetString()
+	case test_driver.KindBytes, test_driver.KindBinaryLiteral:
+		return string(d.GetBytes())
+	case test_driver.KindMysqlDecimal:
+		return d.GetMysqlDecimal().String()
+	}
+	return fmt.Sprintf("%v", d.GetValue())
+}
+
+// FormatRow return the values of row separated by commas
+func FormatRow(row []Datum) string {
+	str := make([]string, 0, len(row))
+	for _, d := range row {
+		str = append(str, FormatDatum(d))
+	}
+	return strings.Join(str, ", ")
+}
+
+// builtinEvals are the implementations of the builtin scalar functions the executor can run
+var builtinEvals = map[string]func(args []Datum) (Datum, error){
+	"coalesce": func(args []Datum) (Datum, error) {
+		for _, arg := range args {
+			if arg.Kind() != test_driver.KindNull {
+				return arg, nil
+			}
+		}
+		return NullDatum(), nil
+	},
+	"ifnull": func(args []Datum) (Datum, error) {
+		if err := checkArgs("ifnull", args, 2); err != nil {
+			return Datum{}, err
+		}
+		if args[0].Kind() != test_driver.KindNull {
+			return args[0], nil
+		}
+		return args[1], nil
+	},
+	"nullif": func(args []Datum) (Datum, error) {
+		if err := checkArgs("nullif", args, 2); err != nil {
+			return Datum{}, err
+		}
+		eq, err := EvalOperator(EQ, args)
+		if err != nil {
+			return Datum{}, err
+		}
+		if IsTrue(eq) {
+			return NullDatum(), nil
+		}
+		return args[0], nil
+	},
+	"if": func(args []Datum) (Datum, error) {
+		if err := checkArgs("if", args, 3); err != nil {
+			return Datum{}, err
+		}
+		if IsTrue(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	},
+	"abs": func(args []Datum) (Datum, error) {
+		if err := checkArgs("abs", args, 1); err != nil {
+			return Datum{}, err
+		}
+		if c, ok := CompareDatum(args[0], InitSetValue(int64(0))); ok && c < 0 {
+			return EvalOperator(UnaryMinus, args)
+		}
+		return args[0], nil
+	},
+	"upper": stringFunction("upper", strings.ToUpper),
+	"ucase": stringFunction("ucase", strings.ToUpper),
+	"lower": stringFunction("lower", strings.ToLower),
+	"lcase": stringFunction("lcase", strings.ToLower),
+	"length": func(args []Datum) (Datum, error) {
+		if err := checkArgs("length", args, 1); err != nil || args[0].Kind() == test_driver.KindNull {
+			return NullDatum(), err
+		}
+		return InitSetValue(int64(len(FormatDatum(args[0])))), nil
+	},
+	"char_length": func(args []Datum) (Datum, error) {
+		if err := checkArgs("char_length", args, 1); err != nil || args[0].Kind() == test_driver.KindNull {
+			return NullDatum(), err
+		}
+		return InitSetValue(int64(len([]rune(FormatDatum(args[0]))))), nil
+	},
+	"concat": func(args []Datum) (Datum, error) {
+		var b strings.Builder
+		for _, arg := range args {
+			if arg.Kind() == test_driver.KindNull {
+				return NullDatum(), nil
+			}
+			b.WriteString(FormatDatum(arg))
+		}
+		return InitSetValue(b.String()), nil
+	},
+	"rand": func(args []Datum) (Datum, error) {
+		return InitSetValue(rand.Float64()), nil
+	},
+}
+
+func checkArgs(name string, args []Datum, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("incorrect parameter count in the call to %v", name)
+	}
+	return nil
+}
+
+func stringFunction(name string, f func(string) string) func(args []Datum) (Datum, error) {
+	return func(args []Datum) (Datum, error) {
+		if err := checkArgs(name, args, 1); err != nil || args[0].Kind() == test_driver.KindNull {
+			return NullDatum(), err
+		}
+		return InitSetValue(f(FormatDatum(args[0]))), nil
+	}
+}
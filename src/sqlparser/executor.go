@@ -0,0 +1,1233 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Executor is a Volcano iterator: Open prepares it and opens its inputs, every Next returns the next row,
+// nil once the rows are exhausted, and Close releases it. Schema describes the rows returned by Next
+type Executor interface {
+	Open() error
+	Next() (Row, error)
+	Close() error
+	Schema() Schema
+}
+
+// Execute run plan over the tables of Storage and return its rows
+func Execute(plan *LogicalPlan) (Schema, []Row, error) {
+	return execute(plan, false)
+}
+
+func execute(plan *LogicalPlan, vectorize bool) (Schema, []Row, error) {
+	b := &executorBuilder{required: RequiredColumns(plan), vectorize: vectorize}
+	exec, err := b.build(plan, nil)
+	if err != nil {
+		return Schema{}, nil, err
+	}
+	if err := exec.Open(); err != nil {
+		exec.Close()
+		return Schema{}, nil, err
+	}
+	var rows []Row
+	for {
+		row, err := exec.Next()
+		if err != nil {
+			exec.Close()
+			return Schema{}, nil, err
+		}
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+	return exec.Schema(), rows, exec.Close()
+}
+
+// OutputResult print the column names and the rows of a result
+func OutputResult(schema Schema, rows []Row) {
+	var names []string
+	for _, c := range schema.Columns {
+		if c.TblName != "" {
+			names = append(names, c.TblName+"."+c.ColName)
+		} else {
+			names = append(names, c.ColName)
+		}
+	}
+	fmt.Printf("%v\n", strings.Join(names, " | "))
+	for _, row := range rows {
+		fmt.Printf("%v\n", strings.Join(strings.Split(FormatRow(row), ", "), " | "))
+	}
+	fmt.Printf("(%d rows)\n", len(rows))
+}
+
+// executorBuilder builds the executors of a plan. required are the columns of the base tables the scans must read,
+// vectorize makes the scans of the MemTables, the Filters, the Projects, the Aggregates and the equi-joins run
+// on chunks, the other operators run row by row on top of them
+type executorBuilder struct {
+	required  map[*LogicalPlan][]bool
+	vectorize bool
+}
+
+// BuildExecutor build the executor of every node of plan
+func BuildExecutor(plan *LogicalPlan) (Executor, error) {
+	b := &executorBuilder{required: RequiredColumns(plan)}
+	return b.build(plan, nil)
+}
+
+// build : the rows of the executor are the output of plan followed by the values of extra.
+// HAVING and ORDER BY, unlike WHERE, may use columns and aggregates which are not in the output of the Project or Aggregate
+// below them, like MySQL allows, they are asked to the Project or Aggregate as extra columns.
+// The nodes passing their input through forward the extra columns, the others compute them on their output
+func (b *executorBuilder) build(plan *LogicalPlan, extra []Expression) (Executor, error) {
+	if b.vectorize && len(extra) == 0 {
+		if vec, ok, err := b.buildVec(plan); err != nil {
+			return nil, err
+		} else if ok {
+			return &chunkRowsExec{child: vec}, nil
+		}
+	}
+	var exec Executor
+	var err error
+	switch plan.Tp {
+	case Project, Aggregate, GroupBy:
+		return b.buildAggregateOrProject(plan, extra)
+	case Filter, HavingFilter, OrderBy, TopN, Limit:
+		return b.buildPassThrough(plan, extra)
+	case Table:
+		if len(plan.child) == 0 {
+			exec, err = newTableScanExec(plan, b.required[plan])
+			break
+		}
+		var child Executor
+		if child, err = b.build(&plan.child[0], nil); err == nil {
+			exec = &projectionExec{child: child, cols: []Expression{wildCard()}, schema: plan.Schema()}
+		}
+	case Join:
+		exec, err = b.buildJoin(plan)
+	case Union:
+		u := &unionExec{all: plan.Content.(UnionNode).All, schema: plan.Schema()}
+		for i := range plan.child {
+			child, err := b.build(&plan.child[i], nil)
+			if err != nil {
+				return nil, err
+			}
+			u.children = append(u.children, child)
+		}
+		exec = u
+	case EmptyRelation:
+		exec = &emptyExec{schema: plan.Schema()}
+	default:
+		return nil, fmt.Errorf("can not execute %v", plan.Tp)
+	}
+	if err != nil || len(extra) == 0 {
+		return exec, err
+	}
+	return &projectionExec{child: exec, cols: append([]Expression{wildCard()}, extra...),
+		schema: extendSchema(exec.Schema(), extra)}, nil
+}
+
+func wildCard() Expression {
+	return Expression{expr: []Datum{InitSetValue("*")}, Fields: make(map[string]ColumnName)}
+}
+
+// extendSchema return schema followed by the columns of extra computed on schema
+func extendSchema(schema Schema, extra []Expression) Schema {
+	return Schema{append(append([]SchemaColumn{}, schema.Columns...), projectSchema(extra, schema).Columns...)}
+}
+
+// missingColumns return the columns and the aggregates used by exprs which are not in schema,
+// the columns in the arguments of the aggregates are left to the Aggregate computing them
+func missingColumns(exprs []Expression, schema Schema) []Expression {
+	var ret []Expression
+	seen := make(map[string]bool)
+	add := func(expr Expression) {
+		if key := expr.print(); !seen[key] {
+			seen[key] = true
+			ret = append(ret, expr)
+		}
+	}
+	var walk func(expr Expression)
+	walk = func(expr Expression) {
+		for _, d := range expr.expr {
+			switch {
+			case d.Args != nil && IsAggregateFunction(d.GetString()):
+				f := Expression{expr: []Datum{d}, Fields: make(map[string]ColumnName)}
+				if schema.ResolveColumn(ColumnName{ColName: f.print()}) == -1 {
+					add(f)
+				}
+			case d.Args != nil:
+				for _, arg := range d.Args {
+					walk(arg)
+				}
+			case expr.IsColumnDatum(d):
+				if col := expr.Fields[d.GetString()]; schema.ResolveColumn(col) == -1 {
+					add(ColumnExpression(col))
+				}
+			}
+		}
+	}
+	for _, expr := range exprs {
+		if _, ok := orderPosition(expr); !ok {
+			walk(expr)
+		}
+	}
+	return ret
+}
+
+// buildPassThrough build the Filters, Sorts and Limits, whose rows are rows of their input
+func (b *executorBuilder) buildPassThrough(plan *LogicalPlan, extra []Expression) (Executor, error) {
+	input := plan.childSchema()
+	width := input.Len() + len(extra)
+	var own []Expression
+	if plan.Tp != Filter {
+		//WHERE only sees the columns of its input
+		own = missingColumns(plan.Expressions(), extendSchema(input, extra))
+	}
+	child, err := b.build(&plan.child[0], append(append([]Expression{}, extra...), own...))
+	if err != nil {
+		return nil, err
+	}
+	switch n := plan.Content.(type) {
+	case WhereFilterNode:
+		return &selectionExec{child: child, conditions: n.Expr, width: width}, nil
+	case HavingFilterNode:
+		return &selectionExec{child: child, conditions: n.Expr, width: width}, nil
+	case OrderByNode:
+		return &sortExec{child: child, items: n.Items, width: width}, nil
+	case TopNNode:
+		return newLimitExec(&sortExec{child: child, items: n.Items, width: width}, n.Count, n.Offset)
+	case LimitNode:
+		return newLimitExec(child, n.Count, n.Offset)
+	}
+	return nil, fmt.Errorf("can not execute %v", plan.Tp)
+}
+
+// buildAggregateOrProject : a Project computing aggregates aggregates its whole input as one group,
+// a GroupBy returns the first row of every group
+func (b *executorBuilder) buildAggregateOrProject(plan *LogicalPlan, extra []Expression) (Executor, error) {
+	var child Executor = &dualExec{}
+	if len(plan.child) > 0 {
+		var err error
+		if child, err = b.build(&plan.child[0], nil); err != nil {
+			return nil, err
+		}
+	}
+	//the extra columns are computed on the input, like the output columns
+	schema := Schema{append(append([]SchemaColumn{}, plan.Schema().Columns...),
+		projectSchema(extra, child.Schema()).Columns...)}
+	var cols, items []Expression
+	mode := CompleteAggregate
+	switch n := plan.Content.(type) {
+	case ProjectionNode:
+		cols = append(append([]Expression{}, n.cols...), extra...)
+		if !hasAggregate(cols) {
+			return &projectionExec{child: child, cols: cols, schema: schema}, nil
+		}
+	case AggregateNode:
+		cols, items, mode = append(append([]Expression{}, n.cols...), extra...), n.Items, n.Mode
+	case GroupByNode:
+		cols, items = append([]Expression{wildCard()}, extra...), n.Items
+	}
+	return &aggregateExec{child: child, cols: cols, items: items, mode: mode, schema: schema}, nil
+}
+
+// tableScanExec returns the rows of a MemTable, with the columns of the schema of the Table node
+type tableScanExec struct {
+	table   *MemTable
+	schema  Schema
+	offsets []int
+	pos     int
+}
+
+func newTableScanExec(plan *LogicalPlan, required []bool) (Executor, error) {
+	name := plan.Content.(TableNode).Table.OrigTblName
+	table, ok := LookupMemTable(name)
+	if !ok {
+		if ext, ok := LookupExternalTable(name); ok {
+			return newFileScanExec(plan, ext, required)
+		}
+		return nil, fmt.Errorf("table %v has no data", name)
+	}
+	e := &tableScanExec{table: table, schema: plan.Schema()}
+	for _, c := range e.schema.Columns {
+		offset := -1
+		for i, def := range table.Def.Columns {
+			if strings.EqualFold(def.Name, c.OrigColName) {
+				offset = i
+				break
+			}
+		}
+		if offset == -1 {
+			return nil, fmt.Errorf("unknown column %v in table %v", c.OrigColName, name)
+		}
+		e.offsets = append(e.offsets, offset)
+	}
+	return e, nil
+}
+
+func (e *tableScanExec) Open() error {
+	e.pos = 0
+	return nil
+}
+
+func (e *tableScanExec) Next() (Row, error) {
+	if e.pos >= len(e.table.Rows) {
+		return nil, nil
+	}
+	row := e.table.Rows[e.pos]
+	e.pos++
+	ret := make(Row, 0, len(e.offsets))
+	for _, i := range e.offsets {
+		ret = append(ret, row[i])
+	}
+	return ret, nil
+}
+
+func (e *tableScanExec) Close() error {
+	return nil
+}
+
+func (e *tableScanExec) Schema() Schema {
+	return e.schema
+}
+
+// projectionExec computes cols on every row of its input, `*` stands for all the values of the input row.
+// The columns are compiled at Open, exprs is nil for `*`
+type projectionExec struct {
+	child  Executor
+	cols   []Expression
+	schema Schema
+	exprs  []CompiledExpr
+}
+
+func (e *projectionExec) Open() error {
+	e.exprs = make([]CompiledExpr, len(e.cols))
+	for i, col := range e.cols {
+		if !IsWildCard(col) {
+			e.exprs[i] = executorExpression(col, e.child.Schema())
+		}
+	}
+	return e.child.Open()
+}
+
+func (e *projectionExec) Next() (Row, error) {
+	row, err := e.child.Next()
+	if row == nil || err != nil {
+		return nil, err
+	}
+	ret := make(Row, 0, e.schema.Len())
+	for _, expr := range e.exprs {
+		if expr == nil {
+			ret = append(ret, row...)
+			continue
+		}
+		v, err := expr(row)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+// projectRow compute cols with ev, whose row stands for the input of `*`
+func projectRow(cols []Expression, ev evaluator) (Row, error) {
+	ret := make(Row, 0, len(cols))
+	for _, col := range cols {
+		if IsWildCard(col) && ev.row == nil {
+			ret = append(ret, nullRow(ev.schema.Len())...)
+			continue
+		} else if IsWildCard(col) {
+			ret = append(ret, ev.row...)
+			continue
+		}
+		v, err := ev.eval(col)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func (e *projectionExec) Close() error {
+	return e.child.Close()
+}
+
+func (e *projectionExec) Schema() Schema {
+	return e.schema
+}
+
+// selectionExec returns the rows of its input where all the conditions are true, cut to width values.
+// The conditions are compiled at Open
+type selectionExec struct {
+	child      Executor
+	conditions []Expression
+	width      int
+	filter     func(row Row) (bool, error)
+}
+
+func (e *selectionExec) Open() error {
+	e.filter = CompileConditions(e.conditions, e.child.Schema())
+	return e.child.Open()
+}
+
+func (e *selectionExec) Next() (Row, error) {
+	for {
+		row, err := e.child.Next()
+		if row == nil || err != nil {
+			return nil, err
+		}
+		ok, err := e.filter(row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return row[:e.width], nil
+		}
+	}
+}
+
+func (e *selectionExec) Close() error {
+	return e.child.Close()
+}
+
+func (e *selectionExec) Schema() Schema {
+	return Schema{e.child.Schema().Columns[:e.width]}
+}
+
+// sortExec reads all the rows of its input and returns them in the order of items, cut to width values.
+// The sort is stable and NULL comes first like in MySQL, an item `ORDER BY 2` is the second column
+type sortExec struct {
+	child Executor
+	items []ByItem
+	width int
+	rows  []Row
+	pos   int
+}
+
+func (e *sortExec) Open() error {
+	if err := e.child.Open(); err != nil {
+		return err
+	}
+	e.rows, e.pos = nil, 0
+	var keys []Row
+	schema := e.child.Schema()
+	for {
+		row, err := e.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		key := make(Row, 0, len(e.items))
+		for _, item := range e.items {
+			if pos, ok := orderPosition(item.Item); ok {
+				if pos < 1 || pos > e.width {
+					return fmt.Errorf("unknown column %d in order clause", pos)
+				}
+				key = append(key, row[pos-1])
+				continue
+			}
+			v, err := EvalExpression(item.Item, schema, row)
+			if err != nil {
+				return err
+			}
+			key = append(key, v)
+		}
+		e.rows = append(e.rows, row[:e.width])
+		keys = append(keys, key)
+	}
+	index := make([]int, len(e.rows))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool {
+		for k, item := range e.items {
+			c := CompareForOrder(keys[index[i]][k], keys[index[j]][k])
+			if item.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	sorted := make([]Row, 0, len(e.rows))
+	for _, i := range index {
+		sorted = append(sorted, e.rows[i])
+	}
+	e.rows = sorted
+	return nil
+}
+
+func (e *sortExec) Next() (Row, error) {
+	if e.pos >= len(e.rows) {
+		return nil, nil
+	}
+	e.pos++
+	return e.rows[e.pos-1], nil
+}
+
+func (e *sortExec) Close() error {
+	e.rows = nil
+	return e.child.Close()
+}
+
+func (e *sortExec) Schema() Schema {
+	return Schema{e.child.Schema().Columns[:e.width]}
+}
+
+// limitExec skips offset rows of its input and returns at most count rows
+type limitExec struct {
+	child         Executor
+	count, offset uint64
+	pos           uint64
+}
+
+func newLimitExec(child Executor, count, offset Expression) (Executor, error) {
+	e := &limitExec{child: child}
+	var err error
+	if e.count, err = limitValue(count); err != nil {
+		return nil, err
+	}
+	if len(offset.expr) > 0 {
+		if e.offset, err = limitValue(offset); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func limitValue(expr Expression) (uint64, error) {
+	v, err := EvalExpression(expr, Schema{}, nil)
+	if err != nil {
+		return 0, err
+	}
+	switch v.Kind() {
+	case test_driver.KindInt64:
+		if v.GetInt64() >= 0 {
+			return uint64(v.GetInt64()), nil
+		}
+	case test_driver.KindUint64:
+		return v.GetUint64(), nil
+	}
+	return 0, fmt.Errorf("incorrect argument %v to LIMIT", FormatDatum(v))
+}
+
+func (e *limitExec) Open() error {
+	e.pos = 0
+	return e.child.Open()
+}
+
+func (e *limitExec) Next() (Row, error) {
+	for e.pos < e.offset {
+		row, err := e.child.Next()
+		if row == nil || err != nil {
+			return nil, err
+		}
+		e.pos++
+	}
+	if e.pos-e.offset >= e.count {
+		return nil, nil
+	}
+	row, err := e.child.Next()
+	if row == nil || err != nil {
+		return nil, err
+	}
+	e.pos++
+	return row, nil
+}
+
+func (e *limitExec) Close() error {
+	return e.child.Close()
+}
+
+func (e *limitExec) Schema() Schema {
+	return e.child.Schema()
+}
+
+// joinExec is a nested loop join, the rows of the right input are read once at Open.
+// The rows of an outer join without match are padded with NULLs
+type joinExec struct {
+	left, right Executor
+	tp          ast.JoinType
+	on          []Expression
+	schema      Schema
+	match       func(row Row) (bool, error)
+	inner       []Row
+	matched     []bool
+	outer       Row
+	found       bool
+	pos         int
+	done        bool
+}
+
+func (b *executorBuilder) buildJoin(plan *LogicalPlan) (Executor, error) {
+	if len(plan.child) != 2 {
+		return nil, fmt.Errorf("can not execute a Join of %d inputs", len(plan.child))
+	}
+	left, err := b.build(&plan.child[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	right, err := b.build(&plan.child[1], nil)
+	if err != nil {
+		return nil, err
+	}
+	n := plan.Content.(JoinNode)
+	return &joinExec{left: left, right: right, tp: n.Tp, on: n.On, schema: plan.Schema()}, nil
+}
+
+func (e *joinExec) Open() error {
+	if err := e.left.Open(); err != nil {
+		return err
+	}
+	if err := e.right.Open(); err != nil {
+		return err
+	}
+	e.inner, e.outer, e.pos, e.done = nil, nil, 0, false
+	e.match = CompileConditions(e.on, e.schema)
+	for {
+		row, err := e.right.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		e.inner = append(e.inner, row)
+	}
+	e.matched = make([]bool, len(e.inner))
+	return nil
+}
+
+func (e *joinExec) Next() (Row, error) {
+	for !e.done {
+		if e.outer == nil {
+			row, err := e.left.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				e.done, e.pos = true, 0
+				break
+			}
+			e.outer, e.found, e.pos = row, false, 0
+		}
+		for e.pos < len(e.inner) {
+			i := e.pos
+			e.pos++
+			row := append(append(make(Row, 0, e.schema.Len()), e.outer...), e.inner[i]...)
+			ok, err := e.match(row)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				e.found, e.matched[i] = true, true
+				return row, nil
+			}
+		}
+		outer := e.outer
+		e.outer = nil
+		if !e.found && (e.tp == ast.LeftJoin || e.tp == FullJoin) {
+			return append(append(make(Row, 0, e.schema.Len()), outer...), nullRow(e.right.Schema().Len())...), nil
+		}
+	}
+	//the rows of the right input without match
+	if e.tp == ast.RightJoin || e.tp == FullJoin {
+		for e.pos < len(e.inner) {
+			i := e.pos
+			e.pos++
+			if !e.matched[i] {
+				return append(nullRow(e.left.Schema().Len()), e.inner[i]...), nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func nullRow(n int) Row {
+	ret := make(Row, n)
+	for i := range ret {
+		ret[i] = NullDatum()
+	}
+	return ret
+}
+
+func (e *joinExec) Close() error {
+	e.inner, e.matched = nil, nil
+	err := e.left.Close()
+	if err2 := e.right.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (e *joinExec) Schema() Schema {
+	return e.schema
+}
+
+// unionExec returns the rows of its inputs one after the other, the duplicated rows are removed
+// unless all is set
+type unionExec struct {
+	children []Executor
+	all      bool
+	schema   Schema
+	cur      int
+	seen     map[string]bool
+}
+
+func (e *unionExec) Open() error {
+	for _, child := range e.children {
+		if err := child.Open(); err != nil {
+			return err
+		}
+	}
+	e.cur, e.seen = 0, make(map[string]bool)
+	return nil
+}
+
+func (e *unionExec) Next() (Row, error) {
+	for e.cur < len(e.children) {
+		row, err := e.children[e.cur].Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			e.cur++
+			continue
+		}
+		if !e.all {
+			key := RowKey(row)
+			if e.seen[key] {
+				continue
+			}
+			e.seen[key] = true
+		}
+		return row, nil
+	}
+	return nil, nil
+}
+
+func (e *unionExec) Close() error {
+	var err error
+	for _, child := range e.children {
+		if err2 := child.Close(); err == nil {
+			err = err2
+		}
+	}
+	e.seen = nil
+	return err
+}
+
+func (e *unionExec) Schema() Schema {
+	return e.schema
+}
+
+// emptyExec returns no row
+type emptyExec struct {
+	schema Schema
+}
+
+func (e *emptyExec) Open() error {
+	return nil
+}
+
+func (e *emptyExec) Next() (Row, error) {
+	return nil, nil
+}
+
+func (e *emptyExec) Close() error {
+	return nil
+}
+
+func (e *emptyExec) Schema() Schema {
+	return e.schema
+}
+
+// dualExec returns one row without columns, the input of a SELECT without FROM
+type dualExec struct {
+	done bool
+}
+
+func (e *dualExec) Open() error {
+	e.done = false
+	return nil
+}
+
+func (e *dualExec) Next() (Row, error) {
+	if e.done {
+		return nil, nil
+	}
+	e.done = true
+	return Row{}, nil
+}
+
+func (e *dualExec) Close() error {
+	return nil
+}
+
+func (e *dualExec) Schema() Schema {
+	return Schema{}
+}
+
+// aggregateExec groups the rows of its input by items and returns one row of cols per group, in the order
+// the groups are first seen. The columns outside of the aggregates are taken from the first row of the group.
+// Without group by items an empty input still makes one group
+type aggregateExec struct {
+	child  Executor
+	cols   []Expression
+	items  []Expression
+	mode   AggregateMode
+	schema Schema
+	rows   []Row
+	pos    int
+}
+
+type aggregateGroup struct {
+	first       Row
+	aggregators map[string]aggregator
+}
+
+func (e *aggregateExec) Open() error {
+	if err := e.child.Open(); err != nil {
+		return err
+	}
+	e.rows, e.pos = nil, 0
+	//the same aggregate may be used by several columns, it is computed once
+	var funcs []Datum
+	var keys []string
+	seen := make(map[string]bool)
+	for _, f := range aggregateFunctions(e.cols) {
+		key := Expression{expr: []Datum{f}}
+		if !seen[key.print()] {
+			seen[key.print()] = true
+			funcs, keys = append(funcs, f), append(keys, key.print())
+		}
+	}
+	input := e.child.Schema()
+	groups := make(map[string]*aggregateGroup)
+	var order []*aggregateGroup
+	newGroup := func(first Row) (*aggregateGroup, error) {
+		g := &aggregateGroup{first: first, aggregators: make(map[string]aggregator)}
+		for i, f := range funcs {
+			agg, err := newAggregator(f.GetString(), e.mode)
+			if err != nil {
+				return nil, err
+			}
+			g.aggregators[keys[i]] = agg
+		}
+		order = append(order, g)
+		return g, nil
+	}
+	for {
+		row, err := e.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		ev := evaluator{schema: input, row: row}
+		values := make(Row, 0, len(e.items))
+		for _, item := range e.items {
+			v, err := ev.eval(item)
+			if err != nil {
+				return err
+			}
+			values = append(values, v)
+		}
+		g, ok := groups[RowKey(values)]
+		if !ok {
+			if g, err = newGroup(row); err != nil {
+				return err
+			}
+			groups[RowKey(values)] = g
+		}
+		for i, f := range funcs {
+			args := make([]Datum, 0, len(f.Args))
+			for _, arg := range f.Args {
+				v, err := ev.eval(arg)
+				if err != nil {
+					return err
+				}
+				args = append(args, v)
+			}
+			if err := g.aggregators[keys[i]].update(args); err != nil {
+				return err
+			}
+		}
+	}
+	if len(order) == 0 && len(e.items) == 0 {
+		if _, err := newGroup(nil); err != nil {
+			return err
+		}
+	}
+	for _, g := range order {
+		g := g
+		ev := evaluator{schema: input, row: g.first, aggregate: func(f Datum) (Datum, error) {
+			key := Expression{expr: []Datum{f}}
+			return g.aggregators[key.print()].result()
+		}}
+		row, err := projectRow(e.cols, ev)
+		if err != nil {
+			return err
+		}
+		e.rows = append(e.rows, row)
+	}
+	return nil
+}
+
+func (e *aggregateExec) Next() (Row, error) {
+	if e.pos >= len(e.rows) {
+		return nil, nil
+	}
+	e.pos++
+	return e.rows[e.pos-1], nil
+}
+
+func (e *aggregateExec) Close() error {
+	e.rows = nil
+	return e.child.Close()
+}
+
+func (e *aggregateExec) Schema() Schema {
+	return e.schema
+}
+
+// aggregator accumulates the values of the arguments of an aggregate function over the rows of a group
+type aggregator interface {
+	update(args []Datum) error
+	result() (Datum, error)
+}
+
+// newAggregator : the FinalAggregate merges the partial results, see SplitAggregates
+func newAggregator(name string, mode AggregateMode) (aggregator, error) {
+	if f, ok := SplitDistinctAggregate(strings.ToLower(name)); ok {
+		agg, err := newAggregator(f, mode)
+		if err != nil {
+			return nil, err
+		}
+		return &distinctAggregator{agg: agg, seen: make(map[string]bool), separated: f == "group_concat"}, nil
+	}
+	switch name = strings.ToLower(name); name {
+	case "count":
+		if mode == FinalAggregate {
+			return &sumAggregator{count: true}, nil
+		}
+		return &countAggregator{}, nil
+	case "sum":
+		return &sumAggregator{}, nil
+	case "avg":
+		return &sumAggregator{avg: true, merge: mode == FinalAggregate}, nil
+	case "max", "min":
+		return &extremeAggregator{max: name == "max"}, nil
+	case "bit_and", "bit_or", "bit_xor":
+		agg := &bitAggregator{op: name}
+		if name == "bit_and" {
+			agg.value = math.MaxUint64
+		}
+		return agg, nil
+	case "group_concat":
+		return &concatAggregator{}, nil
+	case "std", "stddev", "stddev_pop", "stddev_samp", "variance", "var_pop", "var_samp":
+		return &varianceAggregator{sample: strings.HasSuffix(name, "samp"), sqrt: strings.HasPrefix(name, "std")}, nil
+	}
+	return nil, fmt.Errorf("unsupported aggregate function %v", name)
+}
+
+// distinctAggregator passes the values of the arguments to agg the first time they are seen, for COUNT(DISTINCT a).
+// separated is set when the last argument is the separator of GROUP_CONCAT, which is not part of the values
+type distinctAggregator struct {
+	agg       aggregator
+	seen      map[string]bool
+	separated bool
+}
+
+func (a *distinctAggregator) update(args []Datum) error {
+	values := args
+	if a.separated && len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+	key := RowKey(values)
+	if a.seen[key] {
+		return nil
+	}
+	a.seen[key] = true
+	return a.agg.update(args)
+}
+
+func (a *distinctAggregator) result() (Datum, error) {
+	return a.agg.result()
+}
+
+// countAggregator counts the rows where no argument is NULL
+type countAggregator struct {
+	count int64
+}
+
+func (a *countAggregator) update(args []Datum) error {
+	if !HasNull(args) {
+		a.count++
+	}
+	return nil
+}
+
+func (a *countAggregator) result() (Datum, error) {
+	return InitSetValue(a.count), nil
+}
+
+// sumAggregator sums its argument exactly, unless it is a float. count adds up partial counts to a BIGINT,
+// avg divides the sum by the number of values, or by the sum of its second argument when it merges partial results.
+// The values added by addInt64 and addFloat64 are counted in pending, and summed in isum until they overflow,
+// before being added to sum and n
+type sumAggregator struct {
+	count, avg, merge bool
+	valid, float      bool
+	sum               big.Rat
+	fsum              float64
+	n                 big.Rat
+	scale             int
+	isum, pending     int64
+}
+
+func (a *sumAggregator) update(args []Datum) error {
+	if len(args) == 0 || HasNull(args) {
+		return nil
+	}
+	a.flush()
+	//strings are summed as DOUBLE, like in the arithmetic
+	v := NumericValue(args[0])
+	if !IsNumericKind(v.Kind()) {
+		return fmt.Errorf("can not sum %v", FormatDatum(v))
+	}
+	a.valid = true
+	if isFloatKind(v.Kind()) || a.float {
+		if !a.float {
+			a.fsum, _ = a.sum.Float64()
+			a.float = true
+		}
+		f, _ := DatumFloat(v)
+		a.fsum += f
+	} else {
+		r, _ := DatumRat(v)
+		a.sum.Add(&a.sum, r)
+		a.scale = maxInt(a.scale, DecimalScale(v))
+	}
+	switch {
+	case a.merge && len(args) > 1:
+		r, ok := DatumRat(args[1])
+		if !ok {
+			return fmt.Errorf("can not sum %v", FormatDatum(args[1]))
+		}
+		a.n.Add(&a.n, r)
+	default:
+		a.n.Add(&a.n, big.NewRat(1, 1))
+	}
+	return nil
+}
+
+// addInt64 add a non-NULL BIGINT without converting it to a Datum, for the vectorized aggregation
+func (a *sumAggregator) addInt64(v int64) {
+	a.valid = true
+	if s := a.isum + v; a.float {
+		a.fsum += float64(v)
+	} else if (s > a.isum) == (v > 0) {
+		a.isum = s
+	} else {
+		a.flush()
+		a.isum = v
+	}
+	a.pending++
+}
+
+// addFloat64 add a non-NULL DOUBLE without converting it to a Datum, for the vectorized aggregation
+func (a *sumAggregator) addFloat64(f float64) {
+	if !a.float {
+		a.flush()
+		a.fsum, _ = a.sum.Float64()
+		a.float = true
+	}
+	a.valid = true
+	a.fsum += f
+	a.pending++
+}
+
+func (a *sumAggregator) flush() {
+	if a.pending == 0 {
+		return
+	}
+	a.sum.Add(&a.sum, new(big.Rat).SetInt64(a.isum))
+	a.n.Add(&a.n, new(big.Rat).SetInt64(a.pending))
+	a.isum, a.pending = 0, 0
+}
+
+func (a *sumAggregator) result() (Datum, error) {
+	a.flush()
+	switch {
+	case a.count:
+		if !a.valid {
+			return InitSetValue(int64(0)), nil
+		}
+		if a.float || !a.sum.IsInt() || !a.sum.Num().IsInt64() {
+			return Datum{}, fmt.Errorf("BIGINT value is out of range in count")
+		}
+		return InitSetValue(a.sum.Num().Int64()), nil
+	case !a.valid:
+		return NullDatum(), nil
+	case a.avg && a.float:
+		n, _ := a.n.Float64()
+		return InitSetValue(a.fsum / n), nil
+	case a.avg:
+		if a.n.Sign() == 0 {
+			return NullDatum(), nil
+		}
+		//the scale grows by div_precision_increment
+		return DecimalDatum(new(big.Rat).Quo(&a.sum, &a.n), a.scale+4), nil
+	case a.float:
+		return InitSetValue(a.fsum), nil
+	}
+	return DecimalDatum(&a.sum, a.scale), nil
+}
+
+// extremeAggregator keeps the greatest or the smallest non-NULL value
+type extremeAggregator struct {
+	max   bool
+	valid bool
+	value Datum
+}
+
+func (a *extremeAggregator) update(args []Datum) error {
+	if len(args) == 0 || HasNull(args) {
+		return nil
+	}
+	c := CompareForOrder(args[0], a.value)
+	if !a.valid || (a.max && c > 0) || (!a.max && c < 0) {
+		a.valid, a.value = true, args[0]
+	}
+	return nil
+}
+
+// updateInt64 take a non-NULL BIGINT without converting it to a Datum unless it is kept
+func (a *extremeAggregator) updateInt64(v int64) {
+	switch {
+	case a.valid && a.value.Kind() != test_driver.KindInt64:
+		a.update([]Datum{InitSetValue(v)})
+	case !a.valid || (a.max && v > a.value.GetInt64()) || (!a.max && v < a.value.GetInt64()):
+		a.valid, a.value = true, InitSetValue(v)
+	}
+}
+
+func (a *extremeAggregator) result() (Datum, error) {
+	if !a.valid {
+		return NullDatum(), nil
+	}
+	return a.value, nil
+}
+
+// bitAggregator combines the integer values with a bit operator, the result is an unsigned BIGINT
+type bitAggregator struct {
+	op    string
+	value uint64
+}
+
+func (a *bitAggregator) update(args []Datum) error {
+	if len(args) == 0 || HasNull(args) {
+		return nil
+	}
+	if !isIntKind(args[0].Kind()) {
+		return fmt.Errorf("can not evaluate %v on %v", a.op, FormatDatum(args[0]))
+	}
+	v := args[0].GetUint64()
+	switch a.op {
+	case "bit_and":
+		a.value &= v
+	case "bit_or":
+		a.value |= v
+	default:
+		a.value ^= v
+	}
+	return nil
+}
+
+func (a *bitAggregator) result() (Datum, error) {
+	return InitSetValue(a.value), nil
+}
+
+// concatAggregator concatenates the values of the arguments of each row where none is NULL, and joins the rows with
+// the separator. The parser passes the separator as the last argument, a comma unless SEPARATOR is given
+type concatAggregator struct {
+	values    []string
+	separator string
+}
+
+func (a *concatAggregator) update(args []Datum) error {
+	if len(args) == 0 {
+		return nil
+	}
+	values := args[:len(args)-1]
+	if HasNull(values) {
+		return nil
+	}
+	a.separator = FormatDatum(args[len(args)-1])
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(FormatDatum(v))
+	}
+	a.values = append(a.values, b.String())
+	return nil
+}
+
+func (a *concatAggregator) result() (Datum, error) {
+	if len(a.values) == 0 {
+		return NullDatum(), nil
+	}
+	return InitSetValue(strings.Join(a.values, a.separator)), nil
+}
+
+// varianceAggregator computes the population or sample variance with Welford's algorithm,
+// the standard deviation if sqrt is set
+type varianceAggregator struct {
+	sample, sqrt bool
+	n            float64
+	mean, m2     float64
+}
+
+func (a *varianceAggregator) update(args []Datum) error {
+	if len(args) == 0 || HasNull(args) {
+		return nil
+	}
+	v, ok := DatumFloat(NumericValue(args[0]))
+	if !ok {
+		return fmt.Errorf("can not evaluate variance on %v", FormatDatum(args[0]))
+	}
+	a.n++
+	delta := v - a.mean
+	a.mean += delta / a.n
+	a.m2 += delta * (v - a.mean)
+	return nil
+}
+
+func (a *varianceAggregator) result() (Datum, error) {
+	n := a.n
+	if a.sample {
+		n--
+	}
+	if n <= 0 {
+		return NullDatum(), nil
+	}
+	ret := a.m2 / n
+	if a.sqrt {
+		ret = math.Sqrt(ret)
+	}
+	return InitSetValue(ret), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSelectDistinct(t *testing.T) {
+	checkResult(t, "select distinct a from testdata2", "1", "2", "3", "4", "5", "NULL")
+	checkResult(t, "select distinct a from testdata2 order by a desc limit 3", "5", "4", "3")
+	checkResult(t, "select x.a from (select distinct a from testdata2) x where x.a > 2", "3", "4", "5")
+	checkResult(t, "select distinct count(*) from testdata2 group by a", "1", "2", "3")
+}
+
+func TestDistinctAggregates(t *testing.T) {
+	checkResult(t, "select count(distinct a), count(a), sum(distinct a), avg(distinct a) from testdata2", "5, 10, 15, 3.0000")
+	checkResult(t, "select count(distinct a, b) from testdata2", "8")
+
+	_, plan := optimizeQuery(t, "select count(distinct a) from testdata2")
+	schema, _, err := Execute(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := schema.Columns[0].ColName; name != "count(distinct a)" {
+		t.Errorf("count(distinct a) is named %v", name)
+	}
+}
+
+func TestExecuteJoins(t *testing.T) {
+	checkResult(t, "select t.a, s.c from t join s on t.a = s.a", "2, two", "4, four", "4, quatre", "7, seven")
+	checkResult(t, "select t.a, s.c from t left join s on t.a = s.a and s.c <> 'four' where t.a between 3 and 5",
+		"3, NULL", "4, quatre", "5, NULL")
+	checkResult(t, "select s.a, t.b from t right join s on t.a = s.a where t.b is null", "NULL, NULL", "13, NULL")
+	//NULL never equals NULL
+	checkResult(t, "select count(*) from testdata2 x join testdata2 y on x.b = y.b", "34")
+	checkResult(t, "select count(*) from t, s", "72")
+}
+
+func TestExecuteAggregates(t *testing.T) {
+	checkResult(t, "select a, count(*), count(b), sum(b), min(b), max(b) from testdata2 group by a",
+		"1, 2, 2, 3, 1, 2", "2, 2, 2, 3, 1, 2", "3, 3, 3, 5, 1, 2", "4, 2, 2, 5, 0, 5", "5, 1, 0, NULL, NULL, NULL",
+		"NULL, 1, 1, 1, 1, 1")
+	checkResult(t, "select count(*), sum(a), max(b) from t where a > 100", "0, NULL, NULL")
+	checkResult(t, "select a from testdata2 group by a having sum(b) > 3", "3", "4")
+}
+
+func TestExecuteOrderAndLimit(t *testing.T) {
+	checkOrdered(t, "select a, b from testdata2 order by b desc, a limit 4", "4, 5", "1, 2", "2, 2", "3, 2")
+	checkOrdered(t, "select c from s order by a", "none", "two", "four", "quatre", "seven", "thirteen")
+	checkOrdered(t, "select a from t order by a limit 3, 2", "4", "5")
+	checkResult(t, "select a from t where a < 3 union select a from s where a < 5", "1", "2", "4")
+	checkResult(t, "select a from t where a < 3 union all select a from s where a < 5", "1", "2", "2", "4", "4")
+}
+
+func TestGroupConcat(t *testing.T) {
+	checkResult(t, "select a, group_concat(b) from testdata2 where a < 3 group by a", "1, 1,2", "2, 1,2")
+	//the arguments of a row are concatenated, the rows are joined with the separator
+	checkResult(t, "select group_concat(a, b separator '; ') from testdata2 where a = 1", "11; 12")
+	checkResult(t, "select group_concat(b separator '') from testdata2 where a = 3", "122")
+	//rows with a NULL argument are skipped
+	checkResult(t, "select group_concat(a, b) from testdata2 where a >= 4", "40,45")
+	//the separator is not a value of DISTINCT
+	checkResult(t, "select group_concat(distinct b separator '-') from testdata2 where a = 3", "1-2")
+	checkResult(t, "select count(distinct b), group_concat(distinct a, b) from testdata2 where a = 3", "2, 31,32")
+	for _, vectorize := range []bool{false, true} {
+		_, plan := optimizeQuery(t, "select group_concat(distinct a separator ';') from testdata2")
+		execute := Execute
+		if vectorize {
+			execute = ExecuteVectorized
+		}
+		_, rows, err := execute(plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatRow(rows[0]); got != "1;2;3;4;5" {
+			t.Errorf("group_concat(distinct a) is %v, vectorized %v", got, vectorize)
+		}
+	}
+}
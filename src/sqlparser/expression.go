@@ -0,0 +1,184 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// CopyExpression return a deep copy of expr, rules may modify the copy freely
+func CopyExpression(expr Expression) Expression {
+	ret := Expression{AsName: expr.AsName, AsTblName: expr.AsTblName}
+	if expr.expr != nil {
+		ret.expr = make([]Datum, 0, len(expr.expr))
+	}
+	for _, d := range expr.expr {
+		nd := Datum{d.Datum, nil}
+		if d.Args != nil {
+			nd.Args = make([]Expression, 0, len(d.Args))
+			for _, arg := range d.Args {
+				nd.Args = append(nd.Args, CopyExpression(arg))
+			}
+		}
+		ret.expr = append(ret.expr, nd)
+	}
+	if expr.Fields != nil {
+		ret.Fields = make(map[string]ColumnName, len(expr.Fields))
+	}
+	for k, v := range expr.Fields {
+		ret.Fields[k] = v
+	}
+	return ret
+}
+
+// ColumnExpression build the expression referencing col
+func ColumnExpression(col ColumnName) Expression {
+	name := col.ColName
+	if col.TblName != "" {
+		name = col.TblName + "." + col.ColName
+	}
+	return Expression{
+		expr:   []Datum{InitSetValue(name)},
+		Fields: map[string]ColumnName{name: col},
+	}
+}
+
+// IsColumnDatum check whether d is a column reference of expr
+func (expr *Expression) IsColumnDatum(d Datum) bool {
+	if d.Args != nil || d.Kind() != test_driver.KindString {
+		return false
+	}
+	_, ok := expr.Fields[d.GetString()]
+	return ok
+}
+
+// UnaryDatum build the datum of a unary operator, which is kept as a function of one argument
+func UnaryDatum(op MyOp, arg Expression) Datum {
+	ret := InitSetValue(Ops[op].Name)
+	ret.Args = []Expression{arg}
+	return ret
+}
+
+// NotDatum wrap the datum d, which must be a function, into not(d)
+func NotDatum(d Datum) Datum {
+	return UnaryDatum(Not, Expression{
+		expr:   []Datum{d},
+		Fields: make(map[string]ColumnName),
+	})
+}
+
+// SubstituteExpression replace every column of expr by the expression returned by f.
+// Columns of function arguments are replaced too, false is returned if f fails on any column
+func SubstituteExpression(expr Expression, f func(ColumnName) (Expression, bool)) (Expression, bool) {
+	ret := Expression{
+		Fields:    make(map[string]ColumnName),
+		AsName:    expr.AsName,
+		AsTblName: expr.AsTblName,
+	}
+	for _, d := range expr.expr {
+		if d.Args != nil {
+			nd := Datum{d.Datum, make([]Expression, 0, len(d.Args))}
+			for _, arg := range d.Args {
+				newArg, ok := SubstituteExpression(arg, f)
+				if !ok {
+					return expr, false
+				}
+				nd.Args = append(nd.Args, newArg)
+			}
+			ret.expr = append(ret.expr, nd)
+			continue
+		}
+		if expr.IsColumnDatum(d) {
+			def, ok := f(expr.Fields[d.GetString()])
+			if !ok {
+				return expr, false
+			}
+			//the postfix of def takes the place of the column
+			ret.expr = append(ret.expr, CopyExpression(def).expr...)
+			for k, v := range def.Fields {
+				ret.Fields[k] = v
+			}
+			continue
+		}
+		ret.expr = append(ret.expr, d)
+	}
+	return ret, true
+}
+
+// OutputDefinitions return the defining expression of every output column of a Project or Aggregate node,
+// in terms of the input of the node. `*` is expanded to the columns of the child
+func (plan *LogicalPlan) OutputDefinitions() []Expression {
+	var cols []Expression
+	switch n := plan.Content.(type) {
+	case ProjectionNode:
+		cols = n.cols
+	case AggregateNode:
+		cols = n.cols
+	default:
+		return nil
+	}
+	var ret []Expression
+	for _, expr := range cols {
+		if IsWildCard(expr) {
+			for _, c := range plan.childSchema().Columns {
+				ret = append(ret, ColumnExpression(ColumnName{
+					OrigTblName: c.OrigTblName,
+					OrigColName: c.OrigColName,
+					TblName:     c.TblName,
+					ColName:     c.ColName,
+				}))
+			}
+			continue
+		}
+		def := CopyExpression(expr)
+		def.AsName, def.AsTblName = "", ""
+		ret = append(ret, def)
+	}
+	return ret
+}
+
+// MapThroughProject rewrite expr, which is right above the Project or Aggregate proj, in terms of the input of proj.
+// The output columns are replaced by their definitions, which must be deterministic and free of aggregates,
+// and the other columns must come from the input of proj, like the ORDER BY items of MySQL
+func MapThroughProject(expr Expression, proj *LogicalPlan) (Expression, bool) {
+	schema := proj.Schema()
+	defs := proj.OutputDefinitions()
+	input := proj.childSchema()
+	if len(defs) != schema.Len() {
+		return expr, false
+	}
+	return SubstituteExpression(expr, func(col ColumnName) (Expression, bool) {
+		if i := schema.ResolveColumn(col); i != -1 {
+			if !CheckExprDeterministic(defs[i]) || AggregatorInExpression(defs[i]) {
+				return Expression{}, false
+			}
+			return defs[i], true
+		}
+		if input.ResolveColumn(col) != -1 {
+			return ColumnExpression(col), true
+		}
+		return Expression{}, false
+	})
+}
+
+// MapThroughDerivedTable rewrite expr, which is above the derived table tbl, in terms of the input of
+// the Project or Aggregate directly under tbl. The mapping is refused if it goes through
+// a non-deterministic expression, or through an aggregate when allowAggregate is false
+func MapThroughDerivedTable(expr Expression, tbl *LogicalPlan, allowAggregate bool) (Expression, bool) {
+	if !tbl.IsDerivedTable() {
+		return expr, false
+	}
+	schema := tbl.Schema()
+	defs := tbl.child[0].OutputDefinitions()
+	if len(defs) != schema.Len() {
+		return expr, false
+	}
+	return SubstituteExpression(expr, func(col ColumnName) (Expression, bool) {
+		i := schema.ResolveColumn(col)
+		if i == -1 {
+			return Expression{}, false
+		}
+		if !CheckExprDeterministic(defs[i]) || (!allowAggregate && AggregatorInExpression(defs[i])) {
+			return Expression{}, false
+		}
+		return defs[i], true
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// ExprTree is the tree form of the postfix Expression, used by the rewrites which need to look at operands.
+//
+//	binary operators have Op != -1 and two Children,
+//	functions and operators kept as functions (not, in, isnull...) have Func = true and their arguments as Children,
+//	columns have Column != nil, everything else is a literal held in Value
+type ExprTree struct {
+	Value    Datum
+	Op       MyOp
+	Func     bool
+	Column   *ColumnName
+	Children []*ExprTree
+}
+
+// Tree build the tree form of expr, nil is returned for an empty expression.
+// It panics if expr is not a single well formed postfix expression
+func (expr *Expression) Tree() *ExprTree {
+	var s []*ExprTree
+	for _, d := range expr.expr {
+		switch {
+		case d.Args != nil:
+			node := &ExprTree{Value: Datum{d.Datum, nil}, Op: -1, Func: true}
+			for i := range d.Args {
+				node.Children = append(node.Children, d.Args[i].Tree())
+			}
+			s = append(s, node)
+		case expr.IsColumnDatum(d):
+			col := expr.Fields[d.GetString()]
+			s = append(s, &ExprTree{Value: d, Op: -1, Column: &col})
+		case d.Kind() == test_driver.KindString && StrToOp(d.GetString()) != -1 && len(s) >= 2:
+			node := &ExprTree{Value: d, Op: StrToOp(d.GetString()), Children: []*ExprTree{s[len(s)-2], s[len(s)-1]}}
+			s = s[:len(s)-2]
+			s = append(s, node)
+		default:
+			s = append(s, &ExprTree{Value: d, Op: -1})
+		}
+	}
+	switch len(s) {
+	case 0:
+		return nil
+	case 1:
+		return s[0]
+	default:
+		panic(fmt.Sprintf("Error Expression: %d operands left in %v", len(s), expr.expr))
+	}
+}
+
+// Expression flatten the tree back to the postfix form
+func (t *ExprTree) Expression() Expression {
+	ret := Expression{Fields: make(map[string]ColumnName)}
+	if t != nil {
+		t.flatten(&ret)
+	}
+	return ret
+}
+
+func (t *ExprTree) flatten(expr *Expression) {
+	switch {
+	case t.Func:
+		d := Datum{t.Value.Datum, make([]Expression, 0, len(t.Children))}
+		for _, child := range t.Children {
+			d.Args = append(d.Args, child.Expression())
+		}
+		expr.expr = append(expr.expr, d)
+	case t.Column != nil:
+		expr.expr = append(expr.expr, t.Value)
+		expr.Fields[t.Value.GetString()] = *t.Column
+	case t.Op != -1:
+		for _, child := range t.Children {
+			child.flatten(expr)
+		}
+		expr.expr = append(expr.expr, t.Value)
+	default:
+		expr.expr = append(expr.expr, t.Value)
+	}
+}
+
+// FuncName return the name of the function, "" if t is not a function
+func (t *ExprTree) FuncName() string {
+	if !t.Func {
+		return ""
+	}
+	return t.Value.GetString()
+}
+
+// FuncOp return the operator of a function-style operator like not or in, -1 otherwise
+func (t *ExprTree) FuncOp() MyOp {
+	if !t.Func {
+		return -1
+	}
+	return StrToOp(t.Value.GetString())
+}
+
+// IsConstant check whether t is a literal
+func (t *ExprTree) IsConstant() bool {
+	return !t.Func && t.Column == nil && t.Op == -1
+}
+
+func NewBinaryTree(op MyOp, l, r *ExprTree) *ExprTree {
+	return &ExprTree{Value: InitSetValue(Ops[op].Name), Op: op, Children: []*ExprTree{l, r}}
+}
+
+func NewFuncTree(name string, args ...*ExprTree) *ExprTree {
+	return &ExprTree{Value: InitSetValue(name), Op: -1, Func: true, Children: args}
+}
+
+func NewConstTree(d Datum) *ExprTree {
+	return &ExprTree{Value: Datum{d.Datum, nil}, Op: -1}
+}
+
+func NewColumnTree(col ColumnName) *ExprTree {
+	expr := ColumnExpression(col)
+	return expr.Tree()
+}
+
+// Walk call f on every node of the tree in pre-order, the children are skipped if f return false
+func (t *ExprTree) Walk(f func(*ExprTree) bool) {
+	if t == nil || !f(t) {
+		return
+	}
+	for _, child := range t.Children {
+		child.Walk(f)
+	}
+}
+
+// Columns return the columns referenced in the tree
+func (t *ExprTree) Columns() []ColumnName {
+	var ret []ColumnName
+	t.Walk(func(node *ExprTree) bool {
+		if node.Column != nil {
+			ret = append(ret, *node.Column)
+		}
+		return true
+	})
+	return RemoveRepeatedElement(ret)
+}
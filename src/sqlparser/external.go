@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The formats of the files of the external tables
+const (
+	FormatCSV   = "csv"
+	FormatTSV   = "tsv"
+	FormatJSONL = "jsonl" //one JSON object per line, the keys are the column names
+)
+
+// DefaultNullMarker is the text of a NULL value in a CSV or a TSV file, like LOAD DATA writes it.
+// An empty field is NULL too, unless the column is a string
+const DefaultNullMarker = `\N`
+
+// InferRows is the number of records read to infer the columns of an external table declared without them
+var InferRows = 100
+
+// ExternalTable binds a base table to a local file, which is read by every scan of the table.
+// The columns of a CSV or a TSV file are found by the names of the header if Header is set, by position otherwise
+type ExternalTable struct {
+	Def    TableDef
+	Path   string
+	Format string
+	Header bool
+	Null   string
+}
+
+// ExternalTables maps the lower case table name to its file, a MemTable of the same name is scanned instead
+var ExternalTables = make(map[string]*ExternalTable)
+
+// RegisterExternalTable register the file of the table and its definition in the Catalog,
+// the columns are inferred from the file if t.Def has none
+func RegisterExternalTable(t ExternalTable) error {
+	t.Format = strings.ToLower(t.Format)
+	if t.Format == "" {
+		t.Format = formatOfPath(t.Path)
+	}
+	if t.Format != FormatCSV && t.Format != FormatTSV && t.Format != FormatJSONL {
+		return fmt.Errorf("table %v: unknown format %q", t.Def.Name, t.Format)
+	}
+	if t.Null == "" {
+		t.Null = DefaultNullMarker
+	}
+	if len(t.Def.Columns) == 0 {
+		columns, err := t.inferColumns()
+		if err != nil {
+			return fmt.Errorf("table %v: %v", t.Def.Name, err)
+		}
+		t.Def.Columns = columns
+	}
+	RegisterTable(t.Def)
+	ExternalTables[strings.ToLower(t.Def.Name)] = &t
+	return nil
+}
+
+func LookupExternalTable(name string) (*ExternalTable, bool) {
+	t, ok := ExternalTables[strings.ToLower(name)]
+	return t, ok
+}
+
+func formatOfPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tsv", ".tab":
+		return FormatTSV
+	case ".jsonl", ".ndjson", ".json":
+		return FormatJSONL
+	}
+	return FormatCSV
+}
+
+// locationClause is the LOCATION 'file' clause of CREATE TABLE, which the parser does not know:
+// it is rewritten to the CONNECTION table option before parsing
+var locationClause = regexp.MustCompile(`(?i)\bLOCATION\s*(=\s*)?('(?:[^'\\]|\\.|'')*')`)
+
+// CreateExternalTables run the CREATE TABLE statements of sql, like
+//
+//	CREATE TABLE t (a INT NOT NULL, b VARCHAR(10)) ENGINE=CSV LOCATION 'data/t.csv'
+//
+// ENGINE is CSV, TSV or JSON, the default is given by the extension of the file. The file may also be given
+// by CONNECTION='data/t.csv'. A relative path is relative to dir. The first line of a CSV or a TSV file is
+// a header if it holds the names of the columns
+func CreateExternalTables(sql string, dir string) error {
+	p := parser.New()
+	stmts, _, err := p.Parse(locationClause.ReplaceAllString(sql, "CONNECTION = $2"), "", "")
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		create, ok := stmt.(*ast.CreateTableStmt)
+		if !ok {
+			return fmt.Errorf("not a CREATE TABLE statement: %v", stmt.Text())
+		}
+		t := ExternalTable{Def: TableDef{Name: create.Table.Name.O}}
+		for _, c := range create.Cols {
+			def, err := columnDefOf(c)
+			if err != nil {
+				return fmt.Errorf("table %v: %v", t.Def.Name, err)
+			}
+			t.Def.Columns = append(t.Def.Columns, def)
+		}
+		for _, opt := range create.Options {
+			switch opt.Tp {
+			case ast.TableOptionEngine:
+				t.Format = strings.ToLower(opt.StrValue)
+				if t.Format == "json" {
+					t.Format = FormatJSONL
+				}
+			case ast.TableOptionConnection:
+				t.Path = opt.StrValue
+			}
+		}
+		if t.Path == "" {
+			return fmt.Errorf("table %v has no LOCATION", t.Def.Name)
+		}
+		if !filepath.IsAbs(t.Path) {
+			t.Path = filepath.Join(dir, t.Path)
+		}
+		if t.Format == "" {
+			t.Format = formatOfPath(t.Path)
+		}
+		if t.Format != FormatJSONL {
+			if t.Header, err = t.hasHeader(); err != nil {
+				return fmt.Errorf("table %v: %v", t.Def.Name, err)
+			}
+		}
+		if err := RegisterExternalTable(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasHeader check whether the first line of the file names all the columns of the table
+func (t *ExternalTable) hasHeader() (bool, error) {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	r := t.newCSVReader(f)
+	first, err := r.Read()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	names := make(map[string]bool)
+	for _, name := range first {
+		names[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	for _, c := range t.Def.Columns {
+		if !names[strings.ToLower(c.Name)] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (t *ExternalTable) newCSVReader(f io.Reader) *csv.Reader {
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	if t.Format == FormatTSV {
+		r.Comma, r.LazyQuotes = '\t', true
+	}
+	return r
+}
+
+// columnDefOf map the SQL type of c to a datum kind: the integers to int64, or uint64 if UNSIGNED,
+// FLOAT and DOUBLE to float64, DECIMAL to decimal, and the other types to string
+func columnDefOf(c *ast.ColumnDef) (ColumnDef, error) {
+	def := ColumnDef{Name: c.Name.Name.O, Tp: test_driver.KindString}
+	switch c.Tp.GetType() {
+	case mysql.TypeTiny, mysql.TypeShort, mysql.TypeInt24, mysql.TypeLong, mysql.TypeLonglong, mysql.TypeYear:
+		def.Tp = test_driver.KindInt64
+		if mysql.HasUnsignedFlag(c.Tp.GetFlag()) {
+			def.Tp = test_driver.KindUint64
+		}
+	case mysql.TypeFloat, mysql.TypeDouble:
+		def.Tp = test_driver.KindFloat64
+	case mysql.TypeNewDecimal:
+		def.Tp = test_driver.KindMysqlDecimal
+	case mysql.TypeBit, mysql.TypeJSON, mysql.TypeGeometry:
+		return def, fmt.Errorf("column %v: unsupported type %v", def.Name, c.Tp)
+	}
+	for _, opt := range c.Options {
+		if opt.Tp == ast.ColumnOptionNotNull || opt.Tp == ast.ColumnOptionPrimaryKey {
+			def.NotNull = true
+		}
+	}
+	return def, nil
+}
+
+type externalTableJSON struct {
+	Name     string          `json:"name"`
+	Location string          `json:"location"`
+	Format   string          `json:"format,omitempty"`
+	Header   bool            `json:"header,omitempty"`
+	Null     string          `json:"null,omitempty"`
+	Columns  []memColumnJSON `json:"columns,omitempty"`
+	Indexes  []IndexDef      `json:"indexes,omitempty"`
+}
+
+type externalTablesFileJSON struct {
+	Tables []externalTableJSON `json:"tables"`
+}
+
+// LoadExternalTables register the tables of the catalog config data, like
+//
+//	{"tables": [{"name": "t", "location": "t.csv", "header": true, "columns": [{"name": "a", "type": "int64"}]}]}
+//
+// The columns are inferred from the file when they are omitted. A relative location is relative to dir
+func LoadExternalTables(data []byte, dir string) error {
+	var file externalTablesFileJSON
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	for _, t := range file.Tables {
+		ext := ExternalTable{Def: TableDef{Name: t.Name, Indexes: t.Indexes}, Path: t.Location, Format: t.Format,
+			Header: t.Header, Null: t.Null}
+		for _, c := range t.Columns {
+			var tp byte
+			if err := decodeKind(c.Type, &tp); err != nil {
+				return fmt.Errorf("table %v: %v", t.Name, err)
+			}
+			ext.Def.Columns = append(ext.Def.Columns, ColumnDef{Name: c.Name, Tp: tp, NotNull: c.NotNull})
+		}
+		if !filepath.IsAbs(ext.Path) {
+			ext.Path = filepath.Join(dir, ext.Path)
+		}
+		if err := RegisterExternalTable(ext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadCatalogFile register the external tables of path, a file of CREATE TABLE statements if its extension
+// is .sql, a catalog config otherwise
+func LoadCatalogFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".sql") {
+		return CreateExternalTables(string(data), filepath.Dir(path))
+	}
+	return LoadExternalTables(data, filepath.Dir(path))
+}
+
+// recordReader returns the records of an external table, a record gives the text of a column of the file
+// and whether it is a JSON string, nil once the file is exhausted
+type recordReader interface {
+	next() (func(column string, pos int) (string, bool, bool), error)
+	line() int
+}
+
+func (t *ExternalTable) open() (*os.File, recordReader, error) {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.Format == FormatJSONL {
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		return f, &jsonlReader{scanner: s}, nil
+	}
+	r := t.newCSVReader(f)
+	r.ReuseRecord = true
+	ret := &csvReader{reader: r}
+	if t.Header {
+		header, err := r.Read()
+		if err != nil && err != io.EOF {
+			f.Close()
+			return nil, nil, err
+		}
+		ret.lines++
+		ret.header = make(map[string]int)
+		for i, name := range header {
+			ret.header[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		for _, c := range t.Def.Columns {
+			if _, ok := ret.header[strings.ToLower(c.Name)]; !ok {
+				f.Close()
+				return nil, nil, fmt.Errorf("column %v is not in the header of %v", c.Name, t.Path)
+			}
+		}
+	}
+	return f, ret, nil
+}
+
+type csvReader struct {
+	reader *csv.Reader
+	header map[string]int //the position of every column name, nil without header
+	lines  int
+}
+
+func (r *csvReader) next() (func(column string, pos int) (string, bool, bool), error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.lines++
+	return func(column string, pos int) (string, bool, bool) {
+		if r.header != nil {
+			var ok bool
+			if pos, ok = r.header[strings.ToLower(column)]; !ok {
+				return "", false, false
+			}
+		}
+		if pos >= len(record) {
+			return "", false, false
+		}
+		return record[pos], false, true
+	}, nil
+}
+
+func (r *csvReader) line() int {
+	return r.lines
+}
+
+// jsonlReader splits the objects in raw values, only the values of the columns read are decoded
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	lines   int
+}
+
+func (r *jsonlReader) next() (func(column string, pos int) (string, bool, bool), error) {
+	var line []byte
+	for len(line) == 0 {
+		if !r.scanner.Scan() {
+			return nil, r.scanner.Err()
+		}
+		r.lines++
+		line = bytes.TrimSpace(r.scanner.Bytes())
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(line, &object); err != nil {
+		return nil, fmt.Errorf("line %d: %v", r.lines, err)
+	}
+	lower := make(map[string]json.RawMessage, len(object))
+	for k, v := range object {
+		lower[strings.ToLower(k)] = v
+	}
+	return func(column string, pos int) (string, bool, bool) {
+		raw, ok := lower[strings.ToLower(column)]
+		if !ok || string(raw) == "null" {
+			return "", false, false
+		}
+		if raw[0] == '"' {
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				return s, true, true
+			}
+		}
+		return string(raw), false, true
+	}, nil
+}
+
+func (r *jsonlReader) line() int {
+	return r.lines
+}
+
+// parseValue convert the text of a column to a datum of its type, the null marker, an absent value and
+// an empty field of a column which is not a string are NULL. A JSON boolean is 1 or 0
+func (t *ExternalTable) parseValue(text string, quoted, present bool, c ColumnDef) (Datum, error) {
+	if !present || (!quoted && (text == t.Null || (text == "" && c.Tp != test_driver.KindString))) {
+		if c.NotNull {
+			return Datum{}, fmt.Errorf("column %v can not be null", c.Name)
+		}
+		return NullDatum(), nil
+	}
+	if t.Format == FormatJSONL && !quoted && c.Tp != test_driver.KindString {
+		switch text {
+		case "true":
+			text = "1"
+		case "false":
+			text = "0"
+		}
+	}
+	if t.Format != FormatJSONL && c.Tp != test_driver.KindString {
+		text = strings.TrimSpace(text)
+	}
+	d, err := decodeDatum(datumJSON{Kind: datumKindNames[c.Tp], Value: text})
+	if err != nil {
+		return Datum{}, fmt.Errorf("column %v: can not parse %q as %v", c.Name, text, datumKindNames[c.Tp])
+	}
+	return d, nil
+}
+
+var decimalText = regexp.MustCompile(`^[-+]?([0-9]+\.[0-9]*|\.[0-9]+)$`)
+
+// inferColumns read the first InferRows records and type every column with the narrowest of int64, decimal,
+// float64 and string which holds all its values, a column without value is a string.
+// The columns are named by the header, by the keys of the objects, or c1, c2... in a CSV file without header
+func (t *ExternalTable) inferColumns() ([]ColumnDef, error) {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	var samples [][]string
+	var quoted [][]bool
+	if t.Format == FormatJSONL {
+		seen := make(map[string]int)
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for len(samples) < InferRows && s.Scan() {
+			line := bytes.TrimSpace(s.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.UseNumber()
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+				return nil, fmt.Errorf("line %d is not a JSON object", len(samples)+1)
+			}
+			record, isString := make([]string, len(names)), make([]bool, len(names))
+			for dec.More() {
+				key, _ := dec.Token()
+				var v interface{}
+				if err := dec.Decode(&v); err != nil {
+					return nil, err
+				}
+				name := key.(string)
+				i, ok := seen[strings.ToLower(name)]
+				if !ok {
+					i = len(names)
+					seen[strings.ToLower(name)] = i
+					names = append(names, name)
+					record, isString = append(record, ""), append(isString, false)
+				}
+				switch v := v.(type) {
+				case string:
+					record[i], isString[i] = v, true
+				case json.Number:
+					record[i] = v.String()
+				case bool:
+					record[i] = "1"
+				case nil:
+					record[i] = t.Null
+				default:
+					record[i], isString[i] = fmt.Sprint(v), true
+				}
+			}
+			samples, quoted = append(samples, record), append(quoted, isString)
+		}
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		r := t.newCSVReader(f)
+		for len(samples) < InferRows {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if t.Header && names == nil {
+				names = append([]string{}, record...)
+				continue
+			}
+			samples, quoted = append(samples, record), append(quoted, make([]bool, len(record)))
+			for len(names) < len(record) && !t.Header {
+				names = append(names, "c"+strconv.Itoa(len(names)+1))
+			}
+		}
+	}
+	columns := make([]ColumnDef, 0, len(names))
+	for i, name := range names {
+		tp := byte(test_driver.KindNull)
+		for j, record := range samples {
+			if i >= len(record) || record[i] == t.Null || record[i] == "" {
+				continue
+			}
+			tp = widenKind(tp, record[i], quoted[j][i])
+		}
+		if tp == test_driver.KindNull {
+			tp = test_driver.KindString
+		}
+		columns = append(columns, ColumnDef{Name: strings.TrimSpace(name), Tp: tp})
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no column found in %v", t.Path)
+	}
+	return columns, nil
+}
+
+// widenKind return the narrowest kind holding the values of kind tp and the value text
+func widenKind(tp byte, text string, quoted bool) byte {
+	text = strings.TrimSpace(text)
+	kind := byte(test_driver.KindString)
+	if !quoted {
+		if _, err := strconv.ParseInt(text, 10, 64); err == nil {
+			kind = test_driver.KindInt64
+		} else if decimalText.MatchString(text) {
+			kind = test_driver.KindMysqlDecimal
+		} else if _, err := strconv.ParseFloat(text, 64); err == nil {
+			kind = test_driver.KindFloat64
+		}
+	}
+	rank := map[byte]int{test_driver.KindNull: 0, test_driver.KindInt64: 1, test_driver.KindMysqlDecimal: 2,
+		test_driver.KindFloat64: 3, test_driver.KindString: 4}
+	if rank[kind] > rank[tp] {
+		return kind
+	}
+	return tp
+}
+
+// fileScanExec reads the rows of an external table, only the columns marked in required are parsed,
+// the others are NULL
+type fileScanExec struct {
+	table     *ExternalTable
+	schema    Schema
+	columns   []ColumnDef //the column of the table of every column of the schema
+	positions []int       //the position of every column in the table
+	required  []bool
+	file      *os.File
+	reader    recordReader
+}
+
+func newFileScanExec(plan *LogicalPlan, table *ExternalTable, required []bool) (Executor, error) {
+	e := &fileScanExec{table: table, schema: plan.Schema(), required: required}
+	for _, c := range e.schema.Columns {
+		found := false
+		for i, def := range table.Def.Columns {
+			if strings.EqualFold(def.Name, c.OrigColName) {
+				e.columns, e.positions = append(e.columns, def), append(e.positions, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %v in table %v", c.OrigColName, table.Def.Name)
+		}
+	}
+	return e, nil
+}
+
+func (e *fileScanExec) Open() error {
+	var err error
+	e.file, e.reader, err = e.table.open()
+	return err
+}
+
+func (e *fileScanExec) Next() (Row, error) {
+	value, err := e.reader.next()
+	if value == nil || err != nil {
+		return nil, err
+	}
+	ret := make(Row, len(e.columns))
+	for i, c := range e.columns {
+		if e.required != nil && !e.required[i] {
+			ret[i] = NullDatum()
+			continue
+		}
+		text, quoted, present := value(c.Name, e.positions[i])
+		if ret[i], err = e.table.parseValue(text, quoted, present, c); err != nil {
+			return nil, fmt.Errorf("%v line %d: %v", e.table.Path, e.reader.line(), err)
+		}
+	}
+	return ret, nil
+}
+
+func (e *fileScanExec) Close() error {
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close()
+	e.file = nil
+	return err
+}
+
+func (e *fileScanExec) Schema() Schema {
+	return e.schema
+}
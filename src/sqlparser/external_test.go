@@ -0,0 +1,107 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var externalNames = []string{"people", "orders", "events"}
+
+// useExternalTables register the external tables of the catalog file of the test directory in place of the
+// tables of the same names, the returned function restores them
+func useExternalTables(t *testing.T, file string) func() {
+	t.Helper()
+	catalog, stats := map[string]*TableDef{}, map[string]*TableStats{}
+	storage, external := map[string]*MemTable{}, map[string]*ExternalTable{}
+	for _, name := range externalNames {
+		catalog[name], stats[name], storage[name], external[name] = Catalog[name], Statistics[name], Storage[name],
+			ExternalTables[name]
+		delete(Statistics, name)
+		delete(Storage, name)
+		delete(ExternalTables, name)
+	}
+	restore := func() {
+		for _, name := range externalNames {
+			delete(Catalog, name)
+			delete(Statistics, name)
+			delete(Storage, name)
+			delete(ExternalTables, name)
+			if catalog[name] != nil {
+				Catalog[name] = catalog[name]
+			}
+			if stats[name] != nil {
+				Statistics[name] = stats[name]
+			}
+			if storage[name] != nil {
+				Storage[name] = storage[name]
+			}
+			if external[name] != nil {
+				ExternalTables[name] = external[name]
+			}
+		}
+	}
+	if err := LoadCatalogFile(testDir + file); err != nil {
+		restore()
+		t.Fatalf("%v: %v", file, err)
+	}
+	return restore
+}
+
+func TestLoadCatalogFile(t *testing.T) {
+	loadTestTables(t)
+	tests := []struct {
+		file   string
+		people string
+	}{
+		//the columns of a catalog config are inferred from the files and nullable
+		{"external.json", "people.id:int64? people.name:string? people.age:int64? people.city:string?"},
+		{"external.sql", "people.id:int64 people.name:string? people.age:int64? people.city:string?"},
+	}
+	for _, test := range tests {
+		restore := useExternalTables(t, test.file)
+		_, plan := optimizeQuery(t, "select * from people")
+		if got := formatSchema(findPlan(plan, Table).Schema()); got != test.people {
+			t.Errorf("%v: people is %v, want %v", test.file, got, test.people)
+		}
+		if ext, ok := LookupExternalTable("People"); !ok || ext.Path != filepath.Join(testDir, "external/people.csv") {
+			t.Errorf("%v: people is not bound to its file", test.file)
+		}
+		//quoted fields, the null marker, and an empty field which is NULL unless the column is a string
+		checkResult(t, "select * from people", "1, Ann, 34, Paris", "2, Bob, NULL, Lyon", "3, Carl, Jr., 27, Paris",
+			"4, Dina, 41, ")
+		checkResult(t, "select id, note from orders where note is null or note = ''", "2, NULL", "4, ")
+		checkResult(t, "select id, ok from events", "1, 1", "2, 0", "3, NULL", "4, NULL")
+		checkResult(t, "select name from people where city = 'Paris'", "Ann", "Carl, Jr.")
+		checkResult(t, "select p.name, sum(o.amount) from people p join orders o on p.id = o.person group by p.name",
+			"Ann, 15.75", "Bob, 7.75", "Carl, Jr., 40.00")
+		checkResult(t, "select p.name, e.kind from people p left join events e on p.id = e.person and e.ok",
+			"Ann, login", "Bob, NULL", "Carl, Jr., NULL", "Dina, NULL")
+		restore()
+	}
+	if _, ok := LookupExternalTable("people"); ok {
+		t.Error("people is kept after the test")
+	}
+}
+
+func TestExternalTableErrors(t *testing.T) {
+	defer useExternalTables(t, "external.json")()
+	tests := []struct {
+		config, err string
+	}{
+		{`{"tables": [{"name": "people", "location": "external/people.csv", "format": "xml"}]}`, "unknown format"},
+		{`{"tables": [{"name": "people", "location": "external/nosuch.csv"}]}`, "nosuch.csv"},
+		{`{"tables": [{"name": "people", "location": "external/people.csv", "columns": [{"name": "id", "type": "blob"}]}]}`,
+			"blob"},
+		{`{"tables": [`, "unexpected end"},
+	}
+	for _, test := range tests {
+		err := LoadExternalTables([]byte(test.config), testDir)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: error %v, want %v", test.config, err, test.err)
+		}
+	}
+	if err := CreateExternalTables("CREATE TABLE people (id INT) ENGINE=CSV", testDir); err == nil {
+		t.Error("a table without a file is created")
+	}
+}
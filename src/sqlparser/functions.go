@@ -0,0 +1,190 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/test_driver"
+	"strings"
+)
+
+type FunctionKind int
+
+const (
+	ScalarFunction    FunctionKind = iota //one value per row
+	AggregateFunction                     //one value per group of rows
+)
+
+// FunctionDef describes what the rules need to know about a function
+//
+//	Volatile: may return different values for the same arguments, the calls can not be moved nor merged
+//	NullIntolerant: returns NULL as soon as one argument is NULL
+//	NotNull: never returns NULL
+//	ReturnType: the test_driver.KindXXX of the result from the kinds of the arguments, KindNull if unknown
+//	Partial: for a decomposable aggregate, the aggregates computed by the partial phase on the arguments,
+//	         the final phase merges their results with the function itself, see FinalAggregate
+//	Eval: computes a scalar function on the values of its arguments, nil if the executor can not run it
+type FunctionDef struct {
+	Name           string
+	Kind           FunctionKind
+	Volatile       bool
+	NullIntolerant bool
+	NotNull        bool
+	ReturnType     func(args []byte) byte
+	Partial        []string
+	Eval           func(args []Datum) (Datum, error)
+}
+
+// Decomposable check whether the aggregate can be split into a partial and a final phase
+func (def *FunctionDef) Decomposable() bool {
+	return def.Kind == AggregateFunction && len(def.Partial) > 0
+}
+
+// distinctSuffix ends the name of the aggregates of the distinct values of their arguments,
+// COUNT(DISTINCT a) is kept as the function "count distinct"
+const distinctSuffix = " distinct"
+
+// DistinctAggregate return the name of the aggregate f of the distinct values of its arguments
+func DistinctAggregate(f string) string {
+	return strings.ToLower(f) + distinctSuffix
+}
+
+// SplitDistinctAggregate return the aggregate f applies to the distinct values, and whether f is such an aggregate
+func SplitDistinctAggregate(f string) (string, bool) {
+	if strings.HasSuffix(f, distinctSuffix) {
+		return strings.TrimSuffix(f, distinctSuffix), true
+	}
+	return f, false
+}
+
+// FunctionRegistry maps the lower case function name to its definition
+type FunctionRegistry struct {
+	funcs map[string]*FunctionDef
+}
+
+func NewFunctionRegistry() *FunctionRegistry {
+	return &FunctionRegistry{funcs: make(map[string]*FunctionDef)}
+}
+
+// Register add def to r, replacing the function of the same name
+func (r *FunctionRegistry) Register(def FunctionDef) {
+	def.Name = strings.ToLower(def.Name)
+	r.funcs[def.Name] = &def
+}
+
+func (r *FunctionRegistry) Lookup(name string) (*FunctionDef, bool) {
+	def, ok := r.funcs[strings.ToLower(name)]
+	return def, ok
+}
+
+// Functions is the registry consulted by the rules, user defined functions are added with RegisterFunction.
+// A function missing from it is assumed to be a volatile scalar function of unknown type
+var Functions = builtinFunctions()
+
+func RegisterFunction(def FunctionDef) {
+	Functions.Register(def)
+}
+
+func LookupFunction(name string) (*FunctionDef, bool) {
+	return Functions.Lookup(name)
+}
+
+func returnKind(tp byte) func([]byte) byte {
+	return func([]byte) byte {
+		return tp
+	}
+}
+
+// returnFirstArg : the result has the type of the first argument
+func returnFirstArg(args []byte) byte {
+	if len(args) == 0 {
+		return test_driver.KindNull
+	}
+	return args[0]
+}
+
+// returnSum : sum and avg of floats and strings are floats, decimals otherwise
+func returnSum(args []byte) byte {
+	if len(args) > 0 && (args[0] == test_driver.KindFloat32 || args[0] == test_driver.KindFloat64 || isStringKind(args[0])) {
+		return test_driver.KindFloat64
+	}
+	return test_driver.KindMysqlDecimal
+}
+
+func builtinFunctions() *FunctionRegistry {
+	r := NewFunctionRegistry()
+	aggregates := []FunctionDef{
+		{Name: "count", NotNull: true, ReturnType: returnKind(test_driver.KindInt64), Partial: []string{"count"}},
+		{Name: "sum", ReturnType: returnSum, Partial: []string{"sum"}},
+		{Name: "avg", ReturnType: returnSum, Partial: []string{"sum", "count"}},
+		{Name: "max", ReturnType: returnFirstArg, Partial: []string{"max"}},
+		{Name: "min", ReturnType: returnFirstArg, Partial: []string{"min"}},
+		{Name: "bit_and", NotNull: true, ReturnType: returnKind(test_driver.KindUint64), Partial: []string{"bit_and"}},
+		{Name: "bit_or", NotNull: true, ReturnType: returnKind(test_driver.KindUint64), Partial: []string{"bit_or"}},
+		{Name: "bit_xor", NotNull: true, ReturnType: returnKind(test_driver.KindUint64), Partial: []string{"bit_xor"}},
+		{Name: "group_concat", ReturnType: returnKind(test_driver.KindString)},
+		{Name: "std", ReturnType: returnKind(test_driver.KindFloat64)},
+		{Name: "stddev", ReturnType: returnKind(test_driver.KindFloat64)},
+		{Name: "stddev_pop", ReturnType: returnKind(test_driver.KindFloat64)},
+		{Name: "stddev_samp", ReturnType: returnKind(test_driver.KindFloat64)},
+		{Name: "variance", ReturnType: returnKind(test_driver.KindFloat64)},
+		{Name: "var_pop", ReturnType: returnKind(test_driver.KindFloat64)},
+		{Name: "var_samp", ReturnType: returnKind(test_driver.KindFloat64)},
+	}
+	for _, def := range aggregates {
+		def.Kind = AggregateFunction
+		r.Register(def)
+		switch def.Name {
+		case "count", "sum", "avg", "max", "min", "group_concat":
+			//the duplicates of one partial phase may be in another one
+			def.Name, def.Partial = DistinctAggregate(def.Name), nil
+			r.Register(def)
+		}
+	}
+	scalars := map[byte][]string{
+		test_driver.KindFloat64: {"sqrt", "exp", "ln", "log", "log2", "log10", "pow", "power"},
+		test_driver.KindInt64:   {"sign", "length", "char_length", "year", "month", "day"},
+		test_driver.KindString: {"upper", "lower", "ucase", "lcase", "substring", "substr", "left", "right", "trim",
+			"ltrim", "rtrim", "concat", "reverse"},
+	}
+	for tp, names := range scalars {
+		for _, name := range names {
+			r.Register(FunctionDef{Name: name, NullIntolerant: true, ReturnType: returnKind(tp)})
+		}
+	}
+	for _, name := range []string{"abs", "ceil", "ceiling", "floor", "round", "truncate", "greatest", "least"} {
+		r.Register(FunctionDef{Name: name, NullIntolerant: true, ReturnType: returnFirstArg})
+	}
+	for _, name := range []string{"coalesce", "ifnull", "nullif"} {
+		r.Register(FunctionDef{Name: name, ReturnType: returnFirstArg})
+	}
+	r.Register(FunctionDef{Name: "if", ReturnType: func(args []byte) byte {
+		if len(args) < 2 {
+			return test_driver.KindNull
+		}
+		return args[1]
+	}})
+	r.Register(FunctionDef{Name: "concat_ws", ReturnType: returnKind(test_driver.KindString)})
+	//the operators kept as functions, case chooses a result and passes NULL conditions over
+	r.Register(FunctionDef{Name: Ops[Like].Name, NullIntolerant: true, ReturnType: returnKind(test_driver.KindInt64)})
+	r.Register(FunctionDef{Name: Ops[Regexp].Name, NullIntolerant: true, ReturnType: returnKind(test_driver.KindInt64)})
+	r.Register(FunctionDef{Name: Ops[BitNeg].Name, NullIntolerant: true, ReturnType: returnKind(test_driver.KindUint64)})
+	r.Register(FunctionDef{Name: Ops[UnaryMinus].Name, NullIntolerant: true, ReturnType: returnFirstArg})
+	r.Register(FunctionDef{Name: Ops[Case].Name})
+	volatile := map[byte][]string{
+		test_driver.KindFloat64: {"rand"},
+		test_driver.KindString:  {"uuid"},
+		test_driver.KindUint64:  {"uuid_short"},
+		test_driver.KindMysqlTime: {"now", "sysdate", "current_timestamp", "localtime", "localtimestamp", "curdate",
+			"current_date", "utc_date", "utc_timestamp"},
+		test_driver.KindMysqlDuration: {"curtime", "current_time", "utc_time"},
+		test_driver.KindInt64: {"unix_timestamp", "connection_id", "last_insert_id", "row_count", "found_rows", "sleep",
+			"get_lock", "release_lock"},
+	}
+	for tp, names := range volatile {
+		for _, name := range names {
+			r.Register(FunctionDef{Name: name, Volatile: true, ReturnType: returnKind(tp)})
+		}
+	}
+	for name, eval := range builtinEvals {
+		r.funcs[name].Eval = eval
+	}
+	return r
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+func TestFunctionRegistry(t *testing.T) {
+	def, ok := LookupFunction("COUNT")
+	if !ok || def.Kind != AggregateFunction || !def.Decomposable() || !def.NotNull {
+		t.Errorf("count is %+v", def)
+	}
+	if def, _ := LookupFunction("avg"); len(def.Partial) != 2 || def.Partial[0] != "sum" || def.Partial[1] != "count" {
+		t.Errorf("avg is computed by %v in the partial phase", def.Partial)
+	}
+	for _, f := range []string{"group_concat", "stddev", DistinctAggregate("count"), DistinctAggregate("sum")} {
+		if def, ok := LookupFunction(f); !ok || def.Kind != AggregateFunction || def.Decomposable() {
+			t.Errorf("%v is %+v, want a non-decomposable aggregate", f, def)
+		}
+	}
+	if f, ok := SplitDistinctAggregate(DistinctAggregate("COUNT")); !ok || f != "count" {
+		t.Errorf("the distinct aggregate of count is split into %v %v", f, ok)
+	}
+	if !IsNullIntolerantFunction("upper") || IsNullIntolerantFunction("coalesce") || IsNullIntolerantFunction("case") {
+		t.Error("the null-intolerant functions are wrong")
+	}
+	if !IsNonDeterministicFunction("rand") || !IsNonDeterministicFunction("nosuch") || IsNonDeterministicFunction("abs") {
+		t.Error("the volatile functions are wrong")
+	}
+	if def, _ := LookupFunction("sum"); def.ReturnType([]byte{test_driver.KindInt64}) != test_driver.KindMysqlDecimal ||
+		def.ReturnType([]byte{test_driver.KindFloat64}) != test_driver.KindFloat64 {
+		t.Error("the return type of sum is wrong")
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	defer func() { Functions = builtinFunctions() }()
+	//a function unknown to the registry is volatile, the condition stays in HAVING
+	sql := "select a, count(*) from testdata2 group by a having myfunc(a) > 0"
+	_, plan := optimizeQuery(t, sql)
+	if got := filterExprs(plan); len(got) != 0 {
+		t.Errorf("the condition on an unknown function is pushed to %v", got)
+	}
+	RegisterFunction(FunctionDef{Name: "MyFunc", NullIntolerant: true, ReturnType: returnFirstArg})
+	_, plan = optimizeQuery(t, sql)
+	if got := filterExprs(plan); len(got) != 1 || got[0] != "(myfunc(a)>0)" {
+		t.Errorf("the filters of a registered function are %v", got)
+	}
+	//null-intolerant, the left join is an inner join
+	if got := simplifiedJoinTypes(t, "select t.a from t left join s on t.a = s.a where myfunc(s.a) > 0"); got != "inner" {
+		t.Errorf("the join is %v with a null-intolerant function", got)
+	}
+}
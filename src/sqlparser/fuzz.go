@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// FuzzFailure is a query on which the planner or the optimizer panics or breaks an invariant of the plans
+type FuzzFailure struct {
+	SQL      string
+	Stage    string //parse, GetQuery or QueryOptimizer
+	Message  string
+	Location string //the function which panicked
+}
+
+func (f *FuzzFailure) Error() string {
+	if f.Location != "" {
+		return fmt.Sprintf("%v: %v in %v", f.Stage, f.Message, f.Location)
+	}
+	return fmt.Sprintf("%v: %v", f.Stage, f.Message)
+}
+
+// signature identify the failure independently of the names and the numbers of the query,
+// a query is shrunk as long as it fails the same way
+func (f *FuzzFailure) signature() string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return -1
+		}
+		return r
+	}, f.Error())
+}
+
+// CheckQuery run sql through parse, GetQuery and QueryOptimizer and return the first panic or broken invariant:
+// every node has the number of children of its type and is the parent of its children, the optimized plan
+// outputs the columns of the original plan, and it does not reference more columns missing from the input
+// of their node than the original plan
+func CheckQuery(sql string) (failure *FuzzFailure) {
+	stage := "parse"
+	quiet := QuietRules
+	defer func() {
+		QuietRules = quiet
+		if r := recover(); r != nil {
+			failure = &FuzzFailure{SQL: sql, Stage: stage, Message: fmt.Sprint(r), Location: panicLocation()}
+		}
+	}()
+	fail := func(format string, args ...interface{}) *FuzzFailure {
+		return &FuzzFailure{SQL: sql, Stage: stage, Message: fmt.Sprintf(format, args...)}
+	}
+	node, err := parse(sql)
+	if err != nil {
+		return fail("%v", err)
+	}
+	stage = "GetQuery"
+	plan := GetQuery(node)
+	if msg := checkPlan(plan); msg != "" {
+		return fail("%v", msg)
+	}
+	schema := plan.Schema()
+	unresolved := CountUnresolvedColumns(plan)
+
+	stage = "QueryOptimizer"
+	QuietRules, RuleTrace = true, nil
+	plan.QueryOptimizer()
+	if msg := checkPlan(treeRoot); msg != "" {
+		return fail("%v", msg)
+	}
+	if optimized := treeRoot.Schema(); !sameColumnNames(schema, optimized) {
+		return fail("output columns changed from %v to %v", columnNames(schema), columnNames(optimized))
+	}
+	if n := CountUnresolvedColumns(treeRoot); n > unresolved {
+		return fail("%d unresolved columns before optimization, %d after", unresolved, n)
+	}
+	if _, err := CopyLogicalPlan(treeRoot); err != nil {
+		return fail("the optimized plan can not be serialized: %v", err)
+	}
+	return nil
+}
+
+// checkPlan check the number of children and the parent of every node of plan
+func checkPlan(plan *LogicalPlan) string {
+	n := len(plan.child)
+	switch plan.Tp {
+	case Table:
+		if n > 1 {
+			return fmt.Sprintf("Table with %d children", n)
+		}
+	case Join:
+		if n != 2 {
+			return fmt.Sprintf("Join with %d children", n)
+		}
+	case Union:
+		if n < 2 {
+			return fmt.Sprintf("Union with %d children", n)
+		}
+	case EmptyRelation:
+		if n != 0 {
+			return fmt.Sprintf("EmptyRelation with %d children", n)
+		}
+	default:
+		if n != 1 && !(n == 0 && (plan.Tp == Project || plan.Tp == Aggregate)) {
+			return fmt.Sprintf("%v with %d children", OpTypeNames[plan.Tp], n)
+		}
+	}
+	for i := range plan.child {
+		if plan.child[i].parent != plan {
+			return fmt.Sprintf("child %d of %v has a wrong parent", i, OpTypeNames[plan.Tp])
+		}
+		if msg := checkPlan(&plan.child[i]); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func sameColumnNames(s1, s2 Schema) bool {
+	if s1.Len() != s2.Len() {
+		return false
+	}
+	for i, c := range s1.Columns {
+		if !strings.EqualFold(c.ColName, s2.Columns[i].ColName) {
+			return false
+		}
+	}
+	return true
+}
+
+func columnNames(s Schema) []string {
+	ret := make([]string, 0, s.Len())
+	for _, c := range s.Columns {
+		ret = append(ret, c.ColName)
+	}
+	return ret
+}
+
+// panicLocation return the innermost function of the package on the stack of a panic, out of the checks
+func panicLocation() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "main.") && !strings.HasPrefix(frame.Function, "main.CheckQuery") {
+			return strings.TrimPrefix(frame.Function, "main.")
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// ShrinkQuery reduce q while it fails like failure, by removing clauses, fields, join sides and UNION branches
+// and by replacing expressions with their operands, and return the smallest failing query found.
+// A reduction referencing a column which is no longer defined, like a column of a removed join side, is skipped
+func ShrinkQuery(q *GenQuery, failure *FuzzFailure) *GenQuery {
+	signature := failure.signature()
+	for shrunk := true; shrunk; {
+		shrunk = false
+		for _, c := range q.reductions() {
+			if !c.valid() {
+				continue
+			}
+			if f := CheckQuery(c.String()); f != nil && f.signature() == signature {
+				q, shrunk = c, true
+				break
+			}
+		}
+	}
+	return q
+}
+
+// valid check whether the columns used by q are defined by its FROM, and its ORDER BY items by its fields.
+// The columns of the base tables are read from the Catalog
+func (q *GenQuery) valid() bool {
+	scope, ok := q.From.columns()
+	if !ok {
+		return false
+	}
+	for _, exprs := range [][]*genExpr{q.Fields, q.Where, q.GroupBy, q.Having} {
+		for _, e := range exprs {
+			if !e.defined(scope) {
+				return false
+			}
+		}
+	}
+	for _, item := range q.OrderBy {
+		if pos, err := strconv.Atoi(item.Item.Leaf); err == nil {
+			if pos < 1 || pos > len(q.Fields) {
+				return false
+			}
+		} else if !definesAlias(q.Fields, item.Item.Leaf) {
+			return false
+		}
+	}
+	for _, branch := range q.Union {
+		if len(branch.Fields) != len(q.Fields) || !branch.valid() {
+			return false
+		}
+	}
+	return true
+}
+
+// columns return the qualified columns s makes visible, and whether its derived tables and joins are valid
+func (s *genSource) columns() (map[string]bool, bool) {
+	ret := make(map[string]bool)
+	switch {
+	case s.Table != "":
+		def, ok := LookupTable(s.Table)
+		if !ok {
+			return nil, false
+		}
+		for _, c := range def.Columns {
+			ret[s.Alias+"."+c.Name] = true
+		}
+	case s.Query != nil:
+		if !s.Query.valid() {
+			return nil, false
+		}
+		for _, f := range s.Query.Fields {
+			ret[s.Alias+"."+f.Alias] = true
+		}
+	default:
+		for _, side := range []*genSource{s.Left, s.Right} {
+			cols, ok := side.columns()
+			if !ok {
+				return nil, false
+			}
+			for c := range cols {
+				ret[c] = true
+			}
+		}
+		for _, e := range s.On {
+			if !e.defined(ret) {
+				return nil, false
+			}
+		}
+	}
+	return ret, true
+}
+
+// defined check whether the columns of e are in scope
+func (e *genExpr) defined(scope map[string]bool) bool {
+	if strings.Contains(e.Leaf, ".") && e.Leaf[0] >= 'a' && e.Leaf[0] <= 'z' && !scope[e.Leaf] {
+		return false
+	}
+	for _, arg := range e.Args {
+		if !arg.defined(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func definesAlias(fields []*genExpr, alias string) bool {
+	for _, f := range fields {
+		if f.Alias == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// reductions list the queries one step smaller than q, they share the unchanged parts with q
+func (q *GenQuery) reductions() []*GenQuery {
+	var ret []*GenQuery
+	with := func(edit func(c *GenQuery)) {
+		c := *q
+		edit(&c)
+		ret = append(ret, &c)
+	}
+	for i := range q.Union {
+		i := i
+		with(func(c *GenQuery) { c.Union = removeQuery(q.Union, i) })
+	}
+	if len(q.Limit) > 0 {
+		with(func(c *GenQuery) { c.Limit = nil })
+	}
+	for i := range q.OrderBy {
+		i := i
+		with(func(c *GenQuery) { c.OrderBy = append(append([]genOrder{}, q.OrderBy[:i]...), q.OrderBy[i+1:]...) })
+	}
+	for i := range q.Having {
+		i := i
+		with(func(c *GenQuery) { c.Having = removeExpr(q.Having, i) })
+	}
+	if len(q.GroupBy) > 0 {
+		with(func(c *GenQuery) { c.GroupBy = nil })
+	}
+	for i := range q.Where {
+		i := i
+		with(func(c *GenQuery) { c.Where = removeExpr(q.Where, i) })
+	}
+	for i := range q.Fields {
+		i := i
+		if len(q.Fields) > 1 {
+			with(func(c *GenQuery) { c.Fields = removeExpr(q.Fields, i) })
+		}
+	}
+	for _, s := range q.From.reductions() {
+		s := s
+		with(func(c *GenQuery) { c.From = s })
+	}
+	for i := range q.Union {
+		for _, b := range q.Union[i].reductions() {
+			i, b := i, b
+			with(func(c *GenQuery) {
+				c.Union = append([]*GenQuery{}, q.Union...)
+				c.Union[i] = b
+			})
+		}
+	}
+	reduceExprs := func(exprs []*genExpr, set func(c *GenQuery, exprs []*genExpr)) {
+		for i, e := range exprs {
+			for _, r := range e.reductions() {
+				i, r := i, *r
+				r.Alias = e.Alias
+				with(func(c *GenQuery) {
+					exprs := append([]*genExpr{}, exprs...)
+					exprs[i] = &r
+					set(c, exprs)
+				})
+			}
+		}
+	}
+	reduceExprs(q.Fields, func(c *GenQuery, exprs []*genExpr) { c.Fields = exprs })
+	reduceExprs(q.Where, func(c *GenQuery, exprs []*genExpr) { c.Where = exprs })
+	reduceExprs(q.Having, func(c *GenQuery, exprs []*genExpr) { c.Having = exprs })
+	return ret
+}
+
+// reductions list the sources one step smaller than s: a side of a join, or a smaller derived table or join
+func (s *genSource) reductions() []*genSource {
+	var ret []*genSource
+	switch {
+	case s.Query != nil:
+		for _, q := range s.Query.reductions() {
+			c := *s
+			c.Query = q
+			ret = append(ret, &c)
+		}
+	case s.Left != nil:
+		ret = append(ret, s.Left, s.Right)
+		for i := range s.On {
+			c := *s
+			c.On = removeExpr(s.On, i)
+			ret = append(ret, &c)
+		}
+		if s.Join != "JOIN" {
+			c := *s
+			c.Join = "JOIN"
+			ret = append(ret, &c)
+		}
+		for _, l := range s.Left.reductions() {
+			c := *s
+			c.Left = l
+			ret = append(ret, &c)
+		}
+		for _, r := range s.Right.reductions() {
+			c := *s
+			c.Right = r
+			ret = append(ret, &c)
+		}
+	}
+	return ret
+}
+
+// reductions list the expressions one step smaller than e: its operands, or e with a smaller operand
+func (e *genExpr) reductions() []*genExpr {
+	var ret []*genExpr
+	for _, arg := range e.Args {
+		if arg.Leaf != "*" {
+			ret = append(ret, arg)
+		}
+	}
+	for i, arg := range e.Args {
+		for _, r := range arg.reductions() {
+			c := *e
+			c.Args = append([]*genExpr{}, e.Args...)
+			c.Args[i] = r
+			ret = append(ret, &c)
+		}
+	}
+	return ret
+}
+
+func removeExpr(exprs []*genExpr, i int) []*genExpr {
+	return append(append([]*genExpr{}, exprs[:i]...), exprs[i+1:]...)
+}
+
+func removeQuery(queries []*GenQuery, i int) []*GenQuery {
+	return append(append([]*GenQuery{}, queries[:i]...), queries[i+1:]...)
+}
+
+// RegisterFuzzTables register the FuzzTables missing from the Catalog
+func RegisterFuzzTables() {
+	for _, def := range FuzzTables {
+		if _, ok := LookupTable(def.Name); !ok {
+			RegisterTable(def)
+		}
+	}
+}
+
+// FuzzQueries check the queries generated from the seeds seed to seed+n-1 and return their failures,
+// each with the SQL shrunk to a minimal reproducer
+func FuzzQueries(seed int64, n int) []*FuzzFailure {
+	RegisterFuzzTables()
+	var ret []*FuzzFailure
+	for s := seed; s < seed+int64(n); s++ {
+		q := NewQueryGenerator(s, FuzzTables).Generate()
+		if f := CheckQuery(q.String()); f != nil {
+			f.SQL = ShrinkQuery(q, f).String()
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
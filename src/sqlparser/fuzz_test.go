@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+// FuzzOptimizer is a target of Go's native fuzzing, the fuzzer mutates the seed of the query generator.
+// go test -fuzz=FuzzOptimizer runs it, go test only checks the seeds added below
+func FuzzOptimizer(f *testing.F) {
+	useFuzzTables()
+	for seed := int64(0); seed < 16; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		q := NewQueryGenerator(seed, FuzzTables).Generate()
+		if failure := CheckQuery(q.String()); failure != nil {
+			t.Fatalf("%v\nSQL: %v\nshrunk: %v", failure, q, ShrinkQuery(q, failure))
+		}
+	})
+}
+
+func TestFuzzQueries(t *testing.T) {
+	useFuzzTables()
+	for _, failure := range FuzzQueries(1, 200) {
+		t.Errorf("%v\nshrunk: %v", failure, failure.SQL)
+	}
+}
+
+func TestCheckQueryReportsParseErrors(t *testing.T) {
+	useFuzzTables()
+	if failure := CheckQuery("select from"); failure == nil || failure.Stage != "parse" {
+		t.Errorf("a parse error is reported as %v", failure)
+	}
+}
+
+// useFuzzTables register the FuzzTables, t1 and t2 replace the tables of the test directory of the same name
+func useFuzzTables() {
+	for _, def := range FuzzTables {
+		RegisterTable(def)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// MemTable holds the rows of a base table in memory, the values of a row are in the order of Def.Columns
+type MemTable struct {
+	Def  TableDef
+	Rows []Row
+}
+
+// Storage maps the lower case table name to its rows, the executor scans the base tables from there
+var Storage = make(map[string]*MemTable)
+
+// RegisterMemTable store the rows of the table, its definition is registered in the Catalog and
+// the statistics of the rows in Statistics, so the plans are optimized for the data they run on
+func RegisterMemTable(def TableDef, rows []Row) {
+	def.Rows = float64(len(rows))
+	RegisterTable(def)
+	columns := make([]string, 0, len(def.Columns))
+	for _, c := range def.Columns {
+		columns = append(columns, c.Name)
+	}
+	values := make([][]Datum, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, row)
+	}
+	RegisterStats(def.Name, ComputeTableStats(columns, values))
+	Storage[strings.ToLower(def.Name)] = &MemTable{def, rows}
+}
+
+func LookupMemTable(name string) (*MemTable, bool) {
+	t, ok := Storage[strings.ToLower(name)]
+	return t, ok
+}
+
+type memColumnJSON struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"notNull,omitempty"`
+}
+
+type memTableJSON struct {
+	Name    string          `json:"name"`
+	Columns []memColumnJSON `json:"columns"`
+	Indexes []IndexDef      `json:"indexes,omitempty"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type memTablesFileJSON struct {
+	Tables []memTableJSON `json:"tables"`
+}
+
+// LoadMemTables register the tables of the JSON data file data.
+// The type of a column is a datum kind of the plan files, like int64 or decimal, a value is a JSON number,
+// string or null
+func LoadMemTables(data []byte) error {
+	var file memTablesFileJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&file); err != nil {
+		return err
+	}
+	for _, t := range file.Tables {
+		def := TableDef{Name: t.Name, Indexes: t.Indexes}
+		for _, c := range t.Columns {
+			var tp byte
+			if err := decodeKind(c.Type, &tp); err != nil {
+				return fmt.Errorf("table %v: %v", t.Name, err)
+			}
+			def.Columns = append(def.Columns, ColumnDef{Name: c.Name, Tp: tp, NotNull: c.NotNull})
+		}
+		var rows []Row
+		for i, values := range t.Rows {
+			if len(values) != len(t.Columns) {
+				return fmt.Errorf("table %v: row %d has %d values for %d columns", t.Name, i+1, len(values),
+					len(t.Columns))
+			}
+			row := make(Row, 0, len(values))
+			for j, v := range values {
+				d, err := decodeValue(v, t.Columns[j])
+				if err != nil {
+					return fmt.Errorf("table %v: row %d: %v", t.Name, i+1, err)
+				}
+				row = append(row, d)
+			}
+			rows = append(rows, row)
+		}
+		RegisterMemTable(def, rows)
+	}
+	return nil
+}
+
+func LoadMemTablesFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return LoadMemTables(data)
+}
+
+// decodeKind find the datum kind named name
+func decodeKind(name string, tp *byte) error {
+	for k, v := range datumKindNames {
+		if v == name {
+			*tp = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown column type %q", name)
+}
+
+// decodeValue convert the JSON value v to a datum of the type of column c
+func decodeValue(v interface{}, c memColumnJSON) (Datum, error) {
+	switch v := v.(type) {
+	case nil:
+		if c.NotNull {
+			return Datum{}, fmt.Errorf("column %v can not be null", c.Name)
+		}
+		return NullDatum(), nil
+	case json.Number:
+		return decodeDatum(datumJSON{Kind: c.Type, Value: v.String()})
+	case string:
+		return decodeDatum(datumJSON{Kind: c.Type, Value: v})
+	}
+	return Datum{}, fmt.Errorf("column %v: unsupported value %v", c.Name, v)
+}
@@ -1,17 +1,49 @@
 package main
 
 import (
+	"fmt"
 	_ "github.com/pingcap/tidb/parser/test_driver"
 )
 
+// QuietRules stops the rules from printing the plan after every rewrite
+var QuietRules = false
+
+// RuleTrace records the names of the rules applied, in order, the differential test clears it before optimizing
+var RuleTrace []string
+
+// ruleApplied record the name of the rule which just rewrote the plan, and print it with the plan
+func ruleApplied(name string) {
+	RuleTrace = append(RuleTrace, name)
+	if QuietRules {
+		return
+	}
+	fmt.Printf("%v\n", name)
+	OutputQuery(treeRoot, 0)
+}
+
 func (plan *LogicalPlan) QueryOptimizer() {
+	treeRoot = plan
+	aggregateColumnID = 0
+	plan.SimplifyExpressions()
 	plan.PushDownPredicate()
+	plan.SimplifyExpressions()
+	plan.PropagateEmptyRelation()
+	treeRoot.ResetParent()
+	plan.JoinReorder()
+	plan.EagerAggregate()
+	plan.EliminateProjection()
 }
 
 func (plan *LogicalPlan) PushDownPredicate() {
 	plan.CombineFilters()
 	plan.PushPredicateThroughNonJoin()
+	treeRoot.ResetParent()
+	plan.SimplifyOuterJoin()
+	plan.InferPredicates()
 	plan.PushPredicateThroughJoin()
+	treeRoot.ResetParent()
+	plan.BuildTopN()
+	plan.TopNPushDown()
 	plan.LimitPushDown()
 }
 
@@ -21,12 +53,7 @@ func (plan *LogicalPlan) CombineFilters() {
 		if len(cur.child) != 1 {
 			break
 		}
-		if cur.Tp == Filter && cur.child[0].Tp == Filter {
-			cur.Content = WhereFilterNode{
-				Expr: append(cur.Content.(WhereFilterNode).Expr, cur.child[0].Content.(WhereFilterNode).Expr...),
-			}
-			cur.child[0].LogicalPlanDelete()
-		}
+		MergeFilters(cur)
 		cur = &cur.child[0]
 	}
 	for _, child := range cur.child {
@@ -34,6 +61,24 @@ func (plan *LogicalPlan) CombineFilters() {
 	}
 }
 
+// MergeFilters merge the Filters right below filter into it, return true if there was one
+func MergeFilters(filter *LogicalPlan) bool {
+	if filter.Tp != Filter {
+		return false
+	}
+	modify := false
+	for len(filter.child) == 1 && filter.child[0].Tp == Filter {
+		filter.Content = WhereFilterNode{
+			Expr: append(filter.Content.(WhereFilterNode).Expr, filter.child[0].Content.(WhereFilterNode).Expr...),
+		}
+		filter.child[0].parent = filter
+		filter.child[0].LogicalPlanDelete()
+		filter.ResetParent()
+		modify = true
+	}
+	return modify
+}
+
 // FindLogicalPlanInSingleChain return the first found OpType OpNode in the single chain of LogicalPlan
 func FindLogicalPlanInSingleChain(root *LogicalPlan, op OpType) (*LogicalPlan, bool) {
 	if root == nil {
@@ -87,11 +132,3 @@ func (plan *LogicalPlan) IsNextType(Tp OpType) bool {
 		}
 	}
 }
-
-func LimitPush2Project() bool {
-	return true
-}
-
-func LimitPush2Join() bool {
-	return true
-}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/ast"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type PhysicalType int
+
+const (
+	TableScan           PhysicalType = iota + 1 //read all the rows of a base table
+	IndexScan                                   //read the rows of a base table in a range of an index, in the index order
+	Selection                                   //Filter, HavingFilter
+	Projection                                  //Project without aggregates
+	HashJoin                                    //build a hash table on one child, probe it with the rows of the other
+	MergeJoin                                   //merge the children sorted on the equi join columns
+	IndexNestedLoopJoin                         //look up the rows of one child through its index for every row of the other
+	NestedLoopJoin                              //compare every pair of rows
+	HashAgg                                     //aggregate the rows in a hash table
+	StreamAgg                                   //aggregate the rows sorted on the group by columns
+	Sort                                        //sort all the rows
+	PhysicalTopN                                //keep the first rows of the order in a heap
+	PhysicalLimit                               //stop after the first rows
+	PhysicalUnion                               //concatenate the children, remove the duplicates if not All
+	PhysicalEmpty                               //produce no row
+	SubqueryScan                                //rename the columns of a derived table
+)
+
+var PhysicalTypeNames = [...]string{
+	TableScan:           "TableScan",
+	IndexScan:           "IndexScan",
+	Selection:           "Selection",
+	Projection:          "Projection",
+	HashJoin:            "HashJoin",
+	MergeJoin:           "MergeJoin",
+	IndexNestedLoopJoin: "IndexNestedLoopJoin",
+	NestedLoopJoin:      "NestedLoopJoin",
+	HashAgg:             "HashAgg",
+	StreamAgg:           "StreamAgg",
+	Sort:                "Sort",
+	PhysicalTopN:        "TopN",
+	PhysicalLimit:       "Limit",
+	PhysicalUnion:       "Union",
+	PhysicalEmpty:       "Empty",
+	SubqueryScan:        "SubqueryScan",
+}
+
+func (t PhysicalType) String() string {
+	if t > 0 && int(t) < len(PhysicalTypeNames) {
+		return PhysicalTypeNames[t]
+	}
+	return "PhysicalType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// PhysicalPlan is an operator of the plan chosen to run the query. Content is the content of the logical node
+// it implements, an OrderByNode for a Sort. The physical plan is only costed and printed with -explain, Execute
+// runs the logical plan: an IndexNestedLoopJoin or a MergeJoin gives the cost of the join, not its algorithm
+type PhysicalPlan struct {
+	Tp      PhysicalType
+	Content interface{}
+	Index   *IndexDef    //IndexScan: the index read
+	Access  []Expression //IndexScan: the conjuncts giving the range of the index read, the join conditions of a lookup
+	Inner   int          //HashJoin: the child the hash table is built on, IndexNestedLoopJoin: the child looked up
+	Order   []string     //the order of the rows produced, see orderKeys
+	Rows    float64      //estimated number of rows produced
+	Cost    float64      //estimated cost of the subtree, per lookup for the inner side of an IndexNestedLoopJoin
+	child   []PhysicalPlan
+}
+
+// CostModel holds the cost of the elementary operations, in units of reading one row of a table sequentially
+type CostModel struct {
+	ScanRow   float64 //read a row of a table sequentially
+	IndexRow  float64 //read a row of a table through an index
+	IndexSeek float64 //position an index on a key
+	CPURow    float64 //evaluate the expressions of a node on a row
+	HashBuild float64 //insert a row into a hash table
+	HashProbe float64 //look a row up in a hash table
+	Compare   float64 //compare two rows while sorting
+}
+
+var PhysicalCost = CostModel{
+	ScanRow:   1,
+	IndexRow:  3,
+	IndexSeek: 20,
+	CPURow:    0.1,
+	HashBuild: 2,
+	HashProbe: 1,
+	Compare:   0.2,
+}
+
+func newPhysicalPlan(tp PhysicalType, content interface{}, rows, cost float64, children ...PhysicalPlan) *PhysicalPlan {
+	return &PhysicalPlan{Tp: tp, Content: content, Rows: rows, Cost: cost, child: children}
+}
+
+// physicalBuilder keeps the best plan of every logical node for every order asked by its parents.
+// input gives the best plan of a child of a logical node, BuildPhysicalPlan plans the children with best,
+// the Cascades search plans the groups of the children
+type physicalBuilder struct {
+	memo  map[string]*PhysicalPlan
+	input func(child *LogicalPlan, order []ByItem) *PhysicalPlan
+}
+
+// BuildPhysicalPlan choose the cheapest physical plan of the optimized logical plan root. Every logical node
+// enumerates the operators implementing it, the children are asked for the order the operator needs, and a Sort
+// is added on top of the candidates which do not produce the order their parent needs
+func BuildPhysicalPlan(root *LogicalPlan) *PhysicalPlan {
+	root.ResetParent()
+	b := &physicalBuilder{memo: make(map[string]*PhysicalPlan)}
+	b.input = b.best
+	return b.best(root, nil)
+}
+
+// best return the cheapest plan of plan producing its rows in order
+func (b *physicalBuilder) best(plan *LogicalPlan, order []ByItem) *PhysicalPlan {
+	want := orderKeys(plan.Schema(), order)
+	key := fmt.Sprintf("%p %v", plan, strings.Join(want, ","))
+	if ret, ok := b.memo[key]; ok {
+		return ret
+	}
+	var ret *PhysicalPlan
+	for _, p := range b.enumerate(plan, order) {
+		p = enforceOrder(p, order, want)
+		if ret == nil || p.Cost < ret.Cost {
+			ret = p
+		}
+	}
+	b.memo[key] = ret
+	return ret
+}
+
+// enforceOrder add a Sort on top of p if its rows are not in the order want
+func enforceOrder(p *PhysicalPlan, order []ByItem, want []string) *PhysicalPlan {
+	if satisfies(p.Order, want) {
+		return p
+	}
+	ret := newPhysicalPlan(Sort, OrderByNode{order}, p.Rows, p.Cost+sortCost(p.Rows), *p)
+	ret.Order = want
+	return ret
+}
+
+// Pattern matches a node by its OpType and the patterns of its children,
+// AnyOp matches any node and a pattern without children matches any children
+type Pattern struct {
+	Tp       OpType
+	Children []Pattern
+}
+
+const AnyOp OpType = 0
+
+// Match check whether the tree rooted at plan matches p
+func (p Pattern) Match(plan *LogicalPlan) bool {
+	if p.Tp != AnyOp && p.Tp != plan.Tp {
+		return false
+	}
+	if len(p.Children) == 0 {
+		return true
+	}
+	if len(p.Children) != len(plan.child) {
+		return false
+	}
+	for i := range p.Children {
+		if !p.Children[i].Match(&plan.child[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ImplementationRule gives the physical operators implementing the logical nodes matched by Pattern,
+// order is the order wanted by the parent
+type ImplementationRule struct {
+	Name      string
+	Pattern   Pattern
+	Implement func(b *physicalBuilder, plan *LogicalPlan, order []ByItem) []*PhysicalPlan
+}
+
+// ImplementationRules are shared by BuildPhysicalPlan and the Cascades search
+var ImplementationRules = []ImplementationRule{
+	{"Scan", Pattern{Tp: Table}, (*physicalBuilder).implementTable},
+	{"FilterScan", Pattern{Filter, []Pattern{{Tp: Table}}}, (*physicalBuilder).implementFilterScan},
+	{"Selection", Pattern{Tp: Filter}, (*physicalBuilder).implementSelection},
+	{"Selection", Pattern{Tp: HavingFilter}, (*physicalBuilder).implementSelection},
+	{"Empty", Pattern{Tp: EmptyRelation}, (*physicalBuilder).implementEmpty},
+	{"Projection", Pattern{Tp: Project}, (*physicalBuilder).implementProject},
+	{"Aggregate", Pattern{Tp: Aggregate}, (*physicalBuilder).implementAggregate},
+	{"Aggregate", Pattern{Tp: GroupBy}, (*physicalBuilder).implementAggregate},
+	{"Sort", Pattern{Tp: OrderBy}, (*physicalBuilder).implementOrderBy},
+	{"Limit", Pattern{Tp: Limit}, (*physicalBuilder).implementLimit},
+	{"TopN", Pattern{Tp: TopN}, (*physicalBuilder).implementTopN},
+	{"Union", Pattern{Tp: Union}, (*physicalBuilder).implementUnion},
+	{"Join", Pattern{Tp: Join}, (*physicalBuilder).joins},
+}
+
+// enumerate return the operators implementing plan, order is the order wanted by the parent
+func (b *physicalBuilder) enumerate(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	var ret []*PhysicalPlan
+	for _, rule := range ImplementationRules {
+		if rule.Pattern.Match(plan) {
+			ret = append(ret, rule.Implement(b, plan, order)...)
+		}
+	}
+	if len(ret) == 0 {
+		panic("PhysicalPlan Error Type")
+	}
+	return ret
+}
+
+func (b *physicalBuilder) implementTable(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	if len(plan.child) > 0 {
+		child := b.input(&plan.child[0], nil)
+		return []*PhysicalPlan{newPhysicalPlan(SubqueryScan, plan.Content, EstimateRows(plan), child.Cost, *child)}
+	}
+	return b.scans(plan, nil, order)
+}
+
+// implementFilterScan read the rows of the base table under the Filter through its indexes
+func (b *physicalBuilder) implementFilterScan(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	if !isBaseTable(&plan.child[0]) {
+		return nil
+	}
+	return b.scans(&plan.child[0], plan.Expressions(), order)
+}
+
+func (b *physicalBuilder) implementSelection(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	return []*PhysicalPlan{selection(plan.Content, b.input(&plan.child[0], order), EstimateRows(plan))}
+}
+
+func (b *physicalBuilder) implementEmpty(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	return []*PhysicalPlan{newPhysicalPlan(PhysicalEmpty, plan.Content, 0, 0)}
+}
+
+// implementProject : a Project computing aggregates without GROUP BY is a StreamAgg returning one row
+func (b *physicalBuilder) implementProject(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	c := PhysicalCost
+	cols := plan.Content.(ProjectionNode).cols
+	if len(plan.child) == 0 {
+		return []*PhysicalPlan{newPhysicalPlan(Projection, plan.Content, 1, c.CPURow)}
+	}
+	rows := EstimateRows(plan)
+	if hasAggregate(cols) {
+		child := b.input(&plan.child[0], nil)
+		return []*PhysicalPlan{newPhysicalPlan(StreamAgg, plan.Content, rows, child.Cost+child.Rows*c.CPURow, *child)}
+	}
+	childOrder, ok := orderThroughProject(cols, plan.Schema(), order)
+	child := b.input(&plan.child[0], childOrder)
+	cost := child.Cost
+	for _, col := range cols {
+		//passing the columns costs nothing
+		if _, ok := SingleColumn(col); !ok && !IsWildCard(col) {
+			cost += child.Rows * c.CPURow
+			break
+		}
+	}
+	ret := newPhysicalPlan(Projection, plan.Content, rows, cost, *child)
+	if ok {
+		ret.Order = orderKeys(plan.Schema(), order)
+	}
+	return []*PhysicalPlan{ret}
+}
+
+func (b *physicalBuilder) implementAggregate(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	c := PhysicalCost
+	var items []Expression
+	if n, ok := plan.Content.(AggregateNode); ok {
+		items = n.Items
+	} else {
+		items = plan.Content.(GroupByNode).Items
+	}
+	var keys []ByItem
+	for _, item := range items {
+		keys = append(keys, ByItem{Item: item})
+	}
+	rows := EstimateRows(plan)
+	child := b.input(&plan.child[0], nil)
+	sorted := b.input(&plan.child[0], keys)
+	return []*PhysicalPlan{
+		newPhysicalPlan(HashAgg, plan.Content, rows, child.Cost+child.Rows*c.HashBuild+rows*c.CPURow, *child),
+		newPhysicalPlan(StreamAgg, plan.Content, rows, sorted.Cost+sorted.Rows*c.CPURow, *sorted),
+	}
+}
+
+// implementOrderBy : the OrderBy asks its child for its order, which is sorted if it can not do better
+func (b *physicalBuilder) implementOrderBy(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	return []*PhysicalPlan{b.input(&plan.child[0], plan.Content.(OrderByNode).Items)}
+}
+
+func (b *physicalBuilder) implementLimit(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	n := plan.Content.(LimitNode)
+	rows := EstimateRows(plan)
+	child := b.input(&plan.child[0], order)
+	ret := newPhysicalPlan(PhysicalLimit, plan.Content, rows,
+		limitCost(child, limitRows(child.Rows, AddLimitExpression(n.Count, n.Offset)))+rows*PhysicalCost.CPURow, *child)
+	ret.Order = child.Order
+	return []*PhysicalPlan{ret}
+}
+
+// implementTopN keep the first rows in a heap, or stop reading the sorted rows early, which pays off when
+// the child produces them in order
+func (b *physicalBuilder) implementTopN(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	c := PhysicalCost
+	n := plan.Content.(TopNNode)
+	rows := EstimateRows(plan)
+	child := b.input(&plan.child[0], nil)
+	k := limitRows(child.Rows, AddLimitExpression(n.Count, n.Offset))
+	topN := newPhysicalPlan(PhysicalTopN, plan.Content, rows,
+		child.Cost+child.Rows*math.Log2(math.Max(k, 2))*c.Compare, *child)
+	topN.Order = orderKeys(plan.Schema(), n.Items)
+	sorted := b.input(&plan.child[0], n.Items)
+	limit := newPhysicalPlan(PhysicalLimit, LimitNode{n.Count, n.Offset, false}, rows,
+		limitCost(sorted, k)+rows*c.CPURow, *sorted)
+	limit.Order = sorted.Order
+	return []*PhysicalPlan{topN, limit}
+}
+
+func (b *physicalBuilder) implementUnion(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	c := PhysicalCost
+	var children []PhysicalPlan
+	var cost, in float64
+	for i := range plan.child {
+		child := b.input(&plan.child[i], nil)
+		children = append(children, *child)
+		cost += child.Cost + child.Rows*c.CPURow
+		in += child.Rows
+	}
+	if !plan.Content.(UnionNode).All {
+		cost += in * c.HashBuild
+	}
+	return []*PhysicalPlan{newPhysicalPlan(PhysicalUnion, plan.Content, EstimateRows(plan), cost, children...)}
+}
+
+// scans enumerate the ways to read the base table and keep the rows satisfying the conjuncts exprs:
+// a TableScan, and an IndexScan for every index giving a range or the order wanted
+func (b *physicalBuilder) scans(table *LogicalPlan, exprs []Expression, order []ByItem) []*PhysicalPlan {
+	c := PhysicalCost
+	schema := table.Schema()
+	tableRows := EstimateRows(table)
+	rows := tableRows * Selectivity(schema, exprs)
+	filter := func(scan *PhysicalPlan, rest []Expression) *PhysicalPlan {
+		if len(rest) == 0 {
+			return scan
+		}
+		return selection(WhereFilterNode{rest}, scan, rows)
+	}
+	ret := []*PhysicalPlan{filter(newPhysicalPlan(TableScan, table.Content, tableRows, tableRows*c.ScanRow), exprs)}
+	def, ok := LookupTable(table.Content.(TableNode).Table.OrigTblName)
+	if !ok {
+		return ret
+	}
+	want := orderKeys(schema, order)
+	for i := range def.Indexes {
+		index := &def.Indexes[i]
+		access, rest, eq := IndexAccess(schema, index, exprs)
+		indexOrder := indexOrderKeys(schema, index, eq)
+		if len(access) == 0 && (len(want) == 0 || !satisfies(indexOrder, want)) {
+			continue
+		}
+		matched := tableRows * Selectivity(schema, access)
+		scan := newPhysicalPlan(IndexScan, table.Content, matched, c.IndexSeek+matched*c.IndexRow)
+		scan.Index, scan.Access, scan.Order = index, access, indexOrder
+		ret = append(ret, filter(scan, rest))
+	}
+	return ret
+}
+
+// joins enumerate the join algorithms applicable to the Join plan
+func (b *physicalBuilder) joins(plan *LogicalPlan, order []ByItem) []*PhysicalPlan {
+	if len(plan.child) != 2 {
+		return []*PhysicalPlan{b.input(&plan.child[0], order)}
+	}
+	c := PhysicalCost
+	n := plan.Content.(JoinNode)
+	rows := EstimateRows(plan)
+	join := func(tp PhysicalType, cost float64, l, r *PhysicalPlan) *PhysicalPlan {
+		return newPhysicalPlan(tp, n, rows, l.Cost+r.Cost+cost+rows*c.CPURow, *l, *r)
+	}
+	//the order of the left child is kept if all its rows come out in its order
+	keepOrder := n.Tp == ast.CrossJoin || n.Tp == InnerJoin || n.Tp == ast.LeftJoin
+	var leftOrder []ByItem
+	if keepOrder && orderOnSchema(plan.child[0].Schema(), order) {
+		leftOrder = order
+	}
+
+	left, right := b.input(&plan.child[0], nil), b.input(&plan.child[1], nil)
+	outer := b.input(&plan.child[0], leftOrder)
+	nlj := join(NestedLoopJoin, outer.Rows*right.Rows*c.CPURow, outer, right)
+	if keepOrder {
+		nlj.Order = outer.Order
+	}
+	ret := []*PhysicalPlan{nlj}
+
+	var lkeys, rkeys []ByItem
+	for _, expr := range n.On {
+		if l, r, ok := EquiJoinColumns(plan, expr); ok {
+			lkeys = append(lkeys, ByItem{Item: ColumnExpression(l)})
+			rkeys = append(rkeys, ByItem{Item: ColumnExpression(r)})
+		}
+	}
+	if len(lkeys) == 0 {
+		return ret
+	}
+	//the preserved side of an outer join probes, the smaller side of an inner join is built
+	build := 1
+	switch n.Tp {
+	case ast.RightJoin:
+		build = 0
+	case ast.CrossJoin, InnerJoin:
+		if left.Rows < right.Rows {
+			build = 0
+		}
+	}
+	sides := []*PhysicalPlan{left, right}
+	hj := join(HashJoin, sides[build].Rows*c.HashBuild+sides[1-build].Rows*c.HashProbe, left, right)
+	hj.Inner = build
+	ret = append(ret, hj)
+
+	ls, rs := b.input(&plan.child[0], lkeys), b.input(&plan.child[1], rkeys)
+	mj := join(MergeJoin, (ls.Rows+rs.Rows)*c.CPURow, ls, rs)
+	if keepOrder {
+		mj.Order = orderKeys(plan.child[0].Schema(), lkeys)
+	}
+	ret = append(ret, mj)
+
+	for _, inner := range []int{1, 0} {
+		if inner == 1 && !keepOrder && n.Tp != ast.RightJoin {
+			continue
+		}
+		if inner == 0 && n.Tp != ast.CrossJoin && n.Tp != InnerJoin && n.Tp != ast.RightJoin {
+			continue
+		}
+		var outerOrder []ByItem
+		if inner == 1 && keepOrder {
+			outerOrder = leftOrder
+		}
+		ret = append(ret, b.indexJoins(plan, inner, b.input(&plan.child[1-inner], outerOrder))...)
+	}
+	return ret
+}
+
+// indexJoins return an IndexNestedLoopJoin for every index of the inner child whose leading columns are
+// compared to the outer child by the equi join conditions. The inner child must be a base table, maybe under
+// a Filter, whose rows are read by one lookup per outer row
+func (b *physicalBuilder) indexJoins(join *LogicalPlan, inner int, outer *PhysicalPlan) []*PhysicalPlan {
+	c := PhysicalCost
+	table := &join.child[inner]
+	var filters []Expression
+	if table.Tp == Filter {
+		filters = table.Expressions()
+		table = &table.child[0]
+	}
+	if !isBaseTable(table) {
+		return nil
+	}
+	def, ok := LookupTable(table.Content.(TableNode).Table.OrigTblName)
+	if !ok {
+		return nil
+	}
+	schema := table.Schema()
+	conds := make(map[string][]Expression)
+	ndv := make(map[string]float64)
+	for _, expr := range join.Content.(JoinNode).On {
+		l, r, ok := EquiJoinColumns(join, expr)
+		if !ok {
+			continue
+		}
+		col := r
+		if inner == 0 {
+			col = l
+		}
+		if i := schema.ResolveColumn(col); i != -1 {
+			name := strings.ToLower(schema.Columns[i].OrigColName)
+			conds[name] = append(conds[name], expr)
+			ndv[name] = EstimateNDV(table, col)
+		}
+	}
+	rows := EstimateRows(join)
+	var ret []*PhysicalPlan
+	for i := range def.Indexes {
+		index := &def.Indexes[i]
+		var access []Expression
+		perLookup := EstimateRows(table)
+		for _, name := range index.Columns {
+			cond, ok := conds[strings.ToLower(name)]
+			if !ok {
+				break
+			}
+			access = append(access, cond...)
+			perLookup /= math.Max(ndv[strings.ToLower(name)], 1)
+		}
+		if len(access) == 0 {
+			continue
+		}
+		scan := newPhysicalPlan(IndexScan, table.Content, perLookup, c.IndexSeek+perLookup*c.IndexRow)
+		scan.Index, scan.Access = index, access
+		lookup := scan
+		if len(filters) > 0 {
+			lookup = selection(WhereFilterNode{filters}, scan, perLookup*Selectivity(schema, filters))
+		}
+		children := make([]PhysicalPlan, 2)
+		children[inner], children[1-inner] = *lookup, *outer
+		p := newPhysicalPlan(IndexNestedLoopJoin, join.Content, rows,
+			outer.Cost+outer.Rows*lookup.Cost+rows*c.CPURow, children...)
+		p.Inner = inner
+		if inner == 1 {
+			p.Order = outer.Order
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+func selection(content interface{}, child *PhysicalPlan, rows float64) *PhysicalPlan {
+	ret := newPhysicalPlan(Selection, content, rows, child.Cost+child.Rows*PhysicalCost.CPURow, *child)
+	ret.Order = child.Order
+	return ret
+}
+
+func sortCost(rows float64) float64 {
+	return rows * math.Log2(math.Max(rows, 2)) * PhysicalCost.Compare
+}
+
+// limitCost : a child without blocking operator stops once the first rows are produced
+func limitCost(child *PhysicalPlan, rows float64) float64 {
+	if child.Rows <= 0 || !child.pipelined() {
+		return child.Cost
+	}
+	return child.Cost * math.Min(1, rows/child.Rows)
+}
+
+// pipelined check whether the plan produces its first rows before reading all its input
+func (p *PhysicalPlan) pipelined() bool {
+	switch p.Tp {
+	case TableScan, IndexScan, PhysicalEmpty:
+		return true
+	case Selection, Projection, PhysicalLimit, SubqueryScan:
+		return p.child[0].pipelined()
+	case IndexNestedLoopJoin:
+		return p.child[1-p.Inner].pipelined()
+	}
+	return false
+}
+
+func isBaseTable(plan *LogicalPlan) bool {
+	return plan.Tp == Table && len(plan.child) == 0
+}
+
+// orderKeys return the keys comparing the orders of the rows, the columns are resolved in schema
+func orderKeys(schema Schema, items []ByItem) []string {
+	var ret []string
+	for _, item := range items {
+		key := strings.ToLower(item.Item.print())
+		if col, ok := SingleColumn(item.Item); ok {
+			if i := schema.ResolveColumn(col); i != -1 {
+				key = columnKey(schema.Columns[i])
+			}
+		}
+		if item.Desc {
+			key += " desc"
+		}
+		ret = append(ret, key)
+	}
+	return ret
+}
+
+func columnKey(c SchemaColumn) string {
+	return strings.ToLower(c.TblName + "." + c.ColName)
+}
+
+// satisfies check whether rows in the order provided are also in the order want
+func satisfies(provided, want []string) bool {
+	if len(want) > len(provided) {
+		return false
+	}
+	for i := range want {
+		if provided[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// orderOnSchema check whether the order only uses the columns of schema
+func orderOnSchema(schema Schema, order []ByItem) bool {
+	for _, item := range order {
+		if !schema.Contains(item.Item) {
+			return false
+		}
+	}
+	return true
+}
+
+// orderThroughProject return the order of the child of a Project giving the order of its output,
+// false if an item is not a column passed as is by the Project
+func orderThroughProject(cols []Expression, schema Schema, order []ByItem) ([]ByItem, bool) {
+	for _, col := range cols {
+		if IsWildCard(col) {
+			return nil, false
+		}
+	}
+	var ret []ByItem
+	for _, item := range order {
+		col, ok := SingleColumn(item.Item)
+		if !ok {
+			return nil, false
+		}
+		i := schema.ResolveColumn(col)
+		if i == -1 || i >= len(cols) || cols[i].AsName != "" {
+			return nil, false
+		}
+		if _, ok := SingleColumn(cols[i]); !ok {
+			return nil, false
+		}
+		ret = append(ret, ByItem{cols[i], item.Desc})
+	}
+	return ret, true
+}
+
+// IndexAccess split the conjuncts on a base table into the ones giving a range of index and the others.
+// The range is made of `col = constant` on the leading columns of the index, then of the comparisons of
+// the next column with constants. eq is the number of leading columns fixed by an equality
+func IndexAccess(schema Schema, index *IndexDef, exprs []Expression) (access, rest []Expression, eq int) {
+	used := make([]bool, len(exprs))
+	for _, name := range index.Columns {
+		var eqs, ranges []int
+		for i, expr := range exprs {
+			op, col, ok := constantComparison(expr)
+			if used[i] || !ok {
+				continue
+			}
+			if j := schema.ResolveColumn(col); j == -1 || !strings.EqualFold(schema.Columns[j].OrigColName, name) {
+				continue
+			}
+			switch op {
+			case EQ:
+				eqs = append(eqs, i)
+			case LT, LE, GT, GE:
+				ranges = append(ranges, i)
+			}
+		}
+		if len(eqs) > 0 {
+			for _, i := range eqs {
+				used[i] = true
+				access = append(access, exprs[i])
+			}
+			eq++
+			continue
+		}
+		for _, i := range ranges {
+			used[i] = true
+			access = append(access, exprs[i])
+		}
+		break
+	}
+	for i, expr := range exprs {
+		if !used[i] {
+			rest = append(rest, expr)
+		}
+	}
+	return access, rest, eq
+}
+
+// constantComparison match the conjunct `col op constant` or `constant op col`, the operator is
+// returned for the column on the left
+func constantComparison(expr Expression) (MyOp, ColumnName, bool) {
+	t := expr.Tree()
+	if t == nil || len(t.Children) != 2 {
+		return -1, ColumnName{}, false
+	}
+	switch t.Op {
+	case EQ, NE, LT, LE, GT, GE:
+	default:
+		return -1, ColumnName{}, false
+	}
+	l, r := t.Children[0], t.Children[1]
+	switch {
+	case l.Column != nil && r.IsConstant():
+		return t.Op, *l.Column, true
+	case r.Column != nil && l.IsConstant():
+		return ReverseComparison(t.Op), *r.Column, true
+	}
+	return -1, ColumnName{}, false
+}
+
+// indexOrderKeys return the order of the rows read through index, after the first eq columns fixed by equalities
+func indexOrderKeys(schema Schema, index *IndexDef, eq int) []string {
+	var ret []string
+	for _, name := range index.Columns[eq:] {
+		i := schema.ResolveColumn(ColumnName{ColName: name})
+		if i == -1 {
+			break
+		}
+		ret = append(ret, columnKey(schema.Columns[i]))
+	}
+	return ret
+}
+
+// OutputPhysicalPlan print the physical plan with the estimated rows and cost of every node
+func OutputPhysicalPlan(root *PhysicalPlan, deep int) {
+	if root == nil {
+		return
+	}
+	for i := 0; i < deep; i++ {
+		fmt.Printf("    ")
+	}
+	fmt.Printf(" %v: ", root.Tp)
+	if n, ok := root.Content.(interface{ print() }); ok {
+		n.print()
+	}
+	sides := []string{"left", "right"}
+	switch root.Tp {
+	case HashJoin:
+		fmt.Printf(" Build: %v", sides[root.Inner])
+	case IndexNestedLoopJoin:
+		fmt.Printf(" Lookup: %v", sides[root.Inner])
+	}
+	if root.Index != nil {
+		fmt.Printf(" Index: %v(%v)", root.Index.Name, strings.Join(root.Index.Columns, ", "))
+	}
+	if len(root.Access) > 0 {
+		fmt.Printf(" Range: ")
+		for _, expr := range root.Access {
+			fmt.Printf("%v, ", expr.print())
+		}
+	}
+	fmt.Printf("  (rows=%.2f, cost=%.2f)\n", root.Rows, root.Cost)
+	for i := range root.child {
+		OutputPhysicalPlan(&root.child[i], deep+1)
+	}
+}
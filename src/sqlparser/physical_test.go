@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// registerOrders add the tables orders and customers to the Catalog without statistics,
+// the returned function restores what was registered under these names
+func registerOrders() func() {
+	saved := map[string]*TableDef{"orders": Catalog["orders"], "customers": Catalog["customers"]}
+	stats := map[string]*TableStats{"orders": Statistics["orders"], "customers": Statistics["customers"]}
+	delete(Statistics, "orders")
+	delete(Statistics, "customers")
+	RegisterTable(TableDef{
+		Name: "orders",
+		Columns: []ColumnDef{
+			{Name: "id", Tp: test_driver.KindInt64, NotNull: true},
+			{Name: "customer", Tp: test_driver.KindInt64},
+			{Name: "amount", Tp: test_driver.KindInt64},
+		},
+		Rows: 1000000,
+		Indexes: []IndexDef{
+			{Name: "primary", Columns: []string{"id"}, Unique: true},
+			{Name: "by_customer", Columns: []string{"customer"}},
+		},
+	})
+	RegisterTable(TableDef{
+		Name:    "customers",
+		Columns: []ColumnDef{{Name: "id", Tp: test_driver.KindInt64, NotNull: true}, {Name: "name", Tp: test_driver.KindString}},
+		Rows:    1000,
+		Indexes: []IndexDef{{Name: "primary", Columns: []string{"id"}, Unique: true}},
+	})
+	return func() {
+		for name, def := range saved {
+			if def != nil {
+				Catalog[name] = def
+			} else {
+				delete(Catalog, name)
+			}
+			if stats[name] != nil {
+				Statistics[name] = stats[name]
+			}
+		}
+	}
+}
+
+// physicalTypes print the operators of p in pre-order
+func physicalTypes(p *PhysicalPlan) string {
+	ret := []string{p.Tp.String()}
+	for i := range p.child {
+		ret = append(ret, physicalTypes(&p.child[i]))
+	}
+	return strings.Join(ret, " ")
+}
+
+func TestBuildPhysicalPlan(t *testing.T) {
+	defer registerOrders()()
+	tests := []struct {
+		sql, want string
+	}{
+		{"select amount from orders where id = 5", "Projection IndexScan"},
+		{"select amount from orders where amount > 5", "Projection Selection TableScan"},
+		//the index gives the order, the scan stops after 10 rows
+		{"select id, amount from orders order by id limit 10", "Projection Limit IndexScan"},
+		{"select id, amount from orders order by amount limit 10", "Projection TopN TableScan"},
+		{"select c.name, o.amount from customers c join orders o on c.id = o.customer",
+			"Projection IndexNestedLoopJoin TableScan IndexScan"},
+	}
+	for _, test := range tests {
+		_, plan := optimizeQuery(t, test.sql)
+		p := BuildPhysicalPlan(plan)
+		if got := physicalTypes(p); got != test.want {
+			t.Errorf("%v: the physical plan is %v, want %v", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestUniqueKeySelectivity(t *testing.T) {
+	defer registerOrders()()
+	_, plan := optimizeQuery(t, "select amount from orders where id = 5")
+	if p := BuildPhysicalPlan(plan); p.Rows != 1 {
+		t.Errorf("%v rows estimated for an equality on a unique index", p.Rows)
+	}
+	//the customers are unique, every order finds at most one
+	_, plan = optimizeQuery(t, "select o.amount from orders o join customers c on c.id = o.customer")
+	if rows := EstimateRows(plan); rows > 1000000 {
+		t.Errorf("%v rows estimated for the join of the orders with their customer", rows)
+	}
+}
+
+// TestIndexJoinOnFixture plans the joins of the indexed tables of the fixture indexed.json, the rows are
+// read by Execute which runs the logical plan
+func TestIndexJoinOnFixture(t *testing.T) {
+	if err := LoadMemTablesFile(testDir + "indexed.json"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, name := range []string{"accounts", "payments"} {
+			delete(Catalog, name)
+			delete(Statistics, name)
+			delete(Storage, name)
+		}
+	}()
+	tests := []struct {
+		sql, plan string
+		rows      []string
+	}{
+		//the one account looks its payments up through by_account, cheaper than scanning the 40 payments
+		{"select x.name, p.amount from (select id, name from accounts where id = 4) x join payments p on x.id = p.account",
+			"Projection IndexNestedLoopJoin SubqueryScan IndexScan IndexScan", []string{"acct4, 45", "acct4, 195"}},
+		//the one payment looks its account up through the primary key, cheaper than scanning the 30 accounts
+		{"select p.id, a.name from (select id, account from payments where id = 7) p join accounts a on a.id = p.account",
+			"Projection IndexNestedLoopJoin Projection IndexScan IndexScan", []string{"7, acct20"}},
+	}
+	for _, test := range tests {
+		_, plan := optimizeQuery(t, test.sql)
+		if got := physicalTypes(BuildPhysicalPlan(plan)); got != test.plan {
+			t.Errorf("%v: the physical plan is %v, want %v", test.sql, got, test.plan)
+		}
+		checkResult(t, test.sql, test.rows...)
+	}
+}
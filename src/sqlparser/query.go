@@ -1,12 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/pingcap/tidb/parser"
 	"github.com/pingcap/tidb/parser/ast"
 	_ "github.com/pingcap/tidb/parser/test_driver"
 	"io/ioutil"
 	"log"
+	"strings"
 )
 
 const (
@@ -17,6 +19,7 @@ const (
 	PredPushToAggregate = "PredPushToAggregate.mdf"
 	LimitPushToProject  = "LimitPushToProject.mdf"
 	LimitPushToJoin     = "LimitPushToJoin.mdf"
+	tables              = "tables.json"
 )
 
 var treeRoot *LogicalPlan
@@ -30,19 +33,197 @@ func parse(sql string) (*ast.StmtNode, error) {
 	return &stmtNodes[0], nil
 }
 
+// diffSeeds is the number of generated data sets the fixtures are run on by the differential test, 0 to skip it
+var diffSeeds = flag.Int("diff", 0, "run the fixtures before and after optimization on `n` generated data sets")
+
+// fuzzQueries is the number of generated queries checked by the fuzzer, 0 to skip it
+var fuzzQueries = flag.Int("fuzz", 0, "check the planner and the optimizer on `n` generated queries")
+
+// tlpQueries is the number of generated queries checked by the ternary logic partitioning oracles, 0 to skip it
+var tlpQueries = flag.Int("tlp", 0, "check the partitioning oracles on `n` generated queries")
+
+// fuzzSeed is the seed of the first generated query
+var fuzzSeed = flag.Int64("seed", 1, "the seed of the first query generated by -fuzz and -tlp")
+
+// catalogFile binds tables to CSV, TSV or JSON Lines files, by CREATE TABLE statements or a catalog config
+var catalogFile = flag.String("catalog", "", "register the external tables of `file`, .sql or .json")
+
+// statsFile replaces the statistics computed from the MemTables, to plan the query with those of another database
+var statsFile = flag.String("stats", "", "load the table statistics of the JSON `file`")
+
+// querySQL is run instead of the fixture
+var querySQL = flag.String("sql", "", "the `query` to plan and run")
+
+// explainQuery prints the estimated rows of the optimized plan and its physical plan
+var explainQuery = flag.Bool("explain", false, "print the estimated rows and the physical plan of the query")
+
+// cascadesQuery plans the query with the Cascades optimizer too, and compares its cost with the one of the rules
+var cascadesQuery = flag.Bool("cascades", false, "print the memo and the plan of the Cascades optimizer")
+
+// runQuery executes the optimized plan and prints its rows
+var runQuery = flag.Bool("run", false, "execute the query and print its rows")
+
+// vectorQuery runs the query with the vectorized operators
+var vectorQuery = flag.Bool("vector", false, "execute the query by chunks of rows, implies -run")
+
+// benchName selects the benchmarks to run instead of the query
+var benchName = flag.String("bench", "", "run the benchmarks `name`: expr or vector")
+
+// fixtures are the queries of the test directory
+var fixtures = []string{f1, f2, PredPushToProject, PredPushToAggregate, LimitPushToProject, LimitPushToJoin}
+
 func main() {
-	bytes, err := ioutil.ReadFile(dir + LimitPushToJoin)
-	if err != nil {
-		log.Fatal("Failed to read file")
+	flag.Parse()
+	if *diffSeeds > 0 {
+		runDiffTests(*diffSeeds)
+		return
+	}
+	if *fuzzQueries > 0 {
+		runFuzz(*fuzzSeed, *fuzzQueries)
+		return
+	}
+	if *tlpQueries > 0 {
+		runTLPTests(*fuzzSeed, *tlpQueries)
+		return
+	}
+	if *benchName != "" {
+		runBenchmarks(*benchName)
+		return
+	}
+	if err := LoadMemTablesFile(dir + tables); err != nil {
+		log.Fatal(err)
+	}
+	if *catalogFile != "" {
+		if err := LoadCatalogFile(*catalogFile); err != nil {
+			log.Fatal(err)
+		}
 	}
-	astNode, err := parse(string(bytes))
+	if *statsFile != "" {
+		if err := LoadStatsFile(*statsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	sql := *querySQL
+	if sql == "" {
+		bytes, err := ioutil.ReadFile(dir + LimitPushToJoin)
+		if err != nil {
+			log.Fatal("Failed to read file")
+		}
+		sql = string(bytes)
+	}
+	astNode, err := parse(sql)
 	if err != nil {
 		fmt.Printf("parse error: %v\n", err.Error())
 		return
 	}
 	query := GetQuery(astNode)
 	OutputQuery(query, 0)
+	original, err := CopyLogicalPlan(query)
+	if err != nil {
+		log.Fatal(err)
+	}
 	treeRoot = query
 	query.QueryOptimizer()
-	OutputQuery(treeRoot, 0)
+	optimized := treeRoot
+	OutputQuery(optimized, 0)
+	if *explainQuery || *cascadesQuery {
+		physical := BuildPhysicalPlan(optimized)
+		if *explainQuery {
+			Explain(optimized, 0)
+			OutputPhysicalPlan(physical, 0)
+		}
+		if *cascadesQuery {
+			memo, best := CascadesOptimizer(original)
+			memo.Output()
+			OutputPhysicalPlan(best, 0)
+			fmt.Printf("Cost: rules %.2f, cascades %.2f\n", physical.Cost, best.Cost)
+		}
+	}
+	if !*runQuery && !*vectorQuery {
+		return
+	}
+	execute := Execute
+	if *vectorQuery {
+		execute = ExecuteVectorized
+	}
+	schema, rows, err := execute(optimized)
+	if err != nil {
+		fmt.Printf("execution error: %v\n", err.Error())
+		return
+	}
+	OutputResult(schema, rows)
+}
+
+// runDiffTests run the differential test of every fixture on the data sets of the seeds 1 to seeds,
+// and print the mismatches
+func runDiffTests(seeds int) {
+	mismatches := 0
+	for _, f := range fixtures {
+		bytes, err := ioutil.ReadFile(dir + f)
+		if err != nil {
+			log.Fatal("Failed to read file")
+		}
+		for seed := int64(1); seed <= int64(seeds); seed++ {
+			report, err := DiffTest(string(bytes), seed)
+			if err != nil {
+				fmt.Printf("%v: %v\n", f, err.Error())
+				break
+			}
+			if report.Mismatch != "" {
+				mismatches++
+				fmt.Printf("%v:\n", f)
+				report.Output()
+				break
+			}
+		}
+	}
+	fmt.Printf("%d fixtures, %d seeds, %d mismatches\n", len(fixtures), seeds, mismatches)
+}
+
+// runFuzz check n generated queries and print the failures with their shrunk queries
+func runFuzz(seed int64, n int) {
+	failures := FuzzQueries(seed, n)
+	for _, f := range failures {
+		fmt.Printf("%v\n    %v\n", f.Error(), f.SQL)
+	}
+	fmt.Printf("%d queries, %d failures\n", n, len(failures))
+}
+
+// runTLPTests run every oracle on the queries generated from the seeds seed to seed+n-1 and print the mismatches
+func runTLPTests(seed int64, n int) {
+	compared, mismatches := 0, 0
+	for s := seed; s < seed+int64(n); s++ {
+		for _, oracle := range TLPOracles {
+			report := TLPTest(oracle, s)
+			if report.Compared {
+				compared++
+			}
+			if report.Mismatch != "" {
+				mismatches++
+				report.Output()
+				fmt.Println(strings.Repeat("-", 40))
+			}
+		}
+	}
+	fmt.Printf("%d queries, %d compared, %d mismatches\n", n*len(TLPOracles), compared, mismatches)
+}
+
+// runBenchmarks run the benchmarks of name and print their times
+func runBenchmarks(name string) {
+	switch name {
+	case "expr":
+		benchmarks, err := RunExprBenchmarks()
+		if err != nil {
+			log.Fatal(err)
+		}
+		OutputExprBenchmarks(benchmarks)
+	case "vector":
+		benchmarks, err := RunVecBenchmarks()
+		if err != nil {
+			log.Fatal(err)
+		}
+		OutputVecBenchmarks(benchmarks)
+	default:
+		log.Fatalf("unknown benchmark %v", name)
+	}
 }
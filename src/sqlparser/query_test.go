@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testDir is the test directory of the repository seen from the package
+const testDir = "../../" + dir
+
+// loadTestTables register the MemTables of the test directory
+func loadTestTables(t *testing.T) {
+	t.Helper()
+	if err := LoadMemTablesFile(testDir + tables); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// optimizeQuery return the plan of sql before and after QueryOptimizer, the rules are not printed
+func optimizeQuery(t *testing.T, sql string) (original, optimized *LogicalPlan) {
+	t.Helper()
+	node, err := parse(sql)
+	if err != nil {
+		t.Fatalf("%v: %v", sql, err)
+	}
+	plan := GetQuery(node)
+	if original, err = CopyLogicalPlan(plan); err != nil {
+		t.Fatalf("%v: %v", sql, err)
+	}
+	quiet := QuietRules
+	QuietRules = true
+	defer func() { QuietRules = quiet }()
+	treeRoot = plan
+	plan.QueryOptimizer()
+	return original, treeRoot
+}
+
+// checkOptimized run sql on the tables of the test directory before and after optimization, both must
+// return the same want rows
+func checkOptimized(t *testing.T, sql string, want int) []Row {
+	t.Helper()
+	loadTestTables(t)
+	original, optimized := optimizeQuery(t, sql)
+	_, rows1, err := Execute(original)
+	if err != nil {
+		t.Fatalf("%v: %v", sql, err)
+	}
+	_, rows2, err := Execute(optimized)
+	if err != nil {
+		t.Fatalf("%v optimized: %v", sql, err)
+	}
+	if len(rows1) != want {
+		t.Errorf("%v: %d rows, want %d", sql, len(rows1), want)
+	}
+	if len(rows1) != len(rows2) || !sameRows(rows1, rows2) {
+		t.Errorf("%v: %d rows before optimization, %d rows after", sql, len(rows1), len(rows2))
+	}
+	return rows2
+}
+
+// checkResult run sql like checkOptimized, the rows formatted by FormatRow must be want in any order
+func checkResult(t *testing.T, sql string, want ...string) {
+	t.Helper()
+	rows := checkOptimized(t, sql, len(want))
+	got := make([]string, 0, len(rows))
+	for _, row := range rows {
+		got = append(got, FormatRow(row))
+	}
+	sort.Strings(got)
+	want = append([]string{}, want...)
+	sort.Strings(want)
+	if strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Errorf("%v: got %q, want %q", sql, got, want)
+	}
+}
+
+// checkOrdered run sql like checkOptimized, the rows formatted by FormatRow must be want in the same order
+// before and after optimization
+func checkOrdered(t *testing.T, sql string, want ...string) {
+	t.Helper()
+	loadTestTables(t)
+	original, optimized := optimizeQuery(t, sql)
+	for _, plan := range []*LogicalPlan{original, optimized} {
+		_, rows, err := Execute(plan)
+		if err != nil {
+			t.Fatalf("%v: %v", sql, err)
+		}
+		got := make([]string, 0, len(rows))
+		for _, row := range rows {
+			got = append(got, FormatRow(row))
+		}
+		if strings.Join(got, "; ") != strings.Join(want, "; ") {
+			t.Errorf("%v: got %q, want %q", sql, got, want)
+		}
+	}
+}
+
+// findPlan return the first node of type tp of plan in pre-order, nil if there is none
+func findPlan(plan *LogicalPlan, tp OpType) *LogicalPlan {
+	if plan.Tp == tp {
+		return plan
+	}
+	for i := range plan.child {
+		if p := findPlan(&plan.child[i], tp); p != nil {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// QueryGenerator builds random SELECT statements over Tables: nested derived tables, joins of all types,
+// GROUP BY with HAVING, ORDER BY with LIMIT, and predicates with aggregates and volatile functions.
+// All the columns are qualified and all the output columns are named, so the queries are valid in MySQL
+type QueryGenerator struct {
+	Tables   []TableDef
+	MaxDepth int //the depth of the nested derived tables and joins
+	r        *rand.Rand
+	aliases  int
+}
+
+// FuzzTables are the tables the generated queries read when no other tables are given
+var FuzzTables = []TableDef{
+	{Name: "t0", Columns: []ColumnDef{{Name: "a", Tp: test_driver.KindInt64, NotNull: true},
+		{Name: "b", Tp: test_driver.KindInt64}, {Name: "c", Tp: test_driver.KindInt64}}},
+	{Name: "t1", Columns: []ColumnDef{{Name: "a", Tp: test_driver.KindInt64},
+		{Name: "d", Tp: test_driver.KindInt64}, {Name: "s", Tp: test_driver.KindString}}},
+	{Name: "t2", Columns: []ColumnDef{{Name: "b", Tp: test_driver.KindInt64},
+		{Name: "e", Tp: test_driver.KindInt64}}},
+}
+
+func NewQueryGenerator(seed int64, tables []TableDef) *QueryGenerator {
+	return &QueryGenerator{Tables: tables, MaxDepth: 3, r: rand.New(rand.NewSource(seed))}
+}
+
+// GenQuery is a generated SELECT, possibly followed by UNION branches. It is kept as a tree
+// so that a failing query can be shrunk clause by clause
+type GenQuery struct {
+	Fields  []*genExpr
+	From    *genSource
+	Where   []*genExpr
+	GroupBy []*genExpr
+	Having  []*genExpr
+	OrderBy []genOrder
+	Limit   []int //count, or offset and count
+	Union   []*GenQuery
+	All     bool //UNION ALL between the branches
+}
+
+type genOrder struct {
+	Item *genExpr
+	Desc bool
+}
+
+// genSource is a FROM item: a base table, a derived table or a join of two sources
+type genSource struct {
+	Table       string
+	Alias       string
+	Query       *GenQuery
+	Join        string //JOIN, LEFT JOIN, RIGHT JOIN, INNER JOIN or CROSS JOIN
+	Left, Right *genSource
+	On          []*genExpr
+}
+
+// genExpr is an expression: a column or a literal held in Leaf, or an operator or a function applied to Args.
+// Binary operators are printed infix, Op is the keyword of the unary predicates like IS NULL
+type genExpr struct {
+	Leaf  string
+	Op    string
+	Func  string
+	Args  []*genExpr
+	Str   bool //the value is a string
+	Agg   bool //the expression computes an aggregate
+	Alias string
+}
+
+// genColumn is a column a query block can use
+type genColumn struct {
+	name string
+	str  bool
+}
+
+// String print the query as SQL
+func (q *GenQuery) String() string {
+	var b strings.Builder
+	q.write(&b)
+	return b.String()
+}
+
+func (q *GenQuery) write(b *strings.Builder) {
+	b.WriteString("SELECT ")
+	for i, f := range q.Fields {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(f.String())
+		if f.Alias != "" {
+			b.WriteString(" AS " + f.Alias)
+		}
+	}
+	b.WriteString(" FROM ")
+	q.From.write(b)
+	writeExprs(b, " WHERE ", " AND ", q.Where)
+	writeExprs(b, " GROUP BY ", ", ", q.GroupBy)
+	writeExprs(b, " HAVING ", " AND ", q.Having)
+	for i, item := range q.OrderBy {
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(item.Item.String())
+		if item.Desc {
+			b.WriteString(" DESC")
+		}
+	}
+	if len(q.Limit) > 0 {
+		b.WriteString(" LIMIT ")
+		for i, n := range q.Limit {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.Itoa(n))
+		}
+	}
+	for _, branch := range q.Union {
+		if q.All {
+			b.WriteString(" UNION ALL ")
+		} else {
+			b.WriteString(" UNION ")
+		}
+		branch.write(b)
+	}
+}
+
+func writeExprs(b *strings.Builder, keyword, sep string, exprs []*genExpr) {
+	for i, e := range exprs {
+		if i == 0 {
+			b.WriteString(keyword)
+		} else {
+			b.WriteString(sep)
+		}
+		b.WriteString(e.String())
+	}
+}
+
+func (s *genSource) write(b *strings.Builder) {
+	switch {
+	case s.Table != "":
+		b.WriteString(s.Table + " AS " + s.Alias)
+	case s.Query != nil:
+		b.WriteString("(")
+		s.Query.write(b)
+		b.WriteString(") AS " + s.Alias)
+	default:
+		b.WriteString("(")
+		s.Left.write(b)
+		b.WriteString(" " + s.Join + " ")
+		s.Right.write(b)
+		writeExprs(b, " ON ", " AND ", s.On)
+		b.WriteString(")")
+	}
+}
+
+func (e *genExpr) String() string {
+	switch {
+	case e.Func != "":
+		args := make([]string, 0, len(e.Args))
+		for _, arg := range e.Args {
+			args = append(args, arg.String())
+		}
+		return e.Func + "(" + strings.Join(args, ", ") + ")"
+	case e.Op == "IN" || e.Op == "NOT IN":
+		args := make([]string, 0, len(e.Args)-1)
+		for _, arg := range e.Args[1:] {
+			args = append(args, arg.String())
+		}
+		return "(" + e.Args[0].String() + " " + e.Op + " (" + strings.Join(args, ", ") + "))"
+	case e.Op == "NOT":
+		return "(NOT " + e.Args[0].String() + ")"
+	case e.Op != "" && len(e.Args) == 1:
+		return "(" + e.Args[0].String() + " " + e.Op + ")"
+	case e.Op != "":
+		return "(" + e.Args[0].String() + " " + e.Op + " " + e.Args[1].String() + ")"
+	}
+	return e.Leaf
+}
+
+// Generate build a random query
+func (g *QueryGenerator) Generate() *GenQuery {
+	g.aliases = 0
+	q, _ := g.query(g.MaxDepth, -1)
+	if g.r.Intn(8) == 0 {
+		n := len(q.Fields)
+		q.Union = append(q.Union, g.branch(n))
+		if g.r.Intn(2) == 0 {
+			q.Union = append(q.Union, g.branch(n))
+		}
+		q.All = g.r.Intn(2) == 0
+	}
+	return q
+}
+
+// branch build a UNION branch of n columns, without ORDER BY nor LIMIT which would need parentheses
+func (g *QueryGenerator) branch(n int) *GenQuery {
+	q, _ := g.query(g.MaxDepth-1, n)
+	q.OrderBy, q.Limit = nil, nil
+	return q
+}
+
+// query build a query block with fields columns, a random number if fields is -1, and return its output columns
+func (g *QueryGenerator) query(depth, fields int) (*GenQuery, []genColumn) {
+	q := &GenQuery{}
+	var scope []genColumn
+	q.From, scope = g.source(depth)
+	for i, n := 0, g.r.Intn(3); i < n; i++ {
+		q.Where = append(q.Where, g.predicate(scope, 2, false))
+	}
+	if fields == -1 {
+		fields = 1 + g.r.Intn(3)
+	}
+	var out []genColumn
+	grouped := g.r.Intn(3) == 0
+	if grouped {
+		for i, n := 0, 1+g.r.Intn(2); i < n; i++ {
+			q.GroupBy = append(q.GroupBy, g.column(scope, g.r.Intn(4) == 0))
+		}
+	}
+	for i := 0; i < fields; i++ {
+		var f *genExpr
+		switch {
+		case grouped && i < len(q.GroupBy) && g.r.Intn(3) > 0:
+			f = &genExpr{Leaf: q.GroupBy[i].Leaf, Str: q.GroupBy[i].Str}
+		case grouped || (i > 0 && g.r.Intn(5) == 0):
+			//aggregates without GROUP BY make the whole input one group, every field must be an aggregate then
+			if !grouped {
+				q.GroupBy = nil
+				grouped = true
+				return g.scalarAggregate(q, scope, fields)
+			}
+			f = g.aggregate(scope)
+		default:
+			f = g.expr(scope, 2, g.r.Intn(5) == 0)
+		}
+		f.Alias = "f" + strconv.Itoa(i)
+		q.Fields = append(q.Fields, f)
+		out = append(out, genColumn{f.Alias, f.Str})
+	}
+	if grouped && g.r.Intn(2) == 0 {
+		q.Having = append(q.Having, g.havingPredicate(q, scope))
+	}
+	g.orderAndLimit(q, out)
+	return q, out
+}
+
+// scalarAggregate make every field of q an aggregate of its whole input
+func (g *QueryGenerator) scalarAggregate(q *GenQuery, scope []genColumn, fields int) (*GenQuery, []genColumn) {
+	var out []genColumn
+	q.Fields = nil
+	for i := 0; i < fields; i++ {
+		f := g.aggregate(scope)
+		f.Alias = "f" + strconv.Itoa(i)
+		q.Fields = append(q.Fields, f)
+		out = append(out, genColumn{f.Alias, f.Str})
+	}
+	if g.r.Intn(3) == 0 {
+		q.Having = append(q.Having, g.havingPredicate(q, scope))
+	}
+	g.orderAndLimit(q, out)
+	return q, out
+}
+
+func (g *QueryGenerator) orderAndLimit(q *GenQuery, out []genColumn) {
+	if g.r.Intn(5) < 2 {
+		for i, n := 0, 1+g.r.Intn(2); i < n; i++ {
+			var item *genExpr
+			if g.r.Intn(4) == 0 {
+				item = &genExpr{Leaf: strconv.Itoa(1 + g.r.Intn(len(out)))}
+			} else {
+				item = &genExpr{Leaf: out[g.r.Intn(len(out))].name}
+			}
+			q.OrderBy = append(q.OrderBy, genOrder{item, g.r.Intn(2) == 0})
+		}
+	}
+	if g.r.Intn(4) == 0 {
+		q.Limit = []int{g.r.Intn(6)}
+		if g.r.Intn(3) == 0 {
+			q.Limit = []int{g.r.Intn(4), g.r.Intn(6)}
+		}
+	}
+}
+
+// source build a FROM item and return the columns it makes visible
+func (g *QueryGenerator) source(depth int) (*genSource, []genColumn) {
+	g.aliases++
+	alias := "q" + strconv.Itoa(g.aliases)
+	switch n := g.r.Intn(10); {
+	case depth > 0 && n < 3:
+		left, lcols := g.source(depth - 1)
+		right, rcols := g.source(depth - 1)
+		s := &genSource{Left: left, Right: right}
+		s.Join = []string{"JOIN", "LEFT JOIN", "RIGHT JOIN", "INNER JOIN", "CROSS JOIN"}[g.r.Intn(5)]
+		scope := append(append([]genColumn{}, lcols...), rcols...)
+		if s.Join != "CROSS JOIN" {
+			s.On = append(s.On, g.equality(lcols, rcols))
+			if g.r.Intn(3) == 0 {
+				s.On = append(s.On, g.predicate(scope, 1, false))
+			}
+		}
+		return s, scope
+	case depth > 0 && n < 5:
+		sub, out := g.query(depth-1, -1)
+		var scope []genColumn
+		for _, c := range out {
+			scope = append(scope, genColumn{alias + "." + c.name, c.str})
+		}
+		return &genSource{Query: sub, Alias: alias}, scope
+	}
+	table := g.Tables[g.r.Intn(len(g.Tables))]
+	var scope []genColumn
+	for _, c := range table.Columns {
+		scope = append(scope, genColumn{alias + "." + c.Name, c.Tp == test_driver.KindString})
+	}
+	return &genSource{Table: table.Name, Alias: alias}, scope
+}
+
+// equality build a join condition between a column of left and a column of right of the same type
+func (g *QueryGenerator) equality(left, right []genColumn) *genExpr {
+	l := left[g.r.Intn(len(left))]
+	for _, r := range g.r.Perm(len(right)) {
+		if right[r].str == l.str {
+			return &genExpr{Op: "=", Args: []*genExpr{{Leaf: l.name, Str: l.str}, {Leaf: right[r].name, Str: l.str}}}
+		}
+	}
+	return g.predicate(append(append([]genColumn{}, left...), right...), 1, false)
+}
+
+func (g *QueryGenerator) column(scope []genColumn, str bool) *genExpr {
+	var cols []genColumn
+	for _, c := range scope {
+		if c.str == str {
+			cols = append(cols, c)
+		}
+	}
+	if len(cols) == 0 {
+		return g.constant(str)
+	}
+	c := cols[g.r.Intn(len(cols))]
+	return &genExpr{Leaf: c.name, Str: c.str}
+}
+
+func (g *QueryGenerator) constant(str bool) *genExpr {
+	if str {
+		return &genExpr{Leaf: []string{"''", "'a'", "'b'", "'ab'"}[g.r.Intn(4)], Str: true}
+	}
+	if g.r.Intn(10) == 0 {
+		return &genExpr{Leaf: "NULL"}
+	}
+	return &genExpr{Leaf: strconv.Itoa(g.r.Intn(7) - 1)}
+}
+
+// expr build a scalar expression of depth at most depth, a string one if str is set
+func (g *QueryGenerator) expr(scope []genColumn, depth int, str bool) *genExpr {
+	n := g.r.Intn(10)
+	switch {
+	case depth == 0 || n < 4:
+		return g.column(scope, str)
+	case n < 5:
+		return g.constant(str)
+	case str:
+		return &genExpr{Func: []string{"upper", "lower"}[g.r.Intn(2)], Args: []*genExpr{g.expr(scope, depth-1, true)},
+			Str: true}
+	case n < 8:
+		op := []string{"+", "-", "*", "%", "DIV"}[g.r.Intn(5)]
+		return &genExpr{Op: op, Args: []*genExpr{g.expr(scope, depth-1, false), g.expr(scope, depth-1, false)}}
+	default:
+		switch g.r.Intn(3) {
+		case 0:
+			return &genExpr{Func: "abs", Args: []*genExpr{g.expr(scope, depth-1, false)}}
+		case 1:
+			return &genExpr{Func: "coalesce", Args: []*genExpr{g.expr(scope, depth-1, false), g.constant(false)}}
+		}
+		return &genExpr{Func: "if", Args: []*genExpr{g.predicate(scope, depth-1, false),
+			g.expr(scope, depth-1, false), g.expr(scope, depth-1, false)}}
+	}
+}
+
+// predicate build a condition of depth at most depth, volatile functions may be used unless stable is set
+func (g *QueryGenerator) predicate(scope []genColumn, depth int, stable bool) *genExpr {
+	n := g.r.Intn(12)
+	switch {
+	case !stable && n == 0:
+		return &genExpr{Op: "<", Args: []*genExpr{{Func: "rand"}, {Leaf: "0.5"}}}
+	case depth > 0 && n < 3:
+		op := []string{"AND", "OR", "XOR"}[g.r.Intn(3)]
+		return &genExpr{Op: op, Args: []*genExpr{g.predicate(scope, depth-1, stable), g.predicate(scope, depth-1, stable)}}
+	case depth > 0 && n < 4:
+		return &genExpr{Op: "NOT", Args: []*genExpr{g.predicate(scope, depth-1, stable)}}
+	case n < 5:
+		op := []string{"IS NULL", "IS NOT NULL", "IS TRUE", "IS NOT FALSE"}[g.r.Intn(4)]
+		return &genExpr{Op: op, Args: []*genExpr{g.expr(scope, depth, false)}}
+	case n < 6:
+		str := g.r.Intn(4) == 0
+		in := &genExpr{Op: []string{"IN", "NOT IN"}[g.r.Intn(2)], Args: []*genExpr{g.column(scope, str)}}
+		for i, k := 0, 1+g.r.Intn(3); i < k; i++ {
+			in.Args = append(in.Args, g.constant(str))
+		}
+		return in
+	}
+	str := g.r.Intn(5) == 0
+	op := []string{"=", "!=", "<", "<=", ">", ">=", "<=>"}[g.r.Intn(7)]
+	l := g.expr(scope, depth, str)
+	var r *genExpr
+	if g.r.Intn(2) == 0 {
+		r = g.constant(str)
+	} else {
+		r = g.expr(scope, depth, str)
+	}
+	return &genExpr{Op: op, Args: []*genExpr{l, r}}
+}
+
+func (g *QueryGenerator) aggregate(scope []genColumn) *genExpr {
+	f := []string{"count", "sum", "min", "max", "avg", "count"}[g.r.Intn(6)]
+	if f == "count" && g.r.Intn(2) == 0 {
+		return &genExpr{Func: "count", Args: []*genExpr{{Leaf: "*"}}, Agg: true}
+	}
+	str := (f == "min" || f == "max") && g.r.Intn(4) == 0
+	return &genExpr{Func: f, Args: []*genExpr{g.expr(scope, 1, str)}, Str: str, Agg: true}
+}
+
+// havingPredicate compare an aggregate or a group by column of q
+func (g *QueryGenerator) havingPredicate(q *GenQuery, scope []genColumn) *genExpr {
+	var l *genExpr
+	if len(q.GroupBy) > 0 && g.r.Intn(3) == 0 {
+		key := q.GroupBy[g.r.Intn(len(q.GroupBy))]
+		l = &genExpr{Leaf: key.Leaf, Str: key.Str}
+	} else {
+		l = g.aggregate(scope)
+	}
+	op := []string{"=", "!=", "<", "<=", ">", ">="}[g.r.Intn(6)]
+	return &genExpr{Op: op, Args: []*genExpr{l, g.constant(l.Str)}}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"strings"
+)
+
+// SchemaColumn is one output column of a LogicalPlan node
+//
+//	TblName is the qualifier seen by the parent (table AsName, table name or derived table AsName),
+//	OrigTblName and OrigColName point to the base table column, both empty for computed columns
+type SchemaColumn struct {
+	TblName     string
+	ColName     string
+	OrigTblName string
+	OrigColName string
+	Tp          byte //test_driver.KindXXX, KindNull if unknown
+	Nullable    bool
+}
+
+// Schema is the ordered list of the columns produced by a LogicalPlan node
+type Schema struct {
+	Columns []SchemaColumn
+}
+
+func (s Schema) Len() int {
+	return len(s.Columns)
+}
+
+// Match check whether the reference col may denote the schema column c
+func (c SchemaColumn) Match(col ColumnName) bool {
+	if !strings.EqualFold(c.ColName, col.ColName) {
+		return false
+	}
+	return col.TblName == "" || strings.EqualFold(c.TblName, col.TblName)
+}
+
+// ResolveColumn return the index of col in s, -1 if col is not found or is ambiguous
+func (s Schema) ResolveColumn(col ColumnName) int {
+	ret := -1
+	for i, c := range s.Columns {
+		if c.Match(col) {
+			if ret != -1 {
+				return -1
+			}
+			ret = i
+		}
+	}
+	return ret
+}
+
+// Contains check whether every column referenced by expr is produced by s
+func (s Schema) Contains(expr Expression) bool {
+	for _, col := range GetExpressionColName(expr) {
+		if s.ResolveColumn(col) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Schema derives the output columns of plan bottom-up
+func (plan *LogicalPlan) Schema() Schema {
+	switch plan.Tp {
+	case Table:
+		n := plan.Content.(TableNode)
+		if len(plan.child) == 0 {
+			return plan.baseTableSchema()
+		}
+		var ret Schema
+		for _, c := range plan.child[0].Schema().Columns {
+			c.TblName = n.Table.TblName
+			ret.Columns = append(ret.Columns, c)
+		}
+		return ret
+	case Join:
+		var left, right Schema
+		if len(plan.child) > 0 {
+			left = plan.child[0].Schema()
+		}
+		if len(plan.child) > 1 {
+			right = plan.child[1].Schema()
+		}
+		switch plan.Content.(JoinNode).Tp {
+		case ast.LeftJoin:
+			right = right.nullable()
+		case ast.RightJoin:
+			left = left.nullable()
+		case FullJoin:
+			left, right = left.nullable(), right.nullable()
+		}
+		return Schema{append(left.Columns, right.Columns...)}
+	case Project:
+		return projectSchema(plan.Content.(ProjectionNode).cols, plan.childSchema())
+	case Aggregate:
+		return projectSchema(plan.Content.(AggregateNode).cols, plan.childSchema())
+	case EmptyRelation:
+		return plan.Content.(EmptyRelationNode).Schema
+	case Union:
+		ret := plan.childSchema()
+		ret.Columns = append([]SchemaColumn{}, ret.Columns...)
+		for i := 1; i < len(plan.child); i++ {
+			for j, c := range plan.child[i].Schema().Columns {
+				if j < ret.Len() && c.Nullable {
+					ret.Columns[j].Nullable = true
+				}
+			}
+		}
+		return ret
+	default:
+		return plan.childSchema()
+	}
+}
+
+func (plan *LogicalPlan) childSchema() Schema {
+	if len(plan.child) == 0 {
+		return Schema{}
+	}
+	return plan.child[0].Schema()
+}
+
+func (s Schema) nullable() Schema {
+	ret := Schema{make([]SchemaColumn, len(s.Columns))}
+	for i, c := range s.Columns {
+		c.Nullable = true
+		ret.Columns[i] = c
+	}
+	return ret
+}
+
+// ChildOfColumn return the index of the child producing col, -1 if no child or more than one child produces it
+func (plan *LogicalPlan) ChildOfColumn(col ColumnName) int {
+	ret := -1
+	for i := range plan.child {
+		if plan.child[i].Schema().ResolveColumn(col) != -1 {
+			if ret != -1 {
+				return -1
+			}
+			ret = i
+		}
+	}
+	return ret
+}
+
+// ExprInChild check whether all the columns of expr are produced by the i-th child of plan
+func (plan *LogicalPlan) ExprInChild(expr Expression, i int) bool {
+	for _, col := range GetExpressionColName(expr) {
+		if plan.ChildOfColumn(col) != i {
+			return false
+		}
+	}
+	return true
+}
+
+func projectSchema(cols []Expression, child Schema) Schema {
+	var ret Schema
+	for _, expr := range cols {
+		if IsWildCard(expr) {
+			ret.Columns = append(ret.Columns, child.Columns...)
+			continue
+		}
+		var c SchemaColumn
+		if col, ok := SingleColumn(expr); ok {
+			if i := child.ResolveColumn(col); i != -1 {
+				c = child.Columns[i]
+			} else {
+				c = SchemaColumn{TblName: col.TblName, ColName: col.ColName, Nullable: true}
+			}
+		} else {
+			c.ColName = expr.print()
+			c.Tp, c.Nullable = ExprType(expr, child)
+		}
+		if expr.AsName != "" {
+			c.TblName = expr.AsTblName
+			c.ColName = expr.AsName
+		}
+		ret.Columns = append(ret.Columns, c)
+	}
+	return ret
+}
+
+// IsWildCard check whether expr is the `*` field of a projection
+func IsWildCard(expr Expression) bool {
+	return len(expr.expr) == 1 && len(expr.Fields) == 0 && expr.expr[0].Args == nil &&
+		expr.expr[0].Kind() == test_driver.KindString && expr.expr[0].GetString() == "*"
+}
+
+// SingleColumn return the column if expr is just a column reference
+func SingleColumn(expr Expression) (ColumnName, bool) {
+	if len(expr.expr) != 1 || expr.expr[0].Args != nil || expr.expr[0].Kind() != test_driver.KindString {
+		return ColumnName{}, false
+	}
+	col, ok := expr.Fields[expr.expr[0].GetString()]
+	return col, ok
+}
+
+type typed struct {
+	tp       byte
+	nullable bool
+}
+
+// ExprType derive the type and nullability of expr whose columns are resolved in schema
+func ExprType(expr Expression, schema Schema) (byte, bool) {
+	var s []typed
+	for _, d := range expr.expr {
+		if d.Args != nil {
+			s = append(s, funcType(d, schema))
+			continue
+		}
+		if d.Kind() != test_driver.KindString {
+			s = append(s, typed{d.Kind(), d.Kind() == test_driver.KindNull})
+			continue
+		}
+		str := d.GetString()
+		if col, ok := expr.Fields[str]; ok {
+			if i := schema.ResolveColumn(col); i != -1 {
+				s = append(s, typed{schema.Columns[i].Tp, schema.Columns[i].Nullable})
+			} else {
+				s = append(s, typed{test_driver.KindNull, true})
+			}
+			continue
+		}
+		op := StrToOp(str)
+		if op == -1 || len(s) < 2 {
+			s = append(s, typed{test_driver.KindString, false})
+			continue
+		}
+		op1, op2 := s[len(s)-2], s[len(s)-1]
+		s = s[:len(s)-2]
+		s = append(s, typed{binaryOpType(op, op1.tp, op2.tp), op1.nullable || op2.nullable || op == Div || op == IntDiv || op == Mod})
+	}
+	if len(s) == 0 {
+		return test_driver.KindNull, true
+	}
+	return s[len(s)-1].tp, s[len(s)-1].nullable
+}
+
+func binaryOpType(op MyOp, l, r byte) byte {
+	switch op {
+	case Plus, Minus, Mul, Mod:
+		return numericType(l, r)
+	case Div:
+		if numericType(l, r) == test_driver.KindFloat64 {
+			return test_driver.KindFloat64
+		}
+		return test_driver.KindMysqlDecimal
+	case IntDiv, LeftShift, RightShift, And, Or, Xor:
+		return test_driver.KindInt64
+	default:
+		//comparison and logic operators
+		return test_driver.KindInt64
+	}
+}
+
+func operatorFuncType(op MyOp, d Datum, schema Schema) typed {
+	args := make([]typed, 0, len(d.Args))
+	nullable := false
+	for _, arg := range d.Args {
+		tp, null := ExprType(arg, schema)
+		args = append(args, typed{tp, null})
+		nullable = nullable || null
+	}
+	switch {
+	case op == IsNull || op == IsTruth || op == IsFalsity:
+		return typed{test_driver.KindInt64, false}
+	case op == UnaryMinus && len(args) == 1:
+		return typed{numericType(test_driver.KindInt64, args[0].tp), args[0].nullable}
+	case op == BitNeg:
+		return typed{test_driver.KindUint64, nullable}
+	case op == Case:
+		//case(when1, then1, ..., else): the results are the odd arguments and the last one
+		var results []typed
+		for i := 1; i < len(args); i += 2 {
+			results = append(results, args[i])
+		}
+		if len(args) > 0 {
+			results = append(results, args[len(args)-1])
+		}
+		return commonType(results)
+	default:
+		//not, in, like, regexp
+		return typed{test_driver.KindInt64, nullable}
+	}
+}
+
+// commonType is the type of a value which may be any of values, like the result of CASE.
+// A string makes it a string, the numbers are combined like the arithmetic, KindNull is unknown
+func commonType(values []typed) typed {
+	ret := typed{test_driver.KindNull, false}
+	for _, v := range values {
+		ret.nullable = ret.nullable || v.nullable
+		switch {
+		case v.tp == test_driver.KindNull || v.tp == ret.tp:
+		case ret.tp == test_driver.KindNull:
+			ret.tp = v.tp
+		case isStringKind(ret.tp) || isStringKind(v.tp):
+			ret.tp = test_driver.KindString
+		default:
+			ret.tp = numericType(ret.tp, v.tp)
+		}
+	}
+	return ret
+}
+
+// numericType is the result type of the arithmetic on l and r
+func numericType(l, r byte) byte {
+	switch {
+	case l == test_driver.KindNull || r == test_driver.KindNull:
+		return test_driver.KindNull
+	case l == test_driver.KindFloat32 || l == test_driver.KindFloat64 || r == test_driver.KindFloat32 ||
+		r == test_driver.KindFloat64 || l == test_driver.KindString || r == test_driver.KindString:
+		return test_driver.KindFloat64
+	case l == test_driver.KindMysqlDecimal || r == test_driver.KindMysqlDecimal:
+		return test_driver.KindMysqlDecimal
+	case l == test_driver.KindUint64 || r == test_driver.KindUint64:
+		return test_driver.KindUint64
+	default:
+		return test_driver.KindInt64
+	}
+}
+
+// funcType use the return type rule of the registry, the result is nullable unless the function never returns
+// NULL or is null-intolerant with arguments which are not nullable. The unary operators and the other operators
+// kept as functions are typed like the binary operators
+func funcType(d Datum, schema Schema) typed {
+	if op := StrToOp(d.GetString()); op != -1 {
+		return operatorFuncType(op, d, schema)
+	}
+	def, ok := LookupFunction(d.GetString())
+	if !ok || def.ReturnType == nil {
+		return typed{test_driver.KindNull, true}
+	}
+	args := make([]byte, 0, len(d.Args))
+	nullable := false
+	for _, arg := range d.Args {
+		tp, null := ExprType(arg, schema)
+		args = append(args, tp)
+		nullable = nullable || null
+	}
+	ret := typed{def.ReturnType(args), true}
+	switch {
+	case def.NotNull:
+		ret.nullable = false
+	case def.NullIntolerant && def.Kind == ScalarFunction:
+		ret.nullable = nullable
+	}
+	return ret
+}
+
+// baseTableSchema use the Catalog if the table is registered, otherwise the columns are
+// inferred from the references to the table in its query block
+func (plan *LogicalPlan) baseTableSchema() Schema {
+	n := plan.Content.(TableNode)
+	name := n.Table.TblName
+	if name == "" {
+		name = n.Table.OrigTblName
+	}
+	var ret Schema
+	if def, ok := LookupTable(n.Table.OrigTblName); ok {
+		for _, c := range def.Columns {
+			ret.Columns = append(ret.Columns, SchemaColumn{
+				TblName:     name,
+				ColName:     c.Name,
+				OrigTblName: n.Table.OrigTblName,
+				OrigColName: c.Name,
+				Tp:          c.Tp,
+				Nullable:    !c.NotNull,
+			})
+		}
+		return ret
+	}
+	for _, col := range plan.inferTableColumns(name) {
+		ret.Columns = append(ret.Columns, SchemaColumn{
+			TblName:     name,
+			ColName:     col,
+			OrigTblName: n.Table.OrigTblName,
+			OrigColName: col,
+			Tp:          test_driver.KindNull,
+			Nullable:    true,
+		})
+	}
+	return ret
+}
+
+// inferTableColumns collect the columns qualified by name in the query block of plan, in the order of the select
+// list and then of the other clauses. Unqualified columns are only taken if plan is the single source of the block
+func (plan *LogicalPlan) inferTableColumns(name string) []string {
+	root := plan.queryBlockRoot()
+	sources := 0
+	var selected, refs []ColumnName
+	walkQueryBlock(root, func(p *LogicalPlan) {
+		if p.Tp == Table || p.Tp == EmptyRelation {
+			sources++
+		}
+		switch n := p.Content.(type) {
+		case ProjectionNode:
+			for _, expr := range n.cols {
+				selected = append(selected, GetExpressionColName(expr)...)
+			}
+		case AggregateNode:
+			for _, expr := range n.cols {
+				selected = append(selected, GetExpressionColName(expr)...)
+			}
+		}
+		for _, expr := range p.Expressions() {
+			refs = append(refs, GetExpressionColName(expr)...)
+		}
+	})
+	refs = append(selected, refs...)
+	var cols []string
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if (ref.TblName == "" && sources == 1) || (ref.TblName != "" && strings.EqualFold(ref.TblName, name)) {
+			if !seen[strings.ToLower(ref.ColName)] {
+				seen[strings.ToLower(ref.ColName)] = true
+				cols = append(cols, ref.ColName)
+			}
+		}
+	}
+	return cols
+}
+
+// IsDerivedTable check whether plan is a Table node wrapping a sub query
+func (plan *LogicalPlan) IsDerivedTable() bool {
+	return plan.Tp == Table && len(plan.child) > 0
+}
+
+// queryBlockRoot return the highest ancestor of plan in the same query block,
+// derived tables and the branches of a Union are query blocks of their own
+func (plan *LogicalPlan) queryBlockRoot() *LogicalPlan {
+	cur := plan
+	for cur.parent != nil && !cur.parent.IsDerivedTable() && cur.parent.Tp != Union {
+		cur = cur.parent
+	}
+	return cur
+}
+
+// walkQueryBlock call f on every node of the query block rooted at root, sub queries are not entered
+func walkQueryBlock(root *LogicalPlan, f func(*LogicalPlan)) {
+	f(root)
+	if root.IsDerivedTable() || root.Tp == Union {
+		return
+	}
+	for i := range root.child {
+		walkQueryBlock(&root.child[i], f)
+	}
+}
+
+// Expressions return all the expressions held by the node
+func (plan *LogicalPlan) Expressions() []Expression {
+	switch n := plan.Content.(type) {
+	case ProjectionNode:
+		return n.cols
+	case AggregateNode:
+		return append(append([]Expression{}, n.cols...), n.Items...)
+	case JoinNode:
+		return n.On
+	case GroupByNode:
+		return n.Items
+	case HavingFilterNode:
+		return n.Expr
+	case WhereFilterNode:
+		return n.Expr
+	case OrderByNode:
+		var ret []Expression
+		for _, item := range n.Items {
+			ret = append(ret, item.Item)
+		}
+		return ret
+	case LimitNode:
+		return []Expression{n.Count, n.Offset}
+	case TopNNode:
+		var ret []Expression
+		for _, item := range n.Items {
+			ret = append(ret, item.Item)
+		}
+		return append(ret, n.Count, n.Offset)
+	}
+	return nil
+}
+
+// RequiredColumns mark the columns of every base table of plan which the query needs, by Table node.
+// A column is needed when it reaches the output of the query, of a derived table or of a UNION ALL through
+// nodes passing their input, when it may be referenced by an expression above it, or when a `*` reads it.
+// The references are matched by name, a column is marked whenever one of the references may denote it
+func RequiredColumns(plan *LogicalPlan) map[*LogicalPlan][]bool {
+	ret := make(map[*LogicalPlan][]bool)
+	needed := make([]bool, plan.Schema().Len())
+	for i := range needed {
+		needed[i] = true
+	}
+	markRequired(plan, needed, nil, ret)
+	return ret
+}
+
+// markRequired : needed marks the output columns of plan used by its parent, refs are the column references
+// of the ancestors of plan
+func markRequired(plan *LogicalPlan, needed []bool, refs []ColumnName, ret map[*LogicalPlan][]bool) {
+	wildCard := false
+	refs = append([]ColumnName{}, refs...)
+	for _, expr := range plan.Expressions() {
+		wildCard = wildCard || IsWildCard(expr)
+		refs = append(refs, GetExpressionColName(expr)...)
+	}
+	if plan.Tp == Table && len(plan.child) == 0 {
+		schema := plan.Schema()
+		required := make([]bool, schema.Len())
+		for i, c := range schema.Columns {
+			required[i] = i < len(needed) && needed[i]
+			for _, ref := range refs {
+				required[i] = required[i] || c.Match(ref)
+			}
+		}
+		ret[plan] = required
+		return
+	}
+	offset := 0
+	for i := range plan.child {
+		width := plan.child[i].Schema().Len()
+		childNeeded := make([]bool, width)
+		for j := range childNeeded {
+			switch {
+			case plan.Tp == Project || plan.Tp == Aggregate:
+				childNeeded[j] = wildCard
+			case plan.Tp == Union && !plan.Content.(UnionNode).All:
+				//the rows are distinct on all the columns
+				childNeeded[j] = true
+			case plan.Tp == Join:
+				childNeeded[j] = offset+j < len(needed) && needed[offset+j]
+			default:
+				childNeeded[j] = j < len(needed) && needed[j]
+			}
+		}
+		offset += width
+		markRequired(&plan.child[i], childNeeded, refs, ret)
+	}
+}
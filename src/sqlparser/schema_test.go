@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// formatSchema print every column as table.column:kind, followed by ? if it is nullable
+func formatSchema(s Schema) string {
+	var ret []string
+	for _, c := range s.Columns {
+		str := c.TblName + "." + c.ColName + ":" + datumKindNames[c.Tp]
+		if c.Nullable {
+			str += "?"
+		}
+		ret = append(ret, str)
+	}
+	return strings.Join(ret, " ")
+}
+
+func TestPlanSchema(t *testing.T) {
+	loadTestTables(t)
+	tests := []struct {
+		sql, want string
+	}{
+		{"select * from t", "t.a:int64 t.b:int64?"},
+		{"select t.a, s.c from t left join s on t.a = s.a", "t.a:int64 s.c:string?"},
+		{"select s.a, t.b from t right join s on t.a = s.a", "s.a:int64? t.b:int64?"},
+		{"select a, count(*), sum(b) from testdata2 group by a", "testdata2.a:int64? .count(1):int64 .sum(b):decimal?"},
+		{"select x.k, x.a from (select a as k, a + 1 as a from t) x", "x.k:int64 x.a:int64"},
+		{"select a from t union all select a from s", "t.a:int64?"},
+		{"select b / 2, a div 2 from t", ".(b/2):decimal? .(aDIV2):int64?"},
+	}
+	for _, test := range tests {
+		original, optimized := optimizeQuery(t, test.sql)
+		for _, plan := range []*LogicalPlan{original, optimized} {
+			if got := formatSchema(plan.Schema()); got != test.want {
+				t.Errorf("%v: the schema is %v, want %v", test.sql, got, test.want)
+			}
+		}
+	}
+}
+
+func TestOperatorTypes(t *testing.T) {
+	loadTestTables(t)
+	tests := []struct {
+		expr     string
+		tp       byte
+		nullable bool
+	}{
+		{"-a", test_driver.KindInt64, false},
+		{"-b", test_driver.KindInt64, true},
+		{"-(a + 1)", test_driver.KindInt64, false},
+		{"~a", test_driver.KindUint64, false},
+		{"not a", test_driver.KindInt64, false},
+		{"!b", test_driver.KindInt64, true},
+		{"a is null", test_driver.KindInt64, false},
+		{"b is not null", test_driver.KindInt64, false},
+		{"b in (1, 2)", test_driver.KindInt64, true},
+		{"a in (1, 2)", test_driver.KindInt64, false},
+		{"case when a > 1 then b else 2.5 end", test_driver.KindMysqlDecimal, true},
+		{"case when a > 1 then a else 0 end", test_driver.KindInt64, false},
+		{"case when a > 1 then 1 end", test_driver.KindInt64, true},
+	}
+	for _, test := range tests {
+		_, plan := optimizeQuery(t, "select "+test.expr+" from t")
+		col := findPlan(plan, Project).Schema().Columns[0]
+		if col.Tp != test.tp || col.Nullable != test.nullable {
+			t.Errorf("%v has type %v nullable %v, want %v nullable %v", test.expr, col.Tp, col.Nullable, test.tp, test.nullable)
+		}
+	}
+}
+
+func TestInferredColumnsFollowSelectOrder(t *testing.T) {
+	loadTestTables(t)
+	for i := 0; i < 10; i++ {
+		_, plan := optimizeQuery(t, "select zb, za + zd from nosuch where zc > 1 order by za")
+		var names []string
+		for _, col := range findPlan(plan, Table).Schema().Columns {
+			names = append(names, col.ColName)
+		}
+		if len(names) != 4 || names[0] != "zb" || names[1] != "za" || names[2] != "zd" || names[3] != "zc" {
+			t.Fatalf("the columns of nosuch are %v, want [zb za zd zc]", names)
+		}
+	}
+}
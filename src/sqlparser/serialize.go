@@ -0,0 +1,584 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"strconv"
+)
+
+// PlanFormatVersion is the version of the JSON encoding of LogicalPlan,
+// bump it whenever the layout below changes in an incompatible way
+const PlanFormatVersion = 1
+
+type planFileJSON struct {
+	Version int       `json:"version"`
+	Plan    *planJSON `json:"plan"`
+}
+
+type planJSON struct {
+	Op       string          `json:"op"`
+	Content  json.RawMessage `json:"content,omitempty"`
+	Children []*planJSON     `json:"children,omitempty"`
+}
+
+type columnJSON struct {
+	OrigTblName string `json:"origTable,omitempty"`
+	OrigColName string `json:"origColumn,omitempty"`
+	DBName      string `json:"db,omitempty"`
+	TblName     string `json:"table,omitempty"`
+	ColName     string `json:"column,omitempty"`
+}
+
+// datumJSON keeps the kind of the datum and its value in a lossless text form.
+// Args is a pointer so that a function without arguments (Args != nil) is kept apart from a column
+type datumJSON struct {
+	Kind  string      `json:"kind"`
+	Value string      `json:"value,omitempty"`
+	Args  *[]exprJSON `json:"args,omitempty"`
+}
+
+// exprJSON : Expr and Fields are not omitted, so that nil and empty values round trip exactly
+type exprJSON struct {
+	Expr      []datumJSON           `json:"expr"`
+	Fields    map[string]columnJSON `json:"fields"`
+	AsName    string                `json:"as,omitempty"`
+	AsTblName string                `json:"asTable,omitempty"`
+}
+
+type projectionJSON struct {
+	Cols []exprJSON `json:"cols"`
+}
+
+type aggregateJSON struct {
+	Cols    []exprJSON `json:"cols"`
+	GroupBy []exprJSON `json:"groupBy"`
+	Mode    string     `json:"mode,omitempty"`
+}
+
+type joinJSON struct {
+	Tp string     `json:"type"`
+	On []exprJSON `json:"on"`
+}
+
+type tableJSON struct {
+	Table columnJSON `json:"table"`
+}
+
+type filterJSON struct {
+	Expr []exprJSON `json:"expr"`
+}
+
+type byItemJSON struct {
+	Item exprJSON `json:"item"`
+	Desc bool     `json:"desc,omitempty"`
+}
+
+type orderByJSON struct {
+	Items []byItemJSON `json:"items"`
+}
+
+type unionJSON struct {
+	All bool `json:"all,omitempty"`
+}
+
+type topNJSON struct {
+	Items   []byItemJSON `json:"items"`
+	Count   exprJSON     `json:"count"`
+	Offset  exprJSON     `json:"offset"`
+	HasPush bool         `json:"hasPush,omitempty"`
+}
+
+type schemaColumnJSON struct {
+	TblName     string `json:"table,omitempty"`
+	ColName     string `json:"column"`
+	OrigTblName string `json:"origTable,omitempty"`
+	OrigColName string `json:"origColumn,omitempty"`
+	Tp          string `json:"type"`
+	Nullable    bool   `json:"nullable,omitempty"`
+}
+
+type emptyRelationJSON struct {
+	Columns []schemaColumnJSON `json:"columns"`
+}
+
+type limitJSON struct {
+	Count   exprJSON `json:"count"`
+	Offset  exprJSON `json:"offset"`
+	HasPush bool     `json:"hasPush,omitempty"`
+}
+
+var datumKindNames = map[byte]string{
+	test_driver.KindNull:          "null",
+	test_driver.KindInt64:         "int64",
+	test_driver.KindUint64:        "uint64",
+	test_driver.KindFloat32:       "float32",
+	test_driver.KindFloat64:       "float64",
+	test_driver.KindString:        "string",
+	test_driver.KindBytes:         "bytes",
+	test_driver.KindBinaryLiteral: "binary",
+	test_driver.KindMysqlDecimal:  "decimal",
+}
+
+var joinTypeNames = map[ast.JoinType]string{
+	0:             "single",
+	ast.CrossJoin: "cross",
+	ast.LeftJoin:  "left",
+	ast.RightJoin: "right",
+	InnerJoin:     "inner",
+	FullJoin:      "full",
+}
+
+// MarshalJSON encodes the subtree rooted at plan together with the format version
+func (plan *LogicalPlan) MarshalJSON() ([]byte, error) {
+	enc := new(planEncoder)
+	p := enc.encodePlan(plan)
+	if enc.err != nil {
+		return nil, enc.err
+	}
+	return json.Marshal(planFileJSON{Version: PlanFormatVersion, Plan: p})
+}
+
+// UnmarshalJSON decodes a plan written by MarshalJSON and rebuilds the parent links
+func (plan *LogicalPlan) UnmarshalJSON(data []byte) error {
+	var file planFileJSON
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.Version != PlanFormatVersion {
+		return fmt.Errorf("unsupported plan format version %v, expect %v", file.Version, PlanFormatVersion)
+	}
+	if file.Plan == nil {
+		return fmt.Errorf("plan is missing")
+	}
+	p, err := decodePlan(file.Plan)
+	if err != nil {
+		return err
+	}
+	*plan = *p
+	plan.parent = nil
+	plan.ResetParent()
+	return nil
+}
+
+// LoadLogicalPlan builds a LogicalPlan from its JSON encoding, the parent links are rebuilt
+func LoadLogicalPlan(data []byte) (*LogicalPlan, error) {
+	plan := new(LogicalPlan)
+	if err := plan.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// CopyLogicalPlan deep copy the subtree rooted at plan through its JSON encoding
+func CopyLogicalPlan(plan *LogicalPlan) (*LogicalPlan, error) {
+	data, err := plan.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return LoadLogicalPlan(data)
+}
+
+// planEncoder remembers the first error, so the encode helpers can be nested freely. fingerprint leaves out
+// the base table columns the column references of the expressions were resolved to, they do not change the result
+type planEncoder struct {
+	err         error
+	fingerprint bool
+}
+
+func (enc *planEncoder) encodePlan(plan *LogicalPlan) *planJSON {
+	var content interface{}
+	switch plan.Tp {
+	case Project:
+		content = projectionJSON{enc.encodeExprs(plan.Content.(ProjectionNode).cols)}
+	case Aggregate:
+		n := plan.Content.(AggregateNode)
+		var mode string
+		if n.Mode != CompleteAggregate {
+			mode = AggregateModeNames[n.Mode]
+		}
+		content = aggregateJSON{enc.encodeExprs(n.cols), enc.encodeExprs(n.Items), mode}
+	case Join:
+		n := plan.Content.(JoinNode)
+		name, ok := joinTypeNames[n.Tp]
+		if !ok {
+			enc.err = fmt.Errorf("unknown join type %v", n.Tp)
+		}
+		content = joinJSON{name, enc.encodeExprs(n.On)}
+	case Table:
+		content = tableJSON{enc.encodeColumn(plan.Content.(TableNode).Table)}
+	case GroupBy:
+		content = filterJSON{enc.encodeExprs(plan.Content.(GroupByNode).Items)}
+	case HavingFilter:
+		content = filterJSON{enc.encodeExprs(plan.Content.(HavingFilterNode).Expr)}
+	case Filter:
+		content = filterJSON{enc.encodeExprs(plan.Content.(WhereFilterNode).Expr)}
+	case OrderBy:
+		content = orderByJSON{enc.encodeByItems(plan.Content.(OrderByNode).Items)}
+	case Limit:
+		n := plan.Content.(LimitNode)
+		content = limitJSON{enc.encodeExpr(n.Count), enc.encodeExpr(n.Offset), n.hasPush}
+	case EmptyRelation:
+		n := plan.Content.(EmptyRelationNode)
+		cols := make([]schemaColumnJSON, 0, n.Schema.Len())
+		for _, c := range n.Schema.Columns {
+			cols = append(cols, enc.encodeSchemaColumn(c))
+		}
+		content = emptyRelationJSON{cols}
+	case Union:
+		content = unionJSON{plan.Content.(UnionNode).All}
+	case TopN:
+		n := plan.Content.(TopNNode)
+		content = topNJSON{enc.encodeByItems(n.Items), enc.encodeExpr(n.Count), enc.encodeExpr(n.Offset), n.hasPush}
+	default:
+		enc.err = fmt.Errorf("unknown OpType %v", plan.Tp)
+		return nil
+	}
+	raw, err := json.Marshal(content)
+	if err != nil && enc.err == nil {
+		enc.err = err
+	}
+	ret := &planJSON{Op: plan.Tp.String(), Content: raw}
+	for i := range plan.child {
+		ret.Children = append(ret.Children, enc.encodePlan(&plan.child[i]))
+	}
+	return ret
+}
+
+func decodePlan(p *planJSON) (*LogicalPlan, error) {
+	tp := StrToOpType(p.Op)
+	var content interface{}
+	var err error
+	switch tp {
+	case Project:
+		var n projectionJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			var cols []Expression
+			cols, err = decodeExprs(n.Cols)
+			content = ProjectionNode{cols}
+		}
+	case Aggregate:
+		var n aggregateJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			var cols, items []Expression
+			if cols, err = decodeExprs(n.Cols); err == nil {
+				items, err = decodeExprs(n.GroupBy)
+			}
+			var mode = CompleteAggregate
+			if n.Mode != "" {
+				mode = -1
+				for i, v := range AggregateModeNames {
+					if v == n.Mode {
+						mode = AggregateMode(i)
+					}
+				}
+				if mode == -1 && err == nil {
+					err = fmt.Errorf("unknown aggregate mode %q", n.Mode)
+				}
+			}
+			content = AggregateNode{ProjectionNode{cols}, GroupByNode{items}, mode}
+		}
+	case Join:
+		var n joinJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			var on []Expression
+			on, err = decodeExprs(n.On)
+			var jt ast.JoinType = -1
+			for k, v := range joinTypeNames {
+				if v == n.Tp {
+					jt = k
+				}
+			}
+			if jt == -1 {
+				err = fmt.Errorf("unknown join type %q", n.Tp)
+			}
+			content = JoinNode{jt, on}
+		}
+	case Table:
+		var n tableJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			content = TableNode{decodeColumn(n.Table)}
+		}
+	case GroupBy, HavingFilter, Filter:
+		var n filterJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			var exprs []Expression
+			exprs, err = decodeExprs(n.Expr)
+			switch tp {
+			case GroupBy:
+				content = GroupByNode{exprs}
+			case HavingFilter:
+				content = HavingFilterNode{exprs}
+			default:
+				content = WhereFilterNode{exprs}
+			}
+		}
+	case OrderBy:
+		var n orderByJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			var items []ByItem
+			items, err = decodeByItems(n.Items)
+			content = OrderByNode{items}
+		}
+	case Limit:
+		var n limitJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			var count, offset Expression
+			if count, err = decodeExpr(n.Count); err == nil {
+				offset, err = decodeExpr(n.Offset)
+			}
+			content = LimitNode{count, offset, n.HasPush}
+		}
+	case EmptyRelation:
+		var n emptyRelationJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			var schema Schema
+			for _, c := range n.Columns {
+				var col SchemaColumn
+				if col, err = decodeSchemaColumn(c); err != nil {
+					break
+				}
+				schema.Columns = append(schema.Columns, col)
+			}
+			content = EmptyRelationNode{schema}
+		}
+	case Union:
+		var n unionJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			content = UnionNode{n.All}
+		}
+	case TopN:
+		var n topNJSON
+		if err = json.Unmarshal(p.Content, &n); err == nil {
+			var items []ByItem
+			var count, offset Expression
+			if items, err = decodeByItems(n.Items); err == nil {
+				if count, err = decodeExpr(n.Count); err == nil {
+					offset, err = decodeExpr(n.Offset)
+				}
+			}
+			content = TopNNode{items, count, offset, n.HasPush}
+		}
+	default:
+		return nil, fmt.Errorf("unknown op %q", p.Op)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode %v: %v", p.Op, err)
+	}
+	plan := OpNodeInit(tp, content)
+	for _, c := range p.Children {
+		child, err := decodePlan(c)
+		if err != nil {
+			return nil, err
+		}
+		plan.child = append(plan.child, *child)
+	}
+	return plan, nil
+}
+
+func (enc *planEncoder) encodeColumn(c ColumnName) columnJSON {
+	return columnJSON{c.OrigTblName, c.OrigColName, c.DBName, c.TblName, c.ColName}
+}
+
+func decodeColumn(c columnJSON) ColumnName {
+	return ColumnName{c.OrigTblName, c.OrigColName, c.DBName, c.TblName, c.ColName}
+}
+
+func (enc *planEncoder) encodeByItems(items []ByItem) []byItemJSON {
+	if items == nil {
+		return nil
+	}
+	ret := make([]byItemJSON, 0, len(items))
+	for _, item := range items {
+		ret = append(ret, byItemJSON{enc.encodeExpr(item.Item), item.Desc})
+	}
+	return ret
+}
+
+func decodeByItems(items []byItemJSON) ([]ByItem, error) {
+	if items == nil {
+		return nil, nil
+	}
+	ret := make([]ByItem, 0, len(items))
+	for _, item := range items {
+		e, err := decodeExpr(item.Item)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ByItem{e, item.Desc})
+	}
+	return ret, nil
+}
+
+func (enc *planEncoder) encodeSchemaColumn(c SchemaColumn) schemaColumnJSON {
+	kind, ok := datumKindNames[c.Tp]
+	if !ok {
+		enc.err = fmt.Errorf("unsupported column type %v", c.Tp)
+	}
+	return schemaColumnJSON{c.TblName, c.ColName, c.OrigTblName, c.OrigColName, kind, c.Nullable}
+}
+
+func decodeSchemaColumn(c schemaColumnJSON) (SchemaColumn, error) {
+	for k, v := range datumKindNames {
+		if v == c.Tp {
+			return SchemaColumn{c.TblName, c.ColName, c.OrigTblName, c.OrigColName, k, c.Nullable}, nil
+		}
+	}
+	return SchemaColumn{}, fmt.Errorf("unknown column type %q", c.Tp)
+}
+
+func (enc *planEncoder) encodeExprs(exprs []Expression) []exprJSON {
+	if exprs == nil {
+		return nil
+	}
+	ret := make([]exprJSON, 0, len(exprs))
+	for _, expr := range exprs {
+		ret = append(ret, enc.encodeExpr(expr))
+	}
+	return ret
+}
+
+func decodeExprs(exprs []exprJSON) ([]Expression, error) {
+	if exprs == nil {
+		return nil, nil
+	}
+	ret := make([]Expression, 0, len(exprs))
+	for _, e := range exprs {
+		expr, err := decodeExpr(e)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, expr)
+	}
+	return ret, nil
+}
+
+func (enc *planEncoder) encodeExpr(expr Expression) exprJSON {
+	var ret exprJSON
+	ret.AsName, ret.AsTblName = expr.AsName, expr.AsTblName
+	if expr.expr != nil {
+		ret.Expr = make([]datumJSON, 0, len(expr.expr))
+	}
+	for _, d := range expr.expr {
+		ret.Expr = append(ret.Expr, enc.encodeDatum(d))
+	}
+	if expr.Fields != nil {
+		ret.Fields = make(map[string]columnJSON, len(expr.Fields))
+	}
+	for k, v := range expr.Fields {
+		if enc.fingerprint {
+			v.OrigTblName, v.OrigColName = "", ""
+		}
+		ret.Fields[k] = enc.encodeColumn(v)
+	}
+	return ret
+}
+
+func decodeExpr(e exprJSON) (Expression, error) {
+	var ret Expression
+	ret.AsName, ret.AsTblName = e.AsName, e.AsTblName
+	if e.Expr != nil {
+		ret.expr = make([]Datum, 0, len(e.Expr))
+	}
+	for _, d := range e.Expr {
+		datum, err := decodeDatum(d)
+		if err != nil {
+			return ret, err
+		}
+		ret.expr = append(ret.expr, datum)
+	}
+	if e.Fields != nil {
+		ret.Fields = make(map[string]ColumnName, len(e.Fields))
+	}
+	for k, v := range e.Fields {
+		ret.Fields[k] = decodeColumn(v)
+	}
+	return ret, nil
+}
+
+func (enc *planEncoder) encodeDatum(d Datum) datumJSON {
+	var ret datumJSON
+	kind, ok := datumKindNames[d.Kind()]
+	if !ok {
+		enc.err = fmt.Errorf("unsupported datum kind %v", d.Kind())
+	}
+	ret.Kind = kind
+	switch d.Kind() {
+	case test_driver.KindNull:
+	case test_driver.KindInt64:
+		ret.Value = strconv.FormatInt(d.GetInt64(), 10)
+	case test_driver.KindUint64:
+		ret.Value = strconv.FormatUint(d.GetUint64(), 10)
+	case test_driver.KindFloat32:
+		ret.Value = strconv.FormatFloat(float64(d.GetFloat32()), 'g', -1, 32)
+	case test_driver.KindFloat64:
+		ret.Value = strconv.FormatFloat(d.GetFloat64(), 'g', -1, 64)
+	case test_driver.KindString:
+		ret.Value = d.GetString()
+	case test_driver.KindBytes, test_driver.KindBinaryLiteral:
+		ret.Value = base64.StdEncoding.EncodeToString(d.GetBytes())
+	case test_driver.KindMysqlDecimal:
+		ret.Value = d.GetMysqlDecimal().String()
+	}
+	if d.Args != nil {
+		args := enc.encodeExprs(d.Args)
+		if args == nil {
+			args = []exprJSON{}
+		}
+		ret.Args = &args
+	}
+	return ret
+}
+
+func decodeDatum(d datumJSON) (Datum, error) {
+	var ret Datum
+	var err error
+	switch d.Kind {
+	case "null":
+		ret.SetNull()
+	case "int64":
+		var v int64
+		v, err = strconv.ParseInt(d.Value, 10, 64)
+		ret.SetInt64(v)
+	case "uint64":
+		var v uint64
+		v, err = strconv.ParseUint(d.Value, 10, 64)
+		ret.SetUint64(v)
+	case "float32":
+		var v float64
+		v, err = strconv.ParseFloat(d.Value, 32)
+		ret.SetFloat32(float32(v))
+	case "float64":
+		var v float64
+		v, err = strconv.ParseFloat(d.Value, 64)
+		ret.SetFloat64(v)
+	case "string":
+		ret.SetString(d.Value)
+	case "bytes", "binary":
+		var v []byte
+		v, err = base64.StdEncoding.DecodeString(d.Value)
+		if d.Kind == "bytes" {
+			ret.SetBytes(v)
+		} else {
+			ret.SetBinaryLiteral(v)
+		}
+	case "decimal":
+		v := new(test_driver.MyDecimal)
+		err = v.FromString([]byte(d.Value))
+		ret.SetMysqlDecimal(v)
+	default:
+		err = fmt.Errorf("unknown datum kind %q", d.Kind)
+	}
+	if err != nil {
+		return ret, err
+	}
+	if d.Args != nil {
+		ret.Args, err = decodeExprs(*d.Args)
+		if err == nil && ret.Args == nil {
+			ret.Args = []Expression{}
+		}
+	}
+	return ret, err
+}
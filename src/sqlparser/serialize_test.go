@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var roundTripQueries = []string{
+	"select a, b + 1.5 as c from t where b > 20 and a in (1, 2, 3) order by a desc limit 2, 3",
+	"select t.a, s.c from t left join s on t.a = s.a where s.c like 'f%' or s.c is null",
+	"select a, count(*), sum(b) from testdata2 group by a having count(*) > 1",
+	"select x.a from (select a, b from t where b is not null) x where x.a > 3 union select a from s",
+	"select case when a > 5 then 'big' else null end, -a, ~a, 0x41 from t",
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	loadTestTables(t)
+	for _, sql := range roundTripQueries {
+		original, optimized := optimizeQuery(t, sql)
+		for _, plan := range []*LogicalPlan{original, optimized} {
+			data, err := plan.MarshalJSON()
+			if err != nil {
+				t.Fatalf("%v: %v", sql, err)
+			}
+			loaded, err := LoadLogicalPlan(data)
+			if err != nil {
+				t.Fatalf("%v: %v", sql, err)
+			}
+			again, err := loaded.MarshalJSON()
+			if err != nil {
+				t.Fatalf("%v: %v", sql, err)
+			}
+			if string(data) != string(again) {
+				t.Errorf("%v: the plan changed when loaded:\n%s\n%s", sql, data, again)
+			}
+			_, rows1, err1 := Execute(plan)
+			_, rows2, err2 := Execute(loaded)
+			if err1 != nil || err2 != nil {
+				t.Fatalf("%v: %v, %v", sql, err1, err2)
+			}
+			if len(rows1) != len(rows2) || !sameRows(rows1, rows2) {
+				t.Errorf("%v: %d rows before the round trip, %d rows after", sql, len(rows1), len(rows2))
+			}
+		}
+	}
+}
+
+func TestLoadLogicalPlanChecksVersion(t *testing.T) {
+	loadTestTables(t)
+	_, plan := optimizeQuery(t, "select a from t")
+	data, err := plan.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), `"version":1`, `"version":99`, 1))
+	if _, err := LoadLogicalPlan(data); err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("a plan of version 99 is loaded with error %v", err)
+	}
+	if _, err := LoadLogicalPlan([]byte(`{"version":1}`)); err == nil {
+		t.Error("a file without a plan is loaded")
+	}
+}
+
+func TestCopyLogicalPlanRebuildsParents(t *testing.T) {
+	loadTestTables(t)
+	_, plan := optimizeQuery(t, "select t.a from t join s on t.a = s.a where t.b > 1")
+	copied, err := CopyLogicalPlan(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var check func(p *LogicalPlan)
+	check = func(p *LogicalPlan) {
+		for i := range p.child {
+			if p.child[i].parent != p {
+				t.Errorf("the parent of the %v below %v is not rebuilt", p.child[i].Tp, p.Tp)
+			}
+			check(&p.child[i])
+		}
+	}
+	if copied.parent != nil {
+		t.Error("the root of the copy has a parent")
+	}
+	check(copied)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+)
+
+// StatsFormatVersion is the version of the JSON statistics file
+const StatsFormatVersion = 1
+
+// The sizes used when the statistics are computed from data
+var (
+	DefaultHistogramBuckets = 32
+	DefaultMCVCount         = 10
+)
+
+// Bucket of an equi-depth histogram holds the values in (Upper of the previous bucket, Upper],
+// the first bucket starts at the Min of the column
+type Bucket struct {
+	Upper Datum
+	Count float64
+	NDV   float64
+}
+
+// MCVItem is a most common value and the fraction of the rows of the table holding it
+type MCVItem struct {
+	Value    Datum
+	Fraction float64
+}
+
+// ColumnStats describes the values of a column, Histogram is built on the non-NULL values and may be empty,
+// Min and Max are nil if unknown
+type ColumnStats struct {
+	NDV          float64
+	NullFraction float64
+	Min, Max     *Datum
+	Histogram    []Bucket
+	MCV          []MCVItem
+}
+
+type TableStats struct {
+	Rows    float64
+	Columns map[string]*ColumnStats //lower case column name
+}
+
+// Column return the statistics of the column name
+func (s *TableStats) Column(name string) (*ColumnStats, bool) {
+	c, ok := s.Columns[strings.ToLower(name)]
+	return c, ok
+}
+
+// Statistics maps the lower case table name to its statistics
+var Statistics = make(map[string]*TableStats)
+
+func RegisterStats(table string, stats *TableStats) {
+	Statistics[strings.ToLower(table)] = stats
+}
+
+func LookupStats(table string) (*TableStats, bool) {
+	s, ok := Statistics[strings.ToLower(table)]
+	return s, ok
+}
+
+type bucketJSON struct {
+	Upper datumJSON `json:"upper"`
+	Count float64   `json:"count"`
+	NDV   float64   `json:"ndv"`
+}
+
+type mcvJSON struct {
+	Value    datumJSON `json:"value"`
+	Fraction float64   `json:"fraction"`
+}
+
+type columnStatsJSON struct {
+	NDV          float64      `json:"ndv"`
+	NullFraction float64      `json:"nullFraction,omitempty"`
+	Min          *datumJSON   `json:"min,omitempty"`
+	Max          *datumJSON   `json:"max,omitempty"`
+	Histogram    []bucketJSON `json:"histogram,omitempty"`
+	MCV          []mcvJSON    `json:"mcv,omitempty"`
+}
+
+type tableStatsJSON struct {
+	Rows    float64                    `json:"rows"`
+	Columns map[string]columnStatsJSON `json:"columns,omitempty"`
+}
+
+type statsFileJSON struct {
+	Version int                       `json:"version"`
+	Tables  map[string]tableStatsJSON `json:"tables"`
+}
+
+// MarshalStats encode all the registered statistics
+func MarshalStats() ([]byte, error) {
+	enc := new(planEncoder)
+	file := statsFileJSON{Version: StatsFormatVersion, Tables: make(map[string]tableStatsJSON)}
+	for name, t := range Statistics {
+		tbl := tableStatsJSON{Rows: t.Rows, Columns: make(map[string]columnStatsJSON)}
+		for col, c := range t.Columns {
+			cj := columnStatsJSON{NDV: c.NDV, NullFraction: c.NullFraction}
+			if c.Min != nil {
+				d := enc.encodeDatum(*c.Min)
+				cj.Min = &d
+			}
+			if c.Max != nil {
+				d := enc.encodeDatum(*c.Max)
+				cj.Max = &d
+			}
+			for _, b := range c.Histogram {
+				cj.Histogram = append(cj.Histogram, bucketJSON{enc.encodeDatum(b.Upper), b.Count, b.NDV})
+			}
+			for _, m := range c.MCV {
+				cj.MCV = append(cj.MCV, mcvJSON{enc.encodeDatum(m.Value), m.Fraction})
+			}
+			tbl.Columns[col] = cj
+		}
+		file.Tables[name] = tbl
+	}
+	if enc.err != nil {
+		return nil, enc.err
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// LoadStats register the statistics of the JSON statistics file data
+func LoadStats(data []byte) error {
+	var file statsFileJSON
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.Version != StatsFormatVersion {
+		return fmt.Errorf("unsupported statistics format version %d", file.Version)
+	}
+	decode := func(d *datumJSON) (*Datum, error) {
+		if d == nil {
+			return nil, nil
+		}
+		v, err := decodeDatum(*d)
+		return &v, err
+	}
+	for name, t := range file.Tables {
+		stats := &TableStats{Rows: t.Rows, Columns: make(map[string]*ColumnStats)}
+		for col, cj := range t.Columns {
+			c := &ColumnStats{NDV: cj.NDV, NullFraction: cj.NullFraction}
+			var err error
+			if c.Min, err = decode(cj.Min); err != nil {
+				return err
+			}
+			if c.Max, err = decode(cj.Max); err != nil {
+				return err
+			}
+			for _, b := range cj.Histogram {
+				upper, err := decodeDatum(b.Upper)
+				if err != nil {
+					return err
+				}
+				c.Histogram = append(c.Histogram, Bucket{upper, b.Count, b.NDV})
+			}
+			for _, m := range cj.MCV {
+				v, err := decodeDatum(m.Value)
+				if err != nil {
+					return err
+				}
+				c.MCV = append(c.MCV, MCVItem{v, m.Fraction})
+			}
+			stats.Columns[strings.ToLower(col)] = c
+		}
+		RegisterStats(name, stats)
+	}
+	return nil
+}
+
+func LoadStatsFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return LoadStats(data)
+}
+
+// ComputeTableStats compute the statistics of the rows, whose values are in the order of columns
+func ComputeTableStats(columns []string, rows [][]Datum) *TableStats {
+	ret := &TableStats{Rows: float64(len(rows)), Columns: make(map[string]*ColumnStats)}
+	for i, col := range columns {
+		values := make([]Datum, 0, len(rows))
+		for _, row := range rows {
+			values = append(values, row[i])
+		}
+		ret.Columns[strings.ToLower(col)] = ComputeColumnStats(values, DefaultHistogramBuckets, DefaultMCVCount)
+	}
+	return ret
+}
+
+// ComputeColumnStats compute the statistics of the values of a column, with at most buckets histogram buckets
+// and mcvs most common values. A value is only common if it appears more often than the average value
+func ComputeColumnStats(values []Datum, buckets, mcvs int) *ColumnStats {
+	ret := new(ColumnStats)
+	if len(values) == 0 {
+		return ret
+	}
+	var notNull []Datum
+	for _, v := range values {
+		if v.Kind() != test_driver.KindNull {
+			notNull = append(notNull, v)
+		}
+	}
+	total := float64(len(values))
+	ret.NullFraction = 1 - float64(len(notNull))/total
+	if len(notNull) == 0 {
+		return ret
+	}
+	sort.SliceStable(notNull, func(i, j int) bool {
+		return compareForSort(notNull[i], notNull[j]) < 0
+	})
+	min, max := notNull[0], notNull[len(notNull)-1]
+	ret.Min, ret.Max = &min, &max
+
+	type run struct {
+		value Datum
+		count int
+	}
+	var runs []run
+	for _, v := range notNull {
+		if len(runs) > 0 && compareForSort(runs[len(runs)-1].value, v) == 0 {
+			runs[len(runs)-1].count++
+		} else {
+			runs = append(runs, run{v, 1})
+		}
+	}
+	ret.NDV = float64(len(runs))
+
+	common := append([]run{}, runs...)
+	sort.SliceStable(common, func(i, j int) bool { return common[i].count > common[j].count })
+	avg := float64(len(notNull)) / ret.NDV
+	for _, r := range common {
+		if len(ret.MCV) >= mcvs || float64(r.count) <= avg {
+			break
+		}
+		ret.MCV = append(ret.MCV, MCVItem{r.value, float64(r.count) / total})
+	}
+
+	//equi-depth: every bucket gets about the same number of values, equal values are never split
+	depth := int(math.Ceil(float64(len(notNull)) / float64(buckets)))
+	var cur Bucket
+	for _, r := range runs {
+		cur.Upper = r.value
+		cur.Count += float64(r.count)
+		cur.NDV++
+		if cur.Count >= float64(depth) {
+			ret.Histogram = append(ret.Histogram, cur)
+			cur = Bucket{}
+		}
+	}
+	if cur.Count > 0 {
+		ret.Histogram = append(ret.Histogram, cur)
+	}
+	return ret
+}
+
+// compareForSort order the values of a column, values which can not be compared are ordered by their text
+func compareForSort(a, b Datum) int {
+	if c, ok := CompareDatum(a, b); ok {
+		return c
+	}
+	return strings.Compare(a.print(), b.print())
+}
+
+// EqualFraction estimate the fraction of the rows of the table where the column equals v
+func (c *ColumnStats) EqualFraction(v Datum) float64 {
+	if v.Kind() == test_driver.KindNull {
+		return 0
+	}
+	if (c.Min != nil && compareForSort(v, *c.Min) < 0) || (c.Max != nil && compareForSort(v, *c.Max) > 0) {
+		return 0
+	}
+	rest := 1 - c.NullFraction
+	for _, m := range c.MCV {
+		if compareForSort(m.Value, v) == 0 {
+			return m.Fraction
+		}
+		rest -= m.Fraction
+	}
+	others := c.NDV - float64(len(c.MCV))
+	if others < 1 || rest <= 0 {
+		return 0
+	}
+	return rest / others
+}
+
+// LessFraction estimate the fraction of the rows of the table where the column is less than v,
+// or less than or equal to v if inclusive. The histogram is interpolated linearly inside a bucket
+func (c *ColumnStats) LessFraction(v Datum, inclusive bool) (float64, bool) {
+	if v.Kind() == test_driver.KindNull {
+		return 0, true
+	}
+	notNull := 1 - c.NullFraction
+	if len(c.Histogram) == 0 {
+		if c.Min == nil || c.Max == nil {
+			return 0, false
+		}
+		return notNull * interpolate(*c.Min, *c.Max, v), true
+	}
+	var total, below float64
+	for _, b := range c.Histogram {
+		total += b.Count
+	}
+	lower := c.Min
+	for _, b := range c.Histogram {
+		if cmp := compareForSort(v, b.Upper); cmp > 0 || (cmp == 0 && inclusive) {
+			below += b.Count
+			upper := b.Upper
+			lower = &upper
+			continue
+		}
+		//v falls into this bucket or before it
+		if lower != nil && compareForSort(v, *lower) > 0 {
+			below += b.Count * interpolate(*lower, b.Upper, v)
+		}
+		break
+	}
+	return notNull * below / total, true
+}
+
+// interpolate return where v lies between low and high, 0.5 if it can not be computed
+func interpolate(low, high, v Datum) float64 {
+	l, ok1 := DatumFloat(low)
+	h, ok2 := DatumFloat(high)
+	x, ok3 := DatumFloat(v)
+	if !ok1 || !ok2 || !ok3 {
+		switch {
+		case compareForSort(v, low) <= 0:
+			return 0
+		case compareForSort(v, high) >= 0:
+			return 1
+		}
+		return 0.5
+	}
+	if h <= l {
+		if x >= h {
+			return 1
+		}
+		return 0
+	}
+	return math.Max(0, math.Min(1, (x-l)/(h-l)))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLoadStatsFile(t *testing.T) {
+	loadTestTables(t)
+	defer loadTestTables(t)
+	if err := LoadStatsFile(testDir + "stats.json"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := TableRows("t"); rows != 1000000 {
+		t.Errorf("t has %v rows, want 1000000", rows)
+	}
+	stats, _ := LookupStats("t")
+	if c, ok := stats.Column("b"); !ok || c.NDV != 100 || c.NullFraction != 0.1 {
+		t.Errorf("the statistics of t.b are %+v", c)
+	}
+	if c, _ := stats.Column("a"); c.Max == nil || c.Max.GetInt64() != 1000000 {
+		t.Errorf("the statistics of t.a are %+v", c)
+	}
+}
+
+func TestStatsRoundTrip(t *testing.T) {
+	loadTestTables(t)
+	data, err := MarshalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadStats(data); err != nil {
+		t.Fatal(err)
+	}
+	again, err := MarshalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(again) {
+		t.Errorf("the statistics changed when loaded:\n%s\n%s", data, again)
+	}
+}
+
+// estimateRows return the estimated rows of the unoptimized plan of sql
+func estimateRows(t *testing.T, sql string) float64 {
+	t.Helper()
+	node, err := parse(sql)
+	if err != nil {
+		t.Fatalf("%v: %v", sql, err)
+	}
+	return EstimateRows(GetQuery(node))
+}
+
+func TestEstimateRows(t *testing.T) {
+	loadTestTables(t)
+	tests := []struct {
+		sql  string
+		rows float64
+	}{
+		{"select a from t", 12},
+		{"select a from t where a = 4", 1},
+		{"select a from t where b is null", 2},
+		{"select a from t where a in (1, 2, 3)", 3},
+		{"select a from testdata2 where a = 3", 3},
+		{"select a from t where a > 100", 0},
+		{"select a from t limit 3", 3},
+		{"select t.a from t join s on t.a = s.a", 6},
+		//histograms interpolate between the bucket bounds
+		{"select a from t where a < 4", 4},
+		{"select a, count(*) from testdata2 group by a", 5},
+		{"select count(*) from testdata2", 1},
+		//unknown tables have DefaultTableRows rows
+		{"select a from nosuch where a = 1", DefaultTableRows * DefaultEqualSelectivity},
+	}
+	for _, test := range tests {
+		if got := estimateRows(t, test.sql); got < test.rows-0.01 || got > test.rows+0.01 {
+			t.Errorf("%v: %v rows estimated, want %v", test.sql, got, test.rows)
+		}
+	}
+	if err := LoadStatsFile(testDir + "stats.json"); err != nil {
+		t.Fatal(err)
+	}
+	defer loadTestTables(t)
+	if got := estimateRows(t, "select a from t where b = 5"); got < 9000-0.01 || got > 9000+0.01 {
+		t.Errorf("%v rows estimated for 1000000 rows of t, 10%% of NULL b and 100 distinct b", got)
+	}
+}
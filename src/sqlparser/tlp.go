@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math/rand"
+	"strconv"
+)
+
+// The oracles of the ternary logic partitioning test, a query Q is partitioned on a predicate p into
+// Q WHERE p, Q WHERE NOT p and Q WHERE p IS NULL, or the same with HAVING
+const (
+	TLPWhere     = "where"     //the rows of Q are the union of the rows of the partitions
+	TLPAggregate = "aggregate" //the aggregates of Q combine the aggregates of the partitions
+	TLPHaving    = "having"    //the groups of Q are the union of the groups of the partitions
+)
+
+var TLPOracles = []string{TLPWhere, TLPAggregate, TLPHaving}
+
+// TLPReport is the outcome of the ternary logic partitioning of a generated query.
+//
+//	Compared is false when the query is not deterministic or both queries fail, like on a type error.
+//	Mismatch explains the difference, it is empty if the results match
+type TLPReport struct {
+	Oracle    string
+	SQL       string //the query Q
+	Predicate string //the predicate p
+	Union     string //the partitions of Q on p combined by UNION ALL
+	Seed      int64
+	Compared  bool
+	Mismatch  string
+	Expected  []Row
+	Actual    []Row
+}
+
+// TLPTest generate a query and a predicate from seed and check the oracle on tables filled with data
+// generated from seed. Both the query and the union of its partitions run through GetQuery, QueryOptimizer
+// and the executor, so the rules must keep the rows of the query whatever the predicate
+func TLPTest(oracle string, seed int64) (report *TLPReport) {
+	RegisterFuzzTables()
+	g := NewQueryGenerator(seed, FuzzTables)
+	g.MaxDepth = 2
+	q, p := g.partitionedQuery(oracle)
+	union := q.partitions(oracle, p)
+	report = &TLPReport{Oracle: oracle, SQL: q.String(), Predicate: p.String(), Union: union.String(), Seed: seed}
+	defer func() {
+		if r := recover(); r != nil {
+			report.Compared, report.Mismatch = false, ""
+		}
+	}()
+	node, err := parse(report.SQL)
+	if err != nil {
+		report.Mismatch = err.Error()
+		return report
+	}
+	plan := GetQuery(node)
+	if !Deterministic(plan) {
+		return report
+	}
+	//the predicate may call a volatile function inside the value of IF
+	if node, err := parse(report.Union); err != nil || !Deterministic(GetQuery(node)) {
+		return report
+	}
+	restore := generateTables(plan, rand.New(rand.NewSource(seed)))
+	defer restore()
+
+	expected, err1 := executeOptimized(report.SQL)
+	actual, err2 := executeOptimized(report.Union)
+	report.Expected, report.Actual = expected, actual
+	switch {
+	case err1 != nil && err2 != nil:
+		return report
+	case err1 != nil || err2 != nil:
+		report.Mismatch = fmt.Sprintf("query error: %v, partitions error: %v", err1, err2)
+		return report
+	}
+	report.Compared = true
+	if oracle == TLPAggregate {
+		report.Mismatch = compareAggregates(q.Fields, expected, actual)
+	} else if len(expected) != len(actual) || !sameRows(expected, actual) {
+		report.Mismatch = fmt.Sprintf("%d rows in the query, %d rows in the partitions", len(expected), len(actual))
+	}
+	return report
+}
+
+// executeOptimized run sql with the plan of QueryOptimizer
+func executeOptimized(sql string) ([]Row, error) {
+	node, err := parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	plan := GetQuery(node)
+	quiet := QuietRules
+	QuietRules = true
+	plan.QueryOptimizer()
+	QuietRules = quiet
+	_, rows, err := Execute(treeRoot)
+	return rows, err
+}
+
+// compareAggregates combine the rows of the partitions of a query of aggregates and compare them with its row:
+// the counts and the sums are added, the minimums and the maximums are compared
+func compareAggregates(fields []*genExpr, expected, actual []Row) string {
+	if len(expected) != 1 || len(actual) != 3 {
+		return fmt.Sprintf("%d rows in the query, %d rows in the partitions", len(expected), len(actual))
+	}
+	for i, f := range fields {
+		acc := NullDatum()
+		for _, row := range actual {
+			v := row[i]
+			switch {
+			case v.Kind() == test_driver.KindNull:
+			case acc.Kind() == test_driver.KindNull:
+				acc = v
+			case f.Func == "count" || f.Func == "sum":
+				sum, err := EvalOperator(Plus, []Datum{acc, v})
+				if err != nil {
+					return err.Error()
+				}
+				acc = sum
+			case (f.Func == "min") == (CompareForOrder(v, acc) < 0):
+				acc = v
+			}
+		}
+		want := expected[0][i]
+		if (acc.Kind() == test_driver.KindNull) != (want.Kind() == test_driver.KindNull) {
+			return fmt.Sprintf("%v is %v in the query, %v in the partitions", f.Alias, FormatDatum(want),
+				FormatDatum(acc))
+		}
+		if c, ok := CompareValues(acc, want); acc.Kind() != test_driver.KindNull && (!ok || c != 0) {
+			return fmt.Sprintf("%v is %v in the query, %v in the partitions", f.Alias, FormatDatum(want),
+				FormatDatum(acc))
+		}
+	}
+	return ""
+}
+
+// Output print the report of a mismatch with the results of the query and of its partitions
+func (r *TLPReport) Output() {
+	fmt.Printf("oracle: %v\n", r.Oracle)
+	fmt.Printf("SQL: %v\n", r.SQL)
+	fmt.Printf("predicate: %v\n", r.Predicate)
+	fmt.Printf("partitions: %v\n", r.Union)
+	fmt.Printf("seed: %d\n", r.Seed)
+	fmt.Printf("mismatch: %v\n", r.Mismatch)
+	fmt.Printf("query:\n")
+	for _, row := range r.Expected {
+		fmt.Printf("    %v\n", FormatRow(row))
+	}
+	fmt.Printf("partitions:\n")
+	for _, row := range r.Actual {
+		fmt.Printf("    %v\n", FormatRow(row))
+	}
+}
+
+// partitionedQuery build a query without ORDER BY, LIMIT nor UNION for the oracle, and a deterministic
+// predicate on its FROM for TLPWhere and TLPAggregate, or on its groups for TLPHaving
+func (g *QueryGenerator) partitionedQuery(oracle string) (*GenQuery, *genExpr) {
+	g.aliases = 0
+	q := &GenQuery{}
+	var scope []genColumn
+	q.From, scope = g.source(g.MaxDepth)
+	for i, n := 0, g.r.Intn(2); i < n; i++ {
+		q.Where = append(q.Where, g.predicate(scope, 1, true))
+	}
+	field := func(f *genExpr) {
+		f.Alias = "f" + strconv.Itoa(len(q.Fields))
+		q.Fields = append(q.Fields, f)
+	}
+	switch oracle {
+	case TLPAggregate:
+		for i, n := 0, 1+g.r.Intn(3); i < n; i++ {
+			f := []string{"count", "sum", "min", "max"}[g.r.Intn(4)]
+			arg := g.expr(scope, 1, f != "sum" && g.r.Intn(4) == 0)
+			field(&genExpr{Func: f, Args: []*genExpr{arg}, Str: arg.Str && f != "count", Agg: true})
+		}
+	case TLPHaving:
+		for i, n := 0, 1+g.r.Intn(2); i < n; i++ {
+			key := g.column(scope, g.r.Intn(4) == 0)
+			q.GroupBy = append(q.GroupBy, key)
+			field(&genExpr{Leaf: key.Leaf, Str: key.Str})
+		}
+		field(g.aggregate(scope))
+		return q, g.havingPredicate(q, scope)
+	default:
+		for i, n := 0, 1+g.r.Intn(3); i < n; i++ {
+			field(g.expr(scope, 2, g.r.Intn(5) == 0))
+		}
+	}
+	return q, g.predicate(scope, 2, true)
+}
+
+// partitions return Q WHERE p UNION ALL Q WHERE NOT p UNION ALL Q WHERE p IS NULL, or the same with HAVING
+func (q *GenQuery) partitions(oracle string, p *genExpr) *GenQuery {
+	var parts []*GenQuery
+	for _, cond := range []*genExpr{p, {Op: "NOT", Args: []*genExpr{p}}, {Op: "IS NULL", Args: []*genExpr{p}}} {
+		c := *q
+		if oracle == TLPHaving {
+			c.Having = append(append([]*genExpr{}, q.Having...), cond)
+		} else {
+			c.Where = append(append([]*genExpr{}, q.Where...), cond)
+		}
+		parts = append(parts, &c)
+	}
+	parts[0].Union, parts[0].All = parts[1:], true
+	return parts[0]
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTLPOracles(t *testing.T) {
+	useFuzzTables()
+	defer loadTestTables(t)
+	for _, oracle := range TLPOracles {
+		compared := 0
+		for seed := int64(1); seed <= 100; seed++ {
+			report := TLPTest(oracle, seed)
+			if report.Compared {
+				compared++
+			}
+			if report.Mismatch != "" {
+				t.Errorf("%v: seed %d: %v\n%v\n%v", oracle, seed, report.Mismatch, report.SQL, report.Union)
+			}
+		}
+		if compared < 50 {
+			t.Errorf("%v: only %d queries of 100 compared", oracle, compared)
+		}
+	}
+}
+
+func TestTLPPartitions(t *testing.T) {
+	for _, oracle := range TLPOracles {
+		for seed := int64(1); seed <= 20; seed++ {
+			g := NewQueryGenerator(seed, FuzzTables)
+			g.MaxDepth = 2
+			q, p := g.partitionedQuery(oracle)
+			sql := q.String()
+			if q.OrderBy != nil || q.Limit != nil || q.Union != nil {
+				t.Errorf("%v: %v is not a query to partition", oracle, sql)
+			}
+			union := q.partitions(oracle, p).String()
+			if got := strings.Count(union, " UNION ALL "); got != 2 {
+				t.Errorf("%v: %v has %d UNION ALL, want 2", oracle, union, got)
+			}
+			pred := p.String()
+			for _, part := range []string{pred, "(NOT " + pred + ")", "(" + pred + " IS NULL)"} {
+				if !strings.Contains(union, part) {
+					t.Errorf("%v: %v has no partition on %v", oracle, union, part)
+				}
+			}
+			//the partitions leave the query itself untouched
+			if q.String() != sql {
+				t.Errorf("%v: the query changed to %v", oracle, q.String())
+			}
+			if (oracle == TLPHaving) != strings.Contains(union, " HAVING ") {
+				t.Errorf("%v: %v partitions on the wrong clause", oracle, union)
+			}
+		}
+	}
+}
+
+func TestCompareAggregates(t *testing.T) {
+	var fields []*genExpr
+	for _, f := range []string{"count", "sum", "min", "max"} {
+		fields = append(fields, &genExpr{Func: f, Alias: f, Agg: true})
+	}
+	row := func(values ...interface{}) Row {
+		var ret Row
+		for _, v := range values {
+			if v == nil {
+				ret = append(ret, NullDatum())
+			} else {
+				ret = append(ret, InitSetValue(int64(v.(int))))
+			}
+		}
+		return ret
+	}
+	partitions := []Row{row(2, 10, 1, 4), row(3, 20, 3, 9), row(0, nil, nil, nil)}
+	tests := []struct {
+		expected Row
+		match    bool
+	}{
+		{row(5, 30, 1, 9), true},
+		{row(5, 25, 1, 9), false},
+		{row(5, 30, 3, 9), false},
+		{row(4, 30, 1, 9), false},
+		{row(5, nil, 1, 9), false},
+	}
+	for i, test := range tests {
+		got := compareAggregates(fields, []Row{test.expected}, partitions)
+		if (got == "") != test.match {
+			t.Errorf("case %d: mismatch %q, want match %v", i, got, test.match)
+		}
+	}
+	all := []Row{row(0, nil, nil, nil), row(0, nil, nil, nil), row(0, nil, nil, nil)}
+	if got := compareAggregates(fields, []Row{row(0, nil, nil, nil)}, all); got != "" {
+		t.Errorf("the aggregates of empty partitions mismatch: %v", got)
+	}
+	if got := compareAggregates(fields, []Row{row(5, 30, 1, 9)}, partitions[:2]); got == "" {
+		t.Error("two partitions are accepted")
+	}
+}
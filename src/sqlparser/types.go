@@ -4,25 +4,64 @@ import (
 	"fmt"
 	"github.com/pingcap/tidb/parser/ast"
 	"github.com/pingcap/tidb/parser/test_driver"
+	"strconv"
 )
 
 type OpType int
 
 const (
-	Project      OpType = iota + 1 //Select Fields
-	Aggregate                      //Aggregator = Project + GroupBy
-	Join                           //Join Table
-	Table                          //Scan Table
-	GroupBy                        //GroupBy
-	HavingFilter                   //HavingFilter
-	Filter                         //Where Filter
-	OrderBy                        //OrderBy
-	Limit                          //Limit
+	Project       OpType = iota + 1 //Select Fields
+	Aggregate                       //Aggregator = Project + GroupBy
+	Join                            //Join Table
+	Table                           //Scan Table
+	GroupBy                         //GroupBy
+	HavingFilter                    //HavingFilter
+	Filter                          //Where Filter
+	OrderBy                         //OrderBy
+	Limit                           //Limit
+	EmptyRelation                   //Produce no row
+	Union                           //UNION [ALL] of the query blocks of the children
+	TopN                            //OrderBy + Limit
 )
 
+var OpTypeNames = [...]string{
+	Project:       "Project",
+	Aggregate:     "Aggregate",
+	Join:          "Join",
+	Table:         "Table",
+	GroupBy:       "GroupBy",
+	HavingFilter:  "HavingFilter",
+	Filter:        "Filter",
+	OrderBy:       "OrderBy",
+	Limit:         "Limit",
+	EmptyRelation: "EmptyRelation",
+	Union:         "Union",
+	TopN:          "TopN",
+}
+
+func (t OpType) String() string {
+	if t > 0 && int(t) < len(OpTypeNames) {
+		return OpTypeNames[t]
+	}
+	return "OpType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// StrToOpType is the inverse of OpType.String, returns -1 if the name is unknown
+func StrToOpType(str string) OpType {
+	for i, v := range OpTypeNames {
+		if v != "" && v == str {
+			return OpType(i)
+		}
+	}
+	return -1
+}
+
+// Stack builds the plan while the AST is walked, distinct holds the DISTINCT of the selects being built,
+// the innermost last
 type Stack struct {
-	size int
-	data []*LogicalPlan
+	size     int
+	data     []*LogicalPlan
+	distinct []bool
 }
 
 func (s *Stack) Push(value *LogicalPlan) {
@@ -73,10 +112,21 @@ type ColumnName struct {
 	ColName     string
 }
 
+// Expression : AsTblName qualifies AsName, it is only set by the optimizer when an output column
+// has to keep its table name, like the NULL columns padding the empty side of an outer join
 type Expression struct {
-	expr   []Datum
-	Fields map[string]ColumnName
-	AsName string
+	expr      []Datum
+	Fields    map[string]ColumnName
+	AsName    string
+	AsTblName string
+}
+
+// OutputName return the qualified AsName of expr
+func (expr *Expression) OutputName() string {
+	if expr.AsTblName != "" {
+		return expr.AsTblName + "." + expr.AsName
+	}
+	return expr.AsName
 }
 
 type ProjectionNode struct {
@@ -86,13 +136,20 @@ type ProjectionNode struct {
 func (n ProjectionNode) print() {
 	for _, v := range n.cols {
 		if v.AsName != "" {
-			fmt.Printf("%v AS %v, ", v.print(), v.AsName)
+			fmt.Printf("%v AS %v, ", v.print(), v.OutputName())
 		} else {
 			fmt.Printf("%v, ", v.print())
 		}
 	}
 }
 
+// Join types the parser does not have, [INNER] JOIN ... ON is parsed as a CrossJoin with ON conditions.
+// They are produced by the optimizer, FULL [OUTER] JOIN is only reachable by a hand-built plan
+const (
+	InnerJoin ast.JoinType = ast.RightJoin + iota + 1
+	FullJoin
+)
+
 type JoinNode struct {
 	Tp ast.JoinType
 	On []Expression
@@ -106,6 +163,10 @@ func (n JoinNode) print() {
 		fmt.Printf("LeftJoin")
 	case ast.RightJoin:
 		fmt.Printf("RightJoin")
+	case InnerJoin:
+		fmt.Printf("InnerJoin")
+	case FullJoin:
+		fmt.Printf("FullJoin")
 	case 0:
 		fmt.Printf("Single Table")
 		return
@@ -164,15 +225,34 @@ func (n WhereFilterNode) print() {
 	}
 }
 
+// AggregateMode is the phase of the aggregation computed by an Aggregate
+type AggregateMode int
+
+const (
+	CompleteAggregate AggregateMode = iota //aggregate the input rows in one phase
+	PartialAggregate                       //aggregate the input rows per group, merged later by a FinalAggregate
+	FinalAggregate                         //merge the partial results: count and sum add them up, min, max and bit_xxx apply again, avg(s, c) divides the sum of s by the sum of c
+)
+
+var AggregateModeNames = [...]string{
+	CompleteAggregate: "Complete",
+	PartialAggregate:  "Partial",
+	FinalAggregate:    "Final",
+}
+
 type AggregateNode struct {
 	ProjectionNode
 	GroupByNode
+	Mode AggregateMode
 }
 
 func (n AggregateNode) print() {
 	n.ProjectionNode.print()
 	fmt.Printf("Select:  ")
 	n.GroupByNode.print()
+	if n.Mode != CompleteAggregate {
+		fmt.Printf("Mode: %v", AggregateModeNames[n.Mode])
+	}
 }
 
 type GroupByNode struct {
@@ -207,6 +287,56 @@ func (n OrderByNode) print() {
 	}
 }
 
+// EmptyRelationNode replaces a subtree known to produce no row, it keeps the schema of the subtree
+type EmptyRelationNode struct {
+	Schema Schema
+}
+
+func (n EmptyRelationNode) print() {
+	for _, c := range n.Schema.Columns {
+		if c.TblName != "" {
+			fmt.Printf("%v.%v, ", c.TblName, c.ColName)
+		} else {
+			fmt.Printf("%v, ", c.ColName)
+		}
+	}
+}
+
+// UnionNode : All is false for UNION [DISTINCT]. The output columns are named after the first child
+type UnionNode struct {
+	All bool
+}
+
+func (n UnionNode) print() {
+	if n.All {
+		fmt.Printf("All")
+	} else {
+		fmt.Printf("Distinct")
+	}
+}
+
+// TopNNode is an OrderBy with a Limit on top, hasPush is set once a copy of it has been pushed below a Join
+// or a Union, which must not happen twice
+type TopNNode struct {
+	Items   []ByItem
+	Count   Expression
+	Offset  Expression
+	hasPush bool
+}
+
+func (n TopNNode) print() {
+	for _, v := range n.Items {
+		fmt.Printf("%v ", v.Item.print())
+		if v.Desc {
+			fmt.Printf("Desc ")
+		}
+	}
+	fmt.Printf("Count: %v", n.Count.print())
+	if len(n.Offset.expr) > 0 {
+		fmt.Printf(" Offset: %v", n.Offset.print())
+	}
+}
+
 type LimitNode struct {
 	Count   Expression
 	Offset  Expression
@@ -215,8 +345,8 @@ type LimitNode struct {
 
 func (n LimitNode) print() {
 	fmt.Printf("Count: %v", n.Count.print())
-	if len(n.Offset.expr) > 1 {
-		fmt.Printf("Offset: %v", n.Offset.print())
+	if len(n.Offset.expr) > 0 {
+		fmt.Printf(" Offset: %v", n.Offset.print())
 	}
 }
 
@@ -229,7 +359,7 @@ func (expr *Expression) print() string {
 				s = append(s, d)
 			} else {
 				t := StrToOp(d.GetString())
-				if t != -1 {
+				if t != -1 && len(s) >= 2 {
 					op1, op2 := s[len(s)-2], s[len(s)-1]
 					str := "(" + op1.print() + Ops[t].Literal + op2.print() + ")"
 					s = s[:len(s)-2]
@@ -293,6 +423,15 @@ func (plan *LogicalPlan) LogicalPlanDelete() {
 	plan.child = []LogicalPlan{}
 }
 
+// ResetParent rebuilds the parent links of the whole subtree rooted at plan.
+// child is stored by value, so links must be rebuilt after the slices are copied or rebuilt
+func (plan *LogicalPlan) ResetParent() {
+	for i := range plan.child {
+		plan.child[i].parent = plan
+		plan.child[i].ResetParent()
+	}
+}
+
 func (plan *LogicalPlan) LogicalPlanFindRoot() *LogicalPlan {
 	root := plan
 	for {
@@ -304,18 +443,21 @@ func (plan *LogicalPlan) LogicalPlanFindRoot() *LogicalPlan {
 	}
 }
 
+// GetExpressionColName returns the columns of expr in the order they appear in it
 func GetExpressionColName(expr Expression) []ColumnName {
 	var str []ColumnName
-	for _, v := range expr.Fields {
-		str = append(str, v)
-	}
 	for _, exp := range expr.expr {
 		if exp.Args != nil {
 			for _, arg := range exp.Args {
 				str = append(str, GetExpressionColName(arg)...)
 			}
+		} else if expr.IsColumnDatum(exp) {
+			str = append(str, expr.Fields[exp.GetString()])
 		}
 	}
+	for _, v := range expr.Fields {
+		str = append(str, v)
+	}
 	return RemoveRepeatedElement(str)
 }
 
@@ -331,28 +473,3 @@ func RemoveRepeatedElement(s []ColumnName) []ColumnName {
 	}
 	return result
 }
-
-// TableInSubLogicalPlan check if table in the tree of subPlan root
-func TableInSubLogicalPlan(root *LogicalPlan, table string) bool {
-	switch root.Tp {
-	case Project:
-		for _, v := range root.Content.(ProjectionNode).cols {
-			if len(v.AsName) > 0 {
-				if v.AsName == table {
-					return true
-				}
-			}
-		}
-	case Table:
-		if table == root.Content.(TableNode).Table.OrigTblName ||
-			table == root.Content.(TableNode).Table.TblName {
-			return true
-		}
-	}
-	for _, child := range root.child {
-		if TableInSubLogicalPlan(&child, table) {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,829 @@
+package main
+
+import (
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+// VecExecutor is the batch form of Executor: every Next returns the next chunk of rows, nil once the rows are
+// exhausted. The chunks hold at most ChunkSize rows and may have a selection vector
+type VecExecutor interface {
+	Open() error
+	Next() (*Chunk, error)
+	Close() error
+	Schema() Schema
+}
+
+// ExecuteVectorized run plan like Execute with the vectorized operators
+func ExecuteVectorized(plan *LogicalPlan) (Schema, []Row, error) {
+	return execute(plan, true)
+}
+
+// buildVec build the vectorized executor of plan, ok is false if plan has no vectorized operator.
+// The inputs without one are read row by row and cut into chunks
+func (b *executorBuilder) buildVec(plan *LogicalPlan) (exec VecExecutor, ok bool, err error) {
+	child := func() (VecExecutor, error) {
+		if vec, ok, err := b.buildVec(&plan.child[0]); ok || err != nil {
+			return vec, err
+		}
+		row, err := b.build(&plan.child[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		return &rowChunksExec{child: row}, nil
+	}
+	var input VecExecutor
+	switch n := plan.Content.(type) {
+	case TableNode:
+		if len(plan.child) > 0 {
+			if input, err = child(); err != nil {
+				return nil, true, err
+			}
+			return &vecProjectionExec{child: input, cols: []Expression{wildCard()}, schema: plan.Schema()}, true, nil
+		}
+		table, found := LookupMemTable(n.Table.OrigTblName)
+		if !found {
+			return nil, false, nil
+		}
+		scan, err := newTableScanExec(plan, b.required[plan])
+		if err != nil {
+			return nil, true, err
+		}
+		return &vecTableScanExec{table: table, schema: plan.Schema(), offsets: scan.(*tableScanExec).offsets}, true, nil
+	case WhereFilterNode:
+		if input, err = child(); err != nil {
+			return nil, true, err
+		}
+		return &vecSelectionExec{child: input, conditions: n.Expr}, true, nil
+	case HavingFilterNode:
+		//the HAVING using columns missing in its input are left to the row executor
+		if len(missingColumns(n.Expr, plan.childSchema())) > 0 {
+			return nil, false, nil
+		}
+		if input, err = child(); err != nil {
+			return nil, true, err
+		}
+		return &vecSelectionExec{child: input, conditions: n.Expr}, true, nil
+	case ProjectionNode:
+		if len(plan.child) == 0 {
+			return nil, false, nil
+		}
+		if input, err = child(); err != nil {
+			return nil, true, err
+		}
+		if !hasAggregate(n.cols) {
+			return &vecProjectionExec{child: input, cols: n.cols, schema: plan.Schema()}, true, nil
+		}
+		return &vecHashAggExec{child: input, cols: n.cols, mode: CompleteAggregate, schema: plan.Schema()}, true, nil
+	case AggregateNode:
+		if input, err = child(); err != nil {
+			return nil, true, err
+		}
+		return &vecHashAggExec{child: input, cols: n.cols, items: n.Items, mode: n.Mode, schema: plan.Schema()}, true, nil
+	case GroupByNode:
+		if input, err = child(); err != nil {
+			return nil, true, err
+		}
+		return &vecHashAggExec{child: input, cols: []Expression{wildCard()}, items: n.Items, mode: CompleteAggregate,
+			schema: plan.Schema()}, true, nil
+	case JoinNode:
+		return b.buildHashJoin(plan)
+	}
+	return nil, false, nil
+}
+
+// rowChunksExec cuts the rows of a row executor into chunks
+type rowChunksExec struct {
+	child Executor
+}
+
+func (e *rowChunksExec) Open() error {
+	return e.child.Open()
+}
+
+func (e *rowChunksExec) Next() (*Chunk, error) {
+	chk := NewChunk(e.child.Schema())
+	for chk.Len() < ChunkSize {
+		row, err := e.child.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		chk.AppendRow(row)
+	}
+	if chk.Len() == 0 {
+		return nil, nil
+	}
+	return chk, nil
+}
+
+func (e *rowChunksExec) Close() error {
+	return e.child.Close()
+}
+
+func (e *rowChunksExec) Schema() Schema {
+	return e.child.Schema()
+}
+
+// chunkRowsExec returns the selected rows of the chunks of a vectorized executor one by one
+type chunkRowsExec struct {
+	child VecExecutor
+	chk   *Chunk
+	rows  []int
+	pos   int
+}
+
+func (e *chunkRowsExec) Open() error {
+	e.chk, e.rows, e.pos = nil, nil, 0
+	return e.child.Open()
+}
+
+func (e *chunkRowsExec) Next() (Row, error) {
+	for e.pos >= len(e.rows) {
+		chk, err := e.child.Next()
+		if chk == nil || err != nil {
+			return nil, err
+		}
+		e.chk, e.rows, e.pos = chk, chk.Rows(), 0
+	}
+	e.pos++
+	return e.chk.Row(e.rows[e.pos-1]), nil
+}
+
+func (e *chunkRowsExec) Close() error {
+	e.chk, e.rows = nil, nil
+	return e.child.Close()
+}
+
+func (e *chunkRowsExec) Schema() Schema {
+	return e.child.Schema()
+}
+
+// vecTableScanExec returns the rows of a MemTable by chunks, with the columns of the schema of the Table node
+type vecTableScanExec struct {
+	table   *MemTable
+	schema  Schema
+	offsets []int
+	pos     int
+}
+
+func (e *vecTableScanExec) Open() error {
+	e.pos = 0
+	return nil
+}
+
+func (e *vecTableScanExec) Next() (*Chunk, error) {
+	n := minInt(ChunkSize, len(e.table.Rows)-e.pos)
+	if n <= 0 {
+		return nil, nil
+	}
+	rows := e.table.Rows[e.pos : e.pos+n]
+	e.pos += n
+	chk := &Chunk{length: n}
+	for j, offset := range e.offsets {
+		col := NewColumn(e.schema.Columns[j].Tp, n)
+		for i, row := range rows {
+			col.Set(i, row[offset])
+		}
+		chk.Columns = append(chk.Columns, col)
+	}
+	return chk, nil
+}
+
+func (e *vecTableScanExec) Close() error {
+	return nil
+}
+
+func (e *vecTableScanExec) Schema() Schema {
+	return e.schema
+}
+
+// vecSelectionExec narrows the selection vector of the chunks of its input to the rows where all the
+// conditions are true, a condition is only computed on the rows passing the previous ones. The chunks
+// without any row left are skipped
+type vecSelectionExec struct {
+	child      VecExecutor
+	conditions []Expression
+	filters    []VecExpr
+}
+
+func (e *vecSelectionExec) Open() error {
+	e.filters = nil
+	for _, cond := range e.conditions {
+		e.filters = append(e.filters, CompileVector(cond, e.child.Schema()))
+	}
+	return e.child.Open()
+}
+
+func (e *vecSelectionExec) Next() (*Chunk, error) {
+	for {
+		chk, err := e.child.Next()
+		if chk == nil || err != nil {
+			return nil, err
+		}
+		rows, err := filterRows(e.filters, chk, chk.Rows())
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) > 0 {
+			chk.Sel = rows
+			return chk, nil
+		}
+	}
+}
+
+// filterRows return the rows of chk where all the filters are true
+func filterRows(filters []VecExpr, chk *Chunk, rows []int) ([]int, error) {
+	for _, filter := range filters {
+		if len(rows) == 0 {
+			break
+		}
+		col, err := filter(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		rows = selectRows(col, rows)
+	}
+	return rows, nil
+}
+
+func (e *vecSelectionExec) Close() error {
+	return e.child.Close()
+}
+
+func (e *vecSelectionExec) Schema() Schema {
+	return e.child.Schema()
+}
+
+// vecProjectionExec computes cols on the selected rows of the chunks of its input, `*` stands for all the
+// columns of the input which are passed as they are
+type vecProjectionExec struct {
+	child  VecExecutor
+	cols   []Expression
+	schema Schema
+	exprs  []VecExpr
+}
+
+func (e *vecProjectionExec) Open() error {
+	e.exprs = make([]VecExpr, len(e.cols))
+	for i, col := range e.cols {
+		if !IsWildCard(col) {
+			e.exprs[i] = CompileVector(col, e.child.Schema())
+		}
+	}
+	return e.child.Open()
+}
+
+func (e *vecProjectionExec) Next() (*Chunk, error) {
+	chk, err := e.child.Next()
+	if chk == nil || err != nil {
+		return nil, err
+	}
+	ret := &Chunk{Columns: make([]*Column, 0, e.schema.Len()), Sel: chk.Sel, length: chk.Len()}
+	rows := chk.Rows()
+	for _, expr := range e.exprs {
+		if expr == nil {
+			ret.Columns = append(ret.Columns, chk.Columns...)
+			continue
+		}
+		col, err := expr(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret.Columns = append(ret.Columns, col)
+	}
+	return ret, nil
+}
+
+func (e *vecProjectionExec) Close() error {
+	return e.child.Close()
+}
+
+func (e *vecProjectionExec) Schema() Schema {
+	return e.schema
+}
+
+// vecHashAggExec is aggregateExec on chunks: the group by items and the arguments of the aggregates are
+// computed by chunk, the groups of a single int64 or string item are looked up without encoding the values,
+// and count, sum, avg, min and max take the int64 and float64 values unboxed. The output is computed once per
+// group like aggregateExec, from its first row
+type vecHashAggExec struct {
+	child  VecExecutor
+	cols   []Expression
+	items  []Expression
+	mode   AggregateMode
+	schema Schema
+	rows   []Row
+	pos    int
+}
+
+type vecAggGroup struct {
+	first       Row
+	aggregators []aggregator
+}
+
+// groupTable finds the group of the values of the items: a single item of int64 or string values is looked
+// up in ints or strs, until another value shows up and all the groups are moved to keys, under their RowKey
+type groupTable struct {
+	kind  byte
+	ints  map[int64]int
+	strs  map[string]int
+	keys  map[string]int
+	count int
+}
+
+func newGroupTable(items int) *groupTable {
+	t := &groupTable{kind: test_driver.KindNull, keys: make(map[string]int)}
+	if items == 1 {
+		t.kind, t.ints, t.strs = test_driver.KindInt64, make(map[int64]int), make(map[string]int)
+	}
+	return t
+}
+
+// lookup return the group of the values i of cols, a new group gets the next number
+func (t *groupTable) lookup(cols []*Column, i int) (group int, found bool) {
+	if t.kind != test_driver.KindNull && !cols[0].IsNull(i) {
+		col := cols[0]
+		switch {
+		case col.kind == test_driver.KindInt64 && len(t.strs) == 0:
+			if group, found = t.ints[col.ints[i]]; !found {
+				group, t.ints[col.ints[i]] = t.count, t.count
+				t.count++
+			}
+			return group, found
+		case col.kind == test_driver.KindString && len(t.ints) == 0:
+			if group, found = t.strs[col.strs[i]]; !found {
+				group, t.strs[col.strs[i]] = t.count, t.count
+				t.count++
+			}
+			return group, found
+		}
+		t.spill()
+	}
+	values := make(Row, len(cols))
+	for j, col := range cols {
+		values[j] = col.Get(i)
+	}
+	key := RowKey(values)
+	if group, found = t.keys[key]; !found {
+		group, t.keys[key] = t.count, t.count
+		t.count++
+	}
+	return group, found
+}
+
+// spill move the groups of ints and strs to keys
+func (t *groupTable) spill() {
+	for v, group := range t.ints {
+		t.keys[RowKey(Row{InitSetValue(v)})] = group
+	}
+	for v, group := range t.strs {
+		t.keys[RowKey(Row{InitSetValue(v)})] = group
+	}
+	t.kind, t.ints, t.strs = test_driver.KindNull, nil, nil
+}
+
+func (e *vecHashAggExec) Open() error {
+	if err := e.child.Open(); err != nil {
+		return err
+	}
+	e.rows, e.pos = nil, 0
+	//the same aggregate may be used by several columns, it is computed once
+	var funcs []Datum
+	index := make(map[string]int)
+	for _, f := range aggregateFunctions(e.cols) {
+		key := Expression{expr: []Datum{f}}
+		if _, ok := index[key.print()]; !ok {
+			index[key.print()] = len(funcs)
+			funcs = append(funcs, f)
+		}
+	}
+	input := e.child.Schema()
+	var items []VecExpr
+	for _, item := range e.items {
+		items = append(items, CompileVector(item, input))
+	}
+	args := make([][]VecExpr, len(funcs))
+	for i, f := range funcs {
+		for _, arg := range f.Args {
+			args[i] = append(args[i], CompileVector(arg, input))
+		}
+	}
+	table := newGroupTable(len(e.items))
+	var groups []*vecAggGroup
+	newGroup := func(first Row) error {
+		g := &vecAggGroup{first: first}
+		for _, f := range funcs {
+			agg, err := newAggregator(f.GetString(), e.mode)
+			if err != nil {
+				return err
+			}
+			g.aggregators = append(g.aggregators, agg)
+		}
+		groups = append(groups, g)
+		return nil
+	}
+	for {
+		chk, err := e.child.Next()
+		if err != nil {
+			return err
+		}
+		if chk == nil {
+			break
+		}
+		rows := chk.Rows()
+		keys, err := evalAll(items, chk, rows)
+		if err != nil {
+			return err
+		}
+		values := make([][]*Column, len(funcs))
+		for i := range funcs {
+			if values[i], err = evalAll(args[i], chk, rows); err != nil {
+				return err
+			}
+		}
+		for _, i := range rows {
+			group := 0
+			if len(items) > 0 {
+				var found bool
+				if group, found = table.lookup(keys, i); !found {
+					if err := newGroup(chk.Row(i)); err != nil {
+						return err
+					}
+				}
+			} else if len(groups) == 0 {
+				if err := newGroup(chk.Row(i)); err != nil {
+					return err
+				}
+			}
+			for j, agg := range groups[group].aggregators {
+				if err := updateAggregator(agg, values[j], i); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(groups) == 0 && len(e.items) == 0 {
+		if err := newGroup(nil); err != nil {
+			return err
+		}
+	}
+	for _, g := range groups {
+		g := g
+		ev := evaluator{schema: input, row: g.first, aggregate: func(f Datum) (Datum, error) {
+			key := Expression{expr: []Datum{f}}
+			return g.aggregators[index[key.print()]].result()
+		}}
+		row, err := projectRow(e.cols, ev)
+		if err != nil {
+			return err
+		}
+		e.rows = append(e.rows, row)
+	}
+	return nil
+}
+
+// evalAll compute exprs on the rows of chk
+func evalAll(exprs []VecExpr, chk *Chunk, rows []int) ([]*Column, error) {
+	cols := make([]*Column, len(exprs))
+	for i, expr := range exprs {
+		col, err := expr(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		cols[i] = col
+	}
+	return cols, nil
+}
+
+// updateAggregator add the values i of the arguments args to agg, unboxed for count, for sum and avg of a single
+// int64, float64 or string argument, and for min and max of a single int64 argument
+func updateAggregator(agg aggregator, args []*Column, i int) error {
+	switch a := agg.(type) {
+	case *countAggregator:
+		for _, arg := range args {
+			if arg.IsNull(i) {
+				return nil
+			}
+		}
+		a.count++
+		return nil
+	case *sumAggregator:
+		if len(args) != 1 || a.merge {
+			break
+		}
+		switch {
+		case args[0].IsNull(i):
+			return nil
+		case args[0].kind == test_driver.KindInt64:
+			a.addInt64(args[0].ints[i])
+			return nil
+		case args[0].kind == test_driver.KindFloat64:
+			a.addFloat64(args[0].floats[i])
+			return nil
+		case args[0].kind == test_driver.KindString:
+			a.addFloat64(StringToFloat(args[0].strs[i]))
+			return nil
+		}
+	case *extremeAggregator:
+		if len(args) != 1 {
+			break
+		}
+		switch {
+		case args[0].IsNull(i):
+			return nil
+		case args[0].kind == test_driver.KindInt64:
+			a.updateInt64(args[0].ints[i])
+			return nil
+		}
+	}
+	values := make([]Datum, len(args))
+	for j, arg := range args {
+		values[j] = arg.Get(i)
+	}
+	return agg.update(values)
+}
+
+func (e *vecHashAggExec) Next() (*Chunk, error) {
+	if e.pos >= len(e.rows) {
+		return nil, nil
+	}
+	chk := NewChunk(e.schema)
+	for ; e.pos < len(e.rows) && chk.Len() < ChunkSize; e.pos++ {
+		chk.AppendRow(e.rows[e.pos])
+	}
+	return chk, nil
+}
+
+func (e *vecHashAggExec) Close() error {
+	e.rows = nil
+	return e.child.Close()
+}
+
+func (e *vecHashAggExec) Schema() Schema {
+	return e.schema
+}
+
+// vecHashJoinExec joins its inputs on the first conjunct `l = r` of ON comparing two int64 or two string
+// columns: the rows of the right input are read at Open and hashed on r, the chunks of the left input probe
+// them on l and the other conjuncts are computed on the chunks of the matching pairs. The rows come in the order
+// of the nested loop of joinExec. If the values of l or r turn out to be of another kind, the pairs are checked
+// by all the conjuncts like the nested loop
+type vecHashJoinExec struct {
+	left, right VecExecutor
+	tp          ast.JoinType
+	on          []Expression
+	schema      Schema
+	lkey, rkey  int
+	keyCond     int
+	kind        byte
+	residual    []VecExpr
+	all         []VecExpr
+	build       []*Column
+	ints        map[int64][]int
+	strs        map[string][]int
+	loose       bool
+	matched     []bool
+	//the pairs of rows of the left chunk and of the build rows to return, -1 for NULLs
+	chk         *Chunk
+	probe, hits []int
+	done        bool
+}
+
+// buildHashJoin build a vecHashJoinExec if the ON of the join has an equality of two columns of int64
+// or of strings, one from each input
+func (b *executorBuilder) buildHashJoin(plan *LogicalPlan) (VecExecutor, bool, error) {
+	if len(plan.child) != 2 {
+		return nil, false, nil
+	}
+	n := plan.Content.(JoinNode)
+	e := &vecHashJoinExec{tp: n.Tp, on: n.On, schema: plan.Schema(), lkey: -1}
+	lschema, rschema := plan.child[0].Schema(), plan.child[1].Schema()
+	for k, cond := range n.On {
+		l, r, ok := EquiJoinColumns(plan, cond)
+		if !ok {
+			continue
+		}
+		li, ri := lschema.ResolveColumn(l), rschema.ResolveColumn(r)
+		if li == -1 || ri == -1 {
+			continue
+		}
+		lk, rk := lschema.Columns[li].Tp, rschema.Columns[ri].Tp
+		if lk == rk && (lk == test_driver.KindInt64 || lk == test_driver.KindString) {
+			e.lkey, e.rkey, e.keyCond, e.kind = li, ri, k, lk
+			break
+		}
+	}
+	if e.lkey == -1 {
+		return nil, false, nil
+	}
+	var err error
+	for i, input := range []*VecExecutor{&e.left, &e.right} {
+		vec, ok, err2 := b.buildVec(&plan.child[i])
+		if err2 != nil {
+			return nil, true, err2
+		}
+		if !ok {
+			var row Executor
+			if row, err = b.build(&plan.child[i], nil); err != nil {
+				return nil, true, err
+			}
+			vec = &rowChunksExec{child: row}
+		}
+		*input = vec
+	}
+	return e, true, nil
+}
+
+func (e *vecHashJoinExec) Open() error {
+	if err := e.left.Open(); err != nil {
+		return err
+	}
+	if err := e.right.Open(); err != nil {
+		return err
+	}
+	e.residual, e.all = nil, nil
+	for k, cond := range e.on {
+		expr := CompileVector(cond, e.schema)
+		e.all = append(e.all, expr)
+		if k != e.keyCond {
+			e.residual = append(e.residual, expr)
+		}
+	}
+	e.build = nil
+	for _, c := range e.right.Schema().Columns {
+		e.build = append(e.build, NewColumn(c.Tp, 0))
+	}
+	for {
+		chk, err := e.right.Next()
+		if err != nil {
+			return err
+		}
+		if chk == nil {
+			break
+		}
+		rows := chk.Rows()
+		for i, col := range e.build {
+			col.appendRows(chk.Columns[i], rows)
+		}
+	}
+	e.ints, e.strs, e.loose = make(map[int64][]int), make(map[string][]int), false
+	key := e.build[e.rkey]
+	switch {
+	case key.kind != e.kind:
+		e.loose = true
+	case e.kind == test_driver.KindInt64:
+		for i, v := range key.ints[:key.Len()] {
+			if !key.IsNull(i) {
+				e.ints[v] = append(e.ints[v], i)
+			}
+		}
+	default:
+		for i, v := range key.strs[:key.Len()] {
+			if !key.IsNull(i) {
+				e.strs[v] = append(e.strs[v], i)
+			}
+		}
+	}
+	e.matched = make([]bool, e.buildLen())
+	e.chk, e.probe, e.hits, e.done = nil, nil, nil, false
+	return nil
+}
+
+func (e *vecHashJoinExec) buildLen() int {
+	if len(e.build) == 0 {
+		return 0
+	}
+	return e.build[0].Len()
+}
+
+func (e *vecHashJoinExec) Next() (*Chunk, error) {
+	for len(e.probe) == 0 {
+		if e.done {
+			return nil, nil
+		}
+		chk, err := e.left.Next()
+		if err != nil {
+			return nil, err
+		}
+		if chk != nil {
+			if err := e.join(chk); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e.done, e.chk = true, nil
+		//the build rows without match
+		if e.tp == ast.RightJoin || e.tp == FullJoin {
+			for i, matched := range e.matched {
+				if !matched {
+					e.probe, e.hits = append(e.probe, -1), append(e.hits, i)
+				}
+			}
+		}
+	}
+	n := minInt(ChunkSize, len(e.probe))
+	ret := e.pairs(e.chk, e.probe[:n], e.hits[:n])
+	e.probe, e.hits = e.probe[n:], e.hits[n:]
+	return ret, nil
+}
+
+// join find the pairs of the selected rows of chk and of the build rows: the candidates sharing the key,
+// or all the build rows if the keys can not be hashed, are checked by the other conjuncts, and the rows of
+// a left join without match are padded with NULLs
+func (e *vecHashJoinExec) join(chk *Chunk) error {
+	rows := chk.Rows()
+	key := chk.Columns[e.lkey]
+	loose := e.loose || key.kind != e.kind
+	conds := e.residual
+	if loose {
+		conds = e.all
+	}
+	//the candidates of rows[k] are the pairs starts[k] to starts[k+1]
+	var probe, hits []int
+	starts := make([]int, 0, len(rows)+1)
+	for _, i := range rows {
+		starts = append(starts, len(hits))
+		var matches []int
+		switch {
+		case loose:
+			for j := 0; j < e.buildLen(); j++ {
+				probe, hits = append(probe, i), append(hits, j)
+			}
+			continue
+		case key.IsNull(i):
+		case e.kind == test_driver.KindInt64:
+			matches = e.ints[key.ints[i]]
+		default:
+			matches = e.strs[key.strs[i]]
+		}
+		for _, j := range matches {
+			probe, hits = append(probe, i), append(hits, j)
+		}
+	}
+	starts = append(starts, len(hits))
+	keep := make([]bool, len(hits))
+	for lo := 0; lo < len(hits); lo += ChunkSize {
+		hi := minInt(lo+ChunkSize, len(hits))
+		if len(conds) == 0 {
+			for k := lo; k < hi; k++ {
+				keep[k] = true
+			}
+			continue
+		}
+		pairs := e.pairs(chk, probe[lo:hi], hits[lo:hi])
+		passed, err := filterRows(conds, pairs, pairs.Rows())
+		if err != nil {
+			return err
+		}
+		for _, k := range passed {
+			keep[lo+k] = true
+		}
+	}
+	e.chk, e.probe, e.hits = chk, nil, nil
+	for k, i := range rows {
+		found := false
+		for c := starts[k]; c < starts[k+1]; c++ {
+			if keep[c] {
+				found, e.matched[hits[c]] = true, true
+				e.probe, e.hits = append(e.probe, i), append(e.hits, hits[c])
+			}
+		}
+		if !found && (e.tp == ast.LeftJoin || e.tp == FullJoin) {
+			e.probe, e.hits = append(e.probe, i), append(e.hits, -1)
+		}
+	}
+	return nil
+}
+
+// pairs return the chunk of the rows probe of chk followed by the build rows hits, chk is nil for
+// the build rows without match
+func (e *vecHashJoinExec) pairs(chk *Chunk, probe, hits []int) *Chunk {
+	ret := &Chunk{Columns: make([]*Column, 0, e.schema.Len()), length: len(probe)}
+	for i := 0; i < e.left.Schema().Len(); i++ {
+		var src *Column
+		if chk != nil {
+			src = chk.Columns[i]
+		}
+		ret.Columns = append(ret.Columns, gather(src, probe))
+	}
+	for _, col := range e.build {
+		ret.Columns = append(ret.Columns, gather(col, hits))
+	}
+	return ret
+}
+
+func (e *vecHashJoinExec) Close() error {
+	e.build, e.ints, e.strs, e.matched, e.chk, e.probe, e.hits = nil, nil, nil, nil, nil, nil, nil
+	err := e.left.Close()
+	if err2 := e.right.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (e *vecHashJoinExec) Schema() Schema {
+	return e.schema
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestVectorizedMatchesRowByRow(t *testing.T) {
+	useFuzzTables()
+	defer loadTestTables(t)
+	compared := 0
+	for seed := int64(1); seed <= 300; seed++ {
+		g := NewQueryGenerator(seed, FuzzTables)
+		g.MaxDepth = 2
+		oracle := TLPOracles[seed%int64(len(TLPOracles))]
+		q, p := g.partitionedQuery(oracle)
+		for _, sql := range []string{q.String(), q.partitions(oracle, p).String()} {
+			node, err := parse(sql)
+			if err != nil || !Deterministic(GetQuery(node)) {
+				continue
+			}
+			restore := generateTables(GetQuery(node), rand.New(rand.NewSource(seed)))
+			plan, err := optimizedPlan(sql)
+			if err != nil {
+				t.Fatalf("%v: %v", sql, err)
+			}
+			_, rows1, err1 := Execute(plan)
+			_, rows2, err2 := ExecuteVectorized(plan)
+			restore()
+			switch {
+			case (err1 == nil) != (err2 == nil):
+				t.Errorf("%v: %v row by row, %v vectorized", sql, err1, err2)
+			case err1 == nil && (len(rows1) != len(rows2) || !sameRows(rows1, rows2)):
+				t.Errorf("%v: %d rows row by row, %d rows vectorized", sql, len(rows1), len(rows2))
+			case err1 == nil:
+				compared++
+			}
+		}
+	}
+	if compared == 0 {
+		t.Error("no query compared")
+	}
+}
+
+func TestConcurrentExecution(t *testing.T) {
+	loadTestTables(t)
+	sql := "select s.a, count(t.b), sum(t.b) from t join s on t.a = s.a where t.b > 0 group by s.a"
+	plan, err := optimizedPlan(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, want, err := Execute(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan string, 16)
+	for i := 0; i < 16; i++ {
+		execute := Execute
+		if i%2 == 0 {
+			execute = ExecuteVectorized
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, rows, err := execute(plan); err != nil {
+					errs <- err.Error()
+					return
+				} else if len(rows) != len(want) || !sameRows(rows, want) {
+					errs <- "the rows differ from those of a single query"
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pingcap/tidb/parser/test_driver"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// VecExpr computes an expression on the rows of a chunk. The returned column has a value for every row of the
+// chunk but only the rows asked for are computed, it may be a column of the chunk and must not be modified
+type VecExpr func(chk *Chunk, rows []int) (*Column, error)
+
+// vecNode is a node of a vectorized expression, like compiled for the closures on rows. kind is the kind of the
+// values the node is expected to return, the kernels check the kind of the actual columns
+type vecNode struct {
+	eval     VecExpr
+	kind     byte
+	constant bool
+	value    Datum
+}
+
+// CompileVector turn expr into a tree of kernels computing the same values as CompileExpression a chunk at a time:
+// the comparisons and the arithmetic of int64, float64 and strings run in loops over the unboxed values and the
+// null bitmaps, the other operators and the functions are computed row by row. AND, OR, CASE and IF only compute
+// their operands on the rows still undecided
+func CompileVector(expr Expression, schema Schema) VecExpr {
+	c := vecCompiler{schema: schema}
+	return c.compile(expr).eval
+}
+
+type vecCompiler struct {
+	schema Schema
+}
+
+// compile read the postfix expression like exprCompiler.compile
+func (c *vecCompiler) compile(expr Expression) *vecNode {
+	var s []*vecNode
+	for _, d := range expr.expr {
+		switch {
+		case d.Args != nil:
+			s = append(s, c.function(d))
+		case expr.IsColumnDatum(d):
+			s = append(s, c.column(expr.Fields[d.GetString()]))
+		case d.Kind() == test_driver.KindString && StrToOp(d.GetString()) != -1 && len(s) >= 2:
+			node := c.operator(StrToOp(d.GetString()), s[len(s)-2:])
+			s = append(s[:len(s)-2], node)
+		default:
+			s = append(s, vecConstant(Datum{d.Datum, nil}))
+		}
+	}
+	if len(s) != 1 {
+		return vecFailure(fmt.Errorf("malformed expression %v", printExpressions([]Expression{expr})))
+	}
+	return s[0]
+}
+
+// vecConstant return the constant d, the column of its values is kept between the chunks
+func vecConstant(d Datum) *vecNode {
+	var col *Column
+	return &vecNode{
+		eval: func(chk *Chunk, rows []int) (*Column, error) {
+			if col == nil || col.Len() < chk.Len() {
+				col = NewColumn(d.Kind(), maxInt(chk.Len(), ChunkSize))
+				for i := 0; i < col.Len(); i++ {
+					col.Set(i, d)
+				}
+			}
+			return col, nil
+		},
+		kind:     d.Kind(),
+		constant: true,
+		value:    d,
+	}
+}
+
+func vecFailure(err error) *vecNode {
+	return &vecNode{
+		eval: func(*Chunk, []int) (*Column, error) {
+			return nil, err
+		},
+		kind: test_driver.KindNull,
+	}
+}
+
+// column resolve col once, the column of the chunk is returned as is
+func (c *vecCompiler) column(col ColumnName) *vecNode {
+	i := c.schema.ResolveColumn(col)
+	if i == -1 {
+		return vecFailure(fmt.Errorf("unknown or ambiguous column %v", columnText(col)))
+	}
+	return &vecNode{
+		eval: func(chk *Chunk, rows []int) (*Column, error) {
+			return chk.Columns[i], nil
+		},
+		kind: c.schema.Columns[i].Tp,
+	}
+}
+
+// vecFold compute the node once if all its arguments are constants, on a chunk of one row without columns
+func vecFold(node *vecNode, args []*vecNode) *vecNode {
+	for _, arg := range args {
+		if !arg.constant {
+			return node
+		}
+	}
+	col, err := node.eval(&Chunk{length: 1}, sequence[:1])
+	if err != nil {
+		return node
+	}
+	return vecConstant(col.Get(0))
+}
+
+// evalArgs compute the arguments on rows
+func evalArgs(args []*vecNode, chk *Chunk, rows []int) ([]*Column, error) {
+	cols := make([]*Column, len(args))
+	for i, arg := range args {
+		col, err := arg.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		cols[i] = col
+	}
+	return cols, nil
+}
+
+// rowByRow compute f on the values of the arguments of every row, the result is unboxed if it is of kind
+func rowByRow(kind byte, args []*vecNode, f func(values []Datum) (Datum, error)) VecExpr {
+	return func(chk *Chunk, rows []int) (*Column, error) {
+		cols, err := evalArgs(args, chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret := NewColumn(kind, chk.Len())
+		values := make([]Datum, len(cols))
+		for _, i := range rows {
+			for j, col := range cols {
+				values[j] = col.Get(i)
+			}
+			v, err := f(values)
+			if err != nil {
+				return nil, err
+			}
+			ret.Set(i, v)
+		}
+		return ret, nil
+	}
+}
+
+// genericVec compute op with EvalOperator row by row
+func genericVec(op MyOp, kind byte, args []*vecNode) VecExpr {
+	return rowByRow(kind, args, func(values []Datum) (Datum, error) {
+		return EvalOperator(op, values)
+	})
+}
+
+// orNulls set the NULLs of the result of a binary operator, NULL if either operand is
+func orNulls(ret, a, b *Column) {
+	for w := range ret.nulls {
+		ret.nulls[w] = a.nulls[w] | b.nulls[w]
+	}
+}
+
+func (c *vecCompiler) operator(op MyOp, args []*vecNode) *vecNode {
+	args = append([]*vecNode{}, args...)
+	node := &vecNode{eval: genericVec(op, test_driver.KindNull, args), kind: test_driver.KindNull}
+	l, r := args[0], args[1]
+	switch op {
+	case EQ, NE, LT, LE, GT, GE:
+		node.kind = test_driver.KindInt64
+		node.eval = vecComparison(op, l, r)
+	case NullEQ:
+		node.kind = test_driver.KindInt64
+		node.eval = genericVec(op, test_driver.KindInt64, args)
+	case Plus, Minus, Mul, Mod:
+		if l.kind == test_driver.KindInt64 && r.kind == test_driver.KindInt64 {
+			node.kind, node.eval = test_driver.KindInt64, vecIntArithmetic(op, l, r)
+		} else if l.kind == test_driver.KindFloat64 && r.kind == test_driver.KindFloat64 && op != Mod {
+			node.kind, node.eval = test_driver.KindFloat64, vecFloatArithmetic(op, l, r)
+		}
+	case LogicAnd, LogicOr:
+		node.kind, node.eval = test_driver.KindInt64, vecLogic(op, l, r)
+	}
+	return vecFold(node, args)
+}
+
+// vecComparison compare l and r in a loop when both columns hold int64, float64 or strings,
+// the other columns are compared row by row with EvalOperator
+func vecComparison(op MyOp, l, r *vecNode) VecExpr {
+	return func(chk *Chunk, rows []int) (*Column, error) {
+		a, err := l.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret := NewColumn(test_driver.KindInt64, chk.Len())
+		if a.kind != b.kind || !isTypedKind(a.kind) {
+			return operatorRows(op, ret, a, b, rows)
+		}
+		orNulls(ret, a, b)
+		out := ret.ints
+		switch a.kind {
+		case test_driver.KindInt64:
+			x, y := a.ints, b.ints
+			if r.constant {
+				k := y[0]
+				for _, i := range rows {
+					out[i] = boolInt(compareInt64(op, x[i], k))
+				}
+				break
+			}
+			for _, i := range rows {
+				out[i] = boolInt(compareInt64(op, x[i], y[i]))
+			}
+		case test_driver.KindFloat64:
+			x, y := a.floats, b.floats
+			for _, i := range rows {
+				if math.IsNaN(x[i]) || math.IsNaN(y[i]) {
+					v, err := EvalOperator(op, []Datum{a.Get(i), b.Get(i)})
+					if err != nil {
+						return nil, err
+					}
+					ret.Set(i, v)
+					continue
+				}
+				out[i] = boolInt(compareFloat64(op, x[i], y[i]))
+			}
+		default:
+			x, y := a.strs, b.strs
+			for _, i := range rows {
+				out[i] = boolInt(CompareResult(op, strings.Compare(x[i], y[i])))
+			}
+		}
+		return ret, nil
+	}
+}
+
+// operatorRows compute the binary operator op row by row with EvalOperator
+func operatorRows(op MyOp, ret, a, b *Column, rows []int) (*Column, error) {
+	for _, i := range rows {
+		v, err := EvalOperator(op, []Datum{a.Get(i), b.Get(i)})
+		if err != nil {
+			return nil, err
+		}
+		ret.Set(i, v)
+	}
+	return ret, nil
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(op MyOp, x, y float64) bool {
+	c := 0
+	if x < y {
+		c = -1
+	} else if x > y {
+		c = 1
+	}
+	return CompareResult(op, c)
+}
+
+// vecIntArithmetic compute +, -, * and % of two columns of int64, the overflows take the path of EvalOperator
+// which reports them
+func vecIntArithmetic(op MyOp, l, r *vecNode) VecExpr {
+	return func(chk *Chunk, rows []int) (*Column, error) {
+		a, err := l.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret := NewColumn(test_driver.KindInt64, chk.Len())
+		if a.kind != test_driver.KindInt64 || b.kind != test_driver.KindInt64 {
+			return operatorRows(op, ret, a, b, rows)
+		}
+		orNulls(ret, a, b)
+		x, y, out := a.ints, b.ints, ret.ints
+		for _, i := range rows {
+			if ret.IsNull(i) {
+				continue
+			}
+			ok := true
+			switch op {
+			case Plus:
+				out[i] = x[i] + y[i]
+				ok = (out[i] > x[i]) == (y[i] > 0)
+			case Minus:
+				out[i] = x[i] - y[i]
+				ok = (out[i] < x[i]) == (y[i] > 0)
+			case Mod:
+				if y[i] == 0 {
+					ret.SetNull(i)
+					continue
+				}
+				//truncated toward zero like MySQL, MinInt64 % -1 is 0 in Go
+				out[i] = x[i] % y[i]
+			default:
+				hi, lo := bits.Mul64(uint64(absInt64(x[i])), uint64(absInt64(y[i])))
+				ok = hi == 0 && lo <= math.MaxInt64 && x[i] != math.MinInt64 && y[i] != math.MinInt64
+				out[i] = x[i] * y[i]
+			}
+			if !ok {
+				//an overflow, reported by EvalOperator
+				v, err := EvalOperator(op, []Datum{a.Get(i), b.Get(i)})
+				if err != nil {
+					return nil, err
+				}
+				ret.Set(i, v)
+				if ret.kind != test_driver.KindInt64 {
+					return operatorRows(op, ret, a, b, rows)
+				}
+				out = ret.ints
+			}
+		}
+		return ret, nil
+	}
+}
+
+// vecFloatArithmetic compute +, - and * of two columns of float64, the overflows take the path of EvalOperator
+func vecFloatArithmetic(op MyOp, l, r *vecNode) VecExpr {
+	return func(chk *Chunk, rows []int) (*Column, error) {
+		a, err := l.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret := NewColumn(test_driver.KindFloat64, chk.Len())
+		if a.kind != test_driver.KindFloat64 || b.kind != test_driver.KindFloat64 {
+			return operatorRows(op, ret, a, b, rows)
+		}
+		orNulls(ret, a, b)
+		x, y, out := a.floats, b.floats, ret.floats
+		for _, i := range rows {
+			switch op {
+			case Plus:
+				out[i] = x[i] + y[i]
+			case Minus:
+				out[i] = x[i] - y[i]
+			default:
+				out[i] = x[i] * y[i]
+			}
+			if (math.IsInf(out[i], 0) || math.IsNaN(out[i])) && !ret.IsNull(i) {
+				v, err := EvalOperator(op, []Datum{a.Get(i), b.Get(i)})
+				if err != nil {
+					return nil, err
+				}
+				ret.Set(i, v)
+			}
+		}
+		return ret, nil
+	}
+}
+
+// truthAt return the truth of the value i of col once converted to a number, numeric is false if it is not
+// a number even converted, the three-valued logic is then left to EvalOperator
+func truthAt(col *Column, i int) (truth, null, numeric bool) {
+	switch {
+	case col.IsNull(i):
+		return false, true, true
+	case col.kind == test_driver.KindInt64:
+		return col.ints[i] != 0, false, true
+	}
+	n := NumericValue(col.Get(i))
+	truth, null = Truth(n)
+	return truth, null, IsNumericKind(n.Kind())
+}
+
+// vecLogic compute AND and OR with the three-valued logic, the right operand is only computed on the rows
+// where the left one does not decide the result
+func vecLogic(op MyOp, l, r *vecNode) VecExpr {
+	return func(chk *Chunk, rows []int) (*Column, error) {
+		a, err := l.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret := NewColumn(test_driver.KindInt64, chk.Len())
+		var undecided []int
+		for _, i := range rows {
+			t, null, numeric := truthAt(a, i)
+			if numeric && !null && t == (op == LogicOr) {
+				ret.ints[i] = boolInt(t)
+				continue
+			}
+			undecided = append(undecided, i)
+		}
+		if len(undecided) == 0 {
+			return ret, nil
+		}
+		b, err := r.eval(chk, undecided)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range undecided {
+			lt, ln, lnum := truthAt(a, i)
+			rt, rn, rnum := truthAt(b, i)
+			switch {
+			case !lnum || !rnum:
+				v, err := EvalOperator(op, []Datum{a.Get(i), b.Get(i)})
+				if err != nil {
+					return nil, err
+				}
+				ret.Set(i, v)
+			case !rn && rt == (op == LogicOr):
+				ret.ints[i] = boolInt(rt)
+			case ln || rn:
+				ret.SetNull(i)
+			default:
+				ret.ints[i] = boolInt(lt && rt)
+			}
+		}
+		return ret, nil
+	}
+}
+
+// vecNegation compute NOT with the three-valued logic
+func vecNegation(op MyOp, arg *vecNode) VecExpr {
+	return func(chk *Chunk, rows []int) (*Column, error) {
+		a, err := arg.eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret := NewColumn(test_driver.KindInt64, chk.Len())
+		for _, i := range rows {
+			t, null, numeric := truthAt(a, i)
+			switch {
+			case null:
+				ret.SetNull(i)
+			case !numeric:
+				v, err := EvalOperator(op, []Datum{a.Get(i)})
+				if err != nil {
+					return nil, err
+				}
+				ret.Set(i, v)
+			default:
+				ret.ints[i] = boolInt(!t)
+			}
+		}
+		return ret, nil
+	}
+}
+
+// function compile the call d like exprCompiler.function
+func (c *vecCompiler) function(d Datum) *vecNode {
+	name := d.GetString()
+	if IsAggregateFunction(name) {
+		key := Expression{expr: []Datum{d}}
+		return c.column(ColumnName{ColName: key.print()})
+	}
+	args := make([]*vecNode, 0, len(d.Args))
+	for _, arg := range d.Args {
+		args = append(args, c.compile(arg))
+	}
+	if name == Ops[Case].Name || name == "if" {
+		return c.conditional(name, args)
+	}
+	if op := StrToOp(name); op != -1 {
+		node := &vecNode{eval: genericVec(op, test_driver.KindNull, args), kind: test_driver.KindNull}
+		switch op {
+		case In:
+			node.kind = test_driver.KindInt64
+			node.eval = vecInSet(args)
+		case Like:
+			node.kind = test_driver.KindInt64
+			node.eval = vecLikePattern(args)
+		case Regexp:
+			node.kind = test_driver.KindInt64
+			node.eval = genericVec(op, test_driver.KindInt64, args)
+		case IsNull:
+			node.kind = test_driver.KindInt64
+			node.eval = func(chk *Chunk, rows []int) (*Column, error) {
+				a, err := args[0].eval(chk, rows)
+				if err != nil {
+					return nil, err
+				}
+				ret := NewColumn(test_driver.KindInt64, chk.Len())
+				for _, i := range rows {
+					ret.ints[i] = boolInt(a.IsNull(i))
+				}
+				return ret, nil
+			}
+		case Not, Not2:
+			node.kind = test_driver.KindInt64
+			if len(args) == 1 {
+				node.eval = vecNegation(op, args[0])
+			}
+		case IsTruth, IsFalsity:
+			node.kind = test_driver.KindInt64
+			node.eval = genericVec(op, test_driver.KindInt64, args)
+		}
+		return vecFold(node, args)
+	}
+	def, ok := LookupFunction(name)
+	if !ok || def.Eval == nil {
+		return vecFailure(fmt.Errorf("unsupported function %v", name))
+	}
+	node := &vecNode{eval: rowByRow(test_driver.KindNull, args, def.Eval), kind: test_driver.KindNull}
+	if def.Volatile {
+		return node
+	}
+	return vecFold(node, args)
+}
+
+// conditional compile case(when1, then1, ..., else) and if(cond, then, else), the results are only computed
+// on the rows choosing them
+func (c *vecCompiler) conditional(name string, args []*vecNode) *vecNode {
+	if name == "if" && len(args) != 3 {
+		return vecFailure(fmt.Errorf("incorrect parameter count in the call to if"))
+	}
+	if len(args)%2 == 0 {
+		return vecFailure(fmt.Errorf("malformed CASE of %d arguments", len(args)))
+	}
+	node := &vecNode{
+		eval: func(chk *Chunk, rows []int) (*Column, error) {
+			ret := NewColumn(test_driver.KindNull, chk.Len())
+			fill := func(result *vecNode, chosen []int) error {
+				if len(chosen) == 0 {
+					return nil
+				}
+				col, err := result.eval(chk, chosen)
+				if err != nil {
+					return err
+				}
+				for _, i := range chosen {
+					ret.Set(i, col.Get(i))
+				}
+				return nil
+			}
+			for i := 0; i+1 < len(args) && len(rows) > 0; i += 2 {
+				cond, err := args[i].eval(chk, rows)
+				if err != nil {
+					return nil, err
+				}
+				var chosen, rest []int
+				for _, j := range rows {
+					if t, null, _ := truthAt(cond, j); t && !null {
+						chosen = append(chosen, j)
+					} else {
+						rest = append(rest, j)
+					}
+				}
+				if err := fill(args[i+1], chosen); err != nil {
+					return nil, err
+				}
+				rows = rest
+			}
+			return ret, fill(args[len(args)-1], rows)
+		},
+		kind: test_driver.KindNull,
+	}
+	return vecFold(node, args)
+}
+
+// vecInSet look the values up in a set when the list of IN is made of int64 or of string constants,
+// the other lists and the values of another kind are computed by EvalOperator
+func vecInSet(args []*vecNode) VecExpr {
+	generic := genericVec(In, test_driver.KindInt64, args)
+	kind := args[0].kind
+	if kind != test_driver.KindInt64 && kind != test_driver.KindString {
+		return generic
+	}
+	ints, strs := make(map[int64]struct{}), make(map[string]struct{})
+	list := make([]Datum, 0, len(args))
+	for _, arg := range args[1:] {
+		if !arg.constant || arg.value.Kind() != kind {
+			return generic
+		}
+		if kind == test_driver.KindInt64 {
+			ints[arg.value.GetInt64()] = struct{}{}
+		} else {
+			strs[arg.value.GetString()] = struct{}{}
+		}
+		list = append(list, arg.value)
+	}
+	return func(chk *Chunk, rows []int) (*Column, error) {
+		a, err := args[0].eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret := NewColumn(test_driver.KindInt64, chk.Len())
+		for _, i := range rows {
+			var found bool
+			switch {
+			case a.IsNull(i):
+				ret.SetNull(i)
+				continue
+			case a.kind != kind:
+				v, err := EvalOperator(In, append([]Datum{a.Get(i)}, list...))
+				if err != nil {
+					return nil, err
+				}
+				ret.Set(i, v)
+				continue
+			case kind == test_driver.KindInt64:
+				_, found = ints[a.ints[i]]
+			default:
+				_, found = strs[a.strs[i]]
+			}
+			ret.ints[i] = boolInt(found)
+		}
+		return ret, nil
+	}
+}
+
+// vecLikePattern decode the pattern of LIKE once when it is a constant
+func vecLikePattern(args []*vecNode) VecExpr {
+	if len(args) != 2 || !args[1].constant || args[1].value.Kind() == test_driver.KindNull {
+		return genericVec(Like, test_driver.KindInt64, args)
+	}
+	pattern := []rune(StringValue(args[1].value))
+	return func(chk *Chunk, rows []int) (*Column, error) {
+		a, err := args[0].eval(chk, rows)
+		if err != nil {
+			return nil, err
+		}
+		ret := NewColumn(test_driver.KindInt64, chk.Len())
+		for _, i := range rows {
+			switch {
+			case a.IsNull(i):
+				ret.SetNull(i)
+			case a.kind == test_driver.KindString:
+				ret.ints[i] = boolInt(likeMatch([]rune(a.strs[i]), pattern, '\\'))
+			default:
+				ret.ints[i] = boolInt(likeMatch([]rune(StringValue(a.Get(i))), pattern, '\\'))
+			}
+		}
+		return ret, nil
+	}
+}
+
+// selectRows return the rows where col is true
+func selectRows(col *Column, rows []int) []int {
+	ret := make([]int, 0, len(rows))
+	for _, i := range rows {
+		if t, null, _ := truthAt(col, i); t && !null {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
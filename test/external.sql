@@ -0,0 +1,3 @@
+CREATE TABLE people (id INT NOT NULL, name VARCHAR(20), age INT, city VARCHAR(20)) ENGINE=CSV LOCATION 'external/people.csv';
+CREATE TABLE orders (id INT NOT NULL, person INT, amount DECIMAL(10,2), note VARCHAR(20)) ENGINE=TSV LOCATION 'external/orders.tsv';
+CREATE TABLE events (id INT NOT NULL, person INT, kind VARCHAR(10), ok INT) ENGINE=JSON LOCATION 'external/events.jsonl';